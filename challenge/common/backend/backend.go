@@ -12,18 +12,88 @@
 //
 package backend
 
-import "net/http"
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
 
-// "github.com/Arvinderpal/go-storage-server/challenge/common/types"
 // "github.com/Arvinderpal/go-storage-server/challenge/pkg/blob"
 // "github.com/Arvinderpal/go-storage-server/challenge/pkg/option"
 
 type control interface {
-	GlobalStatus() (string, error)
+	GlobalStatus() (*types.Status, error)
+}
+
+type cluster interface {
+	Forward(string, http.ResponseWriter, *http.Request) (bool, error)
+	Ring() (*types.Ring, error)
+	SetRing(*types.Ring, bool) error
+	Members() ([]types.Member, error)
+}
+
+type replication interface {
+	Changes(epoch string, seq uint64) (*types.ChangeSet, error)
+	Watch(prefix string, seq uint64, cancel <-chan struct{}) (*types.ChangeSet, error)
+	LastChange() uint64
+	Promote() error
+}
+
+type consensus interface {
+	RaftVote(*types.VoteRequest) (*types.VoteResponse, error)
+	RaftAppend(*types.AppendRequest) (*types.AppendResponse, error)
+	RaftSnapshot(*types.SnapshotRequest) (*types.SnapshotResponse, error)
+	RaftStatus() (*types.RaftStatus, error)
+}
+
+type antiEntropy interface {
+	MerkleNode(path string) (*types.MerkleNode, error)
+}
+
+type notification interface {
+	Webhooks() ([]types.Webhook, error)
+	AddWebhook(*types.Webhook) (*types.Webhook, error)
+	Webhook(id string) (*types.Webhook, error)
+	RemoveWebhook(id string) error
+	WebhookDeliveries(id string) ([]types.WebhookDelivery, error)
+	WebhookDeadLetters(id string) ([]types.WebhookDelivery, error)
+	RedeliverWebhook(id string) (int, error)
+	Sinks() ([]types.SinkStatus, error)
+}
+
+type security interface {
+	Authenticate(*http.Request) (*types.Principal, error)
+	Presign(p *types.Principal, req *types.PresignRequest, base string) (*types.PresignedURL, error)
+	Allowed(p *types.Principal, perm, location string) bool
+	Admit(p *types.Principal, r *http.Request) (time.Duration, bool)
+	Throttle(p *types.Principal, w http.ResponseWriter, r *http.Request) (http.ResponseWriter, io.ReadCloser)
+	Auditing() bool
+	BlobID(location string) (uint16, bool)
+	Audit(rec *types.AuditRecord)
+	ACL(location string) (*types.ACL, error)
+	ACLs() ([]types.ACL, error)
+	SetACL(p *types.Principal, acl *types.ACL, fromPeer bool) (*types.ACL, error)
+	RemoveACL(p *types.Principal, prefix string, fromPeer bool) error
+}
+
+type tenancy interface {
+	Buckets(p *types.Principal) ([]types.Bucket, error)
+	Bucket(name string) (*types.Bucket, error)
+	CreateBucket(p *types.Principal, b *types.Bucket) (*types.Bucket, error)
+	SetBucket(p *types.Principal, b *types.Bucket, fromPeer bool) (*types.Bucket, error)
+	RemoveBucket(p *types.Principal, name string, fromPeer bool) error
+	Versions(location string) ([]types.BlobVersion, error)
+	Usage(p *types.Principal) (*types.UsageReport, error)
+	Quotas(p *types.Principal) ([]types.PrefixQuota, error)
+	SetQuota(p *types.Principal, q *types.PrefixQuota, fromPeer bool) (*types.PrefixQuota, error)
+	RemoveQuota(p *types.Principal, prefix string, fromPeer bool) error
 }
 
 type blob interface {
 	GetBlob(string, http.ResponseWriter, *http.Request) error
+	HeadBlob(string, http.ResponseWriter, *http.Request) error
 	CreateBlob(string, http.ResponseWriter, *http.Request) error
 	UpdateBlob(string, http.ResponseWriter, *http.Request) error
 	DeleteBlob(string, http.ResponseWriter, *http.Request) error
@@ -33,4 +103,11 @@ type blob interface {
 type DaemonBackend interface {
 	blob
 	control
+	replication
+	cluster
+	consensus
+	antiEntropy
+	notification
+	security
+	tenancy
 }
@@ -0,0 +1,83 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package common
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Blobs in a bucket are keyed by the daemon as <bucket>/<name>: locations
+// outside of buckets can't hold the separator.
+const bucketSeparator = "/"
+
+var bucketNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+
+// ValidBucketName tells whether name can be used for a bucket: 2 to 63
+// lower case letters, digits and dashes, not starting with a dash.
+func ValidBucketName(name string) bool {
+	return bucketNameRegexp.MatchString(name)
+}
+
+// BucketLocation returns the location of name in bucket.
+func BucketLocation(bucket, name string) string {
+	return bucket + bucketSeparator + name
+}
+
+// SplitLocation returns the bucket of location, empty outside of buckets,
+// and the name of location in it.
+func SplitLocation(location string) (bucket, name string) {
+	i := strings.Index(location, bucketSeparator)
+	if i < 0 {
+		return "", location
+	}
+	return location[:i], location[i+1:]
+}
+
+// ValidLocation tells whether location, or a prefix of locations if
+// prefix is set, can be used: a name without separator, in a valid bucket
+// or outside of any. A prefix may be a whole bucket, <bucket>/.
+func ValidLocation(location string, prefix bool) bool {
+	bucket, name := SplitLocation(location)
+	if strings.Contains(name, bucketSeparator) {
+		return false
+	}
+	if bucket == "" && strings.Contains(location, bucketSeparator) {
+		return false
+	}
+	if bucket != "" && !ValidBucketName(bucket) {
+		return false
+	}
+	return name != "" || (prefix && bucket != "")
+}
+
+// StorePath returns the path the blob at location is served at:
+// /store/<location>, or /buckets/<bucket>/store/<name> in a bucket.
+func StorePath(location string) string {
+	if bucket, name := SplitLocation(location); bucket != "" {
+		return "/buckets/" + bucket + "/store/" + name
+	}
+	return "/store/" + location
+}
+
+// PathLocation returns the location of a path returned by StorePath.
+func PathLocation(path string) (string, bool) {
+	if strings.HasPrefix(path, "/store/") {
+		return strings.TrimPrefix(path, "/store/"), true
+	}
+	parts := strings.Split(path, "/")
+	if len(parts) == 5 && parts[0] == "" && parts[1] == "buckets" && parts[3] == "store" {
+		return BucketLocation(parts[2], parts[4]), true
+	}
+	return "", false
+}
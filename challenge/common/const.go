@@ -32,4 +32,35 @@ const (
 
 	// Blob's data file
 	BlobDataFileName = "data.raw"
+
+	// PeerHeader marks requests sent by a peer server, which are applied
+	// locally without being fanned out again.
+	PeerHeader = "X-Peer-Request"
+	// VersionHeader carries the version of a blob between peers.
+	VersionHeader = "X-Blob-Version"
+	// CreatorHeader carries the principal that wrote a blob: in responses,
+	// and in writes between servers, which vouch for the client.
+	CreatorHeader = "X-Blob-Creator"
+	// ConsistencyHeader overrides the read or write quorum of a request:
+	// one, quorum or all. With raft, linearizable asks for a read served by
+	// the leader.
+	ConsistencyHeader = "X-Consistency"
+	// ForwardedHeader marks requests proxied by a cluster member to an
+	// owner of the location, which serves them without forwarding again.
+	ForwardedHeader = "X-Cluster-Forwarded"
+	// APIKeyHeader carries the API key of a request, as <id>:<secret>.
+	APIKeyHeader = "X-Api-Key"
+	// DateHeader and ContentSHA256Header carry the time a request was
+	// signed at, as 20060102T150405Z, and the hex SHA-256 of its body, or
+	// UNSIGNED-PAYLOAD.
+	DateHeader          = "X-Challenge-Date"
+	ContentSHA256Header = "X-Challenge-Content-Sha256"
+
+	// Headers of the requests posting events to webhooks. The signature is
+	// "sha256=" followed by the hex HMAC-SHA256, keyed by the webhook's
+	// secret, of the timestamp header, a dot and the body.
+	WebhookIDHeader        = "X-Webhook-Id"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
 )
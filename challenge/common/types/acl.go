@@ -0,0 +1,98 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package types
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// ACLAnyone in the readers or writers of an ACL stands for every
+	// principal, anonymous ones included.
+	ACLAnyone = "*"
+
+	// Principals are named in ACLs by how they authenticate, followed by
+	// their key ID, token subject or certificate common name, so that a
+	// token can't pass for the key of the same name.
+	ACLKey  = "key:"
+	ACLJWT  = "jwt:"
+	ACLCert = "cert:"
+)
+
+// ValidACLName tells whether name can be used in an ACL.
+func ValidACLName(name string) bool {
+	if name == ACLAnyone {
+		return true
+	}
+	for _, kind := range []string{ACLKey, ACLJWT, ACLCert} {
+		if strings.HasPrefix(name, kind) && len(name) > len(kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// ACL shares the locations starting with Prefix with principals, by
+// qualified name (see ACLKey), beyond the grants of their credentials. Readers may read them, writers
+// may also create, update and delete them, and the owner may in addition
+// change the ACL and those of the prefixes below. Prefix only covers the
+// locations below it across a "/": an ACL is set on a location or on a
+// bucket. A location is covered by the ACL of its longest prefix only.
+type ACL struct {
+	Prefix  string    `json:"prefix"`
+	Owner   string    `json:"owner"`
+	Readers []string  `json:"readers,omitempty"`
+	Writers []string  `json:"writers,omitempty"`
+	Updated time.Time `json:"updated"` // the latest change wins between servers
+}
+
+func listed(names []string, name string) bool {
+	for _, n := range names {
+		if n == name || n == ACLAnyone {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows tells whether the ACL gives the principal called name perm. An
+// empty name is only allowed what ACLAnyone is.
+func (acl *ACL) Allows(name, perm string) bool {
+	if name != "" && name == acl.Owner {
+		return perm != PermAdmin
+	}
+	switch perm {
+	case PermRead:
+		return listed(acl.Readers, name) || listed(acl.Writers, name)
+	case PermWrite, PermDelete:
+		return listed(acl.Writers, name)
+	}
+	return false
+}
+
+// Validate checks the names in the ACL.
+func (acl *ACL) Validate() error {
+	names := append([]string{}, acl.Readers...)
+	names = append(names, acl.Writers...)
+	if acl.Owner != "" {
+		names = append(names, acl.Owner)
+	}
+	for _, name := range names {
+		if !ValidACLName(name) || name == ACLAnyone && name == acl.Owner {
+			return fmt.Errorf("invalid principal %q, should be %s<key ID>, %s<subject>, %s<common name> or %s", name, ACLKey, ACLJWT, ACLCert, ACLAnyone)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package types
+
+import "time"
+
+// AuditRecord is an entry of the audit log: a request changing something,
+// what it did and how it ended. Each record holds the hash of the one
+// before it, chaining them so that changing, removing or inserting one
+// shows.
+type AuditRecord struct {
+	Seq        uint64    `json:"seq"`
+	Time       time.Time `json:"time"`
+	Principal  string    `json:"principal"` // as <method>:<name>, - if not authenticated
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Location   string    `json:"location,omitempty"` // of the blob requests
+	OldID      *uint16   `json:"old_id,omitempty"`   // blob at the location before
+	NewID      *uint16   `json:"new_id,omitempty"`   // blob at the location after
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"` // read from the body
+
+	Prev string `json:"prev"` // hash of the previous record, empty for the first
+	Hash string `json:"hash"` // of the record, this field left empty
+}
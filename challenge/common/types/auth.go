@@ -0,0 +1,82 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package types
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// Permissions a Grant gives. Admin allows everything, including the
+	// endpoints not about blobs (replication, cluster, webhooks...).
+	PermRead   = "read"
+	PermWrite  = "write"
+	PermDelete = "delete"
+	PermAdmin  = "admin"
+)
+
+// Grant gives permissions on the locations starting with Prefix, on every
+// location if it is empty.
+type Grant struct {
+	Prefix string   `json:"prefix"`
+	Perms  []string `json:"perms"`
+}
+
+// Principal is who a request was authenticated as.
+type Principal struct {
+	Name   string  `json:"name"`
+	Method string  `json:"method"` // how it was authenticated, e.g. key
+	Grants []Grant `json:"grants"`
+}
+
+// Covers tells whether prefix covers location. Locations in a bucket,
+// <bucket>/<name>, are only covered by the empty prefix and by prefixes in
+// the bucket, so that a prefix outside of buckets never reaches into one.
+func Covers(prefix, location string) bool {
+	if !strings.HasPrefix(location, prefix) {
+		return false
+	}
+	return prefix == "" || strings.Contains(prefix, "/") || !strings.Contains(location, "/")
+}
+
+// Allowed tells whether p has perm on location. Endpoints not about a
+// location ask for an empty one, which only grants with no prefix cover.
+func (p *Principal) Allowed(perm, location string) bool {
+	for _, g := range p.Grants {
+		if !Covers(g.Prefix, location) {
+			continue
+		}
+		for _, gp := range g.Perms {
+			if gp == perm || gp == PermAdmin {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PresignRequest asks for a URL letting whoever has it send Method requests
+// (GET, PUT or POST) to Location for ExpiresIn seconds.
+type PresignRequest struct {
+	Method    string `json:"method"`
+	Location  string `json:"location"`
+	ExpiresIn int64  `json:"expires_in,omitempty"`
+}
+
+// PresignedURL is a URL returned for a PresignRequest.
+type PresignedURL struct {
+	URL     string    `json:"url"`
+	Method  string    `json:"method"`
+	Expires time.Time `json:"expires"`
+}
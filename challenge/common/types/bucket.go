@@ -0,0 +1,50 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package types
+
+import "time"
+
+// CompressionGzip compresses the data of a bucket's blobs with gzip.
+const CompressionGzip = "gzip"
+
+// Bucket is a namespace of blobs, kept apart from other buckets on disk and
+// in the grants of credentials, with its own settings.
+type Bucket struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+
+	// Versioning keeps the blobs replaced or deleted as noncurrent
+	// versions, readable until deleted on their own.
+	Versioning bool `json:"versioning,omitempty"`
+	// DefaultTTL, in seconds, makes blobs expire that long after they
+	// were written.
+	DefaultTTL int64 `json:"default_ttl,omitempty"`
+	// Compression of the data of new blobs, "" or CompressionGzip.
+	Compression string `json:"compression,omitempty"`
+	// QuotaBytes and QuotaObjects limit the size of the data and the
+	// number of blobs in the bucket, if set.
+	QuotaBytes   int64 `json:"quota_bytes,omitempty"`
+	QuotaObjects int64 `json:"quota_objects,omitempty"`
+
+	Updated time.Time `json:"updated"` // the latest change wins between servers
+
+	Usage *Usage `json:"usage,omitempty"` // filled in when read
+}
+
+// BlobVersion is a version of a blob in a versioned bucket.
+type BlobVersion struct {
+	Version int64  `json:"version"`
+	Size    int64  `json:"size"`
+	Creator string `json:"creator,omitempty"`
+	Current bool   `json:"current"` // false for versions replaced or deleted
+}
@@ -0,0 +1,42 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package types
+
+import "time"
+
+// Ring describes the members of a cluster and how blobs are spread over
+// them.
+type Ring struct {
+	Self    string   `json:"self,omitempty"`
+	Members []string `json:"members"`
+	Owners  int      `json:"owners,omitempty"` // servers holding each blob
+}
+
+// MemberState is the state of a cluster member as seen by the membership
+// protocol.
+type MemberState string
+
+const (
+	MemberAlive   MemberState = "alive"
+	MemberSuspect MemberState = "suspect"
+	MemberDead    MemberState = "dead"
+)
+
+// Member is a server known to the membership protocol.
+type Member struct {
+	Name        string      `json:"name"` // address of the server's API
+	Addr        string      `json:"addr"` // address the server gossips on
+	State       MemberState `json:"state"`
+	Incarnation uint64      `json:"incarnation"`
+	Since       time.Time   `json:"since,omitempty"` // last state change seen here
+}
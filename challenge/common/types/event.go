@@ -0,0 +1,39 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package types
+
+import "time"
+
+// Event tells of a client write that succeeded. It is posted to webhooks
+// and published to sinks.
+type Event struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"type"` // create, update or delete
+	Location string    `json:"location"`
+	Version  int64     `json:"version"`
+	Time     time.Time `json:"time"`
+}
+
+// EventRecord is an event as spooled by a sink, numbered by its offset.
+type EventRecord struct {
+	Offset uint64 `json:"offset"`
+	Event  Event  `json:"event"`
+}
+
+// SinkStatus describes a sink: the offset of the last event it spooled and
+// the offset committed by each of its consumers.
+type SinkStatus struct {
+	Spec       string            `json:"spec"`
+	LastOffset uint64            `json:"last_offset"`
+	Consumers  map[string]uint64 `json:"consumers"`
+}
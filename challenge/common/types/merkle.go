@@ -0,0 +1,32 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package types
+
+// MerkleEntry is a blob, or a recent delete, as hashed in a Merkle tree.
+type MerkleEntry struct {
+	Location string `json:"location"`
+	Hash     string `json:"hash,omitempty"` // SHA-256 of the data, empty for a delete
+	Version  int64  `json:"version"`
+	Deleted  bool   `json:"deleted,omitempty"`
+}
+
+// MerkleNode is a node of a server's Merkle tree. Path is the hex digits
+// leading to it from the root, which has an empty path. Inner nodes list
+// the hashes of their children, leaves the entries they hash. Empty subtrees
+// have an empty hash.
+type MerkleNode struct {
+	Path     string        `json:"path"`
+	Hash     string        `json:"hash"`
+	Children []string      `json:"children,omitempty"`
+	Entries  []MerkleEntry `json:"entries,omitempty"`
+}
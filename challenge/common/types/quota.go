@@ -0,0 +1,49 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package types
+
+import "time"
+
+// Usage is what a bucket, a prefix or a key holds on a server, noncurrent
+// versions included.
+type Usage struct {
+	Bytes   int64 `json:"bytes"`
+	Objects int64 `json:"objects"`
+}
+
+// PrefixQuota limits the size of the data and the number of blobs at the
+// locations starting with Prefix, if set.
+type PrefixQuota struct {
+	Prefix       string    `json:"prefix"`
+	QuotaBytes   int64     `json:"quota_bytes,omitempty"`
+	QuotaObjects int64     `json:"quota_objects,omitempty"`
+	Updated      time.Time `json:"updated"` // the latest change wins between servers
+
+	Usage *Usage `json:"usage,omitempty"` // filled in when read
+}
+
+// KeyUsage is what the blobs written by an API key hold, with its quota.
+type KeyUsage struct {
+	ID           string `json:"id"`
+	QuotaBytes   int64  `json:"quota_bytes,omitempty"`
+	QuotaObjects int64  `json:"quota_objects,omitempty"`
+	Usage        Usage  `json:"usage"`
+}
+
+// UsageReport is what buckets, prefixes with a quota and keys hold on a
+// server.
+type UsageReport struct {
+	Buckets  []Bucket      `json:"buckets"`
+	Prefixes []PrefixQuota `json:"prefixes"`
+	Keys     []KeyUsage    `json:"keys"`
+}
@@ -0,0 +1,66 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package types
+
+import "time"
+
+const (
+	// Operations found in a Change.
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+
+	// Roles a server can have.
+	RolePrimary = "primary"
+	RoleReplica = "replica"
+)
+
+// Change is one entry of a server's change stream.
+type Change struct {
+	Seq      uint64    `json:"seq"`
+	Op       string    `json:"op"`
+	Location string    `json:"location"`
+	Time     time.Time `json:"time"`
+}
+
+// ChangeSet is returned by the change stream endpoint. Epoch identifies the
+// server's current run: sequence numbers from different epochs can't be
+// compared. When Reset is set, the requested changes are not available
+// anymore and Changes instead lists every blob the server holds; the caller
+// must drop whatever else it has.
+type ChangeSet struct {
+	Epoch   string   `json:"epoch"`
+	Seq     uint64   `json:"seq"` // latest sequence number of the server
+	Reset   bool     `json:"reset,omitempty"`
+	Changes []Change `json:"changes"`
+}
+
+// Status is returned by the status endpoint.
+type Status struct {
+	Status      string             `json:"status"`
+	Role        string             `json:"role"`
+	Seq         uint64             `json:"seq"` // latest sequence number of the change stream
+	Replication *ReplicationStatus `json:"replication,omitempty"`
+	Raft        *RaftStatus        `json:"raft,omitempty"`
+}
+
+// ReplicationStatus describes how far behind its primary a replica is.
+type ReplicationStatus struct {
+	Primary     string    `json:"primary"`
+	AppliedSeq  uint64    `json:"applied_seq"`
+	PrimarySeq  uint64    `json:"primary_seq"`
+	LagOps      uint64    `json:"lag_ops"`
+	LagSeconds  float64   `json:"lag_seconds"` // time since the replica was last caught up
+	LastContact time.Time `json:"last_contact"`
+	LastError   string    `json:"last_error,omitempty"`
+}
@@ -0,0 +1,57 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package types
+
+import "time"
+
+const (
+	// Statuses of a WebhookDelivery.
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead" // gave up, kept in the dead-letter list
+)
+
+// Webhook is a subscription to the changes made to blobs. Events lists the
+// operations it is notified of, all of them if empty, and Prefix restricts
+// it to the locations starting with it. Secret is the key payloads are
+// signed with; it is only returned when the webhook is created.
+type Webhook struct {
+	ID      string        `json:"id"`
+	URL     string        `json:"url"`
+	Events  []string      `json:"events,omitempty"`
+	Prefix  string        `json:"prefix,omitempty"`
+	Secret  string        `json:"secret,omitempty"`
+	Created time.Time     `json:"created"`
+	Stats   *WebhookStats `json:"stats,omitempty"`
+}
+
+// WebhookStats sums up the deliveries to a webhook since the server started.
+type WebhookStats struct {
+	Queued       int       `json:"queued"` // events waiting to be delivered
+	Delivered    uint64    `json:"delivered"`
+	Retries      uint64    `json:"retries"`
+	Dead         int       `json:"dead"` // events in the dead-letter list
+	LastDelivery time.Time `json:"last_delivery"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+// WebhookDelivery tells how the delivery of an event to a webhook went.
+type WebhookDelivery struct {
+	Webhook     string    `json:"webhook"`
+	Event       Event     `json:"event"`
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts"`
+	LastAttempt time.Time `json:"last_attempt"`
+	LastCode    int       `json:"last_code,omitempty"` // HTTP status of the last attempt
+	LastError   string    `json:"last_error,omitempty"`
+}
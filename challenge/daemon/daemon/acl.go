@@ -0,0 +1,179 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/acl"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/auth"
+)
+
+const aclsFileName = "acls.json"
+
+// Allowed tells whether p has perm on location, through its grants or the
+// ACL covering location.
+func (d *Daemon) Allowed(p *types.Principal, perm, location string) bool {
+	if p.Allowed(perm, location) {
+		return true
+	}
+	if location == "" || p.Method == auth.MethodPresigned {
+		return false
+	}
+	a, err := d.acls.Effective(location)
+	return err == nil && a.Allows(auth.ACLName(p), perm)
+}
+
+// canManageACL tells whether p may change the ACL of prefix: those whose
+// grants allow them to write it and the owner of the ACL covering it may.
+func (d *Daemon) canManageACL(p *types.Principal, prefix string) bool {
+	if p.Allowed(types.PermWrite, prefix) {
+		return true
+	}
+	if p.Method == auth.MethodAnonymous || p.Method == auth.MethodPresigned {
+		return false
+	}
+	name := auth.ACLName(p)
+	if name == "" {
+		return false
+	}
+	a, err := d.acls.Effective(prefix)
+	return err == nil && a.Owner == name
+}
+
+// ACL returns the ACL covering location, or acl.ErrNotFound.
+func (d *Daemon) ACL(location string) (*types.ACL, error) {
+	return d.acls.Effective(location)
+}
+
+// ACLs returns every ACL, by prefix.
+func (d *Daemon) ACLs() ([]types.ACL, error) {
+	return d.acls.List(), nil
+}
+
+// SetACL sets the ACL of a.Prefix for p, who becomes its owner if it names
+// none, and sends it to the other servers. An ACL sent by a server is
+// stored as is, unless we hold a later one.
+func (d *Daemon) SetACL(p *types.Principal, a *types.ACL, fromPeer bool) (*types.ACL, error) {
+	if !common.ValidLocation(a.Prefix, true) {
+		return nil, fmt.Errorf("invalid prefix %q", a.Prefix)
+	}
+	if fromPeer {
+		if !p.Allowed(types.PermAdmin, "") {
+			return nil, auth.ErrForbidden
+		}
+		_, err := d.acls.Set(a)
+		return a, err
+	}
+	if !d.canManageACL(p, a.Prefix) {
+		return nil, auth.ErrForbidden
+	}
+	if a.Owner == "" {
+		a.Owner = auth.ACLName(p)
+	}
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+	a.Updated = time.Now()
+	if cur, err := d.acls.Effective(a.Prefix); err == nil && cur.Prefix == a.Prefix && !a.Updated.After(cur.Updated) {
+		a.Updated = cur.Updated.Add(time.Nanosecond)
+	}
+	if _, err := d.acls.Set(a); err != nil {
+		return nil, err
+	}
+	buf, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	d.broadcast("PUT", aclPath(a.Prefix), "the ACL of "+a.Prefix, buf)
+	return a, nil
+}
+
+// RemoveACL removes the ACL of prefix for p, and from the other servers.
+func (d *Daemon) RemoveACL(p *types.Principal, prefix string, fromPeer bool) error {
+	if fromPeer {
+		if !p.Allowed(types.PermAdmin, "") {
+			return auth.ErrForbidden
+		}
+		if err := d.acls.Remove(prefix); err != nil && err != acl.ErrNotFound {
+			return err
+		}
+		return nil
+	}
+	if !d.canManageACL(p, prefix) {
+		return auth.ErrForbidden
+	}
+	if err := d.acls.Remove(prefix); err != nil {
+		return err
+	}
+	d.broadcast("DELETE", aclPath(prefix), "the ACL of "+prefix, nil)
+	return nil
+}
+
+// otherServers returns the base URLs of the other servers ACLs and buckets
+// are sent to: peers, cluster members and raft nodes.
+func (d *Daemon) otherServers() []string {
+	var servers []string
+	self := baseURL(d.conf.Advertise)
+	for _, list := range [][]string{d.conf.Peers, d.conf.Members, d.conf.RaftNodes} {
+		for _, s := range list {
+			if u := baseURL(s); u != self {
+				servers = append(servers, u)
+			}
+		}
+	}
+	return servers
+}
+
+// broadcast sends a change to the other servers as a peer request, in the
+// background. Those that miss it keep what they had. what names the change
+// in the logs.
+func (d *Daemon) broadcast(method, path, what string, body []byte) {
+	for _, server := range d.otherServers() {
+		go func(server string) {
+			req, err := http.NewRequest(method, server+path, bytes.NewReader(body))
+			if err != nil {
+				logger.Warningf("Unable to send %s to %s: %s", what, server, err)
+				return
+			}
+			req.Header.Set(common.PeerHeader, "1")
+			resp, err := d.peerClient.Do(req)
+			if err != nil {
+				logger.Warningf("Unable to send %s to %s: %s", what, server, err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				logger.Warningf("Unable to send %s to %s: %s", what, server, resp.Status)
+			}
+		}(server)
+	}
+}
+
+// aclPath returns the path the ACL of prefix is served at.
+func aclPath(prefix string) string {
+	bucket, name := common.SplitLocation(prefix)
+	if bucket == "" {
+		return "/acl/" + name
+	}
+	if name == "" {
+		return "/buckets/" + bucket + "/acl"
+	}
+	return "/buckets/" + bucket + "/acl/" + name
+}
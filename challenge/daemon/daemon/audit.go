@@ -0,0 +1,42 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package daemon
+
+import (
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+// BlobID returns the ID of the blob at location here, if there is one.
+func (d *Daemon) BlobID(location string) (uint16, bool) {
+	d.blobMU.RLock()
+	defer d.blobMU.RUnlock()
+	if bb := d.lookupBlobByLocation(location); bb != nil {
+		return bb.ID, true
+	}
+	return 0, false
+}
+
+// Auditing tells whether requests are recorded in the audit log.
+func (d *Daemon) Auditing() bool {
+	return d.audit != nil
+}
+
+// Audit appends rec to the audit log.
+func (d *Daemon) Audit(rec *types.AuditRecord) {
+	if d.audit == nil {
+		return
+	}
+	if err := d.audit.Append(rec); err != nil {
+		logger.Errorf("Unable to record %s %s by %s in the audit log: %s", rec.Method, rec.Path, rec.Principal, err)
+	}
+}
@@ -0,0 +1,150 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package daemon
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/auth"
+)
+
+const presignKeyFileName = "presign.key"
+
+// peerTransport adds our API key to the requests sent to other servers,
+// unless they carry one already, as forwarded client requests do.
+type peerTransport struct {
+	key  string
+	base http.RoundTripper
+}
+
+func (t *peerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.key == "" || req.Header.Get(common.APIKeyHeader) != "" {
+		return t.base.RoundTrip(req)
+	}
+	// a RoundTripper must not modify the request it is given
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set(common.APIKeyHeader, t.key)
+	return t.base.RoundTrip(r)
+}
+
+// newPeerClient returns a client for requests to other servers, sending
+// our peer key and, with TLS, our certificate. A timeout of 0 means none.
+func (d *Daemon) newPeerClient(timeout time.Duration) *http.Client {
+	t := &peerTransport{key: d.conf.PeerKey, base: http.DefaultTransport}
+	if d.certs != nil {
+		t.base = &http.Transport{
+			Proxy:   http.ProxyFromEnvironment,
+			DialTLS: d.certs.DialTLS,
+		}
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: t,
+	}
+}
+
+// creatorOf returns who a write request is made for: its principal, or the
+// client a server vouches for with the creator header. Nobody when
+// authentication is off.
+func creatorOf(r *http.Request) string {
+	p, ok := auth.FromContext(r.Context())
+	if !ok {
+		return ""
+	}
+	if v := r.Header.Get(common.CreatorHeader); v != "" && p.Allowed(types.PermAdmin, "") {
+		return v
+	}
+	if p.Method == auth.MethodNone {
+		return ""
+	}
+	return p.Name
+}
+
+// Authenticate tells who r comes from.
+func (d *Daemon) Authenticate(r *http.Request) (*types.Principal, error) {
+	return d.auth.Authenticate(r)
+}
+
+// loadPresignKey returns the key presigned URLs are signed with: the
+// configured secret, which servers accepting each other's URLs must share,
+// else a random key kept in the data directory.
+func (d *Daemon) loadPresignKey() ([]byte, error) {
+	if d.conf.PresignSecret != "" {
+		return []byte(d.conf.PresignSecret), nil
+	}
+	path := filepath.Join(d.conf.DataDirBasePath, presignKeyFileName)
+	buf, err := ioutil.ReadFile(path)
+	if err == nil {
+		return []byte(strings.TrimSpace(string(buf))), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	key := hex.EncodeToString(b)
+	if err := os.MkdirAll(d.conf.DataDirBasePath, 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, []byte(key+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	return []byte(key), nil
+}
+
+// Presign returns a URL, on base, letting whoever has it send req.Method
+// requests to req.Location for req.ExpiresIn seconds, as p. p must be
+// allowed to, else auth.ErrForbidden is returned.
+func (d *Daemon) Presign(p *types.Principal, req *types.PresignRequest, base string) (*types.PresignedURL, error) {
+	expiry := auth.DefaultPresignExpiry
+	if req.ExpiresIn != 0 {
+		expiry = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if expiry <= 0 || expiry > auth.MaxPresignExpiry {
+		return nil, fmt.Errorf("expiry must be between 1s and %s", auth.MaxPresignExpiry)
+	}
+	perm, err := auth.PresignPerm(req.Method)
+	if err != nil {
+		return nil, err
+	}
+	if !d.Allowed(p, perm, req.Location) {
+		return nil, auth.ErrForbidden
+	}
+	expires := time.Now().Add(expiry).Truncate(time.Second)
+	u, err := d.auth.Presign(p, req.Method, req.Location, expires)
+	if err != nil {
+		return nil, err
+	}
+	return &types.PresignedURL{
+		URL:     strings.TrimSuffix(base, "/") + u,
+		Method:  req.Method,
+		Expires: expires,
+	}, nil
+}
@@ -13,22 +13,50 @@
 package daemon
 
 import (
-	"bufio"
-	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"math/rand"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 
 	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
 	"github.com/Arvinderpal/go-storage-server/challenge/pkg/blob"
 	"github.com/Arvinderpal/go-storage-server/challenge/pkg/option"
 )
 
+var errBlobNotFound = errors.New("blob not found")
+
 func (d *Daemon) GetBlob(location string, w http.ResponseWriter, r *http.Request) error {
+	if err := d.checkBucket(location, r); err != nil {
+		return err
+	}
+	if version := r.URL.Query().Get("version"); version != "" {
+		// versions are kept by each server on its own
+		return d.getVersion(location, version, w)
+	}
+	if d.raft != nil && r.Header.Get(common.PeerHeader) == "" &&
+		r.Header.Get(common.ConsistencyHeader) == ConsistencyLinearizable {
+		return d.raftRead(location, w, r)
+	}
+	if d.quorum != nil && r.Header.Get(common.PeerHeader) == "" {
+		need, err := d.quorum.readQuorum(location, r.Header.Get(common.ConsistencyHeader))
+		if err != nil {
+			return err
+		}
+		if need > 1 {
+			return d.quorumRead(location, need, w)
+		}
+	}
+	return d.getBlob(location, w)
+}
+
+// getBlob writes the data of the blob at location to w.
+func (d *Daemon) getBlob(location string, w http.ResponseWriter) error {
 
 	var bbCpy *blob.Blob
 	logger.Debugf("Getting Blob: %s", location)
@@ -47,15 +75,78 @@ func (d *Daemon) GetBlob(location string, w http.ResponseWriter, r *http.Request
 	// if the data file is also removed, the reader will throw an error.
 	bbCpy = tmpBb.DeepCopy()
 	tmpBb.UpdateMU.RUnlock()
+	if bbCpy.Status.LastStatus() == blob.Failure || expired(bbCpy) {
+		// its write failed, e.g. on a body not matching its signature, or
+		// it expired; gc removes it
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
 
-	if err := readDataFromDisk(w, bbCpy); err != nil {
+	w.Header().Set(common.VersionHeader, strconv.FormatInt(bbCpy.Version, 10))
+	if bbCpy.Creator != "" {
+		w.Header().Set(common.CreatorHeader, bbCpy.Creator)
+	}
+	if err := d.readData(w, bbCpy); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// HeadBlob tells whether the blob at location exists and its version.
+func (d *Daemon) HeadBlob(location string, w http.ResponseWriter, r *http.Request) error {
+	if err := d.checkBucket(location, r); err != nil {
+		return err
+	}
+	version, exists := d.localVersion(location)
+	if exists && r.Header.Get(common.PeerHeader) == "" && d.blobExpired(location) {
+		exists = false
+	}
+	if version != 0 {
+		w.Header().Set(common.VersionHeader, strconv.FormatInt(version, 10))
+	}
+	if creator := d.blobCreator(location); creator != "" {
+		w.Header().Set(common.CreatorHeader, creator)
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
 func (d *Daemon) CreateBlob(location string, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	body, err := d.admitWrite(location, r)
+	if err != nil {
+		return err
+	}
+	r.Body = body
+	if d.raft != nil {
+		return body.Check(d.raftWrite(types.OpCreate, location, w, r))
+	}
+	if r.Header.Get(common.PeerHeader) != "" {
+		return d.applyFromPeer(types.OpCreate, location, r)
+	}
+	if d.quorum != nil {
+		err = d.quorumWrite(types.OpCreate, location, r)
+	} else {
+		err = d.createBlob(location, 0, creatorOf(r), r.Body)
+	}
+	if err != nil {
+		return body.Check(err)
+	}
+	d.publishEvent(types.OpCreate, location)
+	return nil
+}
+
+// createBlob creates a new blob at location holding the data read from body,
+// written by creator. A version of 0 picks a new one.
+func (d *Daemon) createBlob(location string, version int64, creator string, body io.Reader) error {
 
 	logger.Debugf("Creating Blob: %s", location)
 
@@ -66,7 +157,10 @@ func (d *Daemon) CreateBlob(location string, w http.ResponseWriter, r *http.Requ
 	}
 	d.blobMU.RUnlock()
 
-	bb, err := d.createAndInsertBlob(location)
+	if version == 0 {
+		version = d.newVersion(location)
+	}
+	bb, err := d.createAndInsertBlob(location, version, creator)
 	if err != nil {
 		return err
 	}
@@ -75,7 +169,7 @@ func (d *Daemon) CreateBlob(location string, w http.ResponseWriter, r *http.Requ
 		bb.UpdateMU.Lock()
 		defer bb.UpdateMU.Unlock()
 
-		if err := d.snapshotBlob(bb); err != nil {
+		if err := d.store.PutState(bb); err != nil {
 			bb.LogStatus(blob.Failure, err.Error())
 		} else {
 			bb.LogStatusOK("Blob Created & Saved!")
@@ -85,41 +179,80 @@ func (d *Daemon) CreateBlob(location string, w http.ResponseWriter, r *http.Requ
 		if bb.Status.LastStatus() == blob.OK {
 			logger.Debugf("Processing data for blob %d %s", bb.ID, bb.Location)
 			bb.LogStatusPending("Starting Data WR")
-			if err := writeBlobStateFile(bb); err != nil { // update disk
+			if err := d.store.PutState(bb); err != nil { // update store
 				return err
 			}
-			if err := writeDataToDisk(r, bb); err != nil {
+			if err := d.writeData(body, bb); err != nil {
 				return err
 			}
 			bb.LogStatusOK("Blob Data WR Complete!")
-			if err := writeBlobStateFile(bb); err != nil { // update disk
+			if err := d.store.PutState(bb); err != nil { // update store
 				return err
 			}
+			d.quota.Add(bb.ID, bb.Location, bb.Creator, bb.Size)
 		}
 		return nil
 	}
 
 	if err := processBlob(); err != nil {
-		bb.LogStatus(blob.Failure, err.Error())
+		d.dropFailed(bb, err)
 		return err
 	}
 
+	d.changes.record(types.OpCreate, location)
 	return nil
 }
 
 func (d *Daemon) UpdateBlob(location string, w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	body, err := d.admitWrite(location, r)
+	if err != nil {
+		return err
+	}
+	r.Body = body
+	if d.raft != nil {
+		return body.Check(d.raftWrite(types.OpUpdate, location, w, r))
+	}
+	if r.Header.Get(common.PeerHeader) != "" {
+		return d.applyFromPeer(types.OpUpdate, location, r)
+	}
+	if d.quorum != nil {
+		err = d.quorumWrite(types.OpUpdate, location, r)
+	} else {
+		err = d.updateBlob(location, 0, creatorOf(r), r.Body)
+	}
+	if err == errBlobNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		return body.Check(err)
+	}
+	d.publishEvent(types.OpUpdate, location)
+	return nil
+}
+
+// updateBlob replaces the blob at location with one holding the data read
+// from body, written by creator. A version of 0 picks a new one.
+func (d *Daemon) updateBlob(location string, version int64, creator string, body io.Reader) error {
 
 	logger.Debugf("Updating Blob: %s", location)
 	d.blobMU.RLock()
 	bb := d.lookupBlobByLocation(location)
 	if bb == nil {
 		d.blobMU.RUnlock()
-		w.WriteHeader(http.StatusNotFound)
-		return nil
+		return errBlobNotFound
 	}
 	d.blobMU.RUnlock()
 
-	newBb, oldBb, err := d.deleteAndInsertBlob(location)
+	if version == 0 {
+		version = d.newVersion(location)
+	}
+	versioned := d.versioned(location)
+	newBb, oldBb, err := d.deleteAndInsertBlob(location, version, creator, versioned)
 	if err != nil {
 		return err
 	}
@@ -130,13 +263,20 @@ func (d *Daemon) UpdateBlob(location string, w http.ResponseWriter, r *http.Requ
 		oldBb.UpdateMU.Lock() // possible oldBb is still being worked by another thread, so we'll have to wait for it to finish
 		defer oldBb.UpdateMU.Unlock()
 
-		// we mark the old blob with Failure, to be reclaimed by GC
-		oldBb.LogStatus(blob.Failure, "Deleted!")
-		if err := writeBlobStateFile(oldBb); err != nil { // update disk
+		if versioned {
+			// the old blob stays as a noncurrent version
+			oldBb.Noncurrent = true
+			oldBb.LogStatusOK("Replaced, kept as a noncurrent version")
+		} else {
+			// we mark the old blob with Failure, to be reclaimed by GC
+			d.quota.Remove(oldBb.ID)
+			oldBb.LogStatus(blob.Failure, "Deleted!")
+		}
+		if err := d.store.PutState(oldBb); err != nil { // update store
 			return err
 		}
 
-		if err := d.snapshotBlob(newBb); err != nil {
+		if err := d.store.PutState(newBb); err != nil {
 			newBb.LogStatus(blob.Failure, err.Error())
 		} else {
 			newBb.LogStatusOK("Blob Updated & Saved!")
@@ -146,29 +286,70 @@ func (d *Daemon) UpdateBlob(location string, w http.ResponseWriter, r *http.Requ
 		if newBb.Status.LastStatus() == blob.OK {
 			logger.Debugf("Processing data for blob %d %s", newBb.ID, newBb.Location)
 			newBb.LogStatusPending("Starting Data WR")
-			if err := writeBlobStateFile(newBb); err != nil { // update disk
+			if err := d.store.PutState(newBb); err != nil { // update store
 				return err
 			}
-			if err := writeDataToDisk(r, newBb); err != nil {
+			if err := d.writeData(body, newBb); err != nil {
 				return err
 			}
 			newBb.LogStatusOK("Blob Data WR Complete!")
-			if err := writeBlobStateFile(newBb); err != nil { // update disk
+			if err := d.store.PutState(newBb); err != nil { // update store
 				return err
 			}
+			d.quota.Add(newBb.ID, newBb.Location, newBb.Creator, newBb.Size)
 		}
 		return nil
 	}
 
 	if err := processBlob(); err != nil {
-		newBb.LogStatus(blob.Failure, err.Error())
+		d.dropFailed(newBb, err)
 		return err
 	}
 
+	d.changes.record(types.OpUpdate, location)
 	return nil
 }
 
 func (d *Daemon) DeleteBlob(location string, w http.ResponseWriter, r *http.Request) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if err := d.checkBucket(location, r); err != nil {
+		return err
+	}
+	if version := r.URL.Query().Get("version"); version != "" {
+		err := d.purgeVersion(location, version)
+		if err == errBlobNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return nil
+		}
+		return err
+	}
+	if d.raft != nil {
+		return d.raftWrite(types.OpDelete, location, w, r)
+	}
+	if r.Header.Get(common.PeerHeader) != "" {
+		return d.applyFromPeer(types.OpDelete, location, r)
+	}
+	var err error
+	if d.quorum != nil {
+		err = d.quorumWrite(types.OpDelete, location, r)
+	} else {
+		err = d.removeBlob(location, 0)
+	}
+	if err == errBlobNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	d.publishEvent(types.OpDelete, location)
+	return nil
+}
+
+// removeBlob deletes the blob at location. A version of 0 picks a new one.
+func (d *Daemon) removeBlob(location string, version int64) error {
 
 	logger.Debugf("Deleting Blob: %s", location)
 
@@ -176,20 +357,27 @@ func (d *Daemon) DeleteBlob(location string, w http.ResponseWriter, r *http.Requ
 	bb := d.lookupBlobByLocation(location)
 	if bb == nil {
 		d.blobMU.RUnlock()
-		w.WriteHeader(http.StatusNotFound)
-		return nil
+		return errBlobNotFound
 	}
 	d.blobMU.RUnlock()
 
+	versioned := d.versioned(location)
 	processBlob := func() error {
 		bb.UpdateMU.Lock()
 		defer bb.UpdateMU.Unlock()
 
-		// we change the blob state to "failure" and remove it from the
-		// daemon maps. a failed blob's data will be cleaned up by GC()
 		logger.Debugf("Deleting blob %d %s", bb.ID, bb.Location)
-		bb.LogStatus(blob.Failure, "Deleted!")
-		if err := writeBlobStateFile(bb); err != nil { // update disk
+		if versioned {
+			// the blob stays as a noncurrent version
+			bb.Noncurrent = true
+			bb.LogStatusOK("Deleted, kept as a noncurrent version")
+		} else {
+			// we change the blob state to "failure" and remove it from the
+			// daemon maps. a failed blob's data will be cleaned up by GC()
+			d.quota.Remove(bb.ID)
+			bb.LogStatus(blob.Failure, "Deleted!")
+		}
+		if err := d.store.PutState(bb); err != nil { // update store
 			return err
 		}
 		return nil
@@ -200,71 +388,97 @@ func (d *Daemon) DeleteBlob(location string, w http.ResponseWriter, r *http.Requ
 		return err
 	}
 
+	if version == 0 {
+		version = d.newVersion(location)
+	}
 	d.blobMU.Lock()
-	d.deleteBlob(bb) // remove the blob from daemon
+	if versioned {
+		d.retireBlob(bb)
+	} else {
+		d.deleteBlob(bb) // remove the blob from daemon
+	}
+	d.tombstones[location] = version
 	d.blobMU.Unlock()
 
+	d.changes.record(types.OpDelete, location)
 	return nil
 }
 
-func writeDataToDisk(r *http.Request, bb *blob.Blob) error {
-
-	blobDir := filepath.Join(".", strconv.Itoa(int(bb.ID)))
-	dataFilePath := filepath.Join(blobDir, common.BlobDataFileName)
-
-	f, err := os.Create(dataFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to open file %s for writing: %s", dataFilePath, err)
-
-	}
-	defer f.Close()
+// byteCounter counts the bytes written to it.
+type byteCounter int64
 
-	fw := bufio.NewWriter(f)
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
 
-	// Read body
-	buf, err := ioutil.ReadAll(r.Body)
-	defer r.Body.Close()
-	if err != nil {
-		return err
+// writeData stores everything read from r as the data of bb, compressed if
+// bb asks for it. The checksum and size are those of the data as read.
+func (d *Daemon) writeData(r io.Reader, bb *blob.Blob) error {
+	h := sha256.New()
+	var size byteCounter
+	data := io.TeeReader(r, io.MultiWriter(h, &size))
+	if bb.Compression == types.CompressionGzip {
+		pr, pw := io.Pipe()
+		defer pr.Close() // stops the compression if the store gives up
+		go func(src io.Reader) {
+			zw := gzip.NewWriter(pw)
+			_, err := io.Copy(zw, src)
+			if err == nil {
+				err = zw.Close()
+			}
+			pw.CloseWithError(err)
+		}(data)
+		data = pr
 	}
-
-	rdr := bytes.NewReader(buf)
-	n, err := rdr.WriteTo(fw)
+	n, err := d.store.PutData(bb.ID, data)
 	if err != nil {
 		return err
 	}
+	bb.Checksum = hex.EncodeToString(h.Sum(nil))
+	bb.Size = int64(size)
 
-	logger.Debugf("Wrote %d bytes for blob %d/%s", n, bb.ID, bb.Location)
-
-	fw.Flush()
+	logger.Debugf("Wrote %d bytes (%d stored) for blob %d/%s", bb.Size, n, bb.ID, bb.Location)
 	return nil
 }
 
-// readDataFromDisk will read blob data file and write to the http.ResponseWriter
-func readDataFromDisk(w http.ResponseWriter, bb *blob.Blob) error {
+// gzipReadCloser closes the data a gzip.Reader reads along with it.
+type gzipReadCloser struct {
+	*gzip.Reader
+	rc io.ReadCloser
+}
+
+func (z *gzipReadCloser) Close() error {
+	z.Reader.Close()
+	return z.rc.Close()
+}
 
-	blobDir := filepath.Join(".", strconv.Itoa(int(bb.ID)))
-	blobFiles, err := ioutil.ReadDir(blobDir)
-	if err != nil {
-		return fmt.Errorf("Error while reading directory %q: %s", blobDir, err)
+// openData returns a reader over the data of bb as it was written,
+// decompressing it if needed. The caller must close it.
+func (d *Daemon) openData(bb *blob.Blob) (io.ReadCloser, error) {
+	rc, err := d.store.GetData(bb.ID)
+	if err != nil || bb.Compression != types.CompressionGzip {
+		return rc, err
 	}
-	dataFile := FindBlobDataFile(blobDir, blobFiles)
-	if dataFile == "" {
-		return fmt.Errorf("Data file %q not found in %q",
-			common.BlobDataFileName, blobDir)
+	zr, err := gzip.NewReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
 	}
+	return &gzipReadCloser{zr, rc}, nil
+}
 
-	fw, err := os.Open(dataFile)
+// readData will read blob data from the store and write to the http.ResponseWriter
+func (d *Daemon) readData(w http.ResponseWriter, bb *blob.Blob) error {
+	rc, err := d.openData(bb)
 	if err != nil {
-		return fmt.Errorf("Error while opening data file for %d %s: %s", bb.ID, bb.Location, err)
+		return fmt.Errorf("Error while opening data for %d %s: %s", bb.ID, bb.Location, err)
 	}
-	buf, err := ioutil.ReadAll(fw)
-	rdr := bytes.NewReader(buf)
-	defer fw.Close()
+	defer rc.Close()
 
 	// write response
 	w.WriteHeader(http.StatusOK)
-	n, err := rdr.WriteTo(w)
+	n, err := io.Copy(w, rc)
 	if err != nil {
 		return err
 	}
@@ -275,7 +489,7 @@ func readDataFromDisk(w http.ResponseWriter, bb *blob.Blob) error {
 }
 
 // createAndInsertBlob is a util method for creating a blob obj and inserting it into the daemon maps
-func (d *Daemon) createAndInsertBlob(location string) (*blob.Blob, error) {
+func (d *Daemon) createAndInsertBlob(location string, version int64, creator string) (*blob.Blob, error) {
 	d.blobMU.Lock()
 	defer d.blobMU.Unlock()
 
@@ -283,17 +497,15 @@ func (d *Daemon) createAndInsertBlob(location string) (*blob.Blob, error) {
 	if err != nil {
 		return nil, err
 	}
-	bb := &blob.Blob{
-		ID:       id,
-		Location: location,
-	}
+	bb := d.newBlob(id, location, version, creator)
 	// we insert blob even in case of error later -- gc/cleanup should handle removal of any state created
 	d.insertBlob(bb)
 	return bb, nil
 }
 
-// deleteAndInsertBlob is a util method for deleting a blob struct from daemon and creating another one with same name/location
-func (d *Daemon) deleteAndInsertBlob(location string) (*blob.Blob, *blob.Blob, error) {
+// deleteAndInsertBlob is a util method for deleting a blob struct from daemon and creating another one with same name/location.
+// If keep is set, the old blob stays as a noncurrent version.
+func (d *Daemon) deleteAndInsertBlob(location string, version int64, creator string, keep bool) (*blob.Blob, *blob.Blob, error) {
 	d.blobMU.Lock()
 	defer d.blobMU.Unlock()
 
@@ -302,21 +514,60 @@ func (d *Daemon) deleteAndInsertBlob(location string) (*blob.Blob, *blob.Blob, e
 		return nil, nil, fmt.Errorf("Could not find %s", location)
 	}
 
-	d.deleteBlob(oldBb)
+	if keep {
+		d.retireBlob(oldBb)
+	} else {
+		d.deleteBlob(oldBb)
+	}
 
 	id, err := d.generateBlobID()
 	if err != nil {
 		return nil, nil, err
 	}
-	newBb := &blob.Blob{
-		ID:       id,
-		Location: location,
-	}
+	newBb := d.newBlob(id, location, version, creator)
 
 	d.insertBlob(newBb)
 	return newBb, oldBb, nil
 }
 
+// blobExpired tells whether the blob at location is past its expiry time.
+func (d *Daemon) blobExpired(location string) bool {
+	d.blobMU.RLock()
+	defer d.blobMU.RUnlock()
+	bb := d.lookupBlobByLocation(location)
+	return bb != nil && expired(bb)
+}
+
+// blobCreator returns who wrote the blob at location, if known.
+func (d *Daemon) blobCreator(location string) string {
+	d.blobMU.RLock()
+	bb := d.lookupBlobByLocation(location)
+	d.blobMU.RUnlock()
+	if bb == nil {
+		return ""
+	}
+	bb.UpdateMU.RLock()
+	defer bb.UpdateMU.RUnlock()
+	return bb.Creator
+}
+
+// dropFailed marks bb, whose write failed with err, as failed for gc to
+// reclaim and forgets it, so that its location may be written again.
+func (d *Daemon) dropFailed(bb *blob.Blob, err error) {
+	bb.UpdateMU.Lock()
+	bb.LogStatus(blob.Failure, err.Error())
+	if err := d.store.PutState(bb); err != nil {
+		logger.Warningf("Unable to save the state of failed blob %d/%s: %s", bb.ID, bb.Location, err)
+	}
+	bb.UpdateMU.Unlock()
+
+	d.blobMU.Lock()
+	if d.blobsLocMap[bb.Location] == bb {
+		d.deleteBlob(bb)
+	}
+	d.blobMU.Unlock()
+}
+
 // MUST be called with blobMU lock held
 func (d *Daemon) deleteBlob(bb *blob.Blob) {
 	delete(d.blobsIDMap, bb.ID)
@@ -352,57 +603,10 @@ func (d *Daemon) insertBlob(bb *blob.Blob) {
 
 	if bb.Location != "" {
 		d.blobsLocMap[bb.Location] = bb
+		delete(d.tombstones, bb.Location)
 	}
 }
 
-// snapshotBlob will create the directory where the blob struct and
-// associated data will be stored. It will then call writeBlobStateFile
-// to store blob struct in the state file.
-// Directory name is simply the blob ID
-func (d *Daemon) snapshotBlob(bb *blob.Blob) error {
-
-	blobDir := filepath.Join(".", strconv.Itoa(int(bb.ID)))
-
-	if err := os.MkdirAll(blobDir, 0777); err != nil {
-		return fmt.Errorf("Failed to create endpoint directory: %s", err)
-	}
-
-	if err := writeBlobStateFile(bb); err != nil {
-		return err
-	}
-	return nil
-}
-
-func writeBlobStateFile(bb *blob.Blob) error {
-
-	blobDir := filepath.Join(".", strconv.Itoa(int(bb.ID)))
-	stateFilePath := filepath.Join(blobDir, common.BlobStateFileName)
-
-	f, err := os.Create(stateFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to open file %s for writing: %s", stateFilePath, err)
-
-	}
-	defer f.Close()
-
-	fw := bufio.NewWriter(f)
-
-	if bbStr64, err := bb.Base64(); err != nil {
-		return fmt.Errorf("Unable to create a base64: %s", err)
-	} else {
-		fmt.Fprintf(fw, "%s%s:%s\n", common.BlobStateFilePrefix,
-			common.Version, bbStr64)
-	}
-	fw.WriteString("\n")
-
-	// We dump status log primarily for debugability.
-	fmt.Fprint(fw, bb.Status.DumpLog())
-
-	fw.WriteString("\n")
-
-	return fw.Flush()
-}
-
 func (d *Daemon) generateBlobID() (uint16, error) {
 
 	var id uint16
@@ -422,14 +626,3 @@ func (d *Daemon) generateBlobID() (uint16, error) {
 	// This is bad! Either we have an internal error or user is trying to create > 2^16 blobs
 	return 0, fmt.Errorf("Could not find an ID to allocate! Remove blobs to continue")
 }
-
-// FindBlobDataFile returns the full path of the file that is the Blob data
-// file from the slice of files
-func FindBlobDataFile(basePath string, blobFiles []os.FileInfo) string {
-	for _, blobFile := range blobFiles {
-		if blobFile.Name() == common.BlobDataFileName {
-			return filepath.Join(basePath, blobFile.Name())
-		}
-	}
-	return ""
-}
@@ -0,0 +1,406 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/auth"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/blob"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/bucket"
+)
+
+const bucketsFileName = "buckets.json"
+
+// byVersion sorts blobs by version, oldest first.
+type byVersion []*blob.Blob
+
+func (a byVersion) Len() int           { return len(a) }
+func (a byVersion) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byVersion) Less(i, j int) bool { return a[i].Version < a[j].Version }
+
+// bucketOf returns the bucket location is in, nil outside of buckets, or
+// bucket.ErrNotFound.
+func (d *Daemon) bucketOf(location string) (*types.Bucket, error) {
+	name, _ := common.SplitLocation(location)
+	if name == "" {
+		return nil, nil
+	}
+	return d.buckets.Get(name)
+}
+
+// settingsOf returns the settings of the bucket of location. Locations
+// outside of buckets, and in buckets we don't know about (yet) as those
+// written by peers may be, get none.
+func (d *Daemon) settingsOf(location string) types.Bucket {
+	b, err := d.bucketOf(location)
+	if err != nil || b == nil {
+		return types.Bucket{}
+	}
+	return *b
+}
+
+// checkBucket returns bucket.ErrNotFound for a client request to a
+// location in a bucket that does not exist.
+func (d *Daemon) checkBucket(location string, r *http.Request) error {
+	if r.Header.Get(common.PeerHeader) != "" {
+		return nil
+	}
+	_, err := d.bucketOf(location)
+	return err
+}
+
+// newBlob returns the blob for a write of the given version at location,
+// by creator, following the settings of its bucket.
+func (d *Daemon) newBlob(id uint16, location string, version int64, creator string) *blob.Blob {
+	b := d.settingsOf(location)
+	bb := &blob.Blob{
+		ID:          id,
+		Location:    location,
+		Version:     version,
+		Creator:     creator,
+		Compression: b.Compression,
+	}
+	if b.DefaultTTL > 0 {
+		bb.Expires = version + b.DefaultTTL*int64(time.Second)
+	}
+	return bb
+}
+
+// expired tells whether bb is past its expiry time.
+func expired(bb *blob.Blob) bool {
+	return bb.Expires != 0 && bb.Expires <= time.Now().UnixNano()
+}
+
+// expireBlobs deletes the blobs past their expiry time. The delete gets
+// the expiry time as version, the same on every server holding the blob.
+func (d *Daemon) expireBlobs() {
+	due := []*blob.Blob{}
+	d.blobMU.RLock()
+	for _, bb := range d.blobsLocMap {
+		if expired(bb) {
+			due = append(due, bb)
+		}
+	}
+	d.blobMU.RUnlock()
+
+	for _, bb := range due {
+		mu := d.lockLocation(bb.Location)
+		mu.Lock()
+		// unless it was written again meanwhile
+		if version, exists := d.localVersion(bb.Location); exists && version == bb.Version {
+			if err := d.removeBlob(bb.Location, bb.Expires); err != nil {
+				logger.Warningf("Unable to expire blob %d/%s: %s", bb.ID, bb.Location, err)
+			} else {
+				logger.Debugf("Expired blob %d/%s", bb.ID, bb.Location)
+			}
+		}
+		mu.Unlock()
+	}
+}
+
+// versioned tells whether location is in a versioned bucket.
+func (d *Daemon) versioned(location string) bool {
+	return d.settingsOf(location).Versioning
+}
+
+// retireBlob keeps bb, replaced or deleted, as a noncurrent version of its
+// location. MUST be called with blobMU lock held.
+func (d *Daemon) retireBlob(bb *blob.Blob) {
+	if d.blobsLocMap[bb.Location] == bb {
+		delete(d.blobsLocMap, bb.Location)
+	}
+	d.insertVersion(bb)
+}
+
+// insertVersion inserts bb as a noncurrent version of its location. MUST be
+// called with blobMU lock held.
+func (d *Daemon) insertVersion(bb *blob.Blob) {
+	d.blobsIDMap[bb.ID] = bb
+	d.versions[bb.Location] = append(d.versions[bb.Location], bb)
+}
+
+// lookupVersion returns the noncurrent version of location, or nil. MUST be
+// called with blobMU lock held.
+func (d *Daemon) lookupVersion(location string, version int64) *blob.Blob {
+	for _, bb := range d.versions[location] {
+		if bb.Version == version {
+			return bb
+		}
+	}
+	return nil
+}
+
+// dropVersion forgets the noncurrent version bb. MUST be called with blobMU
+// lock held.
+func (d *Daemon) dropVersion(bb *blob.Blob) {
+	delete(d.blobsIDMap, bb.ID)
+	versions := d.versions[bb.Location]
+	for i, v := range versions {
+		if v == bb {
+			versions = append(versions[:i], versions[i+1:]...)
+			break
+		}
+	}
+	if len(versions) == 0 {
+		delete(d.versions, bb.Location)
+	} else {
+		d.versions[bb.Location] = versions
+	}
+}
+
+// getVersion writes the data of the given version of the blob at location
+// to w, current or not.
+func (d *Daemon) getVersion(location, version string, w http.ResponseWriter) error {
+	v, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid version %q", version)
+	}
+	d.blobMU.RLock()
+	bb := d.lookupVersion(location, v)
+	d.blobMU.RUnlock()
+	if bb == nil {
+		if cur, exists := d.localVersion(location); exists && cur == v {
+			return d.getBlob(location, w)
+		}
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	bb.UpdateMU.RLock()
+	bbCpy := bb.DeepCopy()
+	bb.UpdateMU.RUnlock()
+	w.Header().Set(common.VersionHeader, strconv.FormatInt(bbCpy.Version, 10))
+	if bbCpy.Creator != "" {
+		w.Header().Set(common.CreatorHeader, bbCpy.Creator)
+	}
+	return d.readData(w, bbCpy)
+}
+
+// purgeVersion deletes for good the given noncurrent version of the blob at
+// location.
+func (d *Daemon) purgeVersion(location, version string) error {
+	v, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid version %q", version)
+	}
+	d.blobMU.Lock()
+	bb := d.lookupVersion(location, v)
+	if bb == nil {
+		d.blobMU.Unlock()
+		return errBlobNotFound
+	}
+	d.dropVersion(bb)
+	d.blobMU.Unlock()
+
+	bb.UpdateMU.Lock()
+	defer bb.UpdateMU.Unlock()
+	d.quota.Remove(bb.ID)
+	// gc reclaims it
+	bb.LogStatus(blob.Failure, "Deleted!")
+	return d.store.PutState(bb)
+}
+
+// Versions returns the versions of the blob at location, newest first.
+func (d *Daemon) Versions(location string) ([]types.BlobVersion, error) {
+	if _, err := d.bucketOf(location); err != nil {
+		return nil, err
+	}
+	d.blobMU.RLock()
+	blobs := append([]*blob.Blob(nil), d.versions[location]...)
+	cur := d.lookupBlobByLocation(location)
+	d.blobMU.RUnlock()
+	if cur != nil {
+		blobs = append(blobs, cur)
+	}
+
+	list := []types.BlobVersion{}
+	for i := len(blobs) - 1; i >= 0; i-- {
+		bb := blobs[i]
+		bb.UpdateMU.RLock()
+		list = append(list, types.BlobVersion{
+			Version: bb.Version,
+			Size:    bb.Size,
+			Creator: bb.Creator,
+			Current: bb == cur,
+		})
+		bb.UpdateMU.RUnlock()
+	}
+	return list, nil
+}
+
+// requireAdmin returns auth.ErrForbidden unless p may administer the
+// server, as managing buckets requires.
+func requireAdmin(p *types.Principal) error {
+	if !p.Allowed(types.PermAdmin, "") {
+		return auth.ErrForbidden
+	}
+	return nil
+}
+
+// checkSettings returns an error if the settings of b are invalid.
+func checkSettings(b *types.Bucket) error {
+	if !common.ValidBucketName(b.Name) {
+		return fmt.Errorf("invalid bucket name %q", b.Name)
+	}
+	if b.Compression != "" && b.Compression != types.CompressionGzip {
+		return fmt.Errorf("unknown compression %q", b.Compression)
+	}
+	if b.DefaultTTL < 0 || b.QuotaBytes < 0 || b.QuotaObjects < 0 {
+		return fmt.Errorf("TTL and quotas can't be negative")
+	}
+	return nil
+}
+
+// Buckets returns the buckets p may read from, with their usage here.
+func (d *Daemon) Buckets(p *types.Principal) ([]types.Bucket, error) {
+	list := []types.Bucket{}
+	for _, b := range d.buckets.List() {
+		if !d.Allowed(p, types.PermRead, common.BucketLocation(b.Name, "")) {
+			continue
+		}
+		usage := d.quota.Bucket(b.Name)
+		b.Usage = &usage
+		list = append(list, b)
+	}
+	return list, nil
+}
+
+// Bucket returns the bucket called name, with its usage here.
+func (d *Daemon) Bucket(name string) (*types.Bucket, error) {
+	b, err := d.buckets.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	usage := d.quota.Bucket(name)
+	b.Usage = &usage
+	return b, nil
+}
+
+// CreateBucket creates the bucket b for p, and on the other servers.
+func (d *Daemon) CreateBucket(p *types.Principal, b *types.Bucket) (*types.Bucket, error) {
+	if err := requireAdmin(p); err != nil {
+		return nil, err
+	}
+	if err := checkSettings(b); err != nil {
+		return nil, err
+	}
+	b.Created = time.Now()
+	b.Updated = b.Created
+	if err := d.bucketStore.AddBucket(b.Name); err != nil {
+		return nil, err
+	}
+	if err := d.buckets.Add(b); err != nil {
+		return nil, err
+	}
+	d.sendBucket(b)
+	return d.Bucket(b.Name)
+}
+
+// SetBucket changes the settings of the bucket b for p, and on the other
+// servers. A bucket sent by a server is stored as is, created if needed,
+// unless we hold a later one.
+func (d *Daemon) SetBucket(p *types.Principal, b *types.Bucket, fromPeer bool) (*types.Bucket, error) {
+	if err := requireAdmin(p); err != nil {
+		return nil, err
+	}
+	if err := checkSettings(b); err != nil {
+		return nil, err
+	}
+	if fromPeer {
+		if err := d.bucketStore.AddBucket(b.Name); err != nil {
+			return nil, err
+		}
+		_, err := d.buckets.Set(b)
+		return b, err
+	}
+
+	cur, err := d.buckets.Get(b.Name)
+	if err != nil {
+		return nil, err
+	}
+	b.Created = cur.Created
+	b.Updated = time.Now()
+	if !b.Updated.After(cur.Updated) {
+		b.Updated = cur.Updated.Add(time.Nanosecond)
+	}
+	if _, err := d.buckets.Set(b); err != nil {
+		return nil, err
+	}
+	d.sendBucket(b)
+	return d.Bucket(b.Name)
+}
+
+// sendBucket sends the settings of b to the other servers.
+func (d *Daemon) sendBucket(b *types.Bucket) {
+	buf, err := json.Marshal(b)
+	if err != nil {
+		logger.Warningf("Unable to send bucket %s: %s", b.Name, err)
+		return
+	}
+	d.broadcast("PUT", "/buckets/"+b.Name, "bucket "+b.Name, buf)
+}
+
+// RemoveBucket deletes the bucket called name for p, and from the other
+// servers. A client may only delete an empty bucket; servers sent the
+// delete drop whatever they still hold in it.
+func (d *Daemon) RemoveBucket(p *types.Principal, name string, fromPeer bool) error {
+	if err := requireAdmin(p); err != nil {
+		return err
+	}
+	if !fromPeer {
+		if _, err := d.buckets.Get(name); err != nil {
+			return err
+		}
+		if d.quota.Bucket(name).Objects > 0 {
+			return bucket.ErrNotEmpty
+		}
+	}
+	if err := d.buckets.Remove(name); err != nil && !(fromPeer && err == bucket.ErrNotFound) {
+		return err
+	}
+	if err := d.dropBucket(name); err != nil {
+		return err
+	}
+	if !fromPeer {
+		d.broadcast("DELETE", "/buckets/"+name, "the delete of bucket "+name, nil)
+	}
+	return nil
+}
+
+// dropBucket forgets every blob of the bucket called name and deletes them
+// from the store.
+func (d *Daemon) dropBucket(name string) error {
+	d.blobMU.Lock()
+	for id, bb := range d.blobsIDMap {
+		if b, _ := common.SplitLocation(bb.Location); b == name {
+			delete(d.blobsIDMap, id)
+			delete(d.blobsLocMap, bb.Location)
+			d.quota.Remove(id)
+			delete(d.versions, bb.Location)
+		}
+	}
+	for location := range d.tombstones {
+		if b, _ := common.SplitLocation(location); b == name {
+			delete(d.tombstones, location)
+		}
+	}
+	d.blobMU.Unlock()
+	return d.bucketStore.RemoveBucket(name)
+}
@@ -0,0 +1,300 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+const (
+	changeLogSize     = 10000 // changes kept for replicas and watchers to catch up
+	changesWait       = 30    // in seconds, how long a change stream request waits for new changes
+	changeLogFileName = "changes.jsonl"
+)
+
+// changeLog keeps the most recent changes made to the daemon's blobs and
+// wakes up whoever is waiting for new ones. Changes are appended to a file
+// so that sequence numbers keep increasing across restarts; the file is
+// compacted to the last changeLogSize changes as it grows. The epoch still
+// changes on every start: a crash may lose the change of a write that
+// completed, so replicas resync rather than trust the log.
+type changeLog struct {
+	mu      sync.Mutex
+	epoch   string
+	seq     uint64
+	changes []types.Change
+	notify  chan struct{} // closed and replaced on every new change
+
+	path    string
+	file    *os.File
+	written int // changes in the file
+}
+
+// openChangeLog loads the changes saved at path.
+func openChangeLog(path string) (*changeLog, error) {
+	cl := &changeLog{
+		epoch:  strconv.FormatInt(time.Now().UnixNano(), 36),
+		notify: make(chan struct{}),
+		path:   path,
+	}
+
+	f, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			c := types.Change{}
+			if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+				// the last change may be half written
+				logger.Warningf("Ignoring invalid change at the end of %s: %s", path, err)
+				break
+			}
+			cl.changes = append(cl.changes, c)
+			cl.seq = c.Seq
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		if len(cl.changes) > changeLogSize {
+			cl.changes = cl.changes[len(cl.changes)-changeLogSize:]
+		}
+	}
+
+	if err := cl.rewrite(); err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+// rewrite replaces the file with the changes kept in memory. Must be called
+// with mu held.
+func (cl *changeLog) rewrite() error {
+	tmpPath := cl.path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for i := range cl.changes {
+		if err := enc.Encode(&cl.changes[i]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	if err := os.Rename(tmpPath, cl.path); err != nil {
+		return err
+	}
+
+	if cl.file != nil {
+		cl.file.Close()
+	}
+	if cl.file, err = os.OpenFile(cl.path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return err
+	}
+	cl.written = len(cl.changes)
+	return nil
+}
+
+// record appends a change to the log and returns it.
+func (cl *changeLog) record(op, location string) types.Change {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.seq++
+	c := types.Change{
+		Seq:      cl.seq,
+		Op:       op,
+		Location: location,
+		Time:     time.Now(),
+	}
+	cl.changes = append(cl.changes, c)
+	if len(cl.changes) > changeLogSize {
+		cl.changes = append([]types.Change(nil), cl.changes[len(cl.changes)-changeLogSize:]...)
+	}
+	cl.save(c)
+	close(cl.notify)
+	cl.notify = make(chan struct{})
+	return c
+}
+
+// save appends c to the file. A change that can't be saved is still served
+// from memory; its sequence number may only be reused if we crash before
+// the next change is saved. Must be called with mu held.
+func (cl *changeLog) save(c types.Change) {
+	if cl.written >= 2*changeLogSize {
+		if err := cl.rewrite(); err != nil {
+			logger.Errorf("Unable to compact the change log: %s", err)
+		}
+		return
+	}
+	buf, err := json.Marshal(&c)
+	if err == nil {
+		_, err = cl.file.Write(append(buf, '\n'))
+	}
+	if err == nil {
+		err = cl.file.Sync()
+	}
+	if err != nil {
+		logger.Errorf("Unable to save change %d: %s", c.Seq, err)
+		return
+	}
+	cl.written++
+}
+
+func (cl *changeLog) lastSeq() uint64 {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.seq
+}
+
+// since returns the changes after seq. ok is false if they are not all in
+// the log anymore, or if seq belongs to another epoch. If there are no
+// changes yet, the returned channel is closed once there are.
+func (cl *changeLog) since(epoch string, seq uint64) (changes []types.Change, ok bool, wait <-chan struct{}) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if epoch != cl.epoch || seq > cl.seq {
+		return nil, false, nil
+	}
+	if seq == cl.seq {
+		return []types.Change{}, true, cl.notify
+	}
+	if len(cl.changes) == 0 || cl.changes[0].Seq > seq+1 {
+		return nil, false, nil
+	}
+	first := int(seq + 1 - cl.changes[0].Seq)
+	return append([]types.Change(nil), cl.changes[first:]...), true, nil
+}
+
+// Changes returns the changes made after seq of the given epoch, waiting a
+// while for new ones if there are none yet. If they can't be told, the
+// returned ChangeSet is a reset listing every blob.
+func (d *Daemon) Changes(epoch string, seq uint64) (*types.ChangeSet, error) {
+	changes, ok, wait := d.changes.since(epoch, seq)
+	if ok && wait != nil {
+		select {
+		case <-wait:
+			changes, ok, _ = d.changes.since(epoch, seq)
+		case <-time.After(changesWait * time.Second):
+		}
+	}
+	if ok {
+		return &types.ChangeSet{
+			Epoch:   d.changes.epoch,
+			Seq:     d.changes.lastSeq(),
+			Changes: changes,
+		}, nil
+	}
+
+	// the listing is taken under blobMU and tagged with the sequence number
+	// seen before, so the changes that raced with it get sent again later;
+	// applying them twice is harmless
+	cs := &types.ChangeSet{
+		Epoch: d.changes.epoch,
+		Seq:   d.changes.lastSeq(),
+		Reset: true,
+	}
+	d.blobMU.RLock()
+	for location := range d.blobsLocMap {
+		cs.Changes = append(cs.Changes, types.Change{
+			Seq:      cs.Seq,
+			Op:       types.OpCreate,
+			Location: location,
+		})
+	}
+	d.blobMU.RUnlock()
+	return cs, nil
+}
+
+// Watch returns the changes made after seq to the locations covered by
+// prefix (see types.Covers, blobs of buckets are only covered by prefixes
+// in them), waiting a while for some if there are none yet, or until cancel is
+// closed. The Seq of the returned ChangeSet is where the next call should
+// resume from. If the changes can't be told anymore, it is a reset listing
+// every matching blob.
+func (d *Daemon) Watch(prefix string, seq uint64, cancel <-chan struct{}) (*types.ChangeSet, error) {
+	timeout := time.After(changesWait * time.Second)
+	for {
+		changes, ok, wait := d.changes.since(d.changes.epoch, seq)
+		if !ok {
+			return d.watchReset(prefix), nil
+		}
+		matching := []types.Change{}
+		for _, c := range changes {
+			if types.Covers(prefix, c.Location) {
+				matching = append(matching, c)
+			}
+			seq = c.Seq
+		}
+		if len(matching) > 0 {
+			return &types.ChangeSet{Epoch: d.changes.epoch, Seq: seq, Changes: matching}, nil
+		}
+		if wait == nil {
+			// only changes to other locations, wait for the next ones
+			continue
+		}
+		select {
+		case <-wait:
+		case <-timeout:
+			return &types.ChangeSet{Epoch: d.changes.epoch, Seq: seq, Changes: matching}, nil
+		case <-cancel:
+			return &types.ChangeSet{Epoch: d.changes.epoch, Seq: seq, Changes: matching}, nil
+		}
+	}
+}
+
+func (d *Daemon) watchReset(prefix string) *types.ChangeSet {
+	cs := &types.ChangeSet{
+		Epoch:   d.changes.epoch,
+		Seq:     d.changes.lastSeq(),
+		Reset:   true,
+		Changes: []types.Change{},
+	}
+	d.blobMU.RLock()
+	for location := range d.blobsLocMap {
+		if types.Covers(prefix, location) {
+			cs.Changes = append(cs.Changes, types.Change{
+				Seq:      cs.Seq,
+				Op:       types.OpCreate,
+				Location: location,
+			})
+		}
+	}
+	d.blobMU.RUnlock()
+	return cs
+}
+
+// LastChange returns the sequence number of the latest change.
+func (d *Daemon) LastChange() uint64 {
+	return d.changes.lastSeq()
+}
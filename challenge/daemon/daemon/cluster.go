@@ -0,0 +1,343 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/ring"
+)
+
+const ringFileName = "ring.json"
+
+// cluster spreads blobs over the members of a consistent hash ring. Each
+// location belongs to an owner set of the first members found on the ring;
+// requests for locations we don't own are proxied to an owner.
+type cluster struct {
+	self     string // our address as known by the other members
+	owners   int    // size of the owner set of each location
+	ringPath string
+	client   *http.Client
+
+	mu   sync.RWMutex
+	ring *ring.Ring
+
+	rebalance chan struct{}
+}
+
+// newCluster returns the cluster seen from self. The members saved by the
+// last ring change win over the ones given here.
+func newCluster(self string, members []string, owners int, ringPath string, client *http.Client) (*cluster, error) {
+	if owners <= 0 {
+		owners = 1
+	}
+	c := &cluster{
+		self:      self,
+		owners:    owners,
+		ringPath:  ringPath,
+		client:    client,
+		rebalance: make(chan struct{}, 1),
+	}
+
+	saved := &types.Ring{}
+	buf, err := ioutil.ReadFile(ringPath)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(buf, saved); err != nil {
+			return nil, fmt.Errorf("invalid ring file %s: %s", ringPath, err)
+		}
+		members = saved.Members
+		logger.Infof("Using the ring saved in %s", ringPath)
+	case os.IsNotExist(err):
+		// a member unable to recognize itself would hand its blobs off
+		// to itself
+		if !contains(members, self) {
+			return nil, fmt.Errorf("this server's address %s is not among the members %v", self, members)
+		}
+	default:
+		return nil, err
+	}
+	c.ring = ring.New(members, 0)
+	return c, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *cluster) ownersOf(location string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ring.Owners(location, c.owners)
+}
+
+// peersOf returns the base URLs of the other owners of location.
+func (c *cluster) peersOf(location string) []string {
+	peers := []string{}
+	for _, owner := range c.ownersOf(location) {
+		if owner != c.self {
+			peers = append(peers, baseURL(owner))
+		}
+	}
+	return peers
+}
+
+func (c *cluster) members() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ring.Members()
+}
+
+// setMembers replaces the members of the ring and saves them.
+func (c *cluster) setMembers(members []string) error {
+	buf, err := json.Marshal(&types.Ring{Members: members})
+	if err != nil {
+		return err
+	}
+	tmpPath := c.ringPath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, buf, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, c.ringPath); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.ring = ring.New(members, 0)
+	c.mu.Unlock()
+	c.triggerRebalance()
+	return nil
+}
+
+func (c *cluster) triggerRebalance() {
+	select {
+	case c.rebalance <- struct{}{}:
+	default:
+		// one is already pending
+	}
+}
+
+// proxy sends r to the first owner that answers and copies its response to
+// w.
+func (c *cluster) proxy(owners []string, w http.ResponseWriter, r *http.Request) error {
+	// the body may have to be sent more than once
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			return err
+		}
+	}
+
+	var lastErr error
+	for _, owner := range owners {
+		req, err := http.NewRequest(r.Method, baseURL(owner)+r.URL.RequestURI(), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		for k, v := range r.Header {
+			req.Header[k] = v
+		}
+		// the request was authorized here: the owner gets our peer key,
+		// a signature wouldn't match its host anyway
+		req.Header.Del("Authorization")
+		req.Header.Del(common.APIKeyHeader)
+		req.Header.Set(common.ForwardedHeader, c.self)
+		if creator := creatorOf(r); creator != "" {
+			req.Header.Set(common.CreatorHeader, creator)
+		} else {
+			req.Header.Del(common.CreatorHeader)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			logger.Warningf("Unable to forward %s %s to %s: %s", r.Method, r.URL.Path, owner, err)
+			lastErr = err
+			continue
+		}
+		defer resp.Body.Close()
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		_, err = io.Copy(w, resp.Body)
+		return err
+	}
+	return fmt.Errorf("no owner of %s reachable (%s): %s", r.URL.Path, strings.Join(owners, ", "), lastErr)
+}
+
+// Forward proxies requests for locations this server doesn't own to an
+// owner. It returns false if the request is to be served here.
+func (d *Daemon) Forward(location string, w http.ResponseWriter, r *http.Request) (bool, error) {
+	if d.cluster == nil || r.Header.Get(common.PeerHeader) != "" || r.Header.Get(common.ForwardedHeader) != "" {
+		return false, nil
+	}
+	owners := d.cluster.ownersOf(location)
+	if contains(owners, d.cluster.self) {
+		return false, nil
+	}
+	// try the owners gossip believes are up first
+	alive, down := []string{}, []string{}
+	for _, owner := range owners {
+		if d.isAlive(owner) {
+			alive = append(alive, owner)
+		} else {
+			down = append(down, owner)
+		}
+	}
+	owners = append(alive, down...)
+	logger.Debugf("Forwarding %s %s to %v", r.Method, location, owners)
+	return true, d.cluster.proxy(owners, w, r)
+}
+
+// Ring returns the members of the cluster.
+func (d *Daemon) Ring() (*types.Ring, error) {
+	if d.cluster == nil {
+		return nil, fmt.Errorf("this server is not part of a cluster")
+	}
+	return &types.Ring{
+		Self:    d.cluster.self,
+		Members: d.cluster.members(),
+		Owners:  d.cluster.owners,
+	}, nil
+}
+
+// SetRing changes the members of the cluster and, unless the change comes
+// from another member, passes it on to every old and new member. Each of
+// them then moves the blobs it holds to their new owners.
+func (d *Daemon) SetRing(rg *types.Ring, fromPeer bool) error {
+	if d.cluster == nil {
+		return fmt.Errorf("this server is not part of a cluster")
+	}
+	if len(rg.Members) == 0 {
+		return fmt.Errorf("a ring needs at least one member")
+	}
+
+	old := d.cluster.members()
+	if err := d.cluster.setMembers(rg.Members); err != nil {
+		return err
+	}
+	logger.Infof("Ring members changed from %v to %v", old, rg.Members)
+	if fromPeer {
+		return nil
+	}
+
+	buf, err := json.Marshal(&types.Ring{Members: rg.Members})
+	if err != nil {
+		return err
+	}
+	sent, failed := []string{}, []string{}
+	for _, member := range append(old, rg.Members...) {
+		if member == d.cluster.self || contains(sent, member) {
+			continue
+		}
+		sent = append(sent, member)
+		if err := d.sendRing(member, buf); err != nil {
+			logger.Warningf("Unable to send the ring to %s: %s", member, err)
+			failed = append(failed, member)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("ring not updated on %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (d *Daemon) sendRing(member string, buf []byte) error {
+	req, err := http.NewRequest("PUT", baseURL(member)+"/cluster/ring", bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(common.PeerHeader, "1")
+	resp, err := d.cluster.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("member returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (d *Daemon) rebalancer() {
+	for range d.cluster.rebalance {
+		d.rebalance()
+	}
+}
+
+// rebalance makes sure every owner of the blobs we hold has them, and drops
+// the blobs we don't own anymore once their owners have them.
+func (d *Daemon) rebalance() {
+	d.blobMU.RLock()
+	locations := make([]string, 0, len(d.blobsLocMap))
+	for location := range d.blobsLocMap {
+		locations = append(locations, location)
+	}
+	d.blobMU.RUnlock()
+
+	copied, moved := 0, 0
+	for _, location := range locations {
+		version, exists := d.localVersion(location)
+		if !exists {
+			continue
+		}
+		owners := d.cluster.ownersOf(location)
+		synced := true
+		for _, owner := range owners {
+			if owner == d.cluster.self {
+				continue
+			}
+			peer := baseURL(owner)
+			bv := d.quorum.head(peer, location)
+			if bv.err != nil {
+				logger.Warningf("Unable to get version of %s from %s: %s", location, owner, bv.err)
+				synced = false
+				continue
+			}
+			if bv.version >= version {
+				continue
+			}
+			if err := d.pushTo(peer, location); err != nil {
+				logger.Warningf("Unable to copy %s to %s: %s", location, owner, err)
+				synced = false
+				continue
+			}
+			copied++
+		}
+		if synced && !contains(owners, d.cluster.self) {
+			if err := d.removeBlob(location, version); err != nil && err != errBlobNotFound {
+				logger.Warningf("Unable to remove %s after moving it: %s", location, err)
+				continue
+			}
+			moved++
+		}
+	}
+	if copied > 0 || moved > 0 {
+		logger.Infof("Rebalance copied %d blobs to their owners and handed off %d", copied, moved)
+	}
+}
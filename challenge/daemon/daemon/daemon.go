@@ -14,9 +14,24 @@ package daemon
 
 import (
 	"fmt"
+	"net/http"
+	"path/filepath"
 	"sync"
+	"time"
 
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/acl"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/audit"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/auth"
 	"github.com/Arvinderpal/go-storage-server/challenge/pkg/blob"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/bucket"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/certs"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/membership"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/quota"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/raft"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/ratelimit"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/sink"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/store"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/webhook"
 
 	"github.com/op/go-logging"
 )
@@ -31,6 +46,54 @@ type Daemon struct {
 	blobsIDMap  map[uint16]*blob.Blob
 	blobsLocMap map[string]*blob.Blob
 
+	// store holds the state and data of all blobs, those of each bucket
+	// apart
+	store       store.Store
+	bucketStore *store.BucketStore
+	buckets     *bucket.Registry
+
+	// noncurrent versions of the blobs of versioned buckets, oldest first,
+	// by location; under blobMU
+	versions map[string][]*blob.Blob
+
+	// what buckets, prefixes and keys hold, and quotas of prefixes
+	quota *quota.Tracker
+
+	// changes made to blobs, followed by replicas
+	changes *changeLog
+	replMU  sync.RWMutex
+	replica *replicator // set while replicating from a primary
+
+	// synchronous replication to peers, nil without peers
+	quorum     *quorum
+	tombstones map[string]int64 // version of recent deletes, by location
+	locationMU [locationLocks]sync.Mutex
+
+	cluster *cluster // nil unless running as a cluster member
+
+	members *membership.Memberlist // nil unless gossiping
+
+	merkle merkleCache // tree of our blobs for anti-entropy
+
+	// location map replicated through a Raft log, nil without raft nodes
+	raft          *raft.Raft
+	raftTransport *raftTransport
+
+	// notified of client writes
+	webhooks *webhook.Dispatcher
+	sinks    []sink.Sink
+
+	auth   *auth.Authenticator // who requests come from
+	limits *ratelimit.Limiter  // rates of clients, nil without limits
+	audit  *audit.Log          // requests changing things, nil if off
+
+	// certificates of the listener and of the requests to other
+	// servers, nil without TLS
+	certs *certs.Store
+
+	peerClient *http.Client // for requests to other servers
+	acls       *acl.Store   // shares prefixes beyond the grants of keys
+
 	conf *Config
 }
 
@@ -44,6 +107,8 @@ func NewDaemon(c *Config) (*Daemon, error) {
 		conf:        c,
 		blobsIDMap:  make(map[uint16]*blob.Blob),
 		blobsLocMap: make(map[string]*blob.Blob),
+		tombstones:  make(map[string]int64),
+		versions:    make(map[string][]*blob.Blob),
 	}
 
 	if err := d.init(); err != nil {
@@ -55,17 +120,119 @@ func NewDaemon(c *Config) (*Daemon, error) {
 
 func (d *Daemon) init() (err error) {
 
+	if len(d.conf.Peers) > 0 && len(d.conf.Members) > 0 {
+		return fmt.Errorf("peers and cluster members can't be used together")
+	}
+	if len(d.conf.RaftNodes) > 0 && (len(d.conf.Peers) > 0 || len(d.conf.Members) > 0) {
+		return fmt.Errorf("raft nodes can't be used with peers or cluster members")
+	}
+	if err := d.initTLS(); err != nil {
+		return err
+	}
+	presignKey, err := d.loadPresignKey()
+	if err != nil {
+		return fmt.Errorf("unable to load the presign key: %s", err)
+	}
+	if d.auth, err = auth.New(d.conf.CredentialsFile, presignKey); err != nil {
+		return fmt.Errorf("unable to load credentials: %s", err)
+	}
+	if d.conf.Limits.Enabled() {
+		d.limits = ratelimit.New(d.conf.Limits)
+	}
+	if d.conf.AuditDir != "" {
+		if d.audit, err = audit.Open(d.conf.AuditDir, d.conf.AuditMaxSize); err != nil {
+			return fmt.Errorf("unable to open the audit log: %s", err)
+		}
+	}
+	d.peerClient = d.newPeerClient(peerTimeout * time.Second)
+	peerClient := d.peerClient
+	if len(d.conf.Peers) > 0 {
+		if d.quorum, err = newQuorum(d.conf.Peers, d.conf.WriteQuorum, d.conf.ReadQuorum, peerClient); err != nil {
+			return err
+		}
+	}
+	if len(d.conf.Members) > 0 {
+		ringPath := filepath.Join(d.conf.DataDirBasePath, ringFileName)
+		if d.cluster, err = newCluster(d.conf.Advertise, d.conf.Members, d.conf.Owners, ringPath, peerClient); err != nil {
+			return err
+		}
+		// the owners of a location replicate it between them
+		d.quorum = &quorum{
+			w:       d.conf.WriteQuorum,
+			r:       d.conf.ReadQuorum,
+			client:  peerClient,
+			peersOf: d.cluster.peersOf,
+		}
+	}
+
+	if d.bucketStore, err = store.NewBucketStore(d.conf.StoreName, d.conf.storeConfig()); err != nil {
+		return err
+	}
+	d.store = d.bucketStore
+	if d.buckets, err = bucket.New(filepath.Join(d.conf.DataDirBasePath, bucketsFileName)); err != nil {
+		return fmt.Errorf("unable to load buckets: %s", err)
+	}
+	changesPath := filepath.Join(d.conf.DataDirBasePath, changeLogFileName)
+	if d.changes, err = openChangeLog(changesPath); err != nil {
+		return fmt.Errorf("unable to open the change log: %s", err)
+	}
+	if d.acls, err = acl.New(filepath.Join(d.conf.DataDirBasePath, aclsFileName)); err != nil {
+		return fmt.Errorf("unable to load ACLs: %s", err)
+	}
+	if d.quota, err = quota.New(filepath.Join(d.conf.DataDirBasePath, quotasFileName)); err != nil {
+		return fmt.Errorf("unable to load quotas: %s", err)
+	}
+	webhooksPath := filepath.Join(d.conf.DataDirBasePath, webhooksFileName)
+	if d.webhooks, err = webhook.New(webhook.Config{
+		Path:        webhooksPath,
+		MaxAttempts: d.conf.WebhookAttempts,
+	}); err != nil {
+		return err
+	}
+	for _, spec := range d.conf.Sinks {
+		sk, err := sink.New(spec)
+		if err != nil {
+			return fmt.Errorf("unable to open sink %s: %s", spec, err)
+		}
+		d.sinks = append(d.sinks, sk)
+	}
+
 	/*
-	* If the "restore" directory exists, we will attempt to
+	* If the store already holds blobs, we will attempt to
 	* restore state. Otherwise, we start afresh.
 	*
 	 */
-	if err := d.RestoreState(d.conf.DataDirBasePath, true); err != nil {
+	if err := d.RestoreState(true); err != nil {
 		logger.Warningf("Error while recovering endpoints: %s\n", err)
 	}
 
 	// start our GC for blobs
 	d.gc()
 
+	if len(d.conf.RaftNodes) > 0 {
+		// started once the blobs are restored, the log applies on top of
+		// them
+		if err := d.startRaft(); err != nil {
+			return err
+		}
+	}
+
+	if d.conf.GossipAddr != "" {
+		if err := d.startMembership(); err != nil {
+			return err
+		}
+	}
+
+	if d.cluster != nil {
+		// blobs we don't own anymore are handed off to their owners
+		go d.rebalancer()
+		d.cluster.triggerRebalance()
+	}
+
+	if d.conf.ReplicaOf != "" {
+		d.replica = newReplicator(d, d.conf.ReplicaOf)
+		go d.replica.run()
+	}
+
 	return nil
 }
@@ -16,6 +16,8 @@ import (
 	"sync"
 
 	"github.com/Arvinderpal/go-storage-server/challenge/pkg/option"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/ratelimit"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/store"
 )
 
 var (
@@ -27,7 +29,70 @@ func init() {
 
 // Config is the configuration used by Daemon.
 type Config struct {
-	DataDirBasePath string // base directory for store data and state restore
+	DataDirBasePath string   // base directory for store data and state restore
+	DataDirs        []string // all data directories, DataDirBasePath first
+	Placement       string   // how new blobs are placed over DataDirs
+	StoreName       string   // store backend holding blob state and data
+
+	SegmentThreshold int64 // largest blob packed into a segment (segment store)
+
+	ColdDirPath string           // data directory of the cold tier, tiering is off if empty
+	Tiering     store.TierPolicy // when blobs move between the hot and cold tiers
+
+	ErasureCoding string // erasure coding scheme, e.g. "6+3", blobs are sharded over DataDirs if set
+
+	ReplicaOf string // address of the primary to replicate from, empty on a primary
+
+	// Peers each blob is synchronously replicated to, and the number of
+	// servers (counting this one) that must take part in a write or a read.
+	// A quorum of 0 means a majority.
+	Peers       []string
+	WriteQuorum int
+	ReadQuorum  int
+
+	// Members of the cluster ring, Advertise being our own address in it.
+	// Each blob is held by Owners members, replicated with the quorums
+	// above.
+	Members   []string
+	Advertise string
+	Owners    int
+
+	// UDP address the membership protocol gossips on, empty to disable it,
+	// and gossip addresses of members to join through.
+	GossipAddr string
+	Join       []string
+
+	// Fixed set of nodes, Advertise among them, agreeing on the blobs
+	// through a Raft log.
+	RaftNodes []string
+
+	WebhookAttempts int      // deliveries tried before an event is dead-lettered
+	Sinks           []string // sinks events are published to, as "<kind>:<path>"
+
+	// File of the API keys allowed in, authentication is off if empty,
+	// and key sent to the other servers, as <id>:<secret>.
+	CredentialsFile string
+	PeerKey         string
+
+	// Secret presigned URLs are signed with, random if empty.
+	PresignSecret string
+
+	// Certificate and key of the listener, plain HTTP if empty, and CA
+	// bundle client certificates are verified against, required unless
+	// TLSClientOptional.
+	TLSCert           string
+	TLSKey            string
+	TLSClientCA       string
+	TLSClientOptional bool
+
+	// How fast each client, told apart by its credentials or address, may
+	// send requests and data.
+	Limits ratelimit.Config
+
+	// Directory of the audit log, off if empty, and size its file is
+	// rotated at.
+	AuditDir     string
+	AuditMaxSize int64
 
 	// Options changeable at runtime
 	Opts   *option.BoolOptions
@@ -39,3 +104,16 @@ func NewConfig() *Config {
 		Opts: option.NewBoolOptions(&DaemonOptionLibrary),
 	}
 }
+
+// storeConfig returns the settings used to build the daemon's store.
+func (c *Config) storeConfig() store.Config {
+	return store.Config{
+		BasePath:         c.DataDirBasePath,
+		Paths:            c.DataDirs,
+		Placement:        c.Placement,
+		SegmentThreshold: c.SegmentThreshold,
+		ColdPath:         c.ColdDirPath,
+		Tiering:          c.Tiering,
+		ErasureCoding:    c.ErasureCoding,
+	}
+}
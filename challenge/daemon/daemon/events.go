@@ -0,0 +1,61 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package daemon
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+func newEventID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// publishEvent tells the webhooks and sinks about a client write that
+// succeeded. Writes applied for a peer, a primary or the Raft log are not
+// published: the server the client wrote to does it.
+func (d *Daemon) publishEvent(op, location string) {
+	version, _ := d.localVersion(location)
+	e := types.Event{
+		ID:       newEventID(),
+		Type:     op,
+		Location: location,
+		Version:  version,
+		Time:     time.Now(),
+	}
+	d.webhooks.Notify(e)
+	for _, sk := range d.sinks {
+		// the write is done, a sink failing doesn't undo it
+		if err := sk.Publish(&e); err != nil {
+			logger.Errorf("Unable to publish event %s to a sink: %s", e.ID, err)
+		}
+	}
+}
+
+// Sinks describes the sinks events are published to.
+func (d *Daemon) Sinks() ([]types.SinkStatus, error) {
+	list := []types.SinkStatus{}
+	for _, sk := range d.sinks {
+		st, err := sk.Status()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *st)
+	}
+	return list, nil
+}
@@ -13,10 +13,10 @@
 package daemon
 
 import (
-	"io/ioutil"
 	"time"
 
 	"github.com/Arvinderpal/go-storage-server/challenge/pkg/blob"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/store"
 )
 
 const GC_INTERVAL = 5 // inseconds
@@ -30,7 +30,14 @@ func (d *Daemon) gc() {
 		for {
 			select {
 			case <-ticker.C:
-				d.gcInternal()
+				if !store.CollectsFailed(d.store) {
+					d.gcInternal()
+				}
+				if err := store.MergeAll(d.store); err != nil {
+					logger.Warningf("store merge failed: %s", err)
+				}
+				d.pruneTombstones()
+				d.expireBlobs()
 			case <-quit:
 				ticker.Stop()
 				return
@@ -42,12 +49,11 @@ func (d *Daemon) gc() {
 func (d *Daemon) gcInternal() {
 	var failedBlobs []*blob.Blob
 	logger.Debugf("Started gc")
-	dirFiles, err := ioutil.ReadDir(".") // we are running in the ./data dir
+	possibleBlobs, err := d.readBlobsFromStore()
 	if err != nil {
-		logger.Warningf("gc unable to read data directory: %s", err)
+		logger.Warningf("gc unable to list blobs: %s", err)
+		return
 	}
-	blobIDs := FilterBlobDir(dirFiles)
-	possibleBlobs := readBlobsFromDirNames(blobIDs)
 
 	if len(possibleBlobs) == 0 {
 		logger.Debug("No blobs found.")
@@ -0,0 +1,64 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/membership"
+)
+
+// startMembership joins the gossip of the cluster, known to the other
+// members by our advertised address.
+func (d *Daemon) startMembership() (err error) {
+	d.members, err = membership.New(membership.Config{
+		Name:     d.conf.Advertise,
+		BindAddr: d.conf.GossipAddr,
+		Seeds:    d.conf.Join,
+	})
+	if err != nil {
+		return err
+	}
+	go d.watchMembers(d.members.Subscribe())
+	return nil
+}
+
+// watchMembers reacts to members coming and going.
+func (d *Daemon) watchMembers(events <-chan membership.Event) {
+	for ev := range events {
+		if d.cluster == nil || !contains(d.cluster.members(), ev.Member.Name) {
+			continue
+		}
+		switch ev.Type {
+		case membership.EventJoin, membership.EventAlive:
+			// it may have missed writes while away
+			d.cluster.triggerRebalance()
+		case membership.EventDead:
+			logger.Warningf("Ring member %s is down, its locations are served by the other owners", ev.Member.Name)
+		}
+	}
+}
+
+// isAlive returns false for the members gossip knows to be down.
+func (d *Daemon) isAlive(member string) bool {
+	return d.members == nil || d.members.IsAlive(member)
+}
+
+// Members returns the members known to gossip and their state.
+func (d *Daemon) Members() ([]types.Member, error) {
+	if d.members == nil {
+		return nil, fmt.Errorf("membership gossip is not enabled")
+	}
+	return d.members.Members(), nil
+}
@@ -0,0 +1,101 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package daemon
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/blob"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/merkle"
+)
+
+const merkleTTL = 60 // in seconds, tombstones expire without a change
+
+// merkleCache holds the Merkle tree of our blobs until they change.
+type merkleCache struct {
+	mu    sync.Mutex
+	seq   uint64
+	built time.Time
+	tree  *merkle.Tree
+}
+
+// merkleTree returns the tree of the blobs we hold, rebuilding it if blobs
+// changed since it was built.
+func (d *Daemon) merkleTree() *merkle.Tree {
+	d.merkle.mu.Lock()
+	defer d.merkle.mu.Unlock()
+
+	seq := d.changes.lastSeq()
+	if d.merkle.tree != nil && d.merkle.seq == seq && time.Since(d.merkle.built) < merkleTTL*time.Second {
+		return d.merkle.tree
+	}
+
+	entries := []types.MerkleEntry{}
+	blobs := []*blob.Blob{}
+	d.blobMU.RLock()
+	for _, bb := range d.blobsLocMap {
+		blobs = append(blobs, bb)
+	}
+	for location, version := range d.tombstones {
+		entries = append(entries, types.MerkleEntry{Location: location, Version: version, Deleted: true})
+	}
+	d.blobMU.RUnlock()
+
+	for _, bb := range blobs {
+		checksum, err := d.checksumOf(bb)
+		if err != nil {
+			logger.Warningf("Unable to hash blob %d/%s, leaving it out of the merkle tree: %s", bb.ID, bb.Location, err)
+			continue
+		}
+		entries = append(entries, types.MerkleEntry{Location: bb.Location, Hash: checksum, Version: bb.Version})
+	}
+
+	d.merkle.tree = merkle.New(entries)
+	d.merkle.seq, d.merkle.built = seq, time.Now()
+	return d.merkle.tree
+}
+
+// checksumOf returns the SHA-256 of the data of bb, computing and saving it
+// for blobs written before checksums were kept.
+func (d *Daemon) checksumOf(bb *blob.Blob) (string, error) {
+	bb.UpdateMU.Lock()
+	defer bb.UpdateMU.Unlock()
+	if bb.Checksum != "" {
+		return bb.Checksum, nil
+	}
+
+	rc, err := d.openData(bb)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return "", err
+	}
+	bb.Checksum = hex.EncodeToString(h.Sum(nil))
+	if err := d.store.PutState(bb); err != nil {
+		logger.Warningf("Unable to save the checksum of blob %d/%s: %s", bb.ID, bb.Location, err)
+	}
+	return bb.Checksum, nil
+}
+
+// MerkleNode returns the node at path of the Merkle tree of our blobs.
+func (d *Daemon) MerkleNode(path string) (*types.MerkleNode, error) {
+	return d.merkleTree().Node(path)
+}
@@ -0,0 +1,516 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+const (
+	// Values of common.ConsistencyHeader.
+	ConsistencyOne    = "one"
+	ConsistencyQuorum = "quorum"
+	ConsistencyAll    = "all"
+	// with raft, a read seeing every write acknowledged before it
+	ConsistencyLinearizable = "linearizable"
+
+	peerTimeout   = 30 // in seconds
+	locationLocks = 64
+	tombstoneTTL  = time.Hour
+)
+
+// quorum holds the peers blobs are replicated to synchronously and how many
+// servers, counting this one, must take part in reads and writes.
+type quorum struct {
+	w, r   int // 0 means a majority
+	client *http.Client
+
+	// peersOf returns the base URLs of the peers holding location
+	peersOf func(location string) []string
+}
+
+// newQuorum returns a quorum replicating to the same peers for every blob.
+// A w or r of 0 means a majority of the servers.
+func newQuorum(peers []string, w, r int, client *http.Client) (*quorum, error) {
+	urls := []string{}
+	for _, peer := range peers {
+		urls = append(urls, baseURL(peer))
+	}
+	n := len(urls) + 1
+	if w < 0 || w > n {
+		return nil, fmt.Errorf("write quorum %d out of range 1-%d", w, n)
+	}
+	if r < 0 || r > n {
+		return nil, fmt.Errorf("read quorum %d out of range 1-%d", r, n)
+	}
+	return &quorum{
+		w:       w,
+		r:       r,
+		client:  client,
+		peersOf: func(string) []string { return urls },
+	}, nil
+}
+
+// need returns the number of servers out of n a request with the given
+// consistency level must reach, def if the request doesn't ask for a level.
+func (q *quorum) need(consistency string, def, n int) (int, error) {
+	switch consistency {
+	case "":
+		if def == 0 {
+			return n/2 + 1, nil
+		}
+		if def > n {
+			return n, nil
+		}
+		return def, nil
+	case ConsistencyOne:
+		return 1, nil
+	case ConsistencyQuorum:
+		return n/2 + 1, nil
+	case ConsistencyAll:
+		return n, nil
+	default:
+		return 0, fmt.Errorf("unknown consistency %q, should be %s, %s or %s", consistency, ConsistencyOne, ConsistencyQuorum, ConsistencyAll)
+	}
+}
+
+// readQuorum returns the number of servers a read of location must reach.
+func (q *quorum) readQuorum(location, consistency string) (int, error) {
+	return q.need(consistency, q.r, len(q.peersOf(location))+1)
+}
+
+// writeQuorum returns the number of servers a write of location must reach.
+func (q *quorum) writeQuorum(location, consistency string) (int, error) {
+	return q.need(consistency, q.w, len(q.peersOf(location))+1)
+}
+
+func (q *quorum) request(method, peer, location string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, peer+(&url.URL{Path: common.StorePath(location)}).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(common.PeerHeader, "1")
+	return req, nil
+}
+
+// send applies a write of the given version, by creator, to peer.
+func (q *quorum) send(peer, op, location string, version int64, creator string, data []byte) error {
+	method := "PUT"
+	if op == types.OpDelete {
+		method = "DELETE"
+	}
+	req, err := q.request(method, peer, location, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(common.VersionHeader, strconv.FormatInt(version, 10))
+	if creator != "" {
+		req.Header.Set(common.CreatorHeader, creator)
+	}
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("peer %s returned %s", peer, resp.Status)
+	}
+	return nil
+}
+
+// blobVersion is the version of a blob held by a server.
+type blobVersion struct {
+	peer    string // empty for this server
+	version int64  // 0 if the server never heard of the blob
+	exists  bool   // false if deleted
+	err     error
+}
+
+// head asks peer for the version of its copy of the blob at location.
+func (q *quorum) head(peer, location string) blobVersion {
+	bv := blobVersion{peer: peer}
+	req, err := q.request("HEAD", peer, location, nil)
+	if err != nil {
+		bv.err = err
+		return bv
+	}
+	resp, err := q.client.Do(req)
+	if err != nil {
+		bv.err = err
+		return bv
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		bv.exists = true
+	case http.StatusNotFound:
+	default:
+		bv.err = fmt.Errorf("peer %s returned %s", peer, resp.Status)
+		return bv
+	}
+	if v := resp.Header.Get(common.VersionHeader); v != "" {
+		bv.version, bv.err = strconv.ParseInt(v, 10, 64)
+	}
+	return bv
+}
+
+func newestOf(bvs []blobVersion) blobVersion {
+	newest := bvs[0]
+	for _, bv := range bvs[1:] {
+		if bv.version > newest.version {
+			newest = bv
+		}
+	}
+	return newest
+}
+
+// localVersion returns the version of the blob at location and whether it
+// exists. For a deleted blob the version is the one of the delete, or 0 if
+// it is not known anymore.
+func (d *Daemon) localVersion(location string) (int64, bool) {
+	d.blobMU.RLock()
+	defer d.blobMU.RUnlock()
+	if bb := d.lookupBlobByLocation(location); bb != nil {
+		return bb.Version, true
+	}
+	return d.tombstones[location], false
+}
+
+// newVersion returns a version for a new write at location, newer than the
+// one we hold. Versions are timestamps, the latest write wins.
+func (d *Daemon) newVersion(location string) int64 {
+	version := time.Now().UnixNano()
+	if cur, _ := d.localVersion(location); version <= cur {
+		version = cur + 1
+	}
+	return version
+}
+
+// pruneTombstones forgets deletes older than tombstoneTTL.
+func (d *Daemon) pruneTombstones() {
+	oldest := time.Now().Add(-tombstoneTTL).UnixNano()
+	d.blobMU.Lock()
+	defer d.blobMU.Unlock()
+	for location, version := range d.tombstones {
+		if version < oldest {
+			delete(d.tombstones, location)
+		}
+	}
+}
+
+func (d *Daemon) lockLocation(location string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(location))
+	return &d.locationMU[h.Sum32()%locationLocks]
+}
+
+// applyVersioned applies a write of the given version, by creator, unless
+// we already hold that version or a newer one.
+func (d *Daemon) applyVersioned(op, location string, version int64, creator string, body io.Reader) error {
+	mu := d.lockLocation(location)
+	mu.Lock()
+	defer mu.Unlock()
+	return d.applyLocked(op, location, version, creator, body)
+}
+
+// applyLocked is applyVersioned with the lock of location held.
+func (d *Daemon) applyLocked(op, location string, version int64, creator string, body io.Reader) error {
+	if cur, _ := d.localVersion(location); version <= cur {
+		logger.Debugf("Ignoring %s of %s at version %d, we have version %d", op, location, version, cur)
+		return nil
+	}
+	if op != types.OpDelete {
+		return d.putBlob(location, version, creator, body)
+	}
+	err := d.removeBlob(location, version)
+	if err == errBlobNotFound {
+		// remember the delete so older writes arriving late are ignored
+		d.blobMU.Lock()
+		d.tombstones[location] = version
+		d.blobMU.Unlock()
+		return nil
+	}
+	return err
+}
+
+// applyFromPeer applies a write forwarded by a peer.
+func (d *Daemon) applyFromPeer(op, location string, r *http.Request) error {
+	version, err := strconv.ParseInt(r.Header.Get(common.VersionHeader), 10, 64)
+	if err != nil || version <= 0 {
+		return fmt.Errorf("peer request without a valid %s header", common.VersionHeader)
+	}
+	return d.applyVersioned(op, location, version, creatorOf(r), r.Body)
+}
+
+// quorumWrite applies a client write locally and on the peers, and returns
+// once enough servers have it. The peers that did not answer yet keep going
+// in the background. If the quorum isn't reached the write is undone.
+func (d *Daemon) quorumWrite(op, location string, r *http.Request) error {
+	peers := d.quorum.peersOf(location)
+	need, err := d.quorum.writeQuorum(location, r.Header.Get(common.ConsistencyHeader))
+	if err != nil {
+		return err
+	}
+
+	d.blobMU.RLock()
+	exists := d.lookupBlobByLocation(location) != nil
+	d.blobMU.RUnlock()
+	if op == types.OpCreate && exists {
+		return fmt.Errorf("Blob %s already exists", location)
+	}
+	if op != types.OpCreate && !exists {
+		return errBlobNotFound
+	}
+
+	// the data goes to every peer, so it is read in memory once
+	var data []byte
+	if op != types.OpDelete {
+		if data, err = ioutil.ReadAll(r.Body); err != nil {
+			return err
+		}
+	}
+
+	// keep what we hold, to put it back if the quorum isn't reached
+	prev, err := d.localCopy(location)
+	if err != nil {
+		logger.Warningf("Unable to read %s, a failed write can't be undone: %s", location, err)
+	}
+
+	version, creator := d.newVersion(location), creatorOf(r)
+	if err := d.applyVersioned(op, location, version, creator, bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	results := make(chan error, len(peers))
+	for _, peer := range peers {
+		go func(peer string) {
+			err := d.quorum.send(peer, op, location, version, creator, data)
+			if err != nil {
+				logger.Warningf("Unable to replicate %s of %s to %s: %s", op, location, peer, err)
+			}
+			results <- err
+		}(peer)
+	}
+	acks := 1
+	var lastErr error
+	for i := 0; i < len(peers) && acks < need; i++ {
+		if err := <-results; err != nil {
+			lastErr = err
+			continue
+		}
+		acks++
+	}
+	if acks < need {
+		d.undoWrite(location, version, prev)
+		return fmt.Errorf("write quorum not reached, %d of %d servers stored %s, need %d: %s", acks, len(peers)+1, location, need, lastErr)
+	}
+	return nil
+}
+
+// blobCopy is the content of a blob held by this server.
+type blobCopy struct {
+	version int64 // of the delete if the blob doesn't exist, 0 if unknown
+	exists  bool
+	creator string
+	data    []byte
+}
+
+// localCopy returns our copy of the blob at location.
+func (d *Daemon) localCopy(location string) (*blobCopy, error) {
+	d.blobMU.RLock()
+	bb := d.lookupBlobByLocation(location)
+	tombstone := d.tombstones[location]
+	d.blobMU.RUnlock()
+
+	if bb == nil {
+		return &blobCopy{version: tombstone}, nil
+	}
+
+	bb.UpdateMU.RLock()
+	bbCpy := bb.DeepCopy()
+	bb.UpdateMU.RUnlock()
+	rc, err := d.openData(bbCpy)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	return &blobCopy{version: bbCpy.Version, exists: true, creator: bbCpy.Creator, data: data}, nil
+}
+
+// undoWrite puts prev back at location after a write of the given version
+// failed to reach its quorum. Since only newer versions get applied, prev
+// is written again with a new version, here and on every peer, so that the
+// failed write loses wherever it was stored and read repair doesn't bring
+// it back. Nothing is done if the location was written to since, or if prev
+// is nil.
+func (d *Daemon) undoWrite(location string, version int64, prev *blobCopy) {
+	if prev == nil {
+		return
+	}
+	mu := d.lockLocation(location)
+	mu.Lock()
+	if cur, _ := d.localVersion(location); cur != version {
+		mu.Unlock()
+		return
+	}
+	op := types.OpUpdate
+	if !prev.exists {
+		op = types.OpDelete
+	}
+	undo := d.newVersion(location)
+	err := d.applyLocked(op, location, undo, prev.creator, bytes.NewReader(prev.data))
+	mu.Unlock()
+	if err != nil {
+		logger.Errorf("Unable to undo write of %s: %s", location, err)
+		return
+	}
+	logger.Infof("Undid write of %s at version %d", location, version)
+
+	for _, peer := range d.quorum.peersOf(location) {
+		go func(peer string) {
+			if err := d.quorum.send(peer, op, location, undo, prev.creator, prev.data); err != nil {
+				logger.Warningf("Unable to undo write of %s on %s: %s", location, peer, err)
+			}
+		}(peer)
+	}
+}
+
+// quorumRead asks need servers, counting this one, for their version of the
+// blob at location and serves the newest one. If our copy is stale it is
+// repaired first; stale peers are repaired in the background.
+func (d *Daemon) quorumRead(location string, need int, w http.ResponseWriter) error {
+	peers := d.quorum.peersOf(location)
+	version, exists := d.localVersion(location)
+	bvs := []blobVersion{{version: version, exists: exists}}
+
+	answers := make(chan blobVersion, len(peers))
+	for _, peer := range peers {
+		go func(peer string) {
+			answers <- d.quorum.head(peer, location)
+		}(peer)
+	}
+	pending := len(peers)
+	var lastErr error
+	for len(bvs) < need && pending > 0 {
+		bv := <-answers
+		pending--
+		if bv.err != nil {
+			logger.Warningf("Unable to get version of %s from %s: %s", location, bv.peer, bv.err)
+			lastErr = bv.err
+			continue
+		}
+		bvs = append(bvs, bv)
+	}
+	if len(bvs) < need {
+		return fmt.Errorf("read quorum not reached, %d of %d servers answered for %s, need %d: %s", len(bvs), len(peers)+1, location, need, lastErr)
+	}
+
+	newest := newestOf(bvs)
+	if newest.peer != "" && newest.version > version {
+		if err := d.repairFrom(newest, location); err != nil {
+			return err
+		}
+	}
+
+	go func() {
+		for ; pending > 0; pending-- {
+			if bv := <-answers; bv.err == nil {
+				bvs = append(bvs, bv)
+			}
+		}
+		d.readRepair(location, bvs)
+	}()
+
+	if !newest.exists {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	return d.getBlob(location, w)
+}
+
+// readRepair brings every server in bvs up to the newest version among them.
+func (d *Daemon) readRepair(location string, bvs []blobVersion) {
+	newest := newestOf(bvs)
+	if version, _ := d.localVersion(location); newest.peer != "" && newest.version > version {
+		if err := d.repairFrom(newest, location); err != nil {
+			logger.Warningf("Read repair of %s from %s failed: %s", location, newest.peer, err)
+			return
+		}
+	}
+	for _, bv := range bvs {
+		if bv.peer == "" || bv.version >= newest.version {
+			continue
+		}
+		logger.Infof("Read repair: sending version %d of %s to %s", newest.version, location, bv.peer)
+		if err := d.pushTo(bv.peer, location); err != nil {
+			logger.Warningf("Read repair of %s on %s failed: %s", location, bv.peer, err)
+		}
+	}
+}
+
+// repairFrom applies the copy of the blob at location held by bv.peer here.
+func (d *Daemon) repairFrom(bv blobVersion, location string) error {
+	logger.Infof("Read repair: fetching version %d of %s from %s", bv.version, location, bv.peer)
+	if !bv.exists {
+		return d.applyVersioned(types.OpDelete, location, bv.version, "", nil)
+	}
+
+	req, err := d.quorum.request("GET", bv.peer, location, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.quorum.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer %s returned %s", bv.peer, resp.Status)
+	}
+	version, err := strconv.ParseInt(resp.Header.Get(common.VersionHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("peer %s sent no valid version: %s", bv.peer, err)
+	}
+	return d.applyVersioned(types.OpUpdate, location, version, resp.Header.Get(common.CreatorHeader), resp.Body)
+}
+
+// pushTo sends our copy of the blob at location to peer.
+func (d *Daemon) pushTo(peer, location string) error {
+	c, err := d.localCopy(location)
+	if err != nil {
+		return err
+	}
+	if !c.exists {
+		if c.version == 0 {
+			return nil
+		}
+		return d.quorum.send(peer, types.OpDelete, location, c.version, "", nil)
+	}
+	return d.quorum.send(peer, types.OpUpdate, location, c.version, c.creator, c.data)
+}
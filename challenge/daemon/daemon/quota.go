@@ -0,0 +1,188 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/quota"
+)
+
+const quotasFileName = "quotas.json"
+
+// admitWrite checks that the bucket of location exists and that a client
+// write of r's body there, whose size may not be known, fits in the quotas
+// of its bucket, of the prefixes covering it and of the key writing it. It
+// returns the body to read the data from, failing once the write goes over
+// a quota.
+func (d *Daemon) admitWrite(location string, r *http.Request) (*quota.Reader, error) {
+	if r.Header.Get(common.PeerHeader) != "" {
+		return quota.NewReader(r.Body, -1), nil
+	}
+	b, err := d.bucketOf(location)
+	if err != nil {
+		return nil, err
+	}
+
+	// the blob replaced goes away unless kept as a noncurrent version
+	size, oldCreator, exists := d.currentBlob(location)
+	replaces := exists && (b == nil || !b.Versioning)
+
+	limits := []quota.Limit{}
+	if b != nil {
+		limits = append(limits, quota.Limit{
+			Bytes:    b.QuotaBytes,
+			Objects:  b.QuotaObjects,
+			Usage:    d.quota.Bucket(b.Name),
+			Replaces: replaces,
+		})
+	}
+	for _, q := range d.quota.Covering(location) {
+		limits = append(limits, quota.Limit{
+			Bytes:    q.QuotaBytes,
+			Objects:  q.QuotaObjects,
+			Usage:    *q.Usage,
+			Replaces: replaces,
+		})
+	}
+	if creator := creatorOf(r); creator != "" {
+		if bytes, objects := d.auth.KeyQuota(creator); bytes > 0 || objects > 0 {
+			limits = append(limits, quota.Limit{
+				Bytes:    bytes,
+				Objects:  objects,
+				Usage:    d.quota.Creator(creator),
+				Replaces: replaces && oldCreator == creator,
+			})
+		}
+	}
+
+	contentLength := r.ContentLength
+	if contentLength < 0 {
+		contentLength = 0
+	}
+	room, err := quota.Check(limits, contentLength, size)
+	if err != nil {
+		return nil, err
+	}
+	return quota.NewReader(r.Body, room), nil
+}
+
+// currentBlob returns the size and creator of the blob at location, if
+// there is one.
+func (d *Daemon) currentBlob(location string) (int64, string, bool) {
+	d.blobMU.RLock()
+	bb := d.lookupBlobByLocation(location)
+	d.blobMU.RUnlock()
+	if bb == nil {
+		return 0, "", false
+	}
+	bb.UpdateMU.RLock()
+	defer bb.UpdateMU.RUnlock()
+	return bb.Size, bb.Creator, true
+}
+
+// Usage returns what buckets, prefixes with a quota and API keys hold here,
+// with their quotas, for p.
+func (d *Daemon) Usage(p *types.Principal) (*types.UsageReport, error) {
+	if err := requireAdmin(p); err != nil {
+		return nil, err
+	}
+	buckets, err := d.Buckets(p)
+	if err != nil {
+		return nil, err
+	}
+	keys := d.auth.KeyQuotas()
+	for i := range keys {
+		keys[i].Usage = d.quota.Creator(keys[i].ID)
+	}
+	return &types.UsageReport{
+		Buckets:  buckets,
+		Prefixes: d.quota.Quotas(),
+		Keys:     keys,
+	}, nil
+}
+
+// Quotas returns the quotas of prefixes, by prefix, with their usage here,
+// for p.
+func (d *Daemon) Quotas(p *types.Principal) ([]types.PrefixQuota, error) {
+	if err := requireAdmin(p); err != nil {
+		return nil, err
+	}
+	return d.quota.Quotas(), nil
+}
+
+// SetQuota sets the quota of q.Prefix for p, and on the other servers. A
+// quota sent by a server is stored as is, unless we hold a later one.
+func (d *Daemon) SetQuota(p *types.Principal, q *types.PrefixQuota, fromPeer bool) (*types.PrefixQuota, error) {
+	if err := requireAdmin(p); err != nil {
+		return nil, err
+	}
+	if !common.ValidLocation(q.Prefix, true) {
+		return nil, fmt.Errorf("invalid prefix %q", q.Prefix)
+	}
+	if q.QuotaBytes < 0 || q.QuotaObjects < 0 {
+		return nil, fmt.Errorf("quotas can't be negative")
+	}
+	q.Usage = nil
+	if fromPeer {
+		_, err := d.quota.Set(q)
+		return q, err
+	}
+
+	q.Updated = time.Now()
+	for _, cur := range d.quota.Covering(q.Prefix) {
+		if cur.Prefix == q.Prefix && !q.Updated.After(cur.Updated) {
+			q.Updated = cur.Updated.Add(time.Nanosecond)
+		}
+	}
+	if _, err := d.quota.Set(q); err != nil {
+		return nil, err
+	}
+	buf, err := json.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+	d.broadcast("PUT", "/quotas", "the quota of "+q.Prefix, buf)
+	for _, cur := range d.quota.Covering(q.Prefix) {
+		if cur.Prefix == q.Prefix {
+			return &cur, nil
+		}
+	}
+	return q, nil
+}
+
+// RemoveQuota removes the quota of prefix for p, and from the other
+// servers.
+func (d *Daemon) RemoveQuota(p *types.Principal, prefix string, fromPeer bool) error {
+	if err := requireAdmin(p); err != nil {
+		return err
+	}
+	err := d.quota.RemoveQuota(prefix)
+	if fromPeer {
+		if err == quota.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	d.broadcast("DELETE", "/quotas?prefix="+url.QueryEscape(prefix), "the delete of the quota of "+prefix, nil)
+	return nil
+}
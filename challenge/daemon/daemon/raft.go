@@ -0,0 +1,301 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/raft"
+)
+
+const (
+	raftDirName        = "raft"
+	raftApplyTimeout   = 10  // in seconds
+	raftSnapshotTimout = 300 // in seconds, restoring fetches every blob
+)
+
+// raftCommand is a write committed through the Raft log. Every node applies
+// it with the version picked by the leader, so they all map location to the
+// same blob.
+type raftCommand struct {
+	Op       string `json:"op"`
+	Location string `json:"location"`
+	Version  int64  `json:"version"`
+	Creator  string `json:"creator,omitempty"`
+	Data     []byte `json:"data,omitempty"`
+}
+
+// raftSnapshot is the location map as of a Raft index.
+type raftSnapshot struct {
+	Blobs      map[string]int64 `json:"blobs"`      // version of each blob, by location
+	Tombstones map[string]int64 `json:"tombstones"` // version of recent deletes, by location
+}
+
+// raftFSM applies the Raft log to the daemon.
+type raftFSM struct {
+	d *Daemon
+}
+
+func (f *raftFSM) Apply(command []byte) error {
+	c := &raftCommand{}
+	if err := json.Unmarshal(command, c); err != nil {
+		return fmt.Errorf("invalid raft command: %s", err)
+	}
+	// versions make applying an entry twice harmless
+	return f.d.applyVersioned(c.Op, c.Location, c.Version, c.Creator, bytes.NewReader(c.Data))
+}
+
+func (f *raftFSM) Snapshot() ([]byte, error) {
+	snap := &raftSnapshot{
+		Blobs:      make(map[string]int64),
+		Tombstones: make(map[string]int64),
+	}
+	f.d.blobMU.RLock()
+	for location, bb := range f.d.blobsLocMap {
+		snap.Blobs[location] = bb.Version
+	}
+	for location, version := range f.d.tombstones {
+		snap.Tombstones[location] = version
+	}
+	f.d.blobMU.RUnlock()
+	return json.Marshal(snap)
+}
+
+// Restore makes the daemon hold the blobs of the snapshot, fetching the
+// data it is missing from the leader.
+func (f *raftFSM) Restore(data []byte, leader string) error {
+	snap := &raftSnapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return fmt.Errorf("invalid raft snapshot: %s", err)
+	}
+	d := f.d
+
+	fetched := 0
+	for location, version := range snap.Blobs {
+		if cur, _ := d.localVersion(location); cur >= version {
+			continue
+		}
+		if err := d.fetchFrom(leader, location); err != nil {
+			return fmt.Errorf("unable to fetch %s from %s: %s", location, leader, err)
+		}
+		fetched++
+	}
+
+	stale := []string{}
+	d.blobMU.RLock()
+	for location := range d.blobsLocMap {
+		if _, ok := snap.Blobs[location]; !ok {
+			stale = append(stale, location)
+		}
+	}
+	d.blobMU.RUnlock()
+	for _, location := range stale {
+		version := snap.Tombstones[location]
+		if version == 0 {
+			version = d.newVersion(location)
+		}
+		if err := d.applyVersioned(types.OpDelete, location, version, "", nil); err != nil {
+			return err
+		}
+	}
+	logger.Infof("Restored raft snapshot: fetched %d blobs, removed %d", fetched, len(stale))
+	return nil
+}
+
+// fetchFrom copies the blob at location from node.
+func (d *Daemon) fetchFrom(node, location string) error {
+	req, err := http.NewRequest("GET", baseURL(node)+common.StorePath(location), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(common.PeerHeader, "1")
+	resp, err := d.raftTransport.snapshotClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil // deleted since, a later entry says so
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("node returned %s", resp.Status)
+	}
+	version, err := strconv.ParseInt(resp.Header.Get(common.VersionHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("node sent no valid version: %s", err)
+	}
+	return d.applyVersioned(types.OpUpdate, location, version, resp.Header.Get(common.CreatorHeader), resp.Body)
+}
+
+// raftTransport sends Raft RPCs to the other nodes over HTTP.
+type raftTransport struct {
+	client         *http.Client
+	snapshotClient *http.Client
+}
+
+func newRaftTransport(client, snapshotClient *http.Client) *raftTransport {
+	return &raftTransport{
+		client:         client,
+		snapshotClient: snapshotClient,
+	}
+}
+
+func (t *raftTransport) call(client *http.Client, node, path string, req, resp interface{}) error {
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := client.Post(baseURL(node)+path, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("node returned %s", r.Status)
+	}
+	return json.NewDecoder(r.Body).Decode(resp)
+}
+
+func (t *raftTransport) RequestVote(node string, req *types.VoteRequest) (*types.VoteResponse, error) {
+	resp := &types.VoteResponse{}
+	return resp, t.call(t.client, node, "/raft/vote", req, resp)
+}
+
+func (t *raftTransport) AppendEntries(node string, req *types.AppendRequest) (*types.AppendResponse, error) {
+	resp := &types.AppendResponse{}
+	return resp, t.call(t.client, node, "/raft/append", req, resp)
+}
+
+func (t *raftTransport) InstallSnapshot(node string, req *types.SnapshotRequest) (*types.SnapshotResponse, error) {
+	resp := &types.SnapshotResponse{}
+	return resp, t.call(t.snapshotClient, node, "/raft/snapshot", req, resp)
+}
+
+// startRaft joins the Raft group replicating the location map.
+func (d *Daemon) startRaft() (err error) {
+	d.raftTransport = newRaftTransport(d.newPeerClient(peerTimeout*time.Second), d.newPeerClient(raftSnapshotTimout*time.Second))
+	d.raft, err = raft.New(raft.Config{
+		ID:    d.conf.Advertise,
+		Nodes: d.conf.RaftNodes,
+		Dir:   filepath.Join(d.conf.DataDirBasePath, raftDirName),
+	}, &raftFSM{d: d}, d.raftTransport)
+	return err
+}
+
+// redirectToLeader answers requests only the leader serves with a redirect
+// to it. Other errors are returned as is.
+func redirectToLeader(err error, w http.ResponseWriter, r *http.Request) error {
+	nle, ok := err.(*raft.NotLeaderError)
+	if !ok || nle.Leader == "" {
+		return err
+	}
+	logger.Debugf("Redirecting %s %s to the raft leader %s", r.Method, r.URL.Path, nle.Leader)
+	w.Header().Set("Location", baseURL(nle.Leader)+r.URL.RequestURI())
+	w.WriteHeader(http.StatusTemporaryRedirect)
+	return nil
+}
+
+// raftWrite commits a client write through the Raft log. Followers redirect
+// the client to the leader.
+func (d *Daemon) raftWrite(op, location string, w http.ResponseWriter, r *http.Request) error {
+	if leader := d.raft.Leader(); leader != d.conf.Advertise {
+		return redirectToLeader(&raft.NotLeaderError{Leader: leader}, w, r)
+	}
+
+	_, exists := d.localVersion(location)
+	switch {
+	case op == types.OpCreate && exists:
+		return fmt.Errorf("Blob %s already exists", location)
+	case op != types.OpCreate && !exists:
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	c := &raftCommand{
+		Op:       op,
+		Location: location,
+		Version:  d.newVersion(location),
+		Creator:  creatorOf(r),
+	}
+	if op != types.OpDelete {
+		var err error
+		if c.Data, err = ioutil.ReadAll(r.Body); err != nil {
+			return err
+		}
+	}
+	buf, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := d.raft.Apply(buf, raftApplyTimeout*time.Second); err != nil {
+		return redirectToLeader(err, w, r)
+	}
+	d.publishEvent(op, location)
+	return nil
+}
+
+// raftRead serves a linearizable read from the leader.
+func (d *Daemon) raftRead(location string, w http.ResponseWriter, r *http.Request) error {
+	if err := d.raft.ReadBarrier(raftApplyTimeout * time.Second); err != nil {
+		return redirectToLeader(err, w, r)
+	}
+	return d.getBlob(location, w)
+}
+
+func (d *Daemon) checkRaft() error {
+	if d.raft == nil {
+		return fmt.Errorf("raft is not enabled")
+	}
+	return nil
+}
+
+// RaftVote handles a vote request of a candidate.
+func (d *Daemon) RaftVote(req *types.VoteRequest) (*types.VoteResponse, error) {
+	if err := d.checkRaft(); err != nil {
+		return nil, err
+	}
+	return d.raft.RequestVote(req), nil
+}
+
+// RaftAppend handles entries sent by the leader.
+func (d *Daemon) RaftAppend(req *types.AppendRequest) (*types.AppendResponse, error) {
+	if err := d.checkRaft(); err != nil {
+		return nil, err
+	}
+	return d.raft.AppendEntries(req), nil
+}
+
+// RaftSnapshot handles a snapshot sent by the leader.
+func (d *Daemon) RaftSnapshot(req *types.SnapshotRequest) (*types.SnapshotResponse, error) {
+	if err := d.checkRaft(); err != nil {
+		return nil, err
+	}
+	return d.raft.InstallSnapshot(req), nil
+}
+
+// RaftStatus describes this node of the Raft group.
+func (d *Daemon) RaftStatus() (*types.RaftStatus, error) {
+	if err := d.checkRaft(); err != nil {
+		return nil, err
+	}
+	return d.raft.Status(), nil
+}
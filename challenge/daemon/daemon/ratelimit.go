@@ -0,0 +1,67 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package daemon
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/auth"
+)
+
+// clientIdentity returns who the rates of r are counted against: its
+// principal if it has credentials of its own, else its address.
+func clientIdentity(p *types.Principal, r *http.Request) string {
+	if p != nil {
+		switch p.Method {
+		case auth.MethodNone, auth.MethodAnonymous, auth.MethodPresigned:
+		default:
+			return p.Method + ":" + p.Name
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// unlimited tells whether r, from p, is not subject to rate limits: none
+// are set, or it comes from another server.
+func (d *Daemon) unlimited(p *types.Principal, r *http.Request) bool {
+	return d.limits == nil ||
+		(p != nil && r.Header.Get(common.PeerHeader) != "" && p.Allowed(types.PermAdmin, ""))
+}
+
+// Admit tells whether r, from p, nil if it failed authentication, may be
+// served now, and if not how long its client should wait.
+func (d *Daemon) Admit(p *types.Principal, r *http.Request) (time.Duration, bool) {
+	if d.unlimited(p, r) {
+		return 0, true
+	}
+	return d.limits.Allow(clientIdentity(p, r))
+}
+
+// Throttle returns w and the body of r, from p, slowed down to the byte
+// rate of its client.
+func (d *Daemon) Throttle(p *types.Principal, w http.ResponseWriter, r *http.Request) (http.ResponseWriter, io.ReadCloser) {
+	if d.unlimited(p, r) {
+		return w, r.Body
+	}
+	id := clientIdentity(p, r)
+	return d.limits.Writer(id, w), d.limits.Reader(id, r.Body)
+}
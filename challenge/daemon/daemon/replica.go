@@ -0,0 +1,272 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+const replicaRetryInterval = 2 // in seconds
+
+// replicator follows the change stream of a primary and applies it to the
+// daemon.
+type replicator struct {
+	d       *Daemon
+	primary string // base URL of the primary
+	client  *http.Client
+	quit    chan struct{}
+	done    chan struct{}
+
+	mu          sync.Mutex
+	epoch       string // epoch of the primary we are following
+	applied     uint64 // last change of the primary applied here
+	primarySeq  uint64 // last change the primary told us about
+	caughtUp    time.Time
+	lastContact time.Time
+	lastErr     error
+}
+
+// baseURL turns a server address such as localhost:7777 into a URL.
+func baseURL(addr string) string {
+	if !strings.Contains(addr, "://") {
+		addr = peerScheme + "://" + addr
+	}
+	return strings.TrimRight(addr, "/")
+}
+
+func newReplicator(d *Daemon, primary string) *replicator {
+	return &replicator{
+		d:        d,
+		primary:  baseURL(primary),
+		client:   d.newPeerClient(0),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+		caughtUp: time.Now(),
+	}
+}
+
+// run pulls and applies changes from the primary until stopped.
+func (rp *replicator) run() {
+	defer close(rp.done)
+	logger.Infof("Replicating from %s", rp.primary)
+	for {
+		select {
+		case <-rp.quit:
+			return
+		default:
+		}
+		err := rp.pull()
+		rp.mu.Lock()
+		rp.lastErr = err
+		rp.mu.Unlock()
+		if err == nil {
+			continue
+		}
+		logger.Warningf("Replication from %s failed: %s", rp.primary, err)
+		select {
+		case <-rp.quit:
+			return
+		case <-time.After(replicaRetryInterval * time.Second):
+		}
+	}
+}
+
+// stop ends replication and waits for the change being applied, if any.
+func (rp *replicator) stop() {
+	close(rp.quit)
+	<-rp.done
+}
+
+// get issues a GET to path on the primary, cancelled when replication
+// stops.
+func (rp *replicator) get(path string, query url.Values) (*http.Response, error) {
+	u := rp.primary + (&url.URL{Path: path}).String()
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Cancel = rp.quit
+	return rp.client.Do(req)
+}
+
+// pull fetches the next batch of changes from the primary and applies them.
+func (rp *replicator) pull() error {
+	rp.mu.Lock()
+	query := url.Values{}
+	query.Set("epoch", rp.epoch)
+	query.Set("since", strconv.FormatUint(rp.applied, 10))
+	rp.mu.Unlock()
+
+	resp, err := rp.get("/replication/changes", query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("primary returned %s", resp.Status)
+	}
+	cs := &types.ChangeSet{}
+	if err := json.NewDecoder(resp.Body).Decode(cs); err != nil {
+		return fmt.Errorf("unable to decode changes: %s", err)
+	}
+
+	rp.mu.Lock()
+	rp.lastContact = time.Now()
+	rp.primarySeq = cs.Seq
+	rp.mu.Unlock()
+
+	if cs.Reset {
+		return rp.resync(cs)
+	}
+	for _, c := range cs.Changes {
+		if err := rp.apply(c); err != nil {
+			return err
+		}
+		rp.advance(cs.Epoch, c.Seq)
+	}
+	return nil
+}
+
+// resync makes the daemon hold exactly the blobs listed in cs.
+func (rp *replicator) resync(cs *types.ChangeSet) error {
+	logger.Infof("Resyncing %d blobs from %s", len(cs.Changes), rp.primary)
+	keep := make(map[string]bool)
+	for _, c := range cs.Changes {
+		if err := rp.apply(c); err != nil {
+			return err
+		}
+		keep[c.Location] = true
+	}
+
+	stale := []string{}
+	rp.d.blobMU.RLock()
+	for location := range rp.d.blobsLocMap {
+		if !keep[location] {
+			stale = append(stale, location)
+		}
+	}
+	rp.d.blobMU.RUnlock()
+	for _, location := range stale {
+		if err := rp.d.removeBlob(location, 0); err != nil && err != errBlobNotFound {
+			return err
+		}
+	}
+	rp.advance(cs.Epoch, cs.Seq)
+	return nil
+}
+
+func (rp *replicator) advance(epoch string, seq uint64) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.epoch = epoch
+	rp.applied = seq
+	if rp.applied >= rp.primarySeq {
+		rp.caughtUp = time.Now()
+	}
+}
+
+// apply replays change c of the primary.
+func (rp *replicator) apply(c types.Change) error {
+	if c.Op == types.OpDelete {
+		if err := rp.d.removeBlob(c.Location, 0); err != nil && err != errBlobNotFound {
+			return err
+		}
+		return nil
+	}
+
+	resp, err := rp.get(common.StorePath(c.Location), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		// deleted since, the delete is further down the stream
+		return nil
+	default:
+		return fmt.Errorf("primary returned %s for blob %s", resp.Status, c.Location)
+	}
+	return rp.d.putBlob(c.Location, 0, resp.Header.Get(common.CreatorHeader), resp.Body)
+}
+
+func (rp *replicator) status() *types.ReplicationStatus {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rs := &types.ReplicationStatus{
+		Primary:     rp.primary,
+		AppliedSeq:  rp.applied,
+		PrimarySeq:  rp.primarySeq,
+		LastContact: rp.lastContact,
+	}
+	if rp.primarySeq > rp.applied {
+		rs.LagOps = rp.primarySeq - rp.applied
+		rs.LagSeconds = time.Since(rp.caughtUp).Seconds()
+	}
+	if rp.lastErr != nil {
+		rs.LastError = rp.lastErr.Error()
+	}
+	return rs
+}
+
+// putBlob creates the blob at location, or replaces it if it exists. A
+// version of 0 picks a new one.
+func (d *Daemon) putBlob(location string, version int64, creator string, body io.Reader) error {
+	err := d.updateBlob(location, version, creator, body)
+	if err == errBlobNotFound {
+		return d.createBlob(location, version, creator, body)
+	}
+	return err
+}
+
+// checkWritable returns an error if clients may not change blobs here.
+func (d *Daemon) checkWritable() error {
+	d.replMU.RLock()
+	defer d.replMU.RUnlock()
+	if d.replica != nil {
+		return fmt.Errorf("this server is a read-only replica of %s", d.replica.primary)
+	}
+	return nil
+}
+
+// Promote stops replication and makes this server a primary accepting
+// writes.
+func (d *Daemon) Promote() error {
+	// writes are held off until the last change from the primary is in
+	d.replMU.Lock()
+	defer d.replMU.Unlock()
+	rp := d.replica
+	if rp == nil {
+		return fmt.Errorf("this server is not a replica")
+	}
+	rp.stop()
+	d.replica = nil
+
+	st := rp.status()
+	logger.Infof("Promoted to primary at seq %d of %s (%d changes behind)", st.AppliedSeq, rp.primary, st.LagOps)
+	return nil
+}
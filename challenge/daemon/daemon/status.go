@@ -12,8 +12,25 @@
 //
 package daemon
 
-func (d *Daemon) GlobalStatus() (string, error) {
+import (
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+func (d *Daemon) GlobalStatus() (*types.Status, error) {
 	logger.Infof("Received Staus Request...")
-	// TODO(awander): add a more useful status if desired...
-	return "Ok!", nil
+	st := &types.Status{
+		Status: "Ok!",
+		Role:   types.RolePrimary,
+		Seq:    d.changes.lastSeq(),
+	}
+	d.replMU.RLock()
+	if d.replica != nil {
+		st.Role = types.RoleReplica
+		st.Replication = d.replica.status()
+	}
+	d.replMU.RUnlock()
+	if d.raft != nil {
+		st.Raft = d.raft.Status()
+	}
+	return st, nil
 }
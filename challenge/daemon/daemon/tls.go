@@ -0,0 +1,66 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/certs"
+)
+
+// peerScheme is the scheme of the servers given as host:port, https when
+// we listen with TLS: servers of a group are expected to agree on it.
+var peerScheme = "http"
+
+// initTLS loads the certificates of the listener, if any, and reloads them
+// on each SIGHUP.
+func (d *Daemon) initTLS() (err error) {
+	if d.conf.TLSCert == "" && d.conf.TLSKey == "" {
+		if d.conf.TLSClientCA != "" {
+			return fmt.Errorf("a client CA bundle needs a TLS certificate and key")
+		}
+		return nil
+	}
+	if d.conf.TLSCert == "" || d.conf.TLSKey == "" {
+		return fmt.Errorf("TLS needs both a certificate and a key")
+	}
+	if d.certs, err = certs.New(d.conf.TLSCert, d.conf.TLSKey, d.conf.TLSClientCA, d.conf.TLSClientOptional); err != nil {
+		return err
+	}
+	peerScheme = "https"
+	go d.reloadCertsOnHangup()
+	return nil
+}
+
+func (d *Daemon) reloadCertsOnHangup() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		logger.Infof("SIGHUP received, reloading TLS certificates")
+		if err := d.certs.Reload(); err != nil {
+			logger.Errorf("Keeping the current TLS certificates: %s", err)
+		}
+	}
+}
+
+// TLSListener returns l speaking TLS, or l itself without TLS.
+func (d *Daemon) TLSListener(l net.Listener) net.Listener {
+	if d.certs == nil {
+		return l
+	}
+	return d.certs.Listen(l)
+}
@@ -0,0 +1,54 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package daemon
+
+import (
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+const webhooksFileName = "webhooks.json"
+
+// Webhooks returns the registered webhooks.
+func (d *Daemon) Webhooks() ([]types.Webhook, error) {
+	return d.webhooks.List(), nil
+}
+
+// AddWebhook registers a webhook. The one returned holds its ID and secret.
+func (d *Daemon) AddWebhook(wh *types.Webhook) (*types.Webhook, error) {
+	return d.webhooks.Add(wh)
+}
+
+// Webhook returns the webhook with the given ID, or webhook.ErrNotFound.
+func (d *Daemon) Webhook(id string) (*types.Webhook, error) {
+	return d.webhooks.Get(id)
+}
+
+// RemoveWebhook unregisters a webhook.
+func (d *Daemon) RemoveWebhook(id string) error {
+	return d.webhooks.Remove(id)
+}
+
+// WebhookDeliveries returns the latest deliveries to a webhook.
+func (d *Daemon) WebhookDeliveries(id string) ([]types.WebhookDelivery, error) {
+	return d.webhooks.Deliveries(id)
+}
+
+// WebhookDeadLetters returns the events given up on for a webhook.
+func (d *Daemon) WebhookDeadLetters(id string) ([]types.WebhookDelivery, error) {
+	return d.webhooks.DeadLetters(id)
+}
+
+// RedeliverWebhook queues the dead letters of a webhook again.
+func (d *Daemon) RedeliverWebhook(id string) (int, error) {
+	return d.webhooks.Redeliver(id)
+}
@@ -0,0 +1,116 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package server
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/auth"
+
+	"github.com/gorilla/mux"
+)
+
+// statusRecorder remembers the status a request is answered with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// audit prepares the audit record of r, from p, nil if it failed
+// authentication: every client request is recorded, whatever its method.
+// It returns the writer to answer r with and the function recording r once
+// answered.
+func (router *Router) audit(p *types.Principal, w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	if !router.daemon.Auditing() || peerRequest(p, r) {
+		return w, func() {}
+	}
+
+	rec := &types.AuditRecord{
+		Time:       time.Now().UTC(),
+		Principal:  "-",
+		RemoteAddr: r.RemoteAddr,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+	}
+	if p != nil {
+		rec.Principal = p.Method + ":" + p.Name
+	}
+	location, isBlob := blobLocation(mux.Vars(r))
+	if isBlob {
+		rec.Location = location
+		if id, ok := router.daemon.BlobID(location); ok {
+			rec.OldID = &id
+		}
+	}
+	recorder := &statusRecorder{ResponseWriter: w}
+	body := &countingReader{ReadCloser: r.Body}
+	r.Body = body
+
+	return recorder, func() {
+		if isBlob {
+			if id, ok := router.daemon.BlobID(location); ok {
+				rec.NewID = &id
+			}
+		}
+		rec.Status = recorder.status
+		if rec.Status == 0 {
+			rec.Status = http.StatusOK
+		}
+		rec.Bytes = body.n
+		router.daemon.Audit(rec)
+	}
+}
+
+// peerRequest tells whether r was sent by another server: it is marked as a
+// peer request and was authenticated, with credentials having the admin
+// permission. The header alone proves nothing, any client can set it.
+func peerRequest(p *types.Principal, r *http.Request) bool {
+	if p == nil || r.Header.Get(common.PeerHeader) == "" {
+		return false
+	}
+	switch p.Method {
+	case auth.MethodNone, auth.MethodAnonymous:
+		return false
+	}
+	return p.Allowed(types.PermAdmin, "")
+}
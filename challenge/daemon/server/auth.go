@@ -0,0 +1,203 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/acl"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/auth"
+
+	"github.com/gorilla/mux"
+)
+
+// processAuthError answers a request that isn't authenticated (401) or not
+// allowed (403).
+func processAuthError(w http.ResponseWriter, r *http.Request, code int, text string) {
+	sErr := types.ServerError{Code: code, Text: text}
+	logger.Infof("Refusing %s %s from %s: %s", r.Method, r.URL.Path, r.RemoteAddr, text)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(sErr); err != nil {
+		logger.Errorf("Error while encoding %T '%+v': \"%s\"", sErr, sErr, err)
+	}
+}
+
+// processRateLimit answers a request over the rate of its client (429),
+// telling it when to come back.
+func processRateLimit(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	seconds := int64((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	processAuthError(w, r, http.StatusTooManyRequests, "rate limit exceeded")
+}
+
+// authorize creates a wrapper for inner only serving requests whose
+// principal has perm, through its grants or an ACL, on the location of the
+// request: the {location} of the route, in its {bucket}, else the prefix
+// query parameter. Requests sent as a peer need the admin permission.
+// Requests over the rate of their client are refused, and the others read
+// and answered at its byte rate. Client requests are recorded in the audit
+// log, refused or not. The principal is put in the request's context.
+func (router *Router) authorize(inner http.Handler, perm string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := router.daemon.Authenticate(r)
+		w, audited := router.audit(p, w, r)
+		defer audited()
+		if wait, ok := router.daemon.Admit(p, r); !ok {
+			processRateLimit(w, r, wait)
+			return
+		}
+		if err != nil {
+			processAuthError(w, r, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		need := perm
+		if need != "" && r.Header.Get(common.PeerHeader) != "" {
+			need = types.PermAdmin
+		}
+		if need != "" {
+			location, ok := blobLocation(mux.Vars(r))
+			if !ok {
+				location = r.URL.Query().Get("prefix")
+			}
+			if !router.daemon.Allowed(p, need, location) {
+				if p.Method == auth.MethodAnonymous {
+					processAuthError(w, r, http.StatusUnauthorized, "credentials required")
+				} else {
+					processAuthError(w, r, http.StatusForbidden,
+						fmt.Sprintf("%s has no %s permission on %q", p.Name, need, location))
+				}
+				return
+			}
+		}
+		w, r.Body = router.daemon.Throttle(p, w, r)
+		inner.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), p)))
+	})
+}
+
+// presign mints a presigned URL for the principal of the request, which
+// must be allowed what the URL allows.
+func (router *Router) presign(w http.ResponseWriter, r *http.Request) {
+	req := &types.PresignRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	p, _ := auth.FromContext(r.Context())
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	u, err := router.daemon.Presign(p, req, scheme+"://"+r.Host)
+	if err == auth.ErrForbidden && p.Method == auth.MethodAnonymous {
+		processAuthError(w, r, http.StatusUnauthorized, "credentials required")
+		return
+	}
+	if err == auth.ErrForbidden {
+		processAuthError(w, r, http.StatusForbidden,
+			fmt.Sprintf("%s can't presign %s requests to %q", p.Name, req.Method, req.Location))
+		return
+	}
+	if err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(u); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+// processACLError answers a request about ACLs that failed.
+func processACLError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case acl.ErrNotFound:
+		w.WriteHeader(http.StatusNotFound)
+	case auth.ErrForbidden:
+		p, _ := auth.FromContext(r.Context())
+		if p.Method == auth.MethodAnonymous {
+			processAuthError(w, r, http.StatusUnauthorized, "credentials required")
+			return
+		}
+		processAuthError(w, r, http.StatusForbidden,
+			fmt.Sprintf("%s doesn't own the ACL of %q", p.Name, aclPrefix(r)))
+	default:
+		processServerError(w, r, err)
+	}
+}
+
+// aclPrefix returns the prefix an ACL request is about.
+func aclPrefix(r *http.Request) string {
+	prefix, _ := blobLocation(mux.Vars(r))
+	return prefix
+}
+
+func (router *Router) getACL(w http.ResponseWriter, r *http.Request) {
+	a, err := router.daemon.ACL(aclPrefix(r))
+	if err != nil {
+		processACLError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(a); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) setACL(w http.ResponseWriter, r *http.Request) {
+	a := &types.ACL{}
+	if err := json.NewDecoder(r.Body).Decode(a); err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	a.Prefix = aclPrefix(r)
+	p, _ := auth.FromContext(r.Context())
+	a, err := router.daemon.SetACL(p, a, r.Header.Get(common.PeerHeader) != "")
+	if err != nil {
+		processACLError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(a); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) removeACL(w http.ResponseWriter, r *http.Request) {
+	p, _ := auth.FromContext(r.Context())
+	err := router.daemon.RemoveACL(p, aclPrefix(r), r.Header.Get(common.PeerHeader) != "")
+	if err != nil {
+		processACLError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (router *Router) acls(w http.ResponseWriter, r *http.Request) {
+	list, err := router.daemon.ACLs()
+	if err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		processServerError(w, r, err)
+	}
+}
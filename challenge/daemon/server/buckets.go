@@ -0,0 +1,114 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/auth"
+
+	"github.com/gorilla/mux"
+)
+
+func (router *Router) buckets(w http.ResponseWriter, r *http.Request) {
+	p, _ := auth.FromContext(r.Context())
+	list, err := router.daemon.Buckets(p)
+	if err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) createBucket(w http.ResponseWriter, r *http.Request) {
+	b := &types.Bucket{}
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	p, _ := auth.FromContext(r.Context())
+	b, err := router.daemon.CreateBucket(p, b)
+	if err != nil {
+		processBucketError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(b); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) getBucket(w http.ResponseWriter, r *http.Request) {
+	b, err := router.daemon.Bucket(mux.Vars(r)["bucket"])
+	if err != nil {
+		processBucketError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(b); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) setBucket(w http.ResponseWriter, r *http.Request) {
+	b := &types.Bucket{}
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	b.Name = mux.Vars(r)["bucket"]
+	p, _ := auth.FromContext(r.Context())
+	b, err := router.daemon.SetBucket(p, b, r.Header.Get(common.PeerHeader) != "")
+	if err != nil {
+		processBucketError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(b); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) removeBucket(w http.ResponseWriter, r *http.Request) {
+	p, _ := auth.FromContext(r.Context())
+	err := router.daemon.RemoveBucket(p, mux.Vars(r)["bucket"], r.Header.Get(common.PeerHeader) != "")
+	if err != nil {
+		processBucketError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (router *Router) versions(w http.ResponseWriter, r *http.Request) {
+	location, exists := blobLocation(mux.Vars(r))
+	if !exists {
+		processServerError(w, r, errors.New("server received versions without location"))
+		return
+	}
+	list, err := router.daemon.Versions(location)
+	if err != nil {
+		processBucketError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		processServerError(w, r, err)
+	}
+}
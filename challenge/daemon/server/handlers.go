@@ -15,7 +15,17 @@ package server
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/auth"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/bucket"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/quota"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/webhook"
 
 	"github.com/gorilla/mux"
 )
@@ -31,59 +41,457 @@ func (router *Router) globalStatus(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (router *Router) getBlob(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
+// blobLocation returns the location of a request: its {location}, in its
+// {bucket} if it has one. Requests about a whole bucket get <bucket>/.
+func blobLocation(vars map[string]string) (string, bool) {
 	location, exists := vars["location"]
+	if bucket, ok := vars["bucket"]; ok {
+		return common.BucketLocation(bucket, location), true
+	}
+	return location, exists
+}
+
+// processBucketError answers a request about a blob or a bucket that
+// failed because of the bucket or of a quota.
+func processBucketError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case bucket.ErrNotFound, quota.ErrNotFound:
+		w.WriteHeader(http.StatusNotFound)
+	case bucket.ErrExists, bucket.ErrNotEmpty:
+		processAuthError(w, r, http.StatusConflict, err.Error())
+	case quota.ErrExceeded:
+		processAuthError(w, r, http.StatusInsufficientStorage, err.Error())
+	case quota.ErrTooLarge:
+		processAuthError(w, r, http.StatusRequestEntityTooLarge, err.Error())
+	case auth.ErrForbidden:
+		p, _ := auth.FromContext(r.Context())
+		if p.Method == auth.MethodAnonymous {
+			processAuthError(w, r, http.StatusUnauthorized, "credentials required")
+			return
+		}
+		processAuthError(w, r, http.StatusForbidden, fmt.Sprintf("%s may not manage buckets", p.Name))
+	default:
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) getBlob(w http.ResponseWriter, r *http.Request) {
+	location, exists := blobLocation(mux.Vars(r))
 	if !exists {
 		processServerError(w, r, errors.New("server received get without location"))
 		return
 	}
 
+	if forwarded, err := router.daemon.Forward(location, w, r); forwarded {
+		if err != nil {
+			processServerError(w, r, err)
+		}
+		return
+	}
+
 	if err := router.daemon.GetBlob(location, w, r); err != nil {
-		processServerError(w, r, err)
+		processBucketError(w, r, err)
+		return
+	}
+}
+
+func (router *Router) headBlob(w http.ResponseWriter, r *http.Request) {
+	location, exists := blobLocation(mux.Vars(r))
+	if !exists {
+		processServerError(w, r, errors.New("server received head without location"))
+		return
+	}
+
+	if forwarded, err := router.daemon.Forward(location, w, r); forwarded {
+		if err != nil {
+			processServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := router.daemon.HeadBlob(location, w, r); err != nil {
+		processBucketError(w, r, err)
 		return
 	}
 }
 
 func (router *Router) createBlob(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	location, exists := vars["location"]
+	location, exists := blobLocation(mux.Vars(r))
 	if !exists {
 		processServerError(w, r, errors.New("server received create without location"))
 		return
 	}
 
+	if forwarded, err := router.daemon.Forward(location, w, r); forwarded {
+		if err != nil {
+			processServerError(w, r, err)
+		}
+		return
+	}
+
 	if err := router.daemon.CreateBlob(location, w, r); err != nil {
-		processServerError(w, r, err)
+		processBucketError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (router *Router) deleteBlob(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	location, exists := vars["location"]
+	location, exists := blobLocation(mux.Vars(r))
 	if !exists {
 		processServerError(w, r, errors.New("server received delete without location"))
 		return
 	}
 
+	if forwarded, err := router.daemon.Forward(location, w, r); forwarded {
+		if err != nil {
+			processServerError(w, r, err)
+		}
+		return
+	}
+
 	if err := router.daemon.DeleteBlob(location, w, r); err != nil {
-		processServerError(w, r, err)
+		processBucketError(w, r, err)
 		return
 	}
 }
 
 func (router *Router) updateBlob(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	location, exists := vars["location"]
+	location, exists := blobLocation(mux.Vars(r))
 	if !exists {
 		processServerError(w, r, errors.New("server received update without location"))
 		return
 	}
 
+	if forwarded, err := router.daemon.Forward(location, w, r); forwarded {
+		if err != nil {
+			processServerError(w, r, err)
+		}
+		return
+	}
+
 	if err := router.daemon.UpdateBlob(location, w, r); err != nil {
+		processBucketError(w, r, err)
+		return
+	}
+}
+
+func (router *Router) changes(w http.ResponseWriter, r *http.Request) {
+	var since uint64
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+			processServerError(w, r, errors.New("invalid since parameter"))
+			return
+		}
+	}
+
+	cs, err := router.daemon.Changes(r.URL.Query().Get("epoch"), since)
+	if err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(cs); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+// watch streams the changes to the locations starting with the prefix
+// parameter. Clients accepting text/event-stream get server-sent events,
+// with the sequence number of each change as its id; the others get the
+// next changes as JSON once there are some. Without a since parameter or a
+// Last-Event-ID header, only changes made from now on are sent.
+func (router *Router) watch(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	since := router.daemon.LastChange()
+	s := r.URL.Query().Get("since")
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		s = id
+	}
+	if s != "" {
+		var err error
+		if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+			processServerError(w, r, errors.New("invalid since parameter"))
+			return
+		}
+	}
+
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		cs, err := router.daemon.Watch(prefix, since, r.Context().Done())
+		if err != nil {
+			processServerError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(cs); err != nil {
+			processServerError(w, r, err)
+		}
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		processServerError(w, r, errors.New("streaming is not supported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		cs, err := router.daemon.Watch(prefix, since, r.Context().Done())
+		if err != nil {
+			logger.Errorf("Unable to watch %q: %s", prefix, err)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		default:
+		}
+		switch {
+		case cs.Reset:
+			// the changes after since are gone, the client gets the
+			// matching blobs instead
+			buf, _ := json.Marshal(cs)
+			fmt.Fprintf(w, "id: %d\nevent: reset\ndata: %s\n\n", cs.Seq, buf)
+		case len(cs.Changes) == 0:
+			// keeps proxies from closing an idle stream
+			fmt.Fprintf(w, ": keep-alive\n\n")
+		default:
+			for _, c := range cs.Changes {
+				buf, _ := json.Marshal(c)
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", c.Seq, c.Op, buf)
+			}
+		}
+		flusher.Flush()
+		since = cs.Seq
+	}
+}
+
+func (router *Router) promote(w http.ResponseWriter, r *http.Request) {
+	if err := router.daemon.Promote(); err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (router *Router) ring(w http.ResponseWriter, r *http.Request) {
+	rg, err := router.daemon.Ring()
+	if err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(rg); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) setRing(w http.ResponseWriter, r *http.Request) {
+	rg := &types.Ring{}
+	if err := json.NewDecoder(r.Body).Decode(rg); err != nil {
 		processServerError(w, r, err)
 		return
 	}
+	if err := router.daemon.SetRing(rg, r.Header.Get(common.PeerHeader) != ""); err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (router *Router) members(w http.ResponseWriter, r *http.Request) {
+	members, err := router.daemon.Members()
+	if err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(members); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) raftVote(w http.ResponseWriter, r *http.Request) {
+	req := &types.VoteRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	resp, err := router.daemon.RaftVote(req)
+	if err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) raftAppend(w http.ResponseWriter, r *http.Request) {
+	req := &types.AppendRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	resp, err := router.daemon.RaftAppend(req)
+	if err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) raftSnapshot(w http.ResponseWriter, r *http.Request) {
+	req := &types.SnapshotRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	resp, err := router.daemon.RaftSnapshot(req)
+	if err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) raftStatus(w http.ResponseWriter, r *http.Request) {
+	st, err := router.daemon.RaftStatus()
+	if err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(st); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) merkleNode(w http.ResponseWriter, r *http.Request) {
+	node, err := router.daemon.MerkleNode(r.URL.Query().Get("path"))
+	if err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(node); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+// processWebhookError answers with a 404 for an unknown webhook.
+func processWebhookError(w http.ResponseWriter, r *http.Request, err error) {
+	if err == webhook.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	processServerError(w, r, err)
+}
+
+func (router *Router) webhooks(w http.ResponseWriter, r *http.Request) {
+	list, err := router.daemon.Webhooks()
+	if err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) addWebhook(w http.ResponseWriter, r *http.Request) {
+	wh := &types.Webhook{}
+	if err := json.NewDecoder(r.Body).Decode(wh); err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	added, err := router.daemon.AddWebhook(wh)
+	if err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(added); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) webhook(w http.ResponseWriter, r *http.Request) {
+	wh, err := router.daemon.Webhook(mux.Vars(r)["id"])
+	if err != nil {
+		processWebhookError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(wh); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) removeWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := router.daemon.RemoveWebhook(mux.Vars(r)["id"]); err != nil {
+		processWebhookError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (router *Router) webhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	deliveries, err := router.daemon.WebhookDeliveries(mux.Vars(r)["id"])
+	if err != nil {
+		processWebhookError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(deliveries); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) webhookDeadLetters(w http.ResponseWriter, r *http.Request) {
+	deadLetters, err := router.daemon.WebhookDeadLetters(mux.Vars(r)["id"])
+	if err != nil {
+		processWebhookError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(deadLetters); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	n, err := router.daemon.RedeliverWebhook(mux.Vars(r)["id"])
+	if err != nil {
+		processWebhookError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]int{"requeued": n}); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) sinks(w http.ResponseWriter, r *http.Request) {
+	sinks, err := router.daemon.Sinks()
+	if err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(sinks); err != nil {
+		processServerError(w, r, err)
+	}
 }
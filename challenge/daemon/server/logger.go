@@ -15,20 +15,28 @@ package server
 import (
 	"net/http"
 	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/auth"
 )
 
-// Logger creates a wrapper for inner and logs all requests made to that particular inner.
+// Logger creates a wrapper for inner and logs all requests made to that particular inner,
+// with the principal they were authenticated as.
 func Logger(inner http.Handler, name string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
 		inner.ServeHTTP(w, r)
 
+		principal := "-"
+		if p, ok := auth.FromContext(r.Context()); ok {
+			principal = p.Method + ":" + p.Name
+		}
 		logger.Debugf(
-			"[SERVER] %s\t%s\t%s\t%s",
+			"[SERVER] %s\t%s\t%s\t%s\t%s",
 			r.Method,
 			r.RequestURI,
 			name,
+			principal,
 			time.Since(start),
 		)
 	})
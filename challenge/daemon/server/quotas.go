@@ -0,0 +1,91 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/auth"
+)
+
+// processQuotaError answers a request about quotas that failed.
+func processQuotaError(w http.ResponseWriter, r *http.Request, err error) {
+	if err != auth.ErrForbidden {
+		processBucketError(w, r, err)
+		return
+	}
+	p, _ := auth.FromContext(r.Context())
+	if p.Method == auth.MethodAnonymous {
+		processAuthError(w, r, http.StatusUnauthorized, "credentials required")
+		return
+	}
+	processAuthError(w, r, http.StatusForbidden, fmt.Sprintf("%s may not manage quotas", p.Name))
+}
+
+func (router *Router) usage(w http.ResponseWriter, r *http.Request) {
+	p, _ := auth.FromContext(r.Context())
+	report, err := router.daemon.Usage(p)
+	if err != nil {
+		processQuotaError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) quotas(w http.ResponseWriter, r *http.Request) {
+	p, _ := auth.FromContext(r.Context())
+	list, err := router.daemon.Quotas(p)
+	if err != nil {
+		processQuotaError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) setQuota(w http.ResponseWriter, r *http.Request) {
+	q := &types.PrefixQuota{}
+	if err := json.NewDecoder(r.Body).Decode(q); err != nil {
+		processServerError(w, r, err)
+		return
+	}
+	p, _ := auth.FromContext(r.Context())
+	q, err := router.daemon.SetQuota(p, q, r.Header.Get(common.PeerHeader) != "")
+	if err != nil {
+		processQuotaError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(q); err != nil {
+		processServerError(w, r, err)
+	}
+}
+
+func (router *Router) removeQuota(w http.ResponseWriter, r *http.Request) {
+	p, _ := auth.FromContext(r.Context())
+	err := router.daemon.RemoveQuota(p, r.URL.Query().Get("prefix"), r.Header.Get(common.PeerHeader) != "")
+	if err != nil {
+		processQuotaError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -36,7 +36,8 @@ func NewRouter(daemon backend.DaemonBackend) Router {
 	r := Router{mRouter, routes{}, daemon}
 	r.initBackendRoutes()
 	for _, route := range r.routes {
-		handler := Logger(route.HandlerFunc, route.Name)
+		// requests refused by authorize are logged by it
+		handler := r.authorize(Logger(route.HandlerFunc, route.Name), route.Perm)
 
 		r.Methods(route.Method).
 			Path(route.Pattern).
@@ -14,12 +14,16 @@ package server
 
 import (
 	"net/http"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
 )
 
 type route struct {
-	Name        string
-	Method      string
-	Pattern     string
+	Name    string
+	Method  string
+	Pattern string
+	// permission needed on the location of the request, none if empty
+	Perm        string
 	HandlerFunc http.HandlerFunc
 }
 
@@ -28,24 +32,197 @@ type routes []route
 // * POST /store/<location> - Create new blob at location
 // * PUT /store/<location> - Update, or replace blob
 // * GET /store/<location> - Get blob
+// * HEAD /store/<location> - Check blob exists and get its version
 // * DELETE /store/<location> - Delete blob
+// * GET /replication/changes?epoch=<epoch>&since=<seq> - Changes after seq, for replicas
+// * POST /replication/promote - Turn a replica into a primary
+// * GET /watch?prefix=<prefix>&since=<seq> - Changes to locations, as server-sent events or long-polled JSON
+// * GET /cluster/ring - Members of the cluster
+// * PUT /cluster/ring - Change the members of the cluster
+// * GET /cluster/members - Members known to gossip and their state
+// * POST /raft/{vote,append,snapshot} - Raft RPCs between nodes
+// * GET /raft/status - State of this raft node
+// * GET /merkle?path=<hex> - Node of the Merkle tree of the blobs held here
+// * GET /webhooks - Registered webhooks and their delivery stats
+// * POST /webhooks - Register a webhook
+// * GET /webhooks/<id> - Get a webhook
+// * DELETE /webhooks/<id> - Remove a webhook
+// * GET /webhooks/<id>/deliveries - Latest deliveries to a webhook
+// * GET /webhooks/<id>/dead-letters - Events given up on
+// * POST /webhooks/<id>/redeliver - Queue the dead letters again
+// * GET /sinks - Sinks events are published to and their consumers' offsets
+// * POST /presign - Mint a URL giving time-limited access to a location
+// * GET /acl/<location> - ACL covering a location, its own or a prefix's
+// * PUT /acl/<prefix> - Set the ACL of a prefix
+// * DELETE /acl/<prefix> - Remove the ACL of a prefix
+// * GET /acls - Every ACL
+// * GET /buckets - Buckets the caller may read from
+// * POST /buckets - Create a bucket
+// * GET /buckets/<bucket> - Settings and usage of a bucket
+// * PUT /buckets/<bucket> - Change the settings of a bucket
+// * DELETE /buckets/<bucket> - Delete an empty bucket
+// * POST, PUT, GET, HEAD, DELETE /buckets/<bucket>/store/<location> - Blobs of a bucket, as /store/<location>
+// * GET /buckets/<bucket>/versions/<location> - Versions of a blob of a versioned bucket
+// * GET, PUT, DELETE /buckets/<bucket>/acl[/<prefix>] - ACLs of a bucket and of prefixes in it, as /acl/<prefix>
+// * GET /usage - What buckets, prefixes with a quota and API keys hold, with their quotas
+// * GET /quotas - Quotas of prefixes
+// * PUT /quotas - Set the quota of a prefix
+// * DELETE /quotas?prefix=<prefix> - Remove the quota of a prefix
 
 func (r *Router) initBackendRoutes() {
 	r.routes = routes{
 		route{
-			"GlobalStatus", "GET", "/healthz", r.globalStatus,
+			"GlobalStatus", "GET", "/healthz", "", r.globalStatus,
+		},
+		route{
+			"CreateBlob", "POST", "/store/{location}", types.PermWrite, r.createBlob,
+		},
+		route{
+			"DeleteBlob", "DELETE", "/store/{location}", types.PermDelete, r.deleteBlob,
+		},
+		route{
+			"UpdateBlob", "PUT", "/store/{location}", types.PermWrite, r.updateBlob,
+		},
+		route{
+			"GetBlob", "GET", "/store/{location}", types.PermRead, r.getBlob,
+		},
+		route{
+			"HeadBlob", "HEAD", "/store/{location}", types.PermRead, r.headBlob,
+		},
+		route{
+			"Changes", "GET", "/replication/changes", types.PermAdmin, r.changes,
+		},
+		route{
+			"Promote", "POST", "/replication/promote", types.PermAdmin, r.promote,
+		},
+		route{
+			"Watch", "GET", "/watch", types.PermRead, r.watch,
+		},
+		route{
+			"Ring", "GET", "/cluster/ring", types.PermAdmin, r.ring,
+		},
+		route{
+			"SetRing", "PUT", "/cluster/ring", types.PermAdmin, r.setRing,
+		},
+		route{
+			"Members", "GET", "/cluster/members", types.PermAdmin, r.members,
+		},
+		route{
+			"RaftVote", "POST", "/raft/vote", types.PermAdmin, r.raftVote,
+		},
+		route{
+			"RaftAppend", "POST", "/raft/append", types.PermAdmin, r.raftAppend,
+		},
+		route{
+			"RaftSnapshot", "POST", "/raft/snapshot", types.PermAdmin, r.raftSnapshot,
+		},
+		route{
+			"RaftStatus", "GET", "/raft/status", types.PermAdmin, r.raftStatus,
+		},
+		route{
+			"MerkleNode", "GET", "/merkle", types.PermAdmin, r.merkleNode,
+		},
+		route{
+			"Webhooks", "GET", "/webhooks", types.PermAdmin, r.webhooks,
+		},
+		route{
+			"AddWebhook", "POST", "/webhooks", types.PermAdmin, r.addWebhook,
+		},
+		route{
+			"Webhook", "GET", "/webhooks/{id}", types.PermAdmin, r.webhook,
+		},
+		route{
+			"RemoveWebhook", "DELETE", "/webhooks/{id}", types.PermAdmin, r.removeWebhook,
+		},
+		route{
+			"WebhookDeliveries", "GET", "/webhooks/{id}/deliveries", types.PermAdmin, r.webhookDeliveries,
+		},
+		route{
+			"WebhookDeadLetters", "GET", "/webhooks/{id}/dead-letters", types.PermAdmin, r.webhookDeadLetters,
+		},
+		route{
+			"RedeliverWebhook", "POST", "/webhooks/{id}/redeliver", types.PermAdmin, r.redeliverWebhook,
+		},
+		route{
+			"Sinks", "GET", "/sinks", types.PermAdmin, r.sinks,
+		},
+		route{
+			"Presign", "POST", "/presign", "", r.presign,
+		},
+		route{
+			"GetACL", "GET", "/acl/{location}", types.PermRead, r.getACL,
+		},
+		route{
+			"SetACL", "PUT", "/acl/{location}", "", r.setACL,
+		},
+		route{
+			"RemoveACL", "DELETE", "/acl/{location}", "", r.removeACL,
+		},
+		route{
+			"ACLs", "GET", "/acls", types.PermAdmin, r.acls,
+		},
+		route{
+			"Buckets", "GET", "/buckets", "", r.buckets,
+		},
+		route{
+			"CreateBucket", "POST", "/buckets", "", r.createBucket,
+		},
+		route{
+			"GetBucket", "GET", "/buckets/{bucket}", types.PermRead, r.getBucket,
+		},
+		route{
+			"SetBucket", "PUT", "/buckets/{bucket}", "", r.setBucket,
+		},
+		route{
+			"RemoveBucket", "DELETE", "/buckets/{bucket}", "", r.removeBucket,
+		},
+		route{
+			"CreateBucketBlob", "POST", "/buckets/{bucket}/store/{location}", types.PermWrite, r.createBlob,
+		},
+		route{
+			"DeleteBucketBlob", "DELETE", "/buckets/{bucket}/store/{location}", types.PermDelete, r.deleteBlob,
+		},
+		route{
+			"UpdateBucketBlob", "PUT", "/buckets/{bucket}/store/{location}", types.PermWrite, r.updateBlob,
+		},
+		route{
+			"GetBucketBlob", "GET", "/buckets/{bucket}/store/{location}", types.PermRead, r.getBlob,
+		},
+		route{
+			"HeadBucketBlob", "HEAD", "/buckets/{bucket}/store/{location}", types.PermRead, r.headBlob,
+		},
+		route{
+			"BlobVersions", "GET", "/buckets/{bucket}/versions/{location}", types.PermRead, r.versions,
+		},
+		route{
+			"GetBucketACL", "GET", "/buckets/{bucket}/acl", types.PermRead, r.getACL,
+		},
+		route{
+			"SetBucketACL", "PUT", "/buckets/{bucket}/acl", "", r.setACL,
+		},
+		route{
+			"RemoveBucketACL", "DELETE", "/buckets/{bucket}/acl", "", r.removeACL,
+		},
+		route{
+			"GetPrefixACL", "GET", "/buckets/{bucket}/acl/{location}", types.PermRead, r.getACL,
+		},
+		route{
+			"SetPrefixACL", "PUT", "/buckets/{bucket}/acl/{location}", "", r.setACL,
+		},
+		route{
+			"RemovePrefixACL", "DELETE", "/buckets/{bucket}/acl/{location}", "", r.removeACL,
 		},
 		route{
-			"CreateBlob", "POST", "/store/{location}", r.createBlob,
+			"Usage", "GET", "/usage", types.PermAdmin, r.usage,
 		},
 		route{
-			"DeleteBlob", "DELETE", "/store/{location}", r.deleteBlob,
+			"Quotas", "GET", "/quotas", types.PermAdmin, r.quotas,
 		},
 		route{
-			"UpdateBlob", "PUT", "/store/{location}", r.updateBlob,
+			"SetQuota", "PUT", "/quotas", "", r.setQuota,
 		},
 		route{
-			"GetBlob", "GET", "/store/{location}", r.getBlob,
+			"RemoveQuota", "DELETE", "/quotas", "", r.removeQuota,
 		},
 	}
 }
@@ -39,7 +39,7 @@ type server struct {
 }
 
 // NewServer returns a new Server that listens for requests in socketAddr and sends them
-// to daemon.
+// to daemon, over TLS if the daemon has a certificate.
 func NewServer(socketAddr string, daemon *daemon.Daemon) (Server, error) {
 
 	router := NewRouter(daemon)
@@ -47,6 +47,7 @@ func NewServer(socketAddr string, daemon *daemon.Daemon) (Server, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create listen socket: %s", err)
 	}
+	listener = daemon.TLSListener(listener)
 
 	return server{listener, socketAddr, router}, nil
 }
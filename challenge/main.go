@@ -13,12 +13,20 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 
 	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
 	daemon "github.com/Arvinderpal/go-storage-server/challenge/daemon/daemon"
 	s "github.com/Arvinderpal/go-storage-server/challenge/daemon/server"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/antientropy"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/audit"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/auth"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/store"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/webhook"
 
 	"github.com/codegangsta/cli"
 	l "github.com/op/go-logging"
@@ -41,11 +49,174 @@ func main() {
 			Name:  "debug, D",
 			Usage: "Enable debug messages",
 		},
+		cli.StringSliceFlag{
+			Name:  "dir",
+			Usage: "data directory, repeat for one directory per disk (default: " + common.DataDirBasePath + ")",
+		},
+		cli.StringFlag{
+			Destination: &config.Placement,
+			Name:        "placement",
+			Value:       store.PlaceBySpace,
+			Usage:       "placement of new blobs over several data directories (space, hash)",
+		},
+		cli.StringFlag{
+			Destination: &config.ErasureCoding,
+			Name:        "ec",
+			Usage:       "store blobs as Reed-Solomon shards over the data directories, e.g. 6+3 for 6 data and 3 parity shards",
+		},
+		cli.StringFlag{
+			Destination: &config.StoreName,
+			Name:        "store",
+			Value:       store.DirStoreName,
+			Usage:       "store backend for blobs (dir, mem, segment, log)",
+		},
+		cli.Int64Flag{
+			Destination: &config.SegmentThreshold,
+			Name:        "segment-threshold",
+			Value:       store.DefaultSegmentThreshold,
+			Usage:       "blobs up to this many bytes are packed into segment files (segment store)",
+		},
+		cli.StringFlag{
+			Destination: &config.ColdDirPath,
+			Name:        "cold-dir",
+			Usage:       "data directory of the cold tier; enables hot/cold tiering",
+		},
+		cli.DurationFlag{
+			Destination: &config.Tiering.ColdAfter,
+			Name:        "cold-after",
+			Value:       store.DefaultColdAfter,
+			Usage:       "move blobs not read for this long to the cold tier",
+		},
+		cli.Int64Flag{
+			Destination: &config.Tiering.ColdSize,
+			Name:        "cold-size",
+			Usage:       "move blobs of at least this many bytes to the cold tier as soon as they are idle (0 disables)",
+		},
+		cli.IntFlag{
+			Destination: &config.Tiering.PromoteReads,
+			Name:        "promote-reads",
+			Value:       store.DefaultPromoteReads,
+			Usage:       "reads within --cold-after that bring a cold blob back to the hot tier",
+		},
+		cli.StringFlag{
+			Destination: &config.ReplicaOf,
+			Name:        "replica-of",
+			Usage:       "run as a read-only replica of the primary at this address, e.g. localhost:7777",
+		},
+		cli.StringSliceFlag{
+			Name:  "peer",
+			Usage: "peer server blobs are synchronously replicated to, repeat for each peer",
+		},
+		cli.IntFlag{
+			Destination: &config.WriteQuorum,
+			Name:        "write-quorum",
+			Usage:       "servers, counting this one, that must store a write before it is acknowledged (default: majority)",
+		},
+		cli.IntFlag{
+			Destination: &config.ReadQuorum,
+			Name:        "read-quorum",
+			Usage:       "servers, counting this one, asked for the newest version on a read (default: majority)",
+		},
+		cli.StringSliceFlag{
+			Name:  "member",
+			Usage: "member of the cluster ring, repeat for each member; enables cluster mode",
+		},
+		cli.StringFlag{
+			Destination: &config.Advertise,
+			Name:        "advertise",
+			Usage:       "address of this server in the cluster ring (default: the -s address)",
+		},
+		cli.IntFlag{
+			Destination: &config.Owners,
+			Name:        "owners",
+			Value:       1,
+			Usage:       "cluster members holding each blob",
+		},
+		cli.StringFlag{
+			Destination: &config.GossipAddr,
+			Name:        "gossip",
+			Usage:       "UDP address to gossip membership on, e.g. :7946; enables failure detection",
+		},
+		cli.StringSliceFlag{
+			Name:  "join",
+			Usage: "gossip address of a member to join through, repeat for each seed",
+		},
+		cli.StringSliceFlag{
+			Name:  "raft-node",
+			Usage: "node of the raft group agreeing on the blobs, repeat for each node (itself included)",
+		},
+		cli.IntFlag{
+			Destination: &config.WebhookAttempts,
+			Name:        "webhook-attempts",
+			Value:       webhook.DefaultMaxAttempts,
+			Usage:       "deliveries of an event tried, with exponential backoff, before it goes to the webhook's dead letters",
+		},
+		cli.StringSliceFlag{
+			Name:  "sink",
+			Usage: "publish events to a sink, file:<dir> or unix:<socket>, repeat for each sink",
+		},
+		cli.StringFlag{
+			Destination: &config.CredentialsFile,
+			Name:        "credentials",
+			Usage:       "JSON file of the API keys allowed in and their permissions; enables authentication",
+		},
+		cli.StringFlag{
+			Destination: &config.PeerKey,
+			Name:        "peer-key",
+			EnvVar:      "CHALLENGE_PEER_KEY",
+			Usage:       "API key, as <id>:<secret>, sent to the other servers (peers, replicas, cluster members, raft nodes)",
+		},
+		cli.StringFlag{
+			Destination: &config.PresignSecret,
+			Name:        "presign-secret",
+			EnvVar:      "CHALLENGE_PRESIGN_SECRET",
+			Usage:       "secret presigned URLs are signed with, to share with the servers they may be sent to; random if not set",
+		},
+		cli.StringFlag{
+			Destination: &config.TLSCert,
+			Name:        "tls-cert",
+			Usage:       "PEM certificate to serve HTTPS with, reloaded on SIGHUP",
+		},
+		cli.StringFlag{
+			Destination: &config.TLSKey,
+			Name:        "tls-key",
+			Usage:       "PEM key of the certificate, reloaded on SIGHUP",
+		},
+		cli.StringFlag{
+			Destination: &config.TLSClientCA,
+			Name:        "tls-client-ca",
+			Usage:       "PEM bundle of the CAs client certificates must be issued by; clients must then present one",
+		},
+		cli.BoolFlag{
+			Destination: &config.TLSClientOptional,
+			Name:        "tls-client-optional",
+			Usage:       "with --tls-client-ca, also accept clients without a certificate",
+		},
+		cli.Float64Flag{
+			Destination: &config.Limits.Requests,
+			Name:        "rate-requests",
+			Usage:       "requests per second each client (API key, token subject or address) may send; 0 for no limit",
+		},
+		cli.IntFlag{
+			Destination: &config.Limits.Burst,
+			Name:        "rate-burst",
+			Usage:       "requests a client may send at once above --rate-requests (default: the rate rounded up)",
+		},
+		cli.Int64Flag{
+			Destination: &config.Limits.Bytes,
+			Name:        "rate-bytes",
+			Usage:       "bytes per second each client may upload and download; 0 for no limit",
+		},
 		cli.StringFlag{
-			Destination: &config.DataDirBasePath,
-			Name:        "dir",
-			Value:       common.DataDirBasePath,
-			Usage:       "data directory",
+			Destination: &config.AuditDir,
+			Name:        "audit-dir",
+			Usage:       "directory of the hash-chained audit log of the requests changing things; off if not set",
+		},
+		cli.Int64Flag{
+			Destination: &config.AuditMaxSize,
+			Name:        "audit-max-size",
+			Value:       audit.DefaultMaxSize,
+			Usage:       "size in bytes the audit log file is rotated at",
 		},
 		cli.StringFlag{
 			Destination: &socketAddress,
@@ -55,6 +226,65 @@ func main() {
 		},
 	}
 
+	app.Commands = []cli.Command{
+		{
+			Name:      "sync",
+			Usage:     "reconcile the blobs of two servers, copying only the ones that differ",
+			ArgsUsage: "<server> <server>",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only list the blobs that differ",
+				},
+				cli.StringFlag{
+					Name:   "key",
+					EnvVar: "CHALLENGE_KEY",
+					Usage:  "API key with the admin permission, as <id>:<secret>",
+				},
+			},
+			Action: syncServers,
+		},
+		{
+			Name:      "keygen",
+			Usage:     "create an API key, printing its secret and its entry for the credentials file",
+			ArgsUsage: "<id>",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "prefix",
+					Usage: "locations the key is allowed on (default: all)",
+				},
+				cli.StringSliceFlag{
+					Name:  "perm",
+					Usage: "permission of the key on the prefix (read, write, delete, admin), repeat for each",
+				},
+				cli.BoolFlag{
+					Name:  "sign",
+					Usage: "let the key sign requests instead of sending its secret, keeping its signing key in the credentials file",
+				},
+				cli.Int64Flag{
+					Name:  "quota-bytes",
+					Usage: "most bytes the blobs written with the key may hold (default: no limit)",
+				},
+				cli.Int64Flag{
+					Name:  "quota-objects",
+					Usage: "most blobs written with the key there may be (default: no limit)",
+				},
+			},
+			Action: keygen,
+		},
+		{
+			Name:  "audit",
+			Usage: "inspect the audit log",
+			Subcommands: []cli.Command{
+				{
+					Name:      "verify",
+					Usage:     "check that the records of an audit log chain up, none altered, removed or inserted",
+					ArgsUsage: "<dir>",
+					Action:    verifyAudit,
+				},
+			},
+		},
+	}
 	app.Action = run
 	app.Before = initEnv
 	app.Run(os.Args)
@@ -75,6 +305,20 @@ func run(cli *cli.Context) {
 
 	fmt.Printf("Starting storage-server...\n")
 
+	config.DataDirs = cli.StringSlice("dir")
+	if len(config.DataDirs) == 0 {
+		config.DataDirs = []string{common.DataDirBasePath}
+	}
+	config.DataDirBasePath = config.DataDirs[0]
+	config.Peers = cli.StringSlice("peer")
+	config.Members = cli.StringSlice("member")
+	config.Join = cli.StringSlice("join")
+	config.RaftNodes = cli.StringSlice("raft-node")
+	config.Sinks = cli.StringSlice("sink")
+	if config.Advertise == "" {
+		config.Advertise = advertiseAddress(socketAddress)
+	}
+
 	d, err := daemon.NewDaemon(config)
 	if err != nil {
 		log.Fatalf("Error while creating daemon: %s", err)
@@ -88,3 +332,83 @@ func run(cli *cli.Context) {
 	defer server.Stop()
 	server.Start()
 }
+
+// syncServers runs anti-entropy between the two servers given as arguments.
+func syncServers(ctx *cli.Context) error {
+	if ctx.NArg() != 2 {
+		return cli.NewExitError("sync needs two servers, e.g. localhost:7777 localhost:7778", 1)
+	}
+	syncer := antientropy.NewSyncer()
+	syncer.DryRun = ctx.Bool("dry-run")
+	syncer.Key = ctx.String("key")
+	res, err := syncer.Sync(ctx.Args().Get(0), ctx.Args().Get(1))
+	fmt.Printf("Compared %d tree nodes, copied %d blobs and %d deletes, %d failed\n",
+		res.NodesCompared, res.Copied, res.Deleted, res.Failed)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("sync failed: %s", err), 1)
+	}
+	if res.Failed > 0 {
+		return cli.NewExitError("some blobs could not be copied", 1)
+	}
+	return nil
+}
+
+// verifyAudit checks the chain of the audit log in the given directory.
+func verifyAudit(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return cli.NewExitError("audit verify needs the directory of the audit log", 1)
+	}
+	n, last, err := audit.Verify(ctx.Args().Get(0))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("audit log broken after %d records: %s", n, err), 1)
+	}
+	fmt.Printf("%d records chain up, last hash %s\n", n, last)
+	return nil
+}
+
+// keygen prints a new API key and the entry to add to the credentials
+// file for it.
+func keygen(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return cli.NewExitError("keygen needs the ID of the key, e.g. ci", 1)
+	}
+	perms := ctx.StringSlice("perm")
+	if len(perms) == 0 {
+		perms = []string{types.PermRead}
+	}
+	id, secret := ctx.Args().Get(0), auth.NewSecret()
+	key := &auth.Key{
+		ID:         id,
+		SecretHash: auth.HashSecret(secret),
+		Grants:     []types.Grant{{Prefix: ctx.String("prefix"), Perms: perms}},
+
+		QuotaBytes:   ctx.Int64("quota-bytes"),
+		QuotaObjects: ctx.Int64("quota-objects"),
+	}
+	if ctx.Bool("sign") {
+		key.SigningKey = auth.SigningKey(secret)
+	}
+	entry, err := json.MarshalIndent(key, "", "  ")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("API key (send as %s): %s:%s\n", common.APIKeyHeader, id, secret)
+	if key.SigningKey != "" {
+		fmt.Printf("Signing key: %s\n", key.SigningKey)
+	}
+	fmt.Printf("Entry for the \"keys\" of the credentials file:\n%s\n", entry)
+	return nil
+}
+
+// advertiseAddress returns the address other servers can reach us at when
+// listening on addr, using localhost for the wildcard address.
+func advertiseAddress(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,151 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Package acl keeps the access control lists of location prefixes.
+package acl
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+
+	"github.com/op/go-logging"
+)
+
+var (
+	log = logging.MustGetLogger("challenge-acl")
+
+	// ErrNotFound is returned for a prefix without an ACL.
+	ErrNotFound = errors.New("ACL not found")
+)
+
+// Store holds ACLs by prefix, saved in a file.
+type Store struct {
+	path string
+
+	mu   sync.RWMutex
+	acls map[string]*types.ACL
+}
+
+// New returns a store saving its ACLs in the file at path, loading those
+// saved there before.
+func New(path string) (*Store, error) {
+	s := &Store{path: path, acls: make(map[string]*types.ACL)}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved []*types.ACL
+	if err := json.Unmarshal(buf, &saved); err != nil {
+		return nil, err
+	}
+	for _, acl := range saved {
+		if err := acl.Validate(); err != nil {
+			log.Warningf("ACL of %q: %s", acl.Prefix, err)
+		}
+		s.acls[acl.Prefix] = acl
+	}
+	log.Infof("Loaded %d ACLs from %s", len(s.acls), path)
+	return s, nil
+}
+
+// save writes the ACLs to the file. Must be called with mu held.
+func (s *Store) save() error {
+	buf, err := json.Marshal(s.sorted())
+	if err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+// sorted returns copies of the ACLs, by prefix. Must be called with mu held.
+func (s *Store) sorted() []types.ACL {
+	prefixes := make([]string, 0, len(s.acls))
+	for prefix := range s.acls {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	list := make([]types.ACL, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		list = append(list, *s.acls[prefix])
+	}
+	return list
+}
+
+// List returns the ACLs, by prefix.
+func (s *Store) List() []types.ACL {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sorted()
+}
+
+// covers tells whether the ACL of prefix covers location: the location
+// equal to it and, if it ends with a "/", those below it. Prefixes stop at
+// a "/" so that the ACL of team doesn't cover teammate.
+func covers(prefix, location string) bool {
+	return prefix == location || strings.HasSuffix(prefix, "/") && types.Covers(prefix, location)
+}
+
+// Effective returns the ACL covering location, the one of its longest
+// prefix, or ErrNotFound.
+func (s *Store) Effective(location string) (*types.ACL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var found *types.ACL
+	for prefix, acl := range s.acls {
+		if covers(prefix, location) && (found == nil || len(prefix) > len(found.Prefix)) {
+			found = acl
+		}
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	cpy := *found
+	return &cpy, nil
+}
+
+// Set stores acl, unless the ACL of its prefix was updated later. It tells
+// whether acl was stored.
+func (s *Store) Set(acl *types.ACL) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur, ok := s.acls[acl.Prefix]; ok && !acl.Updated.After(cur.Updated) {
+		return false, nil
+	}
+	cpy := *acl
+	s.acls[acl.Prefix] = &cpy
+	return true, s.save()
+}
+
+// Remove deletes the ACL of prefix.
+func (s *Store) Remove(prefix string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.acls[prefix]; !ok {
+		return ErrNotFound
+	}
+	delete(s.acls, prefix)
+	return s.save()
+}
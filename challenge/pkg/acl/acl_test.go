@@ -0,0 +1,152 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package acl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+func newStore(t *testing.T) (*Store, string) {
+	dir, err := ioutil.TempDir("", "acl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(filepath.Join(dir, "acls.json"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s, dir
+}
+
+func setACL(t *testing.T, s *Store, acl types.ACL) {
+	if acl.Updated.IsZero() {
+		acl.Updated = time.Now()
+	}
+	if ok, err := s.Set(&acl); err != nil || !ok {
+		t.Fatalf("set the ACL of %s: %v, %v", acl.Prefix, ok, err)
+	}
+}
+
+// A location is covered by the ACL of its longest prefix only: what a
+// broader ACL grants, a narrower one leaving the principal out denies.
+func TestEffective(t *testing.T) {
+	s, dir := newStore(t)
+	defer os.RemoveAll(dir)
+
+	setACL(t, s, types.ACL{Prefix: "b1/", Owner: "key:owner", Readers: []string{"key:r1", "key:r2"}, Writers: []string{"key:w1"}})
+	setACL(t, s, types.ACL{Prefix: "b1/team/", Owner: "key:owner", Readers: []string{"key:r1"}})
+	setACL(t, s, types.ACL{Prefix: "b1/team/report", Owner: "key:other", Writers: []string{types.ACLAnyone}})
+
+	tests := []struct {
+		location string
+		prefix   string // of the effective ACL, "" for none
+		name     string
+		perm     string
+		allowed  bool
+	}{
+		{location: "b1/doc", prefix: "b1/", name: "key:r2", perm: types.PermRead, allowed: true},
+		{location: "b1/doc", prefix: "b1/", name: "key:r2", perm: types.PermWrite},
+		{location: "b1/doc", prefix: "b1/", name: "key:w1", perm: types.PermRead, allowed: true},
+		{location: "b1/doc", prefix: "b1/", name: "key:w1", perm: types.PermDelete, allowed: true},
+		{location: "b1/doc", prefix: "b1/", name: "jwt:r2", perm: types.PermRead},
+		{location: "b1/doc", prefix: "b1/", name: "", perm: types.PermRead},
+		{location: "b1/doc", prefix: "b1/", name: "key:owner", perm: types.PermWrite, allowed: true},
+		{location: "b1/doc", prefix: "b1/", name: "key:owner", perm: types.PermAdmin},
+		// the narrower ACL takes over
+		{location: "b1/team/x", prefix: "b1/team/", name: "key:r1", perm: types.PermRead, allowed: true},
+		{location: "b1/team/x", prefix: "b1/team/", name: "key:r2", perm: types.PermRead},
+		{location: "b1/team/x", prefix: "b1/team/", name: "key:w1", perm: types.PermWrite},
+		{location: "b1/team/report", prefix: "b1/team/report", name: "", perm: types.PermWrite, allowed: true},
+		{location: "b1/team/report", prefix: "b1/team/report", name: "key:owner", perm: types.PermWrite, allowed: true},
+		{location: "b1/team/report", prefix: "b1/team/report", name: "key:owner", perm: types.PermRead, allowed: true},
+		// prefixes stop at a "/"
+		{location: "b1/team/report2", prefix: "b1/team/", name: "key:r1", perm: types.PermRead, allowed: true},
+		{location: "b1/teammate/x", prefix: "b1/", name: "key:r2", perm: types.PermRead, allowed: true},
+		{location: "b2/doc", name: "key:r1", perm: types.PermRead},
+		{location: "b1", name: "key:r1", perm: types.PermRead},
+	}
+
+	for _, tt := range tests {
+		acl, err := s.Effective(tt.location)
+		if tt.prefix == "" {
+			if err != ErrNotFound {
+				t.Errorf("%s: got %v, %v, expected %v", tt.location, acl, err, ErrNotFound)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.location, err)
+			continue
+		}
+		if acl.Prefix != tt.prefix {
+			t.Errorf("%s: got the ACL of %s, expected %s", tt.location, acl.Prefix, tt.prefix)
+			continue
+		}
+		if allowed := acl.Allows(tt.name, tt.perm); allowed != tt.allowed {
+			t.Errorf("%s: %q allowed %s: got %v, expected %v", tt.location, tt.name, tt.perm, allowed, tt.allowed)
+		}
+	}
+
+	// without the narrower ACL, the broader one applies again
+	if err := s.Remove("b1/team/"); err != nil {
+		t.Fatal(err)
+	}
+	acl, err := s.Effective("b1/team/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acl.Prefix != "b1/" || !acl.Allows("key:r2", types.PermRead) {
+		t.Errorf("got the ACL of %s after removing b1/team/", acl.Prefix)
+	}
+	if err := s.Remove("b1/team/"); err != ErrNotFound {
+		t.Errorf("removed a missing ACL: got %v, expected %v", err, ErrNotFound)
+	}
+}
+
+// The latest change of an ACL wins, and ACLs are loaded again from the file.
+func TestSet(t *testing.T) {
+	s, dir := newStore(t)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	setACL(t, s, types.ACL{Prefix: "b1/", Owner: "key:owner", Readers: []string{"key:r1"}, Updated: now})
+	old := &types.ACL{Prefix: "b1/", Owner: "key:owner", Readers: []string{"key:old"}, Updated: now.Add(-time.Second)}
+	if ok, err := s.Set(old); err != nil || ok {
+		t.Errorf("set an older ACL: %v, %v", ok, err)
+	}
+	same := &types.ACL{Prefix: "b1/", Owner: "key:owner", Readers: []string{"key:same"}, Updated: now}
+	if ok, err := s.Set(same); err != nil || ok {
+		t.Errorf("set an ACL as old: %v, %v", ok, err)
+	}
+	setACL(t, s, types.ACL{Prefix: "b1/", Owner: "key:owner", Readers: []string{"key:new"}, Updated: now.Add(time.Second)})
+	setACL(t, s, types.ACL{Prefix: "b0/", Owner: "key:owner"})
+
+	loaded, err := New(s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := loaded.List()
+	if len(list) != 2 || list[0].Prefix != "b0/" || list[1].Prefix != "b1/" {
+		t.Fatalf("loaded %+v", list)
+	}
+	if !list[1].Allows("key:new", types.PermRead) || list[1].Allows("key:r1", types.PermRead) {
+		t.Errorf("loaded %+v, expected the latest ACL of b1/", list[1])
+	}
+}
@@ -0,0 +1,230 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package antientropy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/merkle"
+
+	"github.com/op/go-logging"
+)
+
+var (
+	log = logging.MustGetLogger("challenge-antientropy")
+)
+
+const requestTimeout = 60 // in seconds
+
+// Result sums up a sync.
+type Result struct {
+	NodesCompared int
+	Copied        int // blobs copied to the server holding an older version
+	Deleted       int // deletes copied to the server still holding the blob
+	Failed        int
+}
+
+// Syncer reconciles the blobs of two servers by comparing their Merkle
+// trees and copying only the blobs that differ, the newest version winning.
+type Syncer struct {
+	DryRun bool   // only log the differences
+	Key    string // API key sent to the servers, as <id>:<secret>
+
+	client *http.Client
+}
+
+func NewSyncer() *Syncer {
+	return &Syncer{client: &http.Client{Timeout: requestTimeout * time.Second}}
+}
+
+func baseURL(server string) string {
+	if strings.Contains(server, "://") {
+		return strings.TrimRight(server, "/")
+	}
+	return "http://" + server
+}
+
+// Sync makes servers a and b hold the newest version of every blob either
+// of them holds.
+func (s *Syncer) Sync(a, b string) (*Result, error) {
+	res := &Result{}
+	if err := s.walk(baseURL(a), baseURL(b), "", res); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+func (s *Syncer) do(req *http.Request) (*http.Response, error) {
+	if s.Key != "" {
+		req.Header.Set(common.APIKeyHeader, s.Key)
+	}
+	return s.client.Do(req)
+}
+
+func (s *Syncer) node(server, path string) (*types.MerkleNode, error) {
+	req, err := http.NewRequest("GET", server+"/merkle?path="+url.QueryEscape(path), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", server, resp.Status)
+	}
+	node := &types.MerkleNode{}
+	if err := json.NewDecoder(resp.Body).Decode(node); err != nil {
+		return nil, fmt.Errorf("invalid merkle node from %s: %s", server, err)
+	}
+	return node, nil
+}
+
+// walk descends into the subtrees at path that differ between a and b.
+func (s *Syncer) walk(a, b, path string, res *Result) error {
+	na, err := s.node(a, path)
+	if err != nil {
+		return err
+	}
+	nb, err := s.node(b, path)
+	if err != nil {
+		return err
+	}
+	res.NodesCompared++
+	if na.Hash == nb.Hash {
+		return nil
+	}
+	if len(path) == merkle.Depth {
+		s.reconcile(a, b, na.Entries, nb.Entries, res)
+		return nil
+	}
+	for i := range na.Children {
+		if i < len(nb.Children) && na.Children[i] == nb.Children[i] {
+			continue
+		}
+		if err := s.walk(a, b, path+strconv.FormatInt(int64(i), 16), res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcile copies the newer side of every entry that differs between two
+// leaves.
+func (s *Syncer) reconcile(a, b string, ea, eb []types.MerkleEntry, res *Result) {
+	inB := make(map[string]types.MerkleEntry)
+	for _, e := range eb {
+		inB[e.Location] = e
+	}
+	for _, e := range ea {
+		other, ok := inB[e.Location]
+		delete(inB, e.Location)
+		switch {
+		case !ok || e.Version > other.Version:
+			s.copy(a, b, e, res)
+		case e.Hash == other.Hash && e.Deleted == other.Deleted:
+			// same content
+		case e.Version < other.Version:
+			s.copy(b, a, other, res)
+		case e.Hash > other.Hash:
+			// same version, different content: pick one the same way
+			// every time
+			s.copy(a, b, e, res)
+		default:
+			s.copy(b, a, other, res)
+		}
+	}
+	for _, e := range inB {
+		s.copy(b, a, e, res)
+	}
+}
+
+// copy applies entry e of server src to dst.
+func (s *Syncer) copy(src, dst string, e types.MerkleEntry, res *Result) {
+	if s.DryRun {
+		log.Infof("%s is newer on %s (version %d, deleted %t)", e.Location, src, e.Version, e.Deleted)
+		return
+	}
+	var err error
+	if e.Deleted {
+		if err = s.send(dst, "DELETE", e.Location, e.Version, nil); err == nil {
+			res.Deleted++
+		}
+	} else {
+		if err = s.copyBlob(src, dst, e.Location); err == nil {
+			res.Copied++
+		}
+	}
+	if err != nil {
+		log.Warningf("Unable to copy %s from %s to %s: %s", e.Location, src, dst, err)
+		res.Failed++
+		return
+	}
+	log.Infof("Copied %s from %s to %s", e.Location, src, dst)
+}
+
+func (s *Syncer) copyBlob(src, dst, location string) error {
+	req, err := http.NewRequest("GET", src+common.StorePath(location), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(common.PeerHeader, "1")
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", src, resp.Status)
+	}
+	version, err := strconv.ParseInt(resp.Header.Get(common.VersionHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s sent no valid version: %s", src, err)
+	}
+	return s.send(dst, "PUT", location, version, resp)
+}
+
+// send applies a write of the given version on dst, as a peer would.
+func (s *Syncer) send(dst, method, location string, version int64, src *http.Response) error {
+	req, err := http.NewRequest(method, dst+common.StorePath(location), nil)
+	if err != nil {
+		return err
+	}
+	if src != nil {
+		req.Body = src.Body
+		req.ContentLength = src.ContentLength
+		if creator := src.Header.Get(common.CreatorHeader); creator != "" {
+			req.Header.Set(common.CreatorHeader, creator)
+		}
+	}
+	req.Header.Set(common.PeerHeader, "1")
+	req.Header.Set(common.VersionHeader, strconv.FormatInt(version, 10))
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", dst, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,352 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+
+	"github.com/op/go-logging"
+)
+
+const (
+	// FileName is the file records are appended to. Once it grows past
+	// the maximum size it is renamed after its first record, as
+	// audit-<seq>.log, and a new one started.
+	FileName = "audit.log"
+
+	// DefaultMaxSize is the size the log file is rotated at by default.
+	DefaultMaxSize = 64 << 20
+)
+
+var log = logging.MustGetLogger("challenge-audit")
+
+// Hash returns the hash of rec: the SHA-256 of its JSON encoding, its Hash
+// left empty, in hex.
+func Hash(rec *types.AuditRecord) (string, error) {
+	cpy := *rec
+	cpy.Hash = ""
+	buf, err := json.Marshal(&cpy)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Log is an append-only log of records, each chained to the one before it
+// by its hash, in a directory.
+type Log struct {
+	dir     string
+	maxSize int64
+
+	mu    sync.Mutex
+	f     *os.File
+	size  int64
+	first uint64 // seq of the first record of the file, 0 if empty
+	seq   uint64 // of the last record
+	last  string // hash of the last record
+}
+
+// Open opens the log in dir, creating it if needed, rotating its file once
+// it grows past maxSize bytes. Records are appended after the last one
+// found; a record cut short at the end of the log file, by a crash during
+// an append, is dropped first.
+func Open(dir string, maxSize int64) (*Log, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	l := &Log{dir: dir, maxSize: maxSize}
+	files, err := Files(dir)
+	if err != nil {
+		return nil, err
+	}
+	// the last record is in the newest file holding any
+	for i := len(files) - 1; i >= 0 && l.last == ""; i-- {
+		var first, last *types.AuditRecord
+		if filepath.Base(files[i]) == FileName {
+			first, last, err = repair(files[i])
+		} else {
+			first, last, err = ends(files[i])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %s", files[i], err)
+		}
+		if last != nil {
+			l.seq, l.last = last.Seq, last.Hash
+		}
+		if i == len(files)-1 && filepath.Base(files[i]) == FileName && first != nil {
+			l.first = first.Seq
+		}
+	}
+
+	path := filepath.Join(dir, FileName)
+	if l.f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600); err != nil {
+		return nil, err
+	}
+	fi, err := l.f.Stat()
+	if err != nil {
+		l.f.Close()
+		return nil, err
+	}
+	l.size = fi.Size()
+	log.Infof("Appending audit records to %s after #%d", path, l.seq)
+	return l, nil
+}
+
+// Append chains rec to the log and writes it, setting its Seq, Prev and
+// Hash.
+func (l *Log) Append(rec *types.AuditRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rec.Seq = l.seq + 1
+	rec.Prev = l.last
+	hash, err := Hash(rec)
+	if err != nil {
+		return err
+	}
+	rec.Hash = hash
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+
+	if l.size > 0 && l.size+int64(len(buf)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("unable to rotate the audit log: %s", err)
+		}
+	}
+	if _, err := l.f.Write(buf); err != nil {
+		return err
+	}
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	l.size += int64(len(buf))
+	if l.first == 0 {
+		l.first = rec.Seq
+	}
+	l.seq, l.last = rec.Seq, rec.Hash
+	return nil
+}
+
+// rotate renames the log file after its first record and starts a new
+// one. Must be called with mu held.
+func (l *Log) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	path := filepath.Join(l.dir, FileName)
+	if err := os.Rename(path, filepath.Join(l.dir, rotatedName(l.first))); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	l.f, l.size, l.first = f, 0, 0
+	return nil
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+func rotatedName(first uint64) string {
+	return fmt.Sprintf("audit-%020d.log", first)
+}
+
+// Files returns the files of the log in dir, oldest first.
+func Files(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var rotated []string
+	current := ""
+	for _, e := range entries {
+		switch name := e.Name(); {
+		case name == FileName:
+			current = filepath.Join(dir, name)
+		case strings.HasPrefix(name, "audit-") && strings.HasSuffix(name, ".log"):
+			rotated = append(rotated, filepath.Join(dir, name))
+		}
+	}
+	// zero padded, sorting by name sorts by seq
+	sort.Strings(rotated)
+	if current != "" {
+		rotated = append(rotated, current)
+	}
+	return rotated, nil
+}
+
+// read calls fn with each record of the file at path, and its line.
+func read(path string, fn func(line int, rec *types.AuditRecord) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 1<<20)
+	for line := 1; s.Scan(); line++ {
+		rec := &types.AuditRecord{}
+		if err := json.Unmarshal(s.Bytes(), rec); err != nil {
+			return fmt.Errorf("%s:%d: invalid record: %s", path, line, err)
+		}
+		if err := fn(line, rec); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// ends returns the first and last records of the file at path, nil if it
+// has none.
+func ends(path string) (first, last *types.AuditRecord, err error) {
+	err = read(path, func(line int, rec *types.AuditRecord) error {
+		if first == nil {
+			first = rec
+		}
+		last = rec
+		return nil
+	})
+	return first, last, err
+}
+
+// repair drops the partial record a crash during an append may have left at
+// the end of the file at path, and returns the first and last records of the
+// file, nil if it has none. A record that can't be read anywhere else is an
+// error: the chain is broken there and the log must be looked at.
+func repair(path string) (first, last *types.AuditRecord, err error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var size, good int64 // bytes read, up to the end of the last record
+	bad := 0             // line of the first record that can't be read
+	var tail []byte      // from there
+	for line := 1; ; line++ {
+		buf, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		if len(buf) == 0 {
+			break
+		}
+		size += int64(len(buf))
+		rec := &types.AuditRecord{}
+		// a line without its newline was cut short
+		if err == io.EOF || json.Unmarshal(buf, rec) != nil {
+			if bad == 0 {
+				bad = line
+			}
+			tail = append(tail, buf...)
+			continue
+		}
+		if bad != 0 {
+			return nil, nil, fmt.Errorf("%s:%d: invalid record", path, bad)
+		}
+		if first == nil {
+			first = rec
+		}
+		last, good = rec, size
+	}
+	if good == size {
+		return first, last, nil
+	}
+	// records appended after a partial one are glued to it
+	for i := 1; i < len(tail); i++ {
+		rec := &types.AuditRecord{}
+		if tail[i] == '{' && json.Unmarshal(tail[i:], rec) == nil && rec.Seq != 0 {
+			return nil, nil, fmt.Errorf("%s:%d: invalid record", path, bad)
+		}
+	}
+	log.Warningf("Dropping the %d bytes of a partial record at the end of %s", size-good, path)
+	if err := f.Truncate(good); err != nil {
+		return nil, nil, err
+	}
+	return first, last, f.Sync()
+}
+
+// Verify checks the chain of the log in dir, from the oldest file kept:
+// each record must hash to its Hash and hold the hash of the one before it,
+// with no seq missing. It returns the number of records checked and the
+// hash of the last one, which a later check can be sure the log still
+// leads to.
+func Verify(dir string) (int, string, error) {
+	files, err := Files(dir)
+	if err != nil {
+		return 0, "", err
+	}
+	if len(files) == 0 {
+		return 0, "", fmt.Errorf("no audit log in %s", dir)
+	}
+	n := 0
+	var prev *types.AuditRecord
+	for _, path := range files {
+		err := read(path, func(line int, rec *types.AuditRecord) error {
+			hash, err := Hash(rec)
+			if err != nil {
+				return err
+			}
+			if hash != rec.Hash {
+				return fmt.Errorf("%s:%d: record #%d was altered", path, line, rec.Seq)
+			}
+			if prev == nil && rec.Seq == 1 && rec.Prev != "" {
+				return fmt.Errorf("%s:%d: record #1 chains to another", path, line)
+			}
+			if prev != nil {
+				if rec.Seq != prev.Seq+1 {
+					return fmt.Errorf("%s:%d: record #%d follows #%d", path, line, rec.Seq, prev.Seq)
+				}
+				if rec.Prev != prev.Hash {
+					return fmt.Errorf("%s:%d: record #%d does not chain to #%d", path, line, rec.Seq, prev.Seq)
+				}
+			}
+			prev = rec
+			n++
+			return nil
+		})
+		if err != nil {
+			return n, "", err
+		}
+	}
+	if prev == nil {
+		return 0, "", nil
+	}
+	return n, prev.Hash, nil
+}
@@ -0,0 +1,188 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+// appendRecords appends n records to the log in dir.
+func appendRecords(t *testing.T, dir string, n int) {
+	l, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for i := 0; i < n; i++ {
+		rec := &types.AuditRecord{
+			Time:      time.Now().UTC(),
+			Principal: "key:admin",
+			Method:    "PUT",
+			Path:      "/store/foo",
+			Status:    204,
+		}
+		if err := l.Append(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOpenDamaged(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(lines []string) []string // of the log file, each with its newline
+		seq    uint64                        // of the last record kept
+		broken bool                          // the chain is, Open fails
+	}{
+		{
+			name:   "intact",
+			damage: func(lines []string) []string { return lines },
+			seq:    3,
+		},
+		{
+			name: "partial last record",
+			damage: func(lines []string) []string {
+				return append(lines[:2], lines[2][:len(lines[2])/2])
+			},
+			seq: 2,
+		},
+		{
+			name: "last record without its newline",
+			damage: func(lines []string) []string {
+				return append(lines[:2], strings.TrimSuffix(lines[2], "\n"))
+			},
+			seq: 2,
+		},
+		{
+			name: "zeroes after the last record",
+			damage: func(lines []string) []string {
+				return append(lines, "\x00\x00\x00\x00\x00\x00\x00\x00")
+			},
+			seq: 3,
+		},
+		{
+			name: "only a partial record",
+			damage: func(lines []string) []string {
+				return []string{lines[0][:10]}
+			},
+			seq: 0,
+		},
+		{
+			name: "damaged record in the middle",
+			damage: func(lines []string) []string {
+				return []string{lines[0], "{\"seq\":2,\n", lines[2]}
+			},
+			broken: true,
+		},
+		{
+			name: "partial record in the middle",
+			damage: func(lines []string) []string {
+				return []string{lines[0], lines[1][:len(lines[1])/2], lines[2]}
+			},
+			broken: true,
+		},
+	}
+
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "audit")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		appendRecords(t, dir, 3)
+		path := filepath.Join(dir, FileName)
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.SplitAfter(string(buf), "\n")
+		lines = lines[:len(lines)-1] // after the last newline
+		damaged := strings.Join(tt.damage(lines), "")
+		if err := ioutil.WriteFile(path, []byte(damaged), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		l, err := Open(dir, 0)
+		if tt.broken {
+			if err == nil {
+				l.Close()
+				t.Errorf("%s: opened a log with a broken chain", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if l.seq != tt.seq {
+			t.Errorf("%s: carries on after #%d, expected #%d", tt.name, l.seq, tt.seq)
+		}
+		l.Close()
+
+		// the log goes on from the last good record and still chains up
+		appendRecords(t, dir, 1)
+		n, _, err := Verify(dir)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		} else if n != int(tt.seq)+1 {
+			t.Errorf("%s: %d records chain up, expected %d", tt.name, n, tt.seq+1)
+		}
+	}
+}
+
+func TestOpenRotated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// small enough for each record to get a file of its own
+	l, err := Open(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := l.Append(&types.AuditRecord{Method: "GET", Path: "/store/foo"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+	// a crash during the next append, the first of a new file
+	path := filepath.Join(dir, FileName)
+	if err := os.Rename(path, filepath.Join(dir, rotatedName(3))); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(`{"seq":4,"ti`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if l, err = Open(dir, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(&types.AuditRecord{Method: "GET", Path: "/store/foo"}); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if n, _, err := Verify(dir); err != nil || n != 4 {
+		t.Errorf("expected 4 records to chain up, got %d: %v", n, err)
+	}
+}
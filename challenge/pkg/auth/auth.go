@@ -0,0 +1,323 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+
+	"github.com/op/go-logging"
+)
+
+var (
+	log = logging.MustGetLogger("challenge-auth")
+
+	// ErrInvalidCredentials is returned for credentials that don't
+	// authenticate anybody.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+const (
+	// Methods a Principal can be authenticated with.
+	MethodNone      = "none" // authentication is off
+	MethodAnonymous = "anonymous"
+	MethodKey       = "key"
+	MethodSignature = "signature"
+	MethodPresigned = "presigned"
+	MethodJWT       = "jwt"
+	MethodCert      = "certificate"
+
+	hashScheme = "sha256"
+	saltSize   = 16
+)
+
+// ACLName returns the name p goes by in ACLs, qualified by how it was
+// authenticated, or "" if ACLs only know it as anybody.
+func ACLName(p *types.Principal) string {
+	switch p.Method {
+	case MethodKey, MethodSignature:
+		return types.ACLKey + p.Name
+	case MethodJWT:
+		return types.ACLJWT + p.Name
+	case MethodCert:
+		return types.ACLCert + p.Name
+	}
+	return ""
+}
+
+// Credentials is the content of the credentials file.
+type Credentials struct {
+	Keys []Key `json:"keys"`
+	// grants of the requests without credentials, none if empty
+	Anonymous []types.Grant `json:"anonymous,omitempty"`
+	// JWTs accepted as bearer tokens, none if nil
+	JWT *JWTConfig `json:"jwt,omitempty"`
+	// grants of verified client certificates, by subject common name
+	Certificates map[string][]types.Grant `json:"certificates,omitempty"`
+}
+
+// Key is an API key. Only a salted hash of its secret is kept: keys are
+// random, a fast hash is enough to make a leaked file useless. Keys signing
+// their requests also have their signing key, which must be kept as safe.
+type Key struct {
+	ID         string        `json:"id"`
+	SecretHash string        `json:"secret_hash"`           // as returned by HashSecret
+	SigningKey string        `json:"signing_key,omitempty"` // as returned by SigningKey
+	Grants     []types.Grant `json:"grants"`
+	// limits of what the blobs written with the key may hold, none if 0
+	QuotaBytes   int64 `json:"quota_bytes,omitempty"`
+	QuotaObjects int64 `json:"quota_objects,omitempty"`
+}
+
+// Authenticator tells who requests come from.
+type Authenticator struct {
+	enabled   bool
+	keys      map[string]*Key
+	anonymous []types.Grant
+	replays   replayCache
+	jwt       *jwtVerifier
+	certs     map[string][]types.Grant
+
+	// key of the presigned URLs, which no principal has
+	presignKey []byte
+}
+
+// New returns an authenticator checking requests against the credentials
+// file at path, and signing presigned URLs with presignKey. With an empty
+// path, authentication is off and every request is allowed everything.
+func New(path string, presignKey []byte) (*Authenticator, error) {
+	a := &Authenticator{keys: make(map[string]*Key), presignKey: presignKey}
+	if path == "" {
+		return a, nil
+	}
+	a.enabled = true
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	creds := &Credentials{}
+	if err := json.Unmarshal(buf, creds); err != nil {
+		return nil, fmt.Errorf("invalid credentials file %s: %s", path, err)
+	}
+	for i := range creds.Keys {
+		k := &creds.Keys[i]
+		if k.ID == "" || strings.Contains(k.ID, ":") {
+			return nil, fmt.Errorf("invalid key ID %q", k.ID)
+		}
+		if _, ok := a.keys[k.ID]; ok {
+			return nil, fmt.Errorf("key %s is defined twice", k.ID)
+		}
+		if _, _, err := splitHash(k.SecretHash); err != nil {
+			return nil, fmt.Errorf("key %s: %s", k.ID, err)
+		}
+		if err := checkGrants(k.Grants); err != nil {
+			return nil, fmt.Errorf("key %s: %s", k.ID, err)
+		}
+		if k.QuotaBytes < 0 || k.QuotaObjects < 0 {
+			return nil, fmt.Errorf("key %s: quotas can't be negative", k.ID)
+		}
+		a.keys[k.ID] = k
+	}
+	if err := checkGrants(creds.Anonymous); err != nil {
+		return nil, fmt.Errorf("anonymous grants: %s", err)
+	}
+	a.anonymous = creds.Anonymous
+	for cn, grants := range creds.Certificates {
+		if err := checkGrants(grants); err != nil {
+			return nil, fmt.Errorf("certificate %s: %s", cn, err)
+		}
+	}
+	a.certs = creds.Certificates
+	if creds.JWT != nil {
+		jwksPath := creds.JWT.JWKS
+		if !filepath.IsAbs(jwksPath) {
+			jwksPath = filepath.Join(filepath.Dir(path), jwksPath)
+		}
+		if a.jwt, err = newJWTVerifier(creds.JWT, jwksPath); err != nil {
+			return nil, fmt.Errorf("jwt: %s", err)
+		}
+	}
+	log.Infof("Loaded %d API keys from %s", len(a.keys), path)
+	return a, nil
+}
+
+func checkGrants(grants []types.Grant) error {
+	for _, g := range grants {
+		for _, perm := range g.Perms {
+			switch perm {
+			case types.PermRead, types.PermWrite, types.PermDelete, types.PermAdmin:
+			default:
+				return fmt.Errorf("unknown permission %q", perm)
+			}
+		}
+	}
+	return nil
+}
+
+// Enabled tells whether requests are checked at all.
+func (a *Authenticator) Enabled() bool {
+	return a.enabled
+}
+
+// Authenticate returns who r comes from, checking its signature, bearer
+// token, API key or presigned URL, else taking the subject of its verified
+// client certificate. Requests without credentials are anonymous; requests
+// with wrong ones get an error.
+func (a *Authenticator) Authenticate(r *http.Request) (*types.Principal, error) {
+	if !a.enabled {
+		return &types.Principal{
+			Name:   MethodNone,
+			Method: MethodNone,
+			Grants: []types.Grant{{Perms: []string{types.PermAdmin}}},
+		}, nil
+	}
+	if strings.HasPrefix(r.Header.Get("Authorization"), SignAlgorithm+" ") {
+		return a.authenticateSigned(r)
+	}
+	if v := r.Header.Get("Authorization"); strings.HasPrefix(v, BearerScheme+" ") {
+		if a.jwt == nil {
+			return nil, ErrInvalidCredentials
+		}
+		return a.jwt.authenticate(strings.TrimSpace(strings.TrimPrefix(v, BearerScheme+" ")))
+	}
+	if v := r.Header.Get(common.APIKeyHeader); v != "" {
+		return a.authenticateKey(v)
+	}
+	if r.URL.Query().Get(SignatureParam) != "" {
+		return a.authenticatePresigned(r)
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		return &types.Principal{Name: cn, Method: MethodCert, Grants: a.certs[cn]}, nil
+	}
+	return &types.Principal{
+		Name:   MethodAnonymous,
+		Method: MethodAnonymous,
+		Grants: a.anonymous,
+	}, nil
+}
+
+// KeyQuota returns the quotas of the API key id, none for unknown keys.
+func (a *Authenticator) KeyQuota(id string) (bytes, objects int64) {
+	if k, ok := a.keys[id]; ok {
+		return k.QuotaBytes, k.QuotaObjects
+	}
+	return 0, 0
+}
+
+// KeyQuotas returns the API keys with their quotas, by ID.
+func (a *Authenticator) KeyQuotas() []types.KeyUsage {
+	ids := make([]string, 0, len(a.keys))
+	for id := range a.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	list := make([]types.KeyUsage, 0, len(ids))
+	for _, id := range ids {
+		k := a.keys[id]
+		list = append(list, types.KeyUsage{ID: id, QuotaBytes: k.QuotaBytes, QuotaObjects: k.QuotaObjects})
+	}
+	return list
+}
+
+// authenticateKey checks an API key given as <id>:<secret>.
+func (a *Authenticator) authenticateKey(v string) (*types.Principal, error) {
+	i := strings.Index(v, ":")
+	if i < 0 {
+		return nil, ErrInvalidCredentials
+	}
+	k, ok := a.keys[v[:i]]
+	if !ok || !CheckSecret(k.SecretHash, v[i+1:]) {
+		return nil, ErrInvalidCredentials
+	}
+	return &types.Principal{Name: k.ID, Method: MethodKey, Grants: k.Grants}, nil
+}
+
+// NewSecret returns a random secret for a new key.
+func NewSecret() string {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("unable to read random bytes: %s", err))
+	}
+	return hex.EncodeToString(buf)
+}
+
+func hashWithSalt(salt []byte, secret string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(secret))
+	return h.Sum(nil)
+}
+
+// HashSecret returns the hash of secret kept in the credentials file,
+// "sha256:<salt>:<hash>" in hex.
+func HashSecret(secret string) string {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		panic(fmt.Sprintf("unable to read random bytes: %s", err))
+	}
+	return fmt.Sprintf("%s:%x:%x", hashScheme, salt, hashWithSalt(salt, secret))
+}
+
+func splitHash(hash string) (salt, sum []byte, err error) {
+	parts := strings.Split(hash, ":")
+	if len(parts) != 3 || parts[0] != hashScheme {
+		return nil, nil, fmt.Errorf("invalid secret hash, expected %s:<salt>:<hash>", hashScheme)
+	}
+	if salt, err = hex.DecodeString(parts[1]); err != nil {
+		return nil, nil, fmt.Errorf("invalid salt: %s", err)
+	}
+	if sum, err = hex.DecodeString(parts[2]); err != nil || len(sum) != sha256.Size {
+		return nil, nil, fmt.Errorf("invalid hash")
+	}
+	return salt, sum, nil
+}
+
+// CheckSecret tells whether secret matches hash.
+func CheckSecret(hash, secret string) bool {
+	salt, sum, err := splitHash(hash)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(hashWithSalt(salt, secret), sum) == 1
+}
+
+type contextKey int
+
+const principalKey contextKey = 0
+
+// NewContext returns a copy of ctx carrying p.
+func NewContext(ctx context.Context, p *types.Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// FromContext returns the principal carried by ctx, if any.
+func FromContext(ctx context.Context) (*types.Principal, bool) {
+	p, ok := ctx.Value(principalKey).(*types.Principal)
+	return p, ok
+}
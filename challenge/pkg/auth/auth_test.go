@@ -0,0 +1,164 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package auth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+// newAuthenticator writes creds to a credentials file in dir and loads it.
+func newAuthenticator(t *testing.T, dir string, creds *Credentials, presignKey []byte) *Authenticator {
+	buf, err := json.Marshal(creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "credentials.json")
+	if err := ioutil.WriteFile(path, buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(path, presignKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestCheckSecret(t *testing.T) {
+	secret := NewSecret()
+	hash := HashSecret(secret)
+	tests := []struct {
+		name   string
+		hash   string
+		secret string
+		ok     bool
+	}{
+		{"right secret", hash, secret, true},
+		{"wrong secret", hash, NewSecret(), false},
+		{"missing secret", hash, "", false},
+		{"secret with a suffix", hash, secret + "x", false},
+		{"secret used as its hash", secret, secret, false},
+		{"unknown scheme", "md5" + hash[len(hashScheme):], secret, false},
+		{"bad salt", hashScheme + ":zz:" + hash[len(hash)-64:], secret, false},
+		{"short hash", hash[:len(hash)-2], secret, false},
+	}
+	for _, tt := range tests {
+		if ok := CheckSecret(tt.hash, tt.secret); ok != tt.ok {
+			t.Errorf("%s: CheckSecret is %v, expected %v", tt.name, ok, tt.ok)
+		}
+	}
+
+	// salted: the same secret never hashes the same
+	if HashSecret(secret) == hash {
+		t.Errorf("two hashes of a secret are the same")
+	}
+}
+
+func TestAuthenticateKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	alice, bob := NewSecret(), NewSecret()
+	grants := []types.Grant{{Prefix: "alice/", Perms: []string{types.PermRead}}}
+	a := newAuthenticator(t, dir, &Credentials{
+		Keys: []Key{
+			{ID: "alice", SecretHash: HashSecret(alice), Grants: grants},
+			{ID: "bob", SecretHash: HashSecret(bob)},
+		},
+		Anonymous: []types.Grant{{Prefix: "public/", Perms: []string{types.PermRead}}},
+	}, nil)
+
+	tests := []struct {
+		name   string
+		key    string // X-Api-Key, none if empty
+		who    string // principal, "" if refused
+		method string
+	}{
+		{"right secret", "alice:" + alice, "alice", MethodKey},
+		{"wrong secret", "alice:" + NewSecret(), "", ""},
+		{"another key's secret", "alice:" + bob, "", ""},
+		{"missing secret", "alice:", "", ""},
+		{"no separator", "alice" + alice, "", ""},
+		{"unknown key", "carol:" + alice, "", ""},
+		{"no key", "", MethodAnonymous, MethodAnonymous},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest("GET", "http://localhost/store/alice/foo", nil)
+		if tt.key != "" {
+			r.Header.Set(common.APIKeyHeader, tt.key)
+		}
+		p, err := a.Authenticate(r)
+		if tt.who == "" {
+			if err != ErrInvalidCredentials {
+				t.Errorf("%s: expected invalid credentials, got %v", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if p.Name != tt.who || p.Method != tt.method {
+			t.Errorf("%s: authenticated as %s:%s, expected %s:%s", tt.name, p.Method, p.Name, tt.method, tt.who)
+		}
+	}
+
+	// a key only gets its own grants
+	r, _ := http.NewRequest("GET", "http://localhost/store/alice/foo", nil)
+	r.Header.Set(common.APIKeyHeader, "alice:"+alice)
+	p, _ := a.Authenticate(r)
+	if !p.Allowed(types.PermRead, "alice/foo") || p.Allowed(types.PermWrite, "alice/foo") || p.Allowed(types.PermRead, "bob/foo") {
+		t.Errorf("alice got grants %v, expected %v", p.Grants, grants)
+	}
+}
+
+func TestNewInvalidCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hash := HashSecret(NewSecret())
+	tests := []struct {
+		name string
+		keys []Key
+	}{
+		{"empty ID", []Key{{ID: "", SecretHash: hash}}},
+		{"ID with a colon", []Key{{ID: "a:b", SecretHash: hash}}},
+		{"key defined twice", []Key{{ID: "a", SecretHash: hash}, {ID: "a", SecretHash: hash}}},
+		{"plain secret", []Key{{ID: "a", SecretHash: NewSecret()}}},
+		{"unknown permission", []Key{{ID: "a", SecretHash: hash, Grants: []types.Grant{{Perms: []string{"root"}}}}}},
+		{"negative quota", []Key{{ID: "a", SecretHash: hash, QuotaBytes: -1}}},
+	}
+	for _, tt := range tests {
+		buf, _ := json.Marshal(&Credentials{Keys: tt.keys})
+		path := filepath.Join(dir, "credentials.json")
+		if err := ioutil.WriteFile(path, buf, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := New(path, nil); err == nil {
+			t.Errorf("%s: credentials accepted", tt.name)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+const (
+	// BearerScheme starts the Authorization header of requests sending a
+	// JWT: Authorization: Bearer <token>
+	BearerScheme = "Bearer"
+
+	defaultGroupsClaim = "groups"
+	// clock skew allowed on exp and nbf
+	jwtLeeway = time.Minute
+	// how often the JWKS file may be reread for a key we don't know
+	jwksReloadInterval = 10 * time.Second
+)
+
+// JWTConfig is the "jwt" section of the credentials file: the keys tokens
+// are signed with and the grants their claims give. A token gets the grants
+// of its subject, of each of its groups, and PrefixPerms on each prefix
+// listed in its PrefixClaim.
+type JWTConfig struct {
+	JWKS     string `json:"jwks"`               // JWKS file, relative to the credentials file
+	Issuer   string `json:"issuer,omitempty"`   // iss tokens must have, if set
+	Audience string `json:"audience,omitempty"` // aud tokens must have, if set
+
+	Subjects    map[string][]types.Grant `json:"subjects,omitempty"`
+	Groups      map[string][]types.Grant `json:"groups,omitempty"`
+	GroupsClaim string                   `json:"groups_claim,omitempty"` // groups if empty
+	PrefixClaim string                   `json:"prefix_claim,omitempty"`
+	PrefixPerms []string                 `json:"prefix_perms,omitempty"`
+}
+
+func (c *JWTConfig) check() error {
+	if c.JWKS == "" {
+		return fmt.Errorf("no jwks file")
+	}
+	for sub, grants := range c.Subjects {
+		if err := checkGrants(grants); err != nil {
+			return fmt.Errorf("subject %s: %s", sub, err)
+		}
+	}
+	for group, grants := range c.Groups {
+		if err := checkGrants(grants); err != nil {
+			return fmt.Errorf("group %s: %s", group, err)
+		}
+	}
+	if c.PrefixClaim != "" && len(c.PrefixPerms) == 0 {
+		return fmt.Errorf("prefix_claim needs prefix_perms")
+	}
+	return checkGrants([]types.Grant{{Perms: c.PrefixPerms}})
+}
+
+// jwk is a JSON Web Key, as found in the "keys" of a JWKS file.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey is a key tokens can be verified with.
+type publicKey struct {
+	kid string
+	alg string // RS256 or ES256
+	key crypto.PublicKey
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k *jwk) publicKey() (*publicKey, error) {
+	pk := &publicKey{kid: k.Kid}
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || e.BitLen() > 31 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		pk.alg = "RS256"
+		pk.key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point not on curve")
+		}
+		pk.alg = "ES256"
+		pk.key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+	if k.Alg != "" && k.Alg != pk.alg {
+		return nil, fmt.Errorf("algorithm %q doesn't match key type %s", k.Alg, k.Kty)
+	}
+	return pk, nil
+}
+
+// loadJWKS returns the signing keys of the JWKS file at path. Keys we
+// can't use, e.g. for encryption, are skipped.
+func loadJWKS(path string) ([]*publicKey, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(buf, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS file %s: %s", path, err)
+	}
+	var keys []*publicKey
+	for i := range set.Keys {
+		k := &set.Keys[i]
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pk, err := k.publicKey()
+		if err != nil {
+			log.Warningf("Skipping key %q of %s: %s", k.Kid, path, err)
+			continue
+		}
+		keys = append(keys, pk)
+	}
+	return keys, nil
+}
+
+// jwtVerifier checks bearer tokens. The JWKS file is reread when a token
+// names a key we don't know, so keys can be rotated without a restart.
+type jwtVerifier struct {
+	conf *JWTConfig
+	path string
+
+	mu      sync.Mutex
+	keys    []*publicKey
+	modTime time.Time
+	checked time.Time
+}
+
+func newJWTVerifier(conf *JWTConfig, path string) (*jwtVerifier, error) {
+	if err := conf.check(); err != nil {
+		return nil, err
+	}
+	v := &jwtVerifier{conf: conf, path: path}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if v.keys, err = loadJWKS(path); err != nil {
+		return nil, err
+	}
+	v.modTime, v.checked = fi.ModTime(), time.Now()
+	log.Infof("Loaded %d JWT signing keys from %s", len(v.keys), path)
+	return v, nil
+}
+
+// keysFor returns the keys a token signed with alg by kid may be verified
+// with: the key kid, or every key of alg if the token names none.
+func (v *jwtVerifier) keysFor(kid, alg string) []*publicKey {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	find := func() []*publicKey {
+		var keys []*publicKey
+		for _, k := range v.keys {
+			if k.alg == alg && (kid == "" || k.kid == kid) {
+				keys = append(keys, k)
+			}
+		}
+		return keys
+	}
+	keys := find()
+	if len(keys) > 0 || time.Since(v.checked) < jwksReloadInterval {
+		return keys
+	}
+	v.checked = time.Now()
+	fi, err := os.Stat(v.path)
+	if err != nil || !fi.ModTime().After(v.modTime) {
+		return nil
+	}
+	reloaded, err := loadJWKS(v.path)
+	if err != nil {
+		log.Warningf("Unable to reload %s: %s", v.path, err)
+		return nil
+	}
+	log.Infof("Reloaded %d JWT signing keys from %s", len(reloaded), v.path)
+	v.keys, v.modTime = reloaded, fi.ModTime()
+	return find()
+}
+
+func verifySignature(k *publicKey, input string, sig []byte) bool {
+	sum := sha256.Sum256([]byte(input))
+	switch key := k.key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil
+	case *ecdsa.PublicKey:
+		// r and s, 32 bytes each
+		if len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(key, sum[:], r, s)
+	}
+	return false
+}
+
+var errInvalidToken = errors.New("invalid token")
+
+func decodeSegment(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return errInvalidToken
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errInvalidToken
+	}
+	return nil
+}
+
+// stringsClaim returns a claim holding a string or a list of strings.
+func stringsClaim(v interface{}) []string {
+	switch c := v.(type) {
+	case string:
+		return []string{c}
+	case []interface{}:
+		var list []string
+		for _, e := range c {
+			if s, ok := e.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// timeClaim returns a NumericDate claim, and whether it is there.
+func timeClaim(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("invalid %s claim", name)
+	}
+	return time.Unix(int64(n), 0), true, nil
+}
+
+// authenticate checks token and returns its subject with the grants of
+// its claims.
+func (v *jwtVerifier) authenticate(token string) (*types.Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" && header.Alg != "ES256" {
+		return nil, fmt.Errorf("unsupported token algorithm %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	verified := false
+	for _, k := range v.keysFor(header.Kid, header.Alg) {
+		if verifySignature(k, parts[0]+"."+parts[1], sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrInvalidCredentials
+	}
+
+	claims := make(map[string]interface{})
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	exp, ok, err := timeClaim(claims, "exp")
+	if err != nil {
+		return nil, err
+	}
+	if !ok || now.After(exp.Add(jwtLeeway)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	nbf, ok, err := timeClaim(claims, "nbf")
+	if err != nil {
+		return nil, err
+	}
+	if ok && now.Add(jwtLeeway).Before(nbf) {
+		return nil, fmt.Errorf("token not valid yet")
+	}
+	if v.conf.Issuer != "" && claims["iss"] != v.conf.Issuer {
+		return nil, fmt.Errorf("token has the wrong issuer")
+	}
+	if v.conf.Audience != "" && !contains(stringsClaim(claims["aud"]), v.conf.Audience) {
+		return nil, fmt.Errorf("token has the wrong audience")
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, fmt.Errorf("token has no subject")
+	}
+
+	var grants []types.Grant
+	grants = append(grants, v.conf.Subjects[sub]...)
+	groupsClaim := v.conf.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = defaultGroupsClaim
+	}
+	for _, group := range stringsClaim(claims[groupsClaim]) {
+		grants = append(grants, v.conf.Groups[group]...)
+	}
+	if v.conf.PrefixClaim != "" {
+		for _, prefix := range stringsClaim(claims[v.conf.PrefixClaim]) {
+			// an empty prefix would be every location
+			if prefix != "" {
+				grants = append(grants, types.Grant{Prefix: prefix, Perms: v.conf.PrefixPerms})
+			}
+		}
+	}
+	return &types.Principal{Name: sub, Method: MethodJWT, Grants: grants}, nil
+}
@@ -0,0 +1,211 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// padded returns n in size bytes, as ES256 signatures hold r and s.
+func padded(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// makeToken returns a token of header and claims, signed by sign.
+func makeToken(t *testing.T, header, claims map[string]interface{}, sign func(input string) []byte) string {
+	h, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := b64(h) + "." + b64(c)
+	return input + "." + b64(sign(input))
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a key that isn't in the JWKS file
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(padded(ecKey.X, 32)), "y": b64(padded(ecKey.Y, 32))},
+		},
+	}
+	buf, _ := json.Marshal(jwks)
+	if err := ioutil.WriteFile(filepath.Join(dir, "jwks.json"), buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+	a := newAuthenticator(t, dir, &Credentials{
+		JWT: &JWTConfig{
+			JWKS:     "jwks.json",
+			Issuer:   "https://issuer.example",
+			Audience: "challenge",
+			Subjects: map[string][]types.Grant{"alice": {{Prefix: "alice/", Perms: []string{types.PermWrite}}}},
+			Groups:   map[string][]types.Grant{"readers": {{Perms: []string{types.PermRead}}}},
+		},
+	}, nil)
+
+	rs256 := func(key *rsa.PrivateKey) func(string) []byte {
+		return func(input string) []byte {
+			sum := sha256.Sum256([]byte(input))
+			sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sig
+		}
+	}
+	es256 := func(input string) []byte {
+		sum := sha256.Sum256([]byte(input))
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(padded(r, 32), padded(s, 32)...)
+	}
+	none := func(string) []byte { return nil }
+	// HS256 keyed with the RSA public key, as a verifier confusing the
+	// algorithms would check it
+	pub, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs256 := func(input string) []byte { return hmacSHA256(pub, input) }
+
+	now := time.Now().Unix()
+	// claims of a valid token, with changes: nil values are removed
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":    "https://issuer.example",
+			"aud":    "challenge",
+			"sub":    "alice",
+			"exp":    now + 3600,
+			"nbf":    now - 60,
+			"groups": []string{"readers"},
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	rsaHeader := map[string]interface{}{"alg": "RS256", "kid": "rsa", "typ": "JWT"}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"RS256", makeToken(t, rsaHeader, claims(nil), rs256(rsaKey)), true},
+		{"ES256", makeToken(t, map[string]interface{}{"alg": "ES256", "kid": "ec"}, claims(nil), es256), true},
+		{"no kid", makeToken(t, map[string]interface{}{"alg": "RS256"}, claims(nil), rs256(rsaKey)), true},
+		{"audience in a list", makeToken(t, rsaHeader, claims(map[string]interface{}{"aud": []string{"other", "challenge"}}), rs256(rsaKey)), true},
+		{"expired within the leeway", makeToken(t, rsaHeader, claims(map[string]interface{}{"exp": now - 30}), rs256(rsaKey)), true},
+		{"valid soon, within the leeway", makeToken(t, rsaHeader, claims(map[string]interface{}{"nbf": now + 30}), rs256(rsaKey)), true},
+
+		{"expired", makeToken(t, rsaHeader, claims(map[string]interface{}{"exp": now - 120}), rs256(rsaKey)), false},
+		{"no expiry", makeToken(t, rsaHeader, claims(map[string]interface{}{"exp": nil}), rs256(rsaKey)), false},
+		{"expiry as a string", makeToken(t, rsaHeader, claims(map[string]interface{}{"exp": "tomorrow"}), rs256(rsaKey)), false},
+		{"used before nbf", makeToken(t, rsaHeader, claims(map[string]interface{}{"nbf": now + 120}), rs256(rsaKey)), false},
+		{"wrong issuer", makeToken(t, rsaHeader, claims(map[string]interface{}{"iss": "https://evil.example"}), rs256(rsaKey)), false},
+		{"no issuer", makeToken(t, rsaHeader, claims(map[string]interface{}{"iss": nil}), rs256(rsaKey)), false},
+		{"wrong audience", makeToken(t, rsaHeader, claims(map[string]interface{}{"aud": "other"}), rs256(rsaKey)), false},
+		{"no audience", makeToken(t, rsaHeader, claims(map[string]interface{}{"aud": nil}), rs256(rsaKey)), false},
+		{"no subject", makeToken(t, rsaHeader, claims(map[string]interface{}{"sub": nil}), rs256(rsaKey)), false},
+		{"unknown kid", makeToken(t, map[string]interface{}{"alg": "RS256", "kid": "gone"}, claims(nil), rs256(rsaKey)), false},
+		{"unknown key", makeToken(t, rsaHeader, claims(nil), rs256(otherKey)), false},
+		{"unknown key without kid", makeToken(t, map[string]interface{}{"alg": "RS256"}, claims(nil), rs256(otherKey)), false},
+		{"alg none", makeToken(t, map[string]interface{}{"alg": "none"}, claims(nil), none), false},
+		{"alg none with a kid", makeToken(t, map[string]interface{}{"alg": "none", "kid": "rsa"}, claims(nil), none), false},
+		{"RS256 without signature", makeToken(t, rsaHeader, claims(nil), none), false},
+		{"HS256 keyed with the public key", makeToken(t, map[string]interface{}{"alg": "HS256", "kid": "rsa"}, claims(nil), hs256), false},
+		{"ES256 naming the RSA key", makeToken(t, map[string]interface{}{"alg": "ES256", "kid": "rsa"}, claims(nil), es256), false},
+		{"RS256 naming the EC key", makeToken(t, map[string]interface{}{"alg": "RS256", "kid": "ec"}, claims(nil), rs256(rsaKey)), false},
+		{"tampered claims", func() string {
+			token := makeToken(t, rsaHeader, claims(nil), rs256(rsaKey))
+			parts := strings.Split(token, ".")
+			c, _ := json.Marshal(claims(map[string]interface{}{"sub": "admin"}))
+			return parts[0] + "." + b64(c) + "." + parts[2]
+		}(), false},
+		{"two parts", strings.Join(strings.Split(makeToken(t, rsaHeader, claims(nil), rs256(rsaKey)), ".")[:2], "."), false},
+	}
+
+	for _, tt := range tests {
+		r, _ := http.NewRequest("GET", "http://localhost:7777/store/alice/foo", nil)
+		r.Header.Set("Authorization", BearerScheme+" "+tt.token)
+		p, err := a.Authenticate(r)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%s: authenticated as %s", tt.name, p.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if p.Name != "alice" || p.Method != MethodJWT {
+			t.Errorf("%s: authenticated as %s:%s", tt.name, p.Method, p.Name)
+		}
+		// grants of the subject and of its groups
+		if !p.Allowed(types.PermWrite, "alice/foo") || !p.Allowed(types.PermRead, "bob/foo") || p.Allowed(types.PermWrite, "bob/foo") {
+			t.Errorf("%s: got grants %v", tt.name, p.Grants)
+		}
+	}
+}
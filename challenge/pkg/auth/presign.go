@@ -0,0 +1,119 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package auth
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+const (
+	// Query parameters of a presigned URL:
+	//   <common.StorePath(location)>?X-Challenge-Method=GET&X-Challenge-Expires=<unix>
+	//   &X-Challenge-Credential=<principal>&X-Challenge-Signature=<hex>
+	MethodParam     = "X-Challenge-Method"
+	ExpiresParam    = "X-Challenge-Expires"
+	CredentialParam = "X-Challenge-Credential"
+	SignatureParam  = "X-Challenge-Signature"
+
+	// DefaultPresignExpiry and MaxPresignExpiry bound how long a presigned
+	// URL is good for.
+	DefaultPresignExpiry = time.Hour
+	MaxPresignExpiry     = 7 * 24 * time.Hour
+)
+
+// ErrForbidden is returned when a principal asks for more than it is
+// allowed.
+var ErrForbidden = errors.New("forbidden")
+
+// PresignPerm returns the permission a presigned URL for method gives, which
+// whoever mints it must have.
+func PresignPerm(method string) (string, error) {
+	switch method {
+	case "GET":
+		return types.PermRead, nil
+	case "PUT", "POST":
+		return types.PermWrite, nil
+	}
+	return "", fmt.Errorf("URLs can't be presigned for %s", method)
+}
+
+func (a *Authenticator) presignSignature(method, path, expires, credential string) string {
+	s := strings.Join([]string{method, path, expires, credential}, "\n")
+	return hex.EncodeToString(hmacSHA256(a.presignKey, s))
+}
+
+// Presign returns the path and query of a URL letting whoever has it send
+// method requests to location until expires, as p. The caller checks that
+// p has the PresignPerm of method itself.
+func (a *Authenticator) Presign(p *types.Principal, method, location string, expires time.Time) (string, error) {
+	if _, err := PresignPerm(method); err != nil {
+		return "", err
+	}
+	if !common.ValidLocation(location, false) {
+		return "", fmt.Errorf("invalid location %q", location)
+	}
+	path := common.StorePath(location)
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q := url.Values{}
+	q.Set(MethodParam, method)
+	q.Set(ExpiresParam, exp)
+	q.Set(CredentialParam, p.Name)
+	q.Set(SignatureParam, a.presignSignature(method, path, exp, p.Name))
+	return (&url.URL{Path: path}).String() + "?" + q.Encode(), nil
+}
+
+// authenticatePresigned checks a request sent to a URL returned by Presign.
+// A GET URL may be used for HEAD requests too. The principal only gets
+// the permission of the method on the location of the URL.
+func (a *Authenticator) authenticatePresigned(r *http.Request) (*types.Principal, error) {
+	q := r.URL.Query()
+	method, exp, credential := q.Get(MethodParam), q.Get(ExpiresParam), q.Get(CredentialParam)
+	if len(a.presignKey) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+	want := a.presignSignature(method, r.URL.Path, exp, credential)
+	if !hmac.Equal([]byte(want), []byte(q.Get(SignatureParam))) {
+		return nil, ErrInvalidCredentials
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return nil, fmt.Errorf("presigned URL expired")
+	}
+	if r.Method != method && !(method == "GET" && r.Method == "HEAD") {
+		return nil, fmt.Errorf("URL is presigned for %s, not %s", method, r.Method)
+	}
+	perm, err := PresignPerm(method)
+	if err != nil {
+		return nil, err
+	}
+	location, ok := common.PathLocation(r.URL.Path)
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return &types.Principal{
+		Name:   credential,
+		Method: MethodPresigned,
+		Grants: []types.Grant{{Prefix: location, Perms: []string{perm}}},
+	}, nil
+}
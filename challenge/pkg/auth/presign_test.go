@@ -0,0 +1,105 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package auth
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+func TestAuthenticatePresigned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := []byte("presign key")
+	a := newAuthenticator(t, dir, &Credentials{}, key)
+	other := newAuthenticator(t, dir, &Credentials{}, []byte("another key"))
+	alice := &types.Principal{Name: "alice", Method: MethodKey}
+	presign := func(a *Authenticator, method, location string, expires time.Time) string {
+		u, err := a.Presign(alice, method, location, expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	// query parameter name of a presigned URL set to value
+	set := func(u, name, value string) string {
+		parsed, _ := url.Parse(u)
+		q := parsed.Query()
+		q.Set(name, value)
+		parsed.RawQuery = q.Encode()
+		return parsed.String()
+	}
+
+	later := time.Now().Add(time.Hour)
+	get := presign(a, "GET", "foo", later)
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		perm   string // the principal gets on the location, "" if refused
+		loc    string // of the URL
+	}{
+		{"GET", "GET", get, types.PermRead, "foo"},
+		{"HEAD with a GET URL", "HEAD", get, types.PermRead, "foo"},
+		{"PUT", "PUT", presign(a, "PUT", "foo", later), types.PermWrite, "foo"},
+		{"bucket", "GET", presign(a, "GET", "photos/foo", later), types.PermRead, "photos/foo"},
+		{"PUT with a GET URL", "PUT", get, "", "foo"},
+		{"DELETE with a GET URL", "DELETE", get, "", "foo"},
+		{"GET with a PUT URL", "GET", presign(a, "PUT", "foo", later), "", "foo"},
+		{"other method in the URL", "PUT", set(get, MethodParam, "PUT"), "", "foo"},
+		{"other path", "GET", strings.Replace(get, "/store/foo", "/store/bar", 1), "", "foo"},
+		{"other bucket", "GET", strings.Replace(presign(a, "GET", "photos/foo", later), "/photos/", "/videos/", 1), "", "photos/foo"},
+		{"expired", "GET", presign(a, "GET", "foo", time.Now().Add(-time.Second)), "", "foo"},
+		{"expiry pushed back", "GET", set(presign(a, "GET", "foo", time.Now().Add(-time.Second)), ExpiresParam, "9999999999"), "", "foo"},
+		{"other credential", "GET", set(get, CredentialParam, "bob"), "", "foo"},
+		{"tampered signature", "GET", set(get, SignatureParam, strings.Repeat("0", 64)), "", "foo"},
+		{"signed with another key", "GET", presign(other, "GET", "foo", later), "", "foo"},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest(tt.method, "http://localhost:7777"+tt.url, nil)
+		p, err := a.Authenticate(r)
+		if tt.perm == "" {
+			if err == nil {
+				t.Errorf("%s: authenticated as %s", tt.name, p.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		location := tt.loc
+		if p.Name != "alice" || p.Method != MethodPresigned || !p.Allowed(tt.perm, location) {
+			t.Errorf("%s: authenticated as %s:%s with %v", tt.name, p.Method, p.Name, p.Grants)
+		}
+		// nothing else
+		if p.Allowed(types.PermDelete, location) || p.Allowed(tt.perm, "bar") {
+			t.Errorf("%s: got more than %s on %s: %v", tt.name, tt.perm, location, p.Grants)
+		}
+	}
+
+	if _, err := a.Presign(alice, "DELETE", "foo", later); err == nil {
+		t.Errorf("presigned a DELETE URL")
+	}
+}
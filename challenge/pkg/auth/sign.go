@@ -0,0 +1,282 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+const (
+	// SignAlgorithm starts the Authorization header of signed requests:
+	//   CHALLENGE-HMAC-SHA256 Credential=<id>/<yyyymmdd>/challenge_request,
+	//   SignedHeaders=<header>;..., Signature=<hex>
+	SignAlgorithm = "CHALLENGE-HMAC-SHA256"
+	// UnsignedPayload is sent as the content hash of requests whose body
+	// isn't signed.
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+	// MaxClockSkew is how far the date of a signed request may be from
+	// ours.
+	MaxClockSkew = 15 * time.Minute
+
+	signService = "challenge_request"
+	dateFormat  = "20060102T150405Z"
+	dayFormat   = "20060102"
+)
+
+var errPayloadHash = errors.New("body doesn't match its signed SHA-256")
+
+// headers every signature must cover
+var requiredSignedHeaders = []string{"host", strings.ToLower(common.DateHeader), strings.ToLower(common.ContentSHA256Header)}
+
+// SigningKey returns the key a client signs requests with, derived from the
+// secret of its API key. The credentials file keeps it, not the secret.
+func SigningKey(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("challenge-signing-key"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// scopedKey derives the key of a day from a signing key, so that the key
+// signing a request is only good for that day.
+func scopedKey(signingKey, day string) []byte {
+	return hmacSHA256(hmacSHA256([]byte("CHALLENGE"+signingKey), day), signService)
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalQuery returns the query parameters sorted and escaped.
+func canonicalQuery(u *url.URL) string {
+	params := []string{}
+	for k, vs := range u.Query() {
+		for _, v := range vs {
+			params = append(params, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// canonicalRequest is what gets signed of a request: method, path, query,
+// the signed headers and the hash of the body, one per line.
+func canonicalRequest(r *http.Request, signedHeaders []string, payloadHash string) string {
+	headers := ""
+	for _, h := range signedHeaders {
+		v := r.Header.Get(h)
+		if h == "host" {
+			v = r.Host
+			if v == "" {
+				v = r.URL.Host
+			}
+		}
+		headers += h + ":" + strings.TrimSpace(v) + "\n"
+	}
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return strings.Join([]string{
+		r.Method,
+		path,
+		canonicalQuery(r.URL),
+		headers,
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+// signature returns the hex signature of a canonical request made at date
+// by the holder of signingKey.
+func signature(signingKey, date, canonical string) string {
+	day := date[:len(dayFormat)]
+	toSign := strings.Join([]string{
+		SignAlgorithm,
+		date,
+		day + "/" + signService,
+		sha256Hex(canonical),
+	}, "\n")
+	return hex.EncodeToString(hmacSHA256(scopedKey(signingKey, day), toSign))
+}
+
+// SignRequest signs req as the API key id, whose signing key is given.
+// payloadHash is the hex SHA-256 of the body, or UnsignedPayload.
+func SignRequest(req *http.Request, id, signingKey, payloadHash string, t time.Time) {
+	date := t.UTC().Format(dateFormat)
+	req.Header.Set(common.DateHeader, date)
+	req.Header.Set(common.ContentSHA256Header, payloadHash)
+	sig := signature(signingKey, date, canonicalRequest(req, requiredSignedHeaders, payloadHash))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s/%s, SignedHeaders=%s, Signature=%s",
+		SignAlgorithm, id, date[:len(dayFormat)], signService, strings.Join(requiredSignedHeaders, ";"), sig))
+}
+
+// parseAuthorization splits the Authorization header of a signed request.
+func parseAuthorization(v string) (id, day string, signedHeaders []string, sig string, err error) {
+	fields := map[string]string{}
+	for _, f := range strings.Split(strings.TrimPrefix(v, SignAlgorithm), ",") {
+		kv := strings.SplitN(strings.TrimSpace(f), "=", 2)
+		if len(kv) != 2 {
+			return "", "", nil, "", fmt.Errorf("malformed Authorization header")
+		}
+		fields[kv[0]] = kv[1]
+	}
+	cred := strings.Split(fields["Credential"], "/")
+	if len(cred) != 3 || cred[2] != signService {
+		return "", "", nil, "", fmt.Errorf("malformed credential %q", fields["Credential"])
+	}
+	if fields["SignedHeaders"] == "" || fields["Signature"] == "" {
+		return "", "", nil, "", fmt.Errorf("malformed Authorization header")
+	}
+	return cred[0], cred[1], strings.Split(fields["SignedHeaders"], ";"), fields["Signature"], nil
+}
+
+// checkDate parses the date a request was signed at and checks it is close
+// enough to ours.
+func checkDate(date string, now time.Time) (time.Time, error) {
+	t, err := time.Parse(dateFormat, date)
+	if err != nil {
+		return t, fmt.Errorf("invalid date %q", date)
+	}
+	if d := now.Sub(t); d > MaxClockSkew || d < -MaxClockSkew {
+		return t, fmt.Errorf("request date %s is too far from ours", date)
+	}
+	return t, nil
+}
+
+// authenticateSigned checks a request signed with SignRequest. A signature
+// is only accepted once: replaying a request, even within the clock skew,
+// fails.
+func (a *Authenticator) authenticateSigned(r *http.Request) (*types.Principal, error) {
+	id, day, signedHeaders, sig, err := parseAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range requiredSignedHeaders {
+		if !contains(signedHeaders, h) {
+			return nil, fmt.Errorf("header %s must be signed", h)
+		}
+	}
+	date := r.Header.Get(common.DateHeader)
+	t, err := checkDate(date, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if t.Format(dayFormat) != day {
+		return nil, fmt.Errorf("credential scope doesn't match the request date")
+	}
+	payloadHash := r.Header.Get(common.ContentSHA256Header)
+	if payloadHash != UnsignedPayload {
+		if b, err := hex.DecodeString(payloadHash); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("invalid %s header", common.ContentSHA256Header)
+		}
+	}
+
+	k, ok := a.keys[id]
+	if !ok || k.SigningKey == "" {
+		return nil, ErrInvalidCredentials
+	}
+	want := signature(k.SigningKey, date, canonicalRequest(r, signedHeaders, payloadHash))
+	if !hmac.Equal([]byte(want), []byte(sig)) {
+		return nil, ErrInvalidCredentials
+	}
+	if !a.replays.add(sig, t.Add(MaxClockSkew)) {
+		return nil, fmt.Errorf("request was already received")
+	}
+
+	if payloadHash != UnsignedPayload && r.Body != nil {
+		r.Body = &hashCheckReader{rc: r.Body, h: sha256.New(), want: payloadHash}
+	}
+	return &types.Principal{Name: k.ID, Method: MethodSignature, Grants: k.Grants}, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// hashCheckReader fails at the end of a body that doesn't match its
+// signed hash, before the reader sees io.EOF.
+type hashCheckReader struct {
+	rc   io.ReadCloser
+	h    hash.Hash
+	want string
+}
+
+func (hr *hashCheckReader) Read(p []byte) (int, error) {
+	n, err := hr.rc.Read(p)
+	hr.h.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(hr.h.Sum(nil)) != hr.want {
+		return n, errPayloadHash
+	}
+	return n, err
+}
+
+func (hr *hashCheckReader) Close() error {
+	return hr.rc.Close()
+}
+
+// replayCache remembers the signatures seen until they expire.
+type replayCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+// add records sig and returns false if it was already seen.
+func (c *replayCache) add(sig string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.seen == nil {
+		c.seen = make(map[string]time.Time)
+	}
+	if now.Sub(c.lastPrune) > time.Minute {
+		for s, exp := range c.seen {
+			if now.After(exp) {
+				delete(c.seen, s)
+			}
+		}
+		c.lastPrune = now
+	}
+	if _, ok := c.seen[sig]; ok {
+		return false
+	}
+	c.seen[sig] = expires
+	return true
+}
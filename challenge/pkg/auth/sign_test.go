@@ -0,0 +1,201 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package auth
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+func TestAuthenticateSigned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secret, other := NewSecret(), NewSecret()
+	a := newAuthenticator(t, dir, &Credentials{
+		Keys: []Key{
+			{ID: "alice", SecretHash: HashSecret(secret), SigningKey: SigningKey(secret),
+				Grants: []types.Grant{{Perms: []string{types.PermWrite}}}},
+			// can't sign, only send its key
+			{ID: "bob", SecretHash: HashSecret(other)},
+		},
+	}, nil)
+
+	const body = "11111111111111111"
+	tests := []struct {
+		name    string
+		id      string    // key signing, alice if empty
+		secret  string    // it signs with, alice's if empty
+		hash    string    // of the body signed, its SHA-256 if empty
+		at      time.Time // of the signature, now if zero
+		send    string    // body sent, body if empty
+		tamper  func(r *http.Request)
+		ok      bool // authenticated
+		bodyErr bool // and the body fails its hash
+	}{
+		{name: "signed", ok: true},
+		{name: "unsigned payload", hash: UnsignedPayload, send: "anything", ok: true},
+		{name: "wrong secret", secret: other},
+		{name: "unknown key", id: "carol"},
+		{name: "key without a signing key", id: "bob", secret: other},
+		{name: "too old", at: time.Now().Add(-2 * MaxClockSkew)},
+		{name: "too far ahead", at: time.Now().Add(2 * MaxClockSkew)},
+		{name: "tampered body", send: "22222222222222222", ok: true, bodyErr: true},
+		{
+			name:   "tampered signature",
+			tamper: func(r *http.Request) { tamperLast(r, "Authorization") },
+		},
+		{
+			name:   "missing signature",
+			tamper: func(r *http.Request) { r.Header.Set("Authorization", SignAlgorithm+" Credential=alice") },
+		},
+		{
+			name:   "tampered payload hash",
+			tamper: func(r *http.Request) { tamperLast(r, common.ContentSHA256Header) },
+		},
+		{
+			name:   "tampered date",
+			tamper: func(r *http.Request) { tamperLast(r, common.DateHeader) },
+		},
+		{
+			name:   "other method",
+			tamper: func(r *http.Request) { r.Method = "DELETE" },
+		},
+		{
+			name:   "other path",
+			tamper: func(r *http.Request) { r.URL.Path = "/store/bar" },
+		},
+		{
+			name:   "other query",
+			tamper: func(r *http.Request) { r.URL.RawQuery = "version=2" },
+		},
+		{
+			name:   "other host",
+			tamper: func(r *http.Request) { r.Host = "example.com" },
+		},
+	}
+
+	for i, tt := range tests {
+		// a second apart, so that no two cases send the same signature
+		id, key, hash, at, send := "alice", SigningKey(secret), sha256Hex(body), time.Now().Add(-time.Duration(i)*time.Second), body
+		if tt.id != "" {
+			id = tt.id
+		}
+		if tt.secret != "" {
+			key = SigningKey(tt.secret)
+		}
+		if tt.hash != "" {
+			hash = tt.hash
+		}
+		if !tt.at.IsZero() {
+			at = tt.at
+		}
+		if tt.send != "" {
+			send = tt.send
+		}
+		r, _ := http.NewRequest("PUT", "http://localhost:7777/store/foo", strings.NewReader(send))
+		SignRequest(r, id, key, hash, at)
+		if tt.tamper != nil {
+			tt.tamper(r)
+		}
+
+		p, err := a.Authenticate(r)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%s: authenticated as %s", tt.name, p.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if p.Name != "alice" || p.Method != MethodSignature {
+			t.Errorf("%s: authenticated as %s:%s", tt.name, p.Method, p.Name)
+		}
+		got, err := ioutil.ReadAll(r.Body)
+		if tt.bodyErr != (err == errPayloadHash) {
+			t.Errorf("%s: reading the body returned %v", tt.name, err)
+		}
+		if string(got) != send {
+			t.Errorf("%s: read %q, expected %q", tt.name, got, send)
+		}
+	}
+}
+
+// tamperLast changes the last character of header h of r.
+func tamperLast(r *http.Request, h string) {
+	v := r.Header.Get(h)
+	last := "0"
+	if strings.HasSuffix(v, "0") {
+		last = "1"
+	}
+	r.Header.Set(h, v[:len(v)-1]+last)
+}
+
+func TestAuthenticateSignedReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secret := NewSecret()
+	a := newAuthenticator(t, dir, &Credentials{
+		Keys: []Key{{ID: "alice", SecretHash: HashSecret(secret), SigningKey: SigningKey(secret)}},
+	}, nil)
+
+	sign := func(at time.Time) *http.Request {
+		r, _ := http.NewRequest("DELETE", "http://localhost:7777/store/foo", nil)
+		SignRequest(r, "alice", SigningKey(secret), sha256Hex(""), at)
+		return r
+	}
+	now := time.Now()
+	first := sign(now)
+	if _, err := a.Authenticate(first); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		ok   bool
+	}{
+		{"replayed", sign(now), false},
+		{"replayed with other headers", func() *http.Request {
+			r := sign(now)
+			r.Header.Set("User-Agent", "replayer")
+			return r
+		}(), false},
+		{"signed again a second later", sign(now.Add(time.Second)), true},
+	}
+	for _, tt := range tests {
+		_, err := a.Authenticate(tt.r)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: accepted", tt.name)
+		}
+	}
+}
@@ -34,15 +34,43 @@ const (
 
 // Blob contains all the details of the blob on disk
 type Blob struct {
-	ID       uint16 `json:"id"`       // Blob ID
-	Location string `json:"location"` // Blob Location
+	ID       uint16 `json:"id"`                 // Blob ID
+	Location string `json:"location"`           // Blob Location
+	Version  int64  `json:"version,omitempty"`  // Write version, newer writes win between peers
+	Checksum string `json:"checksum,omitempty"` // SHA-256 of the data, hex encoded
+	Creator  string `json:"creator,omitempty"`  // Principal that wrote the data, if authentication is on
+
+	Size        int64  `json:"size,omitempty"`        // Size of the data as written, before compression
+	Compression string `json:"compression,omitempty"` // Compression of the stored data, if any
+	Expires     int64  `json:"expires,omitempty"`     // Time the blob expires at, in Unix nanoseconds like versions, 0 if never
+	Noncurrent  bool   `json:"noncurrent,omitempty"`  // Replaced or deleted in a versioned bucket, kept as an older version
 
 	Opts   *option.BoolOptions `json:"options"`
 	Status *BlobStatus         `json:"status,omitempty"`
 
+	Tier     string     `json:"tier,omitempty"`     // Storage tier holding the blob, if tiering is on
+	Accessed int64      `json:"accessed,omitempty"` // Last read or write, in Unix nanoseconds, if tiering is on
+	Shards   *ShardInfo `json:"shards,omitempty"`   // Shard layout, if the blob is erasure coded
+
 	UpdateMU sync.RWMutex // Blob Mutex must be held for any updates
 }
 
+// ShardInfo describes how the data of an erasure coded blob is laid out.
+type ShardInfo struct {
+	Data      int      `json:"data"`      // Number of data shards
+	Parity    int      `json:"parity"`    // Number of parity shards
+	Size      int64    `json:"size"`      // Size of the blob data
+	Checksums []uint32 `json:"checksums"` // CRC32 of each shard
+	Dirs      []int    `json:"dirs"`      // Data directory of each shard
+}
+
+func (si *ShardInfo) DeepCopy() *ShardInfo {
+	cpy := *si
+	cpy.Checksums = append([]uint32(nil), si.Checksums...)
+	cpy.Dirs = append([]int(nil), si.Dirs...)
+	return &cpy
+}
+
 type statusLog struct {
 	Status    Status    `json:"status"`
 	Timestamp time.Time `json:"timestamp"`
@@ -101,6 +129,17 @@ func (e *BlobStatus) LastStatus() StatusCode {
 	return OK
 }
 
+// LastUpdate returns the time of the last status recorded
+func (e *BlobStatus) LastUpdate() time.Time {
+	if len(e.Log) > 0 {
+		lastLog := e.Log[e.lastIndex()]
+		if lastLog != nil {
+			return lastLog.Timestamp
+		}
+	}
+	return time.Time{}
+}
+
 func (e *BlobStatus) DumpLog() string {
 	logs := []string{}
 	for i := e.lastIndex(); ; i-- {
@@ -135,6 +174,16 @@ func (b *Blob) DeepCopy() *Blob {
 	cpy := &Blob{
 		ID:       b.ID,
 		Location: b.Location,
+		Version:  b.Version,
+		Checksum: b.Checksum,
+		Creator:  b.Creator,
+		Tier:     b.Tier,
+		Accessed: b.Accessed,
+
+		Size:        b.Size,
+		Compression: b.Compression,
+		Expires:     b.Expires,
+		Noncurrent:  b.Noncurrent,
 	}
 
 	if b.Opts != nil {
@@ -143,6 +192,9 @@ func (b *Blob) DeepCopy() *Blob {
 	if b.Status != nil {
 		cpy.Status = b.Status.DeepCopy()
 	}
+	if b.Shards != nil {
+		cpy.Shards = b.Shards.DeepCopy()
+	}
 
 	return cpy
 }
@@ -180,7 +232,7 @@ func (b *Blob) LogStatusPending(msg string) {
 }
 
 // Base64 returns the blob in a base64 format.
-func (bb Blob) Base64() (string, error) {
+func (bb *Blob) Base64() (string, error) {
 	jsonBytes, err := json.Marshal(bb)
 	if err != nil {
 		return "", err
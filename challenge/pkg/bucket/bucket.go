@@ -0,0 +1,152 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package bucket
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+
+	"github.com/op/go-logging"
+)
+
+var (
+	log = logging.MustGetLogger("challenge-bucket")
+
+	// ErrNotFound is returned for a bucket that does not exist.
+	ErrNotFound = errors.New("bucket not found")
+	// ErrExists is returned when creating a bucket that already exists.
+	ErrExists = errors.New("bucket already exists")
+	// ErrNotEmpty is returned when deleting a bucket still holding blobs.
+	ErrNotEmpty = errors.New("bucket not empty")
+)
+
+// Registry holds the buckets, saved in a file.
+type Registry struct {
+	path string
+
+	mu      sync.RWMutex
+	buckets map[string]*types.Bucket
+}
+
+// New returns a registry saving its buckets in the file at path, loading
+// those saved there before.
+func New(path string) (*Registry, error) {
+	r := &Registry{path: path, buckets: make(map[string]*types.Bucket)}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved []*types.Bucket
+	if err := json.Unmarshal(buf, &saved); err != nil {
+		return nil, err
+	}
+	for _, b := range saved {
+		r.buckets[b.Name] = b
+	}
+	log.Infof("Loaded %d buckets from %s", len(r.buckets), path)
+	return r, nil
+}
+
+// save writes the buckets to the file. Must be called with mu held.
+func (r *Registry) save() error {
+	buf, err := json.Marshal(r.sorted())
+	if err != nil {
+		return err
+	}
+	tmpPath := r.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, r.path)
+}
+
+// sorted returns copies of the buckets, by name. Must be called with mu
+// held.
+func (r *Registry) sorted() []types.Bucket {
+	names := make([]string, 0, len(r.buckets))
+	for name := range r.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]types.Bucket, 0, len(names))
+	for _, name := range names {
+		list = append(list, *r.buckets[name])
+	}
+	return list
+}
+
+// List returns the buckets, by name.
+func (r *Registry) List() []types.Bucket {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.sorted()
+}
+
+// Get returns a copy of the bucket called name, or ErrNotFound.
+func (r *Registry) Get(name string) (*types.Bucket, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	b, ok := r.buckets[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cpy := *b
+	return &cpy, nil
+}
+
+// Add stores the new bucket b, or returns ErrExists.
+func (r *Registry) Add(b *types.Bucket) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.buckets[b.Name]; ok {
+		return ErrExists
+	}
+	cpy := *b
+	cpy.Usage = nil
+	r.buckets[b.Name] = &cpy
+	return r.save()
+}
+
+// Set stores b, unless the bucket was updated later. It tells whether b
+// was stored.
+func (r *Registry) Set(b *types.Bucket) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cur, ok := r.buckets[b.Name]; ok && !b.Updated.After(cur.Updated) {
+		return false, nil
+	}
+	cpy := *b
+	cpy.Usage = nil
+	r.buckets[b.Name] = &cpy
+	return true, r.save()
+}
+
+// Remove deletes the bucket called name.
+func (r *Registry) Remove(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.buckets[name]; !ok {
+		return ErrNotFound
+	}
+	delete(r.buckets, name)
+	return r.save()
+}
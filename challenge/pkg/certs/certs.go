@@ -0,0 +1,151 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Package certs holds the TLS certificate of the server and the CA bundle
+// client certificates are checked against, and reloads them in place.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("challenge-certs")
+
+const dialTimeout = 30 * time.Second
+
+// Store holds the certificate and key of the server and, optionally, the
+// CA bundle client certificates are verified against. Reload swaps in new
+// ones for the connections made from then on, without touching established
+// ones: the listener of Listen picks the TLS config of each connection as
+// it accepts it, and DialTLS as it dials.
+type Store struct {
+	certFile, keyFile, caFile string
+	clientAuth                tls.ClientAuthType
+
+	loaded atomic.Value // *loaded
+}
+
+// loaded is what was last loaded from the files, never modified.
+type loaded struct {
+	cert   *tls.Certificate
+	server *tls.Config
+	roots  *x509.CertPool // servers we dial are verified against these
+}
+
+// New loads the certificate and key of the server from certFile and
+// keyFile, and the CA bundle from caFile if it isn't empty. With a CA
+// bundle, client certificates are verified against it and, unless optional
+// is set, required.
+func New(certFile, keyFile, caFile string, optional bool) (*Store, error) {
+	s := &Store{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	s.clientAuth = tls.RequireAndVerifyClientCert
+	if optional {
+		s.clientAuth = tls.VerifyClientCertIfGiven
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func loadPool(pool *x509.CertPool, path string) error {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !pool.AppendCertsFromPEM(buf) {
+		return fmt.Errorf("no certificate found in %s", path)
+	}
+	return nil
+}
+
+// Reload reads the files again. On error the ones loaded before are kept.
+func (s *Store) Reload() error {
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load %s: %s", s.certFile, err)
+	}
+	l := &loaded{cert: &cert}
+	l.server = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// handshakes started with the previous config get the new
+		// certificate too
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.current().cert, nil
+		},
+	}
+	l.roots, err = x509.SystemCertPool()
+	if err != nil {
+		l.roots = x509.NewCertPool()
+	}
+	if s.caFile != "" {
+		clientCAs := x509.NewCertPool()
+		if err := loadPool(clientCAs, s.caFile); err != nil {
+			return fmt.Errorf("unable to load CA bundle: %s", err)
+		}
+		l.server.ClientCAs = clientCAs
+		l.server.ClientAuth = s.clientAuth
+		loadPool(l.roots, s.caFile)
+	}
+	s.loaded.Store(l)
+	log.Infof("Loaded certificate %s", s.certFile)
+	return nil
+}
+
+func (s *Store) current() *loaded {
+	return s.loaded.Load().(*loaded)
+}
+
+// VerifiesClients tells whether client certificates are checked.
+func (s *Store) VerifiesClients() bool {
+	return s.caFile != ""
+}
+
+// Listen returns a listener speaking TLS over inner, with the config last
+// loaded when each connection is accepted.
+func (s *Store) Listen(inner net.Listener) net.Listener {
+	return &listener{Listener: inner, s: s}
+}
+
+type listener struct {
+	net.Listener
+	s *Store
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return tls.Server(conn, l.s.current().server), nil
+}
+
+// DialTLS dials the server at addr for requests to other servers: it gets
+// our certificate if it asks for one, and its own is verified against the
+// system roots and our CA bundle.
+func (s *Store) DialTLS(network, addr string) (net.Conn, error) {
+	l := s.current()
+	conf := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		RootCAs:      l.roots,
+		Certificates: []tls.Certificate{*l.cert},
+	}
+	return tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout, KeepAlive: dialTimeout}, network, addr, conf)
+}
@@ -0,0 +1,186 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package erasure
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Encoder splits data into Data shards and computes Parity shards using a
+// systematic Reed-Solomon code: any Data of the Data+Parity shards are
+// enough to get everything back.
+type Encoder struct {
+	Data   int
+	Parity int
+
+	// (Data+Parity) x Data encoding matrix, its first Data rows are the
+	// identity so data shards are stored as is
+	matrix matrix
+}
+
+// New returns an Encoder for data data shards and parity parity shards.
+func New(data, parity int) (*Encoder, error) {
+	if data <= 0 || parity < 0 || data+parity > 256 {
+		return nil, fmt.Errorf("invalid shard counts %d+%d", data, parity)
+	}
+	v := vandermonde(data+parity, data)
+	top, err := v.subRows(seq(data)).invert()
+	if err != nil {
+		return nil, err
+	}
+	return &Encoder{
+		Data:   data,
+		Parity: parity,
+		matrix: v.mul(top),
+	}, nil
+}
+
+// ParseScheme parses a scheme such as "6+3" into its data and parity shard
+// counts.
+func ParseScheme(scheme string) (int, int, error) {
+	parts := strings.Split(scheme, "+")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid erasure coding scheme %q, should be <data>+<parity>", scheme)
+	}
+	data, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid erasure coding scheme %q: %s", scheme, err)
+	}
+	parity, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid erasure coding scheme %q: %s", scheme, err)
+	}
+	return data, parity, nil
+}
+
+func seq(n int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}
+
+// Shards returns the total number of shards.
+func (e *Encoder) Shards() int {
+	return e.Data + e.Parity
+}
+
+// Split cuts buf into Data equally sized shards, zero padding the last one,
+// and appends room for the Parity shards. Call Encode to fill those.
+func (e *Encoder) Split(buf []byte) [][]byte {
+	shardSize := (len(buf) + e.Data - 1) / e.Data
+	if shardSize == 0 {
+		shardSize = 1
+	}
+	shards := make([][]byte, e.Shards())
+	for i := range shards {
+		shards[i] = make([]byte, shardSize)
+		if i < e.Data && i*shardSize < len(buf) {
+			copy(shards[i], buf[i*shardSize:])
+		}
+	}
+	return shards
+}
+
+// Encode computes the parity shards from the data shards.
+func (e *Encoder) Encode(shards [][]byte) error {
+	if len(shards) != e.Shards() {
+		return fmt.Errorf("got %d shards, want %d", len(shards), e.Shards())
+	}
+	for i := e.Data; i < e.Shards(); i++ {
+		e.encodeRow(i, shards[:e.Data], shards[i])
+	}
+	return nil
+}
+
+// encodeRow computes out as row of the encoding matrix applied to in.
+func (e *Encoder) encodeRow(row int, in [][]byte, out []byte) {
+	for b := range out {
+		out[b] = 0
+	}
+	for c, shard := range in {
+		f := e.matrix[row][c]
+		if f == 0 {
+			continue
+		}
+		for b := range out {
+			out[b] ^= gfMul(f, shard[b])
+		}
+	}
+}
+
+// Reconstruct rebuilds the missing shards, given as nil entries, in place.
+// At least Data shards must be present.
+func (e *Encoder) Reconstruct(shards [][]byte) error {
+	if len(shards) != e.Shards() {
+		return fmt.Errorf("got %d shards, want %d", len(shards), e.Shards())
+	}
+	present := []int{}
+	shardSize := 0
+	for i, shard := range shards {
+		if shard != nil {
+			present = append(present, i)
+			shardSize = len(shard)
+		}
+	}
+	if len(present) < e.Data {
+		return fmt.Errorf("only %d shards left, need %d", len(present), e.Data)
+	}
+	if len(present) == e.Shards() {
+		return nil
+	}
+	present = present[:e.Data]
+
+	// the rows of the present shards map the data shards onto them,
+	// inverting that sub matrix maps them back
+	dec, err := e.matrix.subRows(present).invert()
+	if err != nil {
+		return err
+	}
+	in := make([][]byte, e.Data)
+	for i, idx := range present {
+		in[i] = shards[idx]
+	}
+	decoder := &Encoder{Data: e.Data, matrix: dec}
+	for i := 0; i < e.Data; i++ {
+		if shards[i] == nil {
+			shards[i] = make([]byte, shardSize)
+			decoder.encodeRow(i, in, shards[i])
+		}
+	}
+	for i := e.Data; i < e.Shards(); i++ {
+		if shards[i] == nil {
+			shards[i] = make([]byte, shardSize)
+			e.encodeRow(i, shards[:e.Data], shards[i])
+		}
+	}
+	return nil
+}
+
+// Join glues the data shards back together and trims the padding off.
+func (e *Encoder) Join(shards [][]byte, size int64) ([]byte, error) {
+	buf := make([]byte, 0, size)
+	for i := 0; i < e.Data && int64(len(buf)) < size; i++ {
+		if shards[i] == nil {
+			return nil, fmt.Errorf("data shard %d is missing", i)
+		}
+		buf = append(buf, shards[i]...)
+	}
+	if int64(len(buf)) < size {
+		return nil, fmt.Errorf("shards hold %d bytes, want %d", len(buf), size)
+	}
+	return buf[:size], nil
+}
@@ -0,0 +1,136 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package erasure
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// subsets calls fn with every subset of 0..n-1 of size k.
+func subsets(n, k int, fn func([]int)) {
+	var walk func(start int, cur []int)
+	walk = func(start int, cur []int) {
+		if len(cur) == k {
+			fn(cur)
+			return
+		}
+		for i := start; i < n; i++ {
+			walk(i+1, append(cur, i))
+		}
+	}
+	walk(0, nil)
+}
+
+func TestReconstruct(t *testing.T) {
+	tests := []struct {
+		data, parity int
+		size         int
+	}{
+		{1, 1, 10},
+		{2, 1, 0},
+		{3, 2, 1},
+		{4, 2, 1000},
+		{6, 3, 4097},
+		{10, 4, 333},
+	}
+
+	for _, tt := range tests {
+		enc, err := New(tt.data, tt.parity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, tt.size)
+		rand.Read(buf)
+		shards := enc.Split(buf)
+		if err := enc.Encode(shards); err != nil {
+			t.Fatal(err)
+		}
+
+		for missing := 1; missing <= tt.parity+1; missing++ {
+			subsets(enc.Shards(), missing, func(lost []int) {
+				cpy := make([][]byte, len(shards))
+				copy(cpy, shards)
+				for _, i := range lost {
+					cpy[i] = nil
+				}
+				err := enc.Reconstruct(cpy)
+				if missing > tt.parity {
+					if err == nil {
+						t.Errorf("%d+%d: shards %v lost, expected an error", tt.data, tt.parity, lost)
+					}
+					return
+				}
+				if err != nil {
+					t.Errorf("%d+%d: shards %v lost: %s", tt.data, tt.parity, lost, err)
+					return
+				}
+				for i := range shards {
+					if !bytes.Equal(cpy[i], shards[i]) {
+						t.Errorf("%d+%d: shards %v lost, shard %d rebuilt wrong", tt.data, tt.parity, lost, i)
+					}
+				}
+				got, err := enc.Join(cpy, int64(tt.size))
+				if err != nil || !bytes.Equal(got, buf) {
+					t.Errorf("%d+%d: shards %v lost, joined data differs (%v)", tt.data, tt.parity, lost, err)
+				}
+			})
+		}
+	}
+}
+
+func TestParseScheme(t *testing.T) {
+	tests := []struct {
+		scheme       string
+		data, parity int
+		ok           bool
+	}{
+		{"6+3", 6, 3, true},
+		{"10+4", 10, 4, true},
+		{"4+0", 4, 0, true},
+		{"6", 0, 0, false},
+		{"6+", 0, 0, false},
+		{"a+3", 0, 0, false},
+		{"6+3+1", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		data, parity, err := ParseScheme(tt.scheme)
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: unexpected error %v", tt.scheme, err)
+			continue
+		}
+		if data != tt.data || parity != tt.parity {
+			t.Errorf("%q: got %d+%d, expected %d+%d", tt.scheme, data, parity, tt.data, tt.parity)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, tt := range []struct {
+		data, parity int
+		ok           bool
+	}{
+		{1, 0, true},
+		{6, 3, true},
+		{200, 56, true},
+		{0, 3, false},
+		{6, -1, false},
+		{200, 57, false},
+	} {
+		if _, err := New(tt.data, tt.parity); (err == nil) != tt.ok {
+			t.Errorf("%d+%d: unexpected error %v", tt.data, tt.parity, err)
+		}
+	}
+}
@@ -0,0 +1,543 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package membership
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+
+	"github.com/op/go-logging"
+)
+
+var (
+	log = logging.MustGetLogger("challenge-membership")
+)
+
+const (
+	DefaultProbeInterval  = time.Second
+	DefaultProbeTimeout   = 500 * time.Millisecond
+	DefaultSuspectTimeout = 5 * time.Second
+	DefaultIndirectChecks = 3
+	DefaultSyncInterval   = 15 * time.Second
+
+	// Types of Event.
+	EventJoin    = "join"
+	EventSuspect = "suspect"
+	EventAlive   = "alive"
+	EventDead    = "dead"
+)
+
+// Config holds the settings of a Memberlist.
+type Config struct {
+	Name     string   // our name, the address of our API
+	BindAddr string   // UDP address to gossip on
+	Seeds    []string // gossip addresses of members to join through
+
+	ProbeInterval  time.Duration // how often a member is probed
+	ProbeTimeout   time.Duration // how long to wait for a direct ack
+	SuspectTimeout time.Duration // how long a member stays suspect before it is declared dead
+	IndirectChecks int           // members asked to probe on our behalf
+	SyncInterval   time.Duration // how often the full member list is exchanged
+}
+
+// Event tells about a change in the state of a member.
+type Event struct {
+	Type   string
+	Member types.Member
+}
+
+// Memberlist keeps track of the members of a cluster using a SWIM-style
+// protocol over UDP. Every ProbeInterval a member is pinged; if it does not
+// ack in time, IndirectChecks other members are asked to ping it, and if
+// nobody gets an ack it becomes suspect. A suspect that doesn't refute the
+// suspicion, by gossiping a higher incarnation of itself, within
+// SuspectTimeout is declared dead. State changes are piggybacked on the
+// protocol messages, and the full member list is exchanged with a random
+// member every SyncInterval.
+type Memberlist struct {
+	conf Config
+	conn *net.UDPConn
+
+	mu         sync.Mutex
+	self       *types.Member
+	members    map[string]*types.Member // by name, without us
+	probeOrder []string
+	probeIndex int
+	seq        uint64
+	acks       map[uint64]func()
+	broadcasts []*broadcast
+	subs       []chan Event
+}
+
+// New starts gossiping on conf.BindAddr and joins the members at conf.Seeds.
+func New(conf Config) (*Memberlist, error) {
+	if conf.ProbeInterval <= 0 {
+		conf.ProbeInterval = DefaultProbeInterval
+	}
+	if conf.ProbeTimeout <= 0 || conf.ProbeTimeout >= conf.ProbeInterval {
+		conf.ProbeTimeout = conf.ProbeInterval / 2
+	}
+	if conf.SuspectTimeout <= 0 {
+		conf.SuspectTimeout = DefaultSuspectTimeout
+	}
+	if conf.IndirectChecks <= 0 {
+		conf.IndirectChecks = DefaultIndirectChecks
+	}
+	if conf.SyncInterval <= 0 {
+		conf.SyncInterval = DefaultSyncInterval
+	}
+
+	laddr, err := net.ResolveUDPAddr("udp", conf.BindAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gossip address %q: %s", conf.BindAddr, err)
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen for gossip on %s: %s", conf.BindAddr, err)
+	}
+
+	m := &Memberlist{
+		conf: conf,
+		conn: conn,
+		self: &types.Member{
+			Name:  conf.Name,
+			Addr:  advertiseAddr(conn.LocalAddr().(*net.UDPAddr)),
+			State: types.MemberAlive,
+			Since: time.Now(),
+		},
+		members: make(map[string]*types.Member),
+		acks:    make(map[uint64]func()),
+	}
+	go m.receiver()
+	go m.prober()
+	go m.syncer()
+	m.join()
+	return m, nil
+}
+
+// advertiseAddr returns the address other members reach us at, using
+// localhost for the wildcard address.
+func advertiseAddr(addr *net.UDPAddr) string {
+	if addr.IP == nil || addr.IP.IsUnspecified() {
+		return net.JoinHostPort("localhost", fmt.Sprint(addr.Port))
+	}
+	return addr.String()
+}
+
+// Members returns every member we know of, us included, sorted by name.
+func (m *Memberlist) Members() []types.Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := []types.Member{*m.self}
+	for _, mb := range m.members {
+		members = append(members, *mb)
+	}
+	sort.Sort(byName(members))
+	return members
+}
+
+type byName []types.Member
+
+func (p byName) Len() int           { return len(p) }
+func (p byName) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p byName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// IsAlive returns true unless the member named name is known to be suspect
+// or dead.
+func (m *Memberlist) IsAlive(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mb, ok := m.members[name]; ok {
+		return mb.State == types.MemberAlive
+	}
+	return true
+}
+
+// Subscribe returns a channel receiving every membership change. Events are
+// dropped if the channel is not drained.
+func (m *Memberlist) Subscribe() <-chan Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch := make(chan Event, 64)
+	m.subs = append(m.subs, ch)
+	return ch
+}
+
+// emit sends an event to the subscribers. Must be called with mu held.
+func (m *Memberlist) emit(typ string, mb *types.Member) {
+	log.Infof("Member %s (%s) is %s", mb.Name, mb.Addr, typ)
+	for _, ch := range m.subs {
+		select {
+		case ch <- Event{Type: typ, Member: *mb}:
+		default:
+			log.Warningf("Membership subscriber too slow, dropping %s event of %s", typ, mb.Name)
+		}
+	}
+}
+
+func (m *Memberlist) selfUpdate() update {
+	return update{
+		Name:        m.self.Name,
+		Addr:        m.self.Addr,
+		State:       types.MemberAlive,
+		Incarnation: m.self.Incarnation,
+	}
+}
+
+// queue schedules u to be piggybacked on the next messages. Must be called
+// with mu held.
+func (m *Memberlist) queue(u update) {
+	// enough transmissions for the update to reach everyone with high
+	// probability
+	transmits := 3 * int(math.Ceil(math.Log2(float64(len(m.members)+2))))
+	for _, b := range m.broadcasts {
+		if b.u.Name == u.Name {
+			b.u, b.transmits = u, transmits
+			return
+		}
+	}
+	m.broadcasts = append(m.broadcasts, &broadcast{u: u, transmits: transmits})
+}
+
+// piggyback returns the updates to attach to an outgoing message. Must be
+// called with mu held.
+func (m *Memberlist) piggyback() []update {
+	updates := []update{}
+	kept := m.broadcasts[:0]
+	for _, b := range m.broadcasts {
+		if len(updates) < maxPiggyback {
+			updates = append(updates, b.u)
+			b.transmits--
+		}
+		if b.transmits > 0 {
+			kept = append(kept, b)
+		}
+	}
+	m.broadcasts = kept
+	return updates
+}
+
+// apply merges u into our view of the members, following the SWIM rules: a
+// higher incarnation always wins, and at the same incarnation suspect beats
+// alive and dead beats both. Must be called with mu held.
+func (m *Memberlist) apply(u update) {
+	if u.Name == m.self.Name {
+		if u.State != types.MemberAlive && u.Incarnation >= m.self.Incarnation {
+			// refute the rumor about us
+			m.self.Incarnation = u.Incarnation + 1
+			log.Infof("Refuting %s rumor about us with incarnation %d", u.State, m.self.Incarnation)
+			m.queue(m.selfUpdate())
+		}
+		return
+	}
+
+	mb, ok := m.members[u.Name]
+	if !ok {
+		if u.State == types.MemberDead {
+			return
+		}
+		mb = &types.Member{
+			Name:        u.Name,
+			Addr:        u.Addr,
+			State:       u.State,
+			Incarnation: u.Incarnation,
+			Since:       time.Now(),
+		}
+		m.members[u.Name] = mb
+		m.probeOrder = append(m.probeOrder, u.Name)
+		m.queue(u)
+		m.emit(EventJoin, mb)
+		return
+	}
+
+	override := false
+	switch u.State {
+	case types.MemberAlive:
+		override = u.Incarnation > mb.Incarnation
+	case types.MemberSuspect:
+		override = u.Incarnation > mb.Incarnation ||
+			u.Incarnation == mb.Incarnation && mb.State == types.MemberAlive
+	case types.MemberDead:
+		override = u.Incarnation >= mb.Incarnation && mb.State != types.MemberDead
+	}
+	if !override {
+		return
+	}
+
+	prev := mb.State
+	mb.Addr = u.Addr
+	mb.Incarnation = u.Incarnation
+	mb.State = u.State
+	m.queue(u)
+	if prev == u.State {
+		return
+	}
+	mb.Since = time.Now()
+	switch {
+	case u.State == types.MemberAlive && prev == types.MemberDead:
+		m.emit(EventJoin, mb)
+	case u.State == types.MemberAlive:
+		m.emit(EventAlive, mb)
+	case u.State == types.MemberSuspect:
+		m.emit(EventSuspect, mb)
+	case u.State == types.MemberDead:
+		m.emit(EventDead, mb)
+	}
+}
+
+// send fills in the sender and piggybacked updates of msg and sends it to
+// addr.
+func (m *Memberlist) send(addr string, msg *message) error {
+	m.mu.Lock()
+	msg.From = m.selfUpdate()
+	if msg.Type != msgSync && msg.Type != msgSyncAck {
+		msg.Updates = append(msg.Updates, m.piggyback()...)
+	}
+	m.mu.Unlock()
+
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	_, err = m.conn.WriteToUDP(buf, raddr)
+	return err
+}
+
+// receiver handles incoming messages.
+func (m *Memberlist) receiver() {
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := m.conn.ReadFromUDP(buf)
+		if err != nil {
+			log.Errorf("Unable to read gossip: %s", err)
+			return
+		}
+		msg := &message{}
+		if err := json.Unmarshal(buf[:n], msg); err != nil {
+			log.Warningf("Dropping invalid gossip message: %s", err)
+			continue
+		}
+		m.handle(msg)
+	}
+}
+
+func (m *Memberlist) handle(msg *message) {
+	m.mu.Lock()
+	m.apply(msg.From)
+	for _, u := range msg.Updates {
+		m.apply(u)
+	}
+	// a member we think is down doesn't know it yet: tell it so it can
+	// refute
+	var about []update
+	if mb, ok := m.members[msg.From.Name]; ok && mb.State != types.MemberAlive {
+		about = append(about, update{Name: mb.Name, Addr: mb.Addr, State: mb.State, Incarnation: mb.Incarnation})
+	}
+	m.mu.Unlock()
+
+	switch msg.Type {
+	case msgPing:
+		m.send(msg.From.Addr, &message{Type: msgAck, Seq: msg.Seq, Updates: about})
+	case msgAck:
+		m.mu.Lock()
+		fn, ok := m.acks[msg.Seq]
+		m.mu.Unlock()
+		if ok {
+			fn()
+		}
+	case msgPingReq:
+		// probe the target and relay its ack to the requester
+		from, seq := msg.From.Addr, msg.Seq
+		m.ping(msg.Target, m.conf.ProbeInterval, func() {
+			m.send(from, &message{Type: msgAck, Seq: seq})
+		})
+	case msgSync:
+		m.send(msg.From.Addr, &message{Type: msgSyncAck, Updates: m.state()})
+	case msgSyncAck:
+	}
+}
+
+// state returns the whole member list as updates.
+func (m *Memberlist) state() []update {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	updates := []update{}
+	for _, mb := range m.members {
+		updates = append(updates, update{Name: mb.Name, Addr: mb.Addr, State: mb.State, Incarnation: mb.Incarnation})
+	}
+	return updates
+}
+
+// ping sends a ping to addr and calls acked if an ack comes back within
+// timeout.
+func (m *Memberlist) ping(addr string, timeout time.Duration, acked func()) {
+	m.mu.Lock()
+	m.seq++
+	seq := m.seq
+	var once sync.Once
+	m.acks[seq] = func() { once.Do(acked) }
+	m.mu.Unlock()
+
+	time.AfterFunc(timeout, func() {
+		m.mu.Lock()
+		delete(m.acks, seq)
+		m.mu.Unlock()
+	})
+	if err := m.send(addr, &message{Type: msgPing, Seq: seq}); err != nil {
+		log.Debugf("Unable to ping %s: %s", addr, err)
+	}
+}
+
+// nextTarget returns the next member to probe, going round robin over the
+// members in a random order.
+func (m *Memberlist) nextTarget() *types.Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := 0; i < len(m.probeOrder); i++ {
+		if m.probeIndex >= len(m.probeOrder) {
+			// a new round, reshuffle
+			for j := range m.probeOrder {
+				k := rand.Intn(j + 1)
+				m.probeOrder[j], m.probeOrder[k] = m.probeOrder[k], m.probeOrder[j]
+			}
+			m.probeIndex = 0
+		}
+		mb := m.members[m.probeOrder[m.probeIndex]]
+		m.probeIndex++
+		if mb.State != types.MemberDead {
+			cpy := *mb
+			return &cpy
+		}
+	}
+	return nil
+}
+
+// randomMembers returns up to n live members other than except.
+func (m *Memberlist) randomMembers(n int, except string) []types.Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	candidates := []types.Member{}
+	for _, mb := range m.members {
+		if mb.State == types.MemberAlive && mb.Name != except {
+			candidates = append(candidates, *mb)
+		}
+	}
+	for i := range candidates {
+		j := rand.Intn(i + 1)
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	}
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
+
+// prober probes one member every ProbeInterval and declares the members
+// that stayed suspect too long dead.
+func (m *Memberlist) prober() {
+	ticker := time.NewTicker(m.conf.ProbeInterval)
+	for range ticker.C {
+		m.reapSuspects()
+		target := m.nextTarget()
+		if target == nil {
+			continue
+		}
+		m.probe(target)
+	}
+}
+
+func (m *Memberlist) probe(target *types.Member) {
+	acked := make(chan struct{})
+	var once sync.Once
+	done := func() { once.Do(func() { close(acked) }) }
+
+	m.ping(target.Addr, m.conf.ProbeInterval, done)
+	select {
+	case <-acked:
+		return
+	case <-time.After(m.conf.ProbeTimeout):
+	}
+
+	// no direct ack, ask others to try
+	m.mu.Lock()
+	m.seq++
+	seq := m.seq
+	m.acks[seq] = done
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.acks, seq)
+		m.mu.Unlock()
+	}()
+	for _, mb := range m.randomMembers(m.conf.IndirectChecks, target.Name) {
+		m.send(mb.Addr, &message{Type: msgPingReq, Seq: seq, Target: target.Addr})
+	}
+	select {
+	case <-acked:
+		return
+	case <-time.After(m.conf.ProbeInterval - m.conf.ProbeTimeout):
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mb, ok := m.members[target.Name]; ok && mb.State == types.MemberAlive && mb.Incarnation == target.Incarnation {
+		m.apply(update{Name: mb.Name, Addr: mb.Addr, State: types.MemberSuspect, Incarnation: mb.Incarnation})
+	}
+}
+
+// reapSuspects declares dead the members suspect for longer than
+// SuspectTimeout.
+func (m *Memberlist) reapSuspects() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, mb := range m.members {
+		if mb.State == types.MemberSuspect && time.Since(mb.Since) > m.conf.SuspectTimeout {
+			m.apply(update{Name: mb.Name, Addr: mb.Addr, State: types.MemberDead, Incarnation: mb.Incarnation})
+		}
+	}
+}
+
+// join asks the seeds for their member lists.
+func (m *Memberlist) join() {
+	for _, seed := range m.conf.Seeds {
+		if err := m.send(seed, &message{Type: msgSync, Updates: m.state()}); err != nil {
+			log.Warningf("Unable to join through %s: %s", seed, err)
+		}
+	}
+}
+
+// syncer exchanges the whole member list with a random member every
+// SyncInterval, or with the seeds while we know no live member.
+func (m *Memberlist) syncer() {
+	ticker := time.NewTicker(m.conf.SyncInterval)
+	for range ticker.C {
+		peers := m.randomMembers(1, "")
+		if len(peers) == 0 {
+			m.join()
+			continue
+		}
+		m.send(peers[0].Addr, &message{Type: msgSync, Updates: m.state()})
+	}
+}
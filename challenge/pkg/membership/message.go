@@ -0,0 +1,53 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package membership
+
+import (
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+const (
+	msgPing    = "ping"
+	msgAck     = "ack"
+	msgPingReq = "ping-req"
+	msgSync    = "sync"
+	msgSyncAck = "sync-ack"
+
+	maxPiggyback = 8 // updates carried by a message besides a sync
+)
+
+// update is a member's state as spread by gossip.
+type update struct {
+	Name        string            `json:"name"`
+	Addr        string            `json:"addr"`
+	State       types.MemberState `json:"state"`
+	Incarnation uint64            `json:"incarnation"`
+}
+
+// message is what members send each other over UDP, one per datagram.
+type message struct {
+	Type string `json:"type"`
+	Seq  uint64 `json:"seq"`
+	From update `json:"from"` // the sender, alive
+
+	// ping-req: the member to probe on the sender's behalf
+	Target string `json:"target,omitempty"`
+
+	Updates []update `json:"updates,omitempty"`
+}
+
+// broadcast is an update waiting to be piggybacked on outgoing messages.
+type broadcast struct {
+	u         update
+	transmits int // left before it is dropped
+}
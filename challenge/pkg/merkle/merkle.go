@@ -0,0 +1,124 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+const (
+	// Fanout is the number of children of an inner node, one per hex
+	// digit.
+	Fanout = 16
+	// Depth is the length of the path of a leaf: Fanout^Depth leaves.
+	Depth = 3
+)
+
+const hexDigits = "0123456789abcdef"
+
+// Tree is a Merkle tree over (location, content hash) pairs. Each location
+// falls in the leaf named by the first Depth hex digits of its SHA-256, so
+// two servers holding the same blobs have the same tree, and comparing trees
+// from the root down finds the leaves that differ without listing every
+// blob.
+type Tree struct {
+	hashes  map[string]string // by path, empty subtrees left out
+	entries map[string][]types.MerkleEntry
+}
+
+// LeafOf returns the path of the leaf holding location.
+func LeafOf(location string) string {
+	sum := sha256.Sum256([]byte(location))
+	return hex.EncodeToString(sum[:])[:Depth]
+}
+
+// New builds the tree of entries.
+func New(entries []types.MerkleEntry) *Tree {
+	t := &Tree{
+		hashes:  make(map[string]string),
+		entries: make(map[string][]types.MerkleEntry),
+	}
+	for _, e := range entries {
+		leaf := LeafOf(e.Location)
+		t.entries[leaf] = append(t.entries[leaf], e)
+	}
+
+	for leaf, es := range t.entries {
+		sort.Sort(byLocation(es))
+		h := sha256.New()
+		for _, e := range es {
+			// deletes hash as an empty content hash
+			fmt.Fprintf(h, "%s\x00%s\n", e.Location, e.Hash)
+		}
+		t.hashes[leaf] = hex.EncodeToString(h.Sum(nil))
+	}
+	for depth := Depth - 1; depth >= 0; depth-- {
+		parents := make(map[string]bool)
+		for path := range t.hashes {
+			if len(path) == depth+1 {
+				parents[path[:depth]] = true
+			}
+		}
+		for parent := range parents {
+			h := sha256.New()
+			for _, child := range t.children(parent) {
+				fmt.Fprintf(h, "%s\n", child)
+			}
+			t.hashes[parent] = hex.EncodeToString(h.Sum(nil))
+		}
+	}
+	return t
+}
+
+func (t *Tree) children(path string) []string {
+	children := make([]string, Fanout)
+	for i := 0; i < Fanout; i++ {
+		children[i] = t.hashes[path+hexDigits[i:i+1]]
+	}
+	return children
+}
+
+// Root returns the hash of the whole tree.
+func (t *Tree) Root() string {
+	return t.hashes[""]
+}
+
+// Node returns the node at path.
+func (t *Tree) Node(path string) (*types.MerkleNode, error) {
+	if len(path) > Depth {
+		return nil, fmt.Errorf("invalid merkle path %q: deeper than %d", path, Depth)
+	}
+	for _, c := range path {
+		if c < '0' || c > '9' && c < 'a' || c > 'f' {
+			return nil, fmt.Errorf("invalid merkle path %q: not lowercase hex", path)
+		}
+	}
+	node := &types.MerkleNode{Path: path, Hash: t.hashes[path]}
+	if len(path) < Depth {
+		node.Children = t.children(path)
+	} else {
+		node.Entries = append([]types.MerkleEntry{}, t.entries[path]...)
+	}
+	return node, nil
+}
+
+type byLocation []types.MerkleEntry
+
+func (p byLocation) Len() int           { return len(p) }
+func (p byLocation) Less(i, j int) bool { return p[i].Location < p[j].Location }
+func (p byLocation) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
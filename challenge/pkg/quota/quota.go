@@ -0,0 +1,348 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+
+	"github.com/op/go-logging"
+)
+
+var (
+	log = logging.MustGetLogger("challenge-quota")
+
+	// ErrExceeded is returned for writes a quota has no room left for.
+	ErrExceeded = errors.New("quota exceeded")
+	// ErrTooLarge is returned for writes larger than a quota on their own.
+	ErrTooLarge = errors.New("blob larger than the quota")
+	// ErrNotFound is returned for a prefix without a quota.
+	ErrNotFound = errors.New("quota not found")
+)
+
+// entry is a blob counted in the usage.
+type entry struct {
+	location string
+	creator  string
+	size     int64
+}
+
+// Tracker keeps what each bucket, each prefix with a quota and each creator
+// holds, updated as blobs are written and deleted, and the quotas of
+// prefixes, saved in a file.
+type Tracker struct {
+	path string
+
+	mu       sync.RWMutex
+	blobs    map[uint16]entry
+	buckets  map[string]*types.Usage
+	creators map[string]*types.Usage
+	prefixes map[string]*types.PrefixQuota // with their usage
+}
+
+// New returns a tracker saving the quotas of prefixes in the file at path,
+// loading those saved there before.
+func New(path string) (*Tracker, error) {
+	t := &Tracker{
+		path:     path,
+		blobs:    make(map[uint16]entry),
+		buckets:  make(map[string]*types.Usage),
+		creators: make(map[string]*types.Usage),
+		prefixes: make(map[string]*types.PrefixQuota),
+	}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved []*types.PrefixQuota
+	if err := json.Unmarshal(buf, &saved); err != nil {
+		return nil, err
+	}
+	for _, q := range saved {
+		q.Usage = &types.Usage{}
+		t.prefixes[q.Prefix] = q
+	}
+	log.Infof("Loaded %d quotas from %s", len(t.prefixes), path)
+	return t, nil
+}
+
+// save writes the quotas of prefixes to the file. Must be called with mu
+// held.
+func (t *Tracker) save() error {
+	list := t.sorted()
+	for i := range list {
+		list[i].Usage = nil
+	}
+	buf, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	tmpPath := t.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, t.path)
+}
+
+// sorted returns copies of the quotas of prefixes, by prefix. Must be
+// called with mu held.
+func (t *Tracker) sorted() []types.PrefixQuota {
+	prefixes := make([]string, 0, len(t.prefixes))
+	for prefix := range t.prefixes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	list := make([]types.PrefixQuota, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		list = append(list, copyQuota(t.prefixes[prefix]))
+	}
+	return list
+}
+
+func copyQuota(q *types.PrefixQuota) types.PrefixQuota {
+	cpy := *q
+	usage := *q.Usage
+	cpy.Usage = &usage
+	return cpy
+}
+
+func addTo(m map[string]*types.Usage, key string, bytes, objects int64) {
+	u, ok := m[key]
+	if !ok {
+		u = &types.Usage{}
+		m[key] = u
+	}
+	u.Bytes += bytes
+	u.Objects += objects
+	if u.Objects == 0 {
+		delete(m, key)
+	}
+}
+
+// count adds e, or takes it away if sign is -1, from every usage it is part
+// of. Must be called with mu held.
+func (t *Tracker) count(e entry, sign int64) {
+	if bucket, _ := common.SplitLocation(e.location); bucket != "" {
+		addTo(t.buckets, bucket, sign*e.size, sign)
+	}
+	if e.creator != "" {
+		addTo(t.creators, e.creator, sign*e.size, sign)
+	}
+	for prefix, q := range t.prefixes {
+		if types.Covers(prefix, e.location) {
+			q.Usage.Bytes += sign * e.size
+			q.Usage.Objects += sign
+		}
+	}
+}
+
+// Add counts the blob id, at location and written by creator, holding size
+// bytes. A blob counted already is counted again with the new values.
+func (t *Tracker) Add(id uint16, location, creator string, size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if old, ok := t.blobs[id]; ok {
+		t.count(old, -1)
+	}
+	e := entry{location: location, creator: creator, size: size}
+	t.blobs[id] = e
+	t.count(e, 1)
+}
+
+// Remove stops counting the blob id, if it was.
+func (t *Tracker) Remove(id uint16) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if old, ok := t.blobs[id]; ok {
+		t.count(old, -1)
+		delete(t.blobs, id)
+	}
+}
+
+// Bucket returns what the bucket called name holds.
+func (t *Tracker) Bucket(name string) types.Usage {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if u, ok := t.buckets[name]; ok {
+		return *u
+	}
+	return types.Usage{}
+}
+
+// Creator returns what the blobs written by name hold.
+func (t *Tracker) Creator(name string) types.Usage {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if u, ok := t.creators[name]; ok {
+		return *u
+	}
+	return types.Usage{}
+}
+
+// Creators returns what the blobs of each creator hold, by name.
+func (t *Tracker) Creators() map[string]types.Usage {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	creators := make(map[string]types.Usage, len(t.creators))
+	for name, u := range t.creators {
+		creators[name] = *u
+	}
+	return creators
+}
+
+// Covering returns the quotas of the prefixes covering location, with
+// their usage.
+func (t *Tracker) Covering(location string) []types.PrefixQuota {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	list := []types.PrefixQuota{}
+	for prefix, q := range t.prefixes {
+		if types.Covers(prefix, location) {
+			list = append(list, copyQuota(q))
+		}
+	}
+	return list
+}
+
+// Quotas returns the quotas of prefixes, by prefix, with their usage.
+func (t *Tracker) Quotas() []types.PrefixQuota {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.sorted()
+}
+
+// Set stores the quota q, unless the quota of its prefix was updated later.
+// It tells whether q was stored.
+func (t *Tracker) Set(q *types.PrefixQuota) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cur, ok := t.prefixes[q.Prefix]
+	if ok && !q.Updated.After(cur.Updated) {
+		return false, nil
+	}
+	cpy := *q
+	if ok {
+		cpy.Usage = cur.Usage
+	} else {
+		// a new prefix, its usage is what the blobs counted so far hold
+		cpy.Usage = &types.Usage{}
+		for _, e := range t.blobs {
+			if types.Covers(q.Prefix, e.location) {
+				cpy.Usage.Bytes += e.size
+				cpy.Usage.Objects++
+			}
+		}
+	}
+	t.prefixes[q.Prefix] = &cpy
+	return true, t.save()
+}
+
+// RemoveQuota deletes the quota of prefix.
+func (t *Tracker) RemoveQuota(prefix string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.prefixes[prefix]; !ok {
+		return ErrNotFound
+	}
+	delete(t.prefixes, prefix)
+	return t.save()
+}
+
+// Limit is a quota a write falls under, with what its scope holds.
+type Limit struct {
+	Bytes   int64 // 0 for no limit
+	Objects int64 // 0 for no limit
+	Usage   types.Usage
+	// Replaces is set if the blob the write replaces is in the scope and
+	// goes away with it
+	Replaces bool
+}
+
+// Check tells whether a write of size bytes, -1 if not known yet,
+// replacing a blob of replacedSize bytes for the limits that say so, fits
+// in limits. It returns how many bytes the write may hold, -1 for no limit,
+// ErrTooLarge if size is above a limit on its own and ErrExceeded if there
+// is no room left.
+func Check(limits []Limit, size, replacedSize int64) (int64, error) {
+	room := int64(-1)
+	for _, l := range limits {
+		objects, bytes := l.Usage.Objects+1, l.Usage.Bytes
+		if l.Replaces {
+			objects, bytes = objects-1, bytes-replacedSize
+		}
+		if l.Objects > 0 && objects > l.Objects {
+			return 0, ErrExceeded
+		}
+		if l.Bytes == 0 {
+			continue
+		}
+		if size > l.Bytes {
+			return 0, ErrTooLarge
+		}
+		left := l.Bytes - bytes
+		if left < 0 || size > left {
+			return 0, ErrExceeded
+		}
+		if room < 0 || left < room {
+			room = left
+		}
+	}
+	return room, nil
+}
+
+// Reader fails with ErrExceeded once more than the bytes a write may hold
+// were read from it.
+type Reader struct {
+	io.ReadCloser
+	room     int64
+	exceeded bool
+}
+
+// NewReader returns a Reader over rc letting room bytes through, any
+// number if room is -1.
+func NewReader(rc io.ReadCloser, room int64) *Reader {
+	return &Reader{ReadCloser: rc, room: room}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if r.room < 0 {
+		return n, err
+	}
+	if int64(n) > r.room {
+		r.exceeded = true
+		return 0, ErrExceeded
+	}
+	r.room -= int64(n)
+	return n, err
+}
+
+// Check returns ErrExceeded in place of err, the error of a write reading
+// from r, if the write went over its quota.
+func (r *Reader) Check(err error) error {
+	if err != nil && r.exceeded {
+		return ErrExceeded
+	}
+	return err
+}
@@ -0,0 +1,298 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package quota
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   []Limit
+		size     int64
+		replaced int64
+		room     int64
+		err      error
+	}{
+		{
+			name: "no limits",
+			size: 100,
+			room: -1,
+		},
+		{
+			name:   "fits",
+			limits: []Limit{{Bytes: 100, Objects: 3, Usage: types.Usage{Bytes: 40, Objects: 2}}},
+			size:   10,
+			room:   60,
+		},
+		{
+			name:   "unknown size",
+			limits: []Limit{{Bytes: 100, Usage: types.Usage{Bytes: 40, Objects: 2}}},
+			size:   -1,
+			room:   60,
+		},
+		{
+			name:   "objects only",
+			limits: []Limit{{Objects: 3, Usage: types.Usage{Bytes: 40, Objects: 2}}},
+			size:   1000,
+			room:   -1,
+		},
+		{
+			name:   "no objects left",
+			limits: []Limit{{Bytes: 100, Objects: 2, Usage: types.Usage{Bytes: 40, Objects: 2}}},
+			size:   10,
+			err:    ErrExceeded,
+		},
+		{
+			name:   "no bytes left",
+			limits: []Limit{{Bytes: 100, Usage: types.Usage{Bytes: 95, Objects: 2}}},
+			size:   10,
+			err:    ErrExceeded,
+		},
+		{
+			name:   "over the limit",
+			limits: []Limit{{Bytes: 100, Usage: types.Usage{Bytes: 150, Objects: 2}}},
+			size:   -1,
+			err:    ErrExceeded,
+		},
+		{
+			name:   "too large",
+			limits: []Limit{{Bytes: 100}},
+			size:   101,
+			err:    ErrTooLarge,
+		},
+		{
+			name:     "replaces a blob",
+			limits:   []Limit{{Bytes: 100, Objects: 2, Usage: types.Usage{Bytes: 95, Objects: 2}, Replaces: true}},
+			size:     10,
+			replaced: 30,
+			room:     35,
+		},
+		{
+			name:     "replaces a blob out of scope",
+			limits:   []Limit{{Bytes: 100, Objects: 3, Usage: types.Usage{Bytes: 95, Objects: 2}}},
+			size:     10,
+			replaced: 30,
+			err:      ErrExceeded,
+		},
+		{
+			name: "smallest room",
+			limits: []Limit{
+				{Bytes: 100, Usage: types.Usage{Bytes: 40, Objects: 2}},
+				{Bytes: 1000, Usage: types.Usage{Bytes: 980, Objects: 9}},
+				{Objects: 10, Usage: types.Usage{Bytes: 5, Objects: 1}},
+			},
+			size: 10,
+			room: 20,
+		},
+	}
+
+	for _, tt := range tests {
+		room, err := Check(tt.limits, tt.size, tt.replaced)
+		if err != tt.err {
+			t.Errorf("%s: got error %v, expected %v", tt.name, err, tt.err)
+			continue
+		}
+		if err == nil && room != tt.room {
+			t.Errorf("%s: got room %d, expected %d", tt.name, room, tt.room)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		room     int64
+		exceeded bool
+	}{
+		{name: "no limit", data: "0123456789", room: -1},
+		{name: "room left", data: "0123456789", room: 20},
+		{name: "exactly the room", data: "0123456789", room: 10},
+		{name: "over the room", data: "0123456789", room: 9, exceeded: true},
+	}
+
+	for _, tt := range tests {
+		r := NewReader(ioutil.NopCloser(bytes.NewBufferString(tt.data)), tt.room)
+		var buf bytes.Buffer
+		_, err := io.Copy(&buf, r)
+		err = r.Check(err)
+		if tt.exceeded {
+			if err != ErrExceeded {
+				t.Errorf("%s: got error %v, expected %v", tt.name, err, ErrExceeded)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if buf.String() != tt.data {
+			t.Errorf("%s: read %q, expected %q", tt.name, buf.String(), tt.data)
+		}
+	}
+}
+
+func newTracker(t *testing.T) (*Tracker, string) {
+	dir, err := ioutil.TempDir("", "quota")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := New(filepath.Join(dir, "quotas.json"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return tr, dir
+}
+
+func checkUsage(t *testing.T, what string, got types.Usage, bytes, objects int64) {
+	if got.Bytes != bytes || got.Objects != objects {
+		t.Errorf("%s: got %d bytes in %d objects, expected %d bytes in %d objects",
+			what, got.Bytes, got.Objects, bytes, objects)
+	}
+}
+
+func covering(t *testing.T, tr *Tracker, location string) types.Usage {
+	list := tr.Covering(location)
+	if len(list) != 1 {
+		t.Fatalf("got %d quotas covering %s, expected 1", len(list), location)
+	}
+	return *list[0].Usage
+}
+
+// What a blob holds counts against its bucket, its creator and the prefixes
+// covering it, until it is deleted.
+func TestTrackerUsage(t *testing.T) {
+	tr, dir := newTracker(t)
+	defer os.RemoveAll(dir)
+
+	if _, err := tr.Set(&types.PrefixQuota{Prefix: "b1/logs", QuotaBytes: 100, Updated: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	tr.Add(1, "b1/logs/a", "k1", 10)
+	tr.Add(2, "b1/logs/b", "k2", 20)
+	tr.Add(3, "b1/data", "k1", 40)
+	tr.Add(4, "b2/logs/a", "k1", 80)
+
+	checkUsage(t, "bucket b1", tr.Bucket("b1"), 70, 3)
+	checkUsage(t, "bucket b2", tr.Bucket("b2"), 80, 1)
+	checkUsage(t, "creator k1", tr.Creator("k1"), 130, 3)
+	checkUsage(t, "creator k2", tr.Creator("k2"), 20, 1)
+	checkUsage(t, "prefix b1/logs", covering(t, tr, "b1/logs/c"), 30, 2)
+	if list := tr.Covering("b1/data"); len(list) != 0 {
+		t.Errorf("a quota on b1/logs covers b1/data")
+	}
+
+	// written again, bigger and by another creator
+	tr.Add(1, "b1/logs/a", "k2", 50)
+	checkUsage(t, "bucket b1 after rewrite", tr.Bucket("b1"), 110, 3)
+	checkUsage(t, "creator k1 after rewrite", tr.Creator("k1"), 120, 2)
+	checkUsage(t, "creator k2 after rewrite", tr.Creator("k2"), 70, 2)
+	checkUsage(t, "prefix b1/logs after rewrite", covering(t, tr, "b1/logs/c"), 70, 2)
+
+	for _, id := range []uint16{1, 2, 3, 4} {
+		tr.Remove(id)
+	}
+	tr.Remove(1) // not counted any more
+	checkUsage(t, "bucket b1 after delete", tr.Bucket("b1"), 0, 0)
+	checkUsage(t, "bucket b2 after delete", tr.Bucket("b2"), 0, 0)
+	checkUsage(t, "creator k1 after delete", tr.Creator("k1"), 0, 0)
+	checkUsage(t, "creator k2 after delete", tr.Creator("k2"), 0, 0)
+	checkUsage(t, "prefix b1/logs after delete", covering(t, tr, "b1/logs/c"), 0, 0)
+	if n := len(tr.Creators()); n != 0 {
+		t.Errorf("got %d creators after delete, expected none", n)
+	}
+}
+
+// A write refused for a full quota fits again once a blob is deleted.
+func TestTrackerReleased(t *testing.T) {
+	tr, dir := newTracker(t)
+	defer os.RemoveAll(dir)
+
+	if _, err := tr.Set(&types.PrefixQuota{Prefix: "b1/", QuotaBytes: 100, QuotaObjects: 2, Updated: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	check := func(size int64) error {
+		q := tr.Covering("b1/new")[0]
+		_, err := Check([]Limit{{Bytes: q.QuotaBytes, Objects: q.QuotaObjects, Usage: *q.Usage}}, size, 0)
+		return err
+	}
+
+	tr.Add(1, "b1/a", "", 60)
+	if err := check(50); err != ErrExceeded {
+		t.Errorf("bytes: got %v, expected %v", err, ErrExceeded)
+	}
+	tr.Add(2, "b1/b", "", 10)
+	if err := check(1); err != ErrExceeded {
+		t.Errorf("objects: got %v, expected %v", err, ErrExceeded)
+	}
+	tr.Remove(1)
+	if err := check(50); err != nil {
+		t.Errorf("after delete: %s", err)
+	}
+}
+
+// A quota set on a prefix holding blobs already starts with their usage,
+// and is loaded again from the file without it.
+func TestTrackerSet(t *testing.T) {
+	tr, dir := newTracker(t)
+	defer os.RemoveAll(dir)
+
+	tr.Add(1, "b1/logs/a", "", 10)
+	tr.Add(2, "b1/data", "", 20)
+	now := time.Now()
+	q := &types.PrefixQuota{Prefix: "b1/logs", QuotaBytes: 100, Updated: now}
+	if ok, err := tr.Set(q); err != nil || !ok {
+		t.Fatalf("set: %v, %v", ok, err)
+	}
+	checkUsage(t, "new prefix", covering(t, tr, "b1/logs/a"), 10, 1)
+
+	old := &types.PrefixQuota{Prefix: "b1/logs", QuotaBytes: 5, Updated: now.Add(-time.Second)}
+	if ok, err := tr.Set(old); err != nil || ok {
+		t.Errorf("set an older quota: %v, %v", ok, err)
+	}
+	newer := &types.PrefixQuota{Prefix: "b1/logs", QuotaBytes: 200, Updated: now.Add(time.Second)}
+	if ok, err := tr.Set(newer); err != nil || !ok {
+		t.Errorf("set a newer quota: %v, %v", ok, err)
+	}
+	if got := covering(t, tr, "b1/logs/a"); got.Objects != 1 {
+		t.Errorf("a newer quota lost the usage of its prefix")
+	}
+
+	loaded, err := New(tr.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := loaded.Quotas()
+	if len(list) != 1 || list[0].QuotaBytes != 200 {
+		t.Fatalf("loaded %+v", list)
+	}
+	checkUsage(t, "loaded", *list[0].Usage, 0, 0)
+
+	if err := tr.RemoveQuota("b1/logs"); err != nil {
+		t.Error(err)
+	}
+	if err := tr.RemoveQuota("b1/logs"); err != ErrNotFound {
+		t.Errorf("removed a missing quota: got %v, expected %v", err, ErrNotFound)
+	}
+}
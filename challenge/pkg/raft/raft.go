@@ -0,0 +1,853 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+
+	"github.com/op/go-logging"
+)
+
+var (
+	log = logging.MustGetLogger("challenge-raft")
+)
+
+const (
+	Follower  = "follower"
+	Candidate = "candidate"
+	Leader    = "leader"
+
+	DefaultHeartbeatInterval = 100 * time.Millisecond
+	DefaultElectionTimeout   = time.Second // randomized between 1x and 2x
+	DefaultSnapshotThreshold = 1024
+
+	maxAppendEntries = 64 // entries sent per AppendEntries
+)
+
+var (
+	// ErrTimeout is returned when an entry or a read isn't applied in time.
+	// The entry may still be applied later.
+	ErrTimeout = errors.New("timed out waiting for the raft log")
+	// ErrLeadershipLost is returned for entries pending when the leader
+	// stepped down. They may still be applied by the new leader.
+	ErrLeadershipLost = errors.New("leadership lost while applying the entry")
+)
+
+// NotLeaderError is returned for requests only the leader can serve.
+type NotLeaderError struct {
+	Leader string // empty while no leader is known
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return "no raft leader elected"
+	}
+	return fmt.Sprintf("not the raft leader, the leader is %s", e.Leader)
+}
+
+// FSM is the state machine the log is applied to. It must be persistent:
+// after a restart only the entries following the last snapshot are applied
+// again, so applying an entry twice must be harmless.
+type FSM interface {
+	Apply(command []byte) error
+	// Snapshot returns the state as of the last applied entry.
+	Snapshot() ([]byte, error)
+	// Restore replaces the state with a snapshot sent by the leader.
+	Restore(data []byte, leader string) error
+}
+
+// Transport sends RPCs to the other nodes.
+type Transport interface {
+	RequestVote(node string, req *types.VoteRequest) (*types.VoteResponse, error)
+	AppendEntries(node string, req *types.AppendRequest) (*types.AppendResponse, error)
+	InstallSnapshot(node string, req *types.SnapshotRequest) (*types.SnapshotResponse, error)
+}
+
+// Config holds the settings of a Raft node.
+type Config struct {
+	ID    string   // our address, one of Nodes
+	Nodes []string // every node of the group, fixed
+	Dir   string   // where the log, snapshot and state are kept
+
+	HeartbeatInterval time.Duration
+	ElectionTimeout   time.Duration
+	SnapshotThreshold uint64 // applied entries that trigger a snapshot
+}
+
+// Raft is a node of a Raft group with a fixed set of nodes. Commands given
+// to the leader's Apply are appended to its log, replicated to the others,
+// and applied to every node's FSM in the same order once a majority holds
+// them.
+type Raft struct {
+	conf  Config
+	fsm   FSM
+	trans Transport
+	store *storage
+
+	// held while the FSM is changed, so snapshots and restores see it
+	// between entries
+	applyMU sync.Mutex
+
+	mu          sync.Mutex
+	state       string
+	term        uint64
+	votedFor    string
+	leader      string
+	log         []types.RaftEntry // entries after snapIndex
+	snapIndex   uint64
+	snapTerm    uint64
+	commitIndex uint64
+	lastApplied uint64
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	lastContact time.Time // of the leader, or of the last vote granted
+	restoring   bool
+	waiters     map[uint64]chan error
+	applied     *sync.Cond // signaled as lastApplied moves
+
+	applyCh   chan struct{}
+	replicate map[string]chan struct{}
+}
+
+// New starts a Raft node, picking up its log and state from conf.Dir.
+func New(conf Config, fsm FSM, trans Transport) (*Raft, error) {
+	if conf.HeartbeatInterval <= 0 {
+		conf.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if conf.ElectionTimeout <= 0 {
+		conf.ElectionTimeout = DefaultElectionTimeout
+	}
+	if conf.SnapshotThreshold == 0 {
+		conf.SnapshotThreshold = DefaultSnapshotThreshold
+	}
+	if !contains(conf.Nodes, conf.ID) {
+		return nil, fmt.Errorf("this node %s is not among the raft nodes %v", conf.ID, conf.Nodes)
+	}
+
+	store, err := newStorage(conf.Dir)
+	if err != nil {
+		return nil, err
+	}
+	r := &Raft{
+		conf:        conf,
+		fsm:         fsm,
+		trans:       trans,
+		store:       store,
+		state:       Follower,
+		nextIndex:   make(map[string]uint64),
+		matchIndex:  make(map[string]uint64),
+		lastContact: time.Now(),
+		waiters:     make(map[uint64]chan error),
+		applyCh:     make(chan struct{}, 1),
+		replicate:   make(map[string]chan struct{}),
+	}
+	r.applied = sync.NewCond(&r.mu)
+
+	st, err := store.loadState()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the raft state: %s", err)
+	}
+	r.term, r.votedFor = st.Term, st.VotedFor
+	snap, err := store.loadSnapshot()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the raft snapshot: %s", err)
+	}
+	if snap != nil {
+		// the FSM is persistent and already holds the snapshot
+		r.snapIndex, r.snapTerm = snap.LastIndex, snap.LastTerm
+		r.commitIndex, r.lastApplied = snap.LastIndex, snap.LastIndex
+	}
+	entries, err := store.loadLog()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the raft log: %s", err)
+	}
+	for _, e := range entries {
+		if e.Index > r.snapIndex {
+			r.log = append(r.log, e)
+		}
+	}
+	log.Infof("Raft node %s starting at term %d with entries %d to %d", conf.ID, r.term, r.snapIndex, r.lastIndex())
+
+	// the replicators read the map, so fill it before starting them
+	for _, node := range conf.Nodes {
+		if node != conf.ID {
+			r.replicate[node] = make(chan struct{}, 1)
+		}
+	}
+	for node := range r.replicate {
+		go r.replicator(node)
+	}
+	go r.applier()
+	go r.ticker()
+	return r, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// The following must be called with mu held.
+
+func (r *Raft) lastIndex() uint64 {
+	return r.snapIndex + uint64(len(r.log))
+}
+
+func (r *Raft) lastTerm() uint64 {
+	if len(r.log) == 0 {
+		return r.snapTerm
+	}
+	return r.log[len(r.log)-1].Term
+}
+
+// termAt returns the term of the entry at index, false if it was compacted
+// or is past the end of the log.
+func (r *Raft) termAt(index uint64) (uint64, bool) {
+	switch {
+	case index == r.snapIndex:
+		return r.snapTerm, true
+	case index < r.snapIndex || index > r.lastIndex():
+		return 0, false
+	}
+	return r.log[index-r.snapIndex-1].Term, true
+}
+
+func (r *Raft) quorum() int {
+	return len(r.conf.Nodes)/2 + 1
+}
+
+func (r *Raft) persistState() {
+	if err := r.store.saveState(&persistentState{Term: r.term, VotedFor: r.votedFor}); err != nil {
+		// voting again after a restart could elect two leaders
+		log.Fatalf("Unable to save the raft state: %s", err)
+	}
+}
+
+// stepDown turns us into a follower of term.
+func (r *Raft) stepDown(term uint64) {
+	if term > r.term {
+		r.term, r.votedFor = term, ""
+		r.persistState()
+	}
+	if r.state == Leader {
+		log.Infof("Stepping down as raft leader at term %d", r.term)
+		for index, ch := range r.waiters {
+			ch <- ErrLeadershipLost
+			delete(r.waiters, index)
+		}
+	}
+	r.state = Follower
+}
+
+// electionTimeout returns a random timeout, so that nodes rarely start
+// elections together.
+func (r *Raft) electionTimeout() time.Duration {
+	return r.conf.ElectionTimeout + time.Duration(rand.Int63n(int64(r.conf.ElectionTimeout)))
+}
+
+// ticker starts an election when the leader hasn't been heard of in a
+// while.
+func (r *Raft) ticker() {
+	timeout := r.electionTimeout()
+	for {
+		time.Sleep(r.conf.HeartbeatInterval / 2)
+		r.mu.Lock()
+		expired := r.state != Leader && !r.restoring && time.Since(r.lastContact) > timeout
+		r.mu.Unlock()
+		if expired {
+			r.election()
+			timeout = r.electionTimeout()
+		}
+	}
+}
+
+func (r *Raft) election() {
+	r.mu.Lock()
+	r.state = Candidate
+	r.term++
+	r.votedFor = r.conf.ID
+	r.leader = ""
+	r.persistState()
+	r.lastContact = time.Now()
+	term := r.term
+	req := &types.VoteRequest{
+		Term:         r.term,
+		Candidate:    r.conf.ID,
+		LastLogIndex: r.lastIndex(),
+		LastLogTerm:  r.lastTerm(),
+	}
+	r.mu.Unlock()
+	log.Infof("Starting raft election for term %d", term)
+
+	votes := make(chan bool, len(r.conf.Nodes))
+	for _, node := range r.conf.Nodes {
+		if node == r.conf.ID {
+			continue
+		}
+		go func(node string) {
+			resp, err := r.trans.RequestVote(node, req)
+			if err != nil {
+				log.Debugf("Unable to request vote from %s: %s", node, err)
+				votes <- false
+				return
+			}
+			r.mu.Lock()
+			if resp.Term > r.term {
+				r.stepDown(resp.Term)
+			}
+			r.mu.Unlock()
+			votes <- resp.Granted
+		}(node)
+	}
+
+	granted := 1
+	for i := 0; ; i++ {
+		r.mu.Lock()
+		if r.state != Candidate || r.term != term {
+			r.mu.Unlock()
+			return
+		}
+		if granted >= r.quorum() {
+			r.becomeLeader()
+			r.mu.Unlock()
+			return
+		}
+		r.mu.Unlock()
+		if i == len(r.conf.Nodes)-1 {
+			return // lost, the ticker will try again
+		}
+		if <-votes {
+			granted++
+		}
+	}
+}
+
+// becomeLeader must be called with mu held.
+func (r *Raft) becomeLeader() {
+	log.Infof("Elected raft leader for term %d", r.term)
+	r.state = Leader
+	r.leader = r.conf.ID
+	for _, node := range r.conf.Nodes {
+		r.nextIndex[node] = r.lastIndex() + 1
+		r.matchIndex[node] = 0
+	}
+	// entries of previous terms are only committed along with one of ours
+	r.appendEntry(nil)
+}
+
+// appendEntry adds command to the leader's log and returns its index. Must
+// be called with mu held.
+func (r *Raft) appendEntry(command []byte) uint64 {
+	e := types.RaftEntry{Index: r.lastIndex() + 1, Term: r.term, Command: command}
+	if err := r.store.appendLog([]types.RaftEntry{e}); err != nil {
+		log.Fatalf("Unable to append to the raft log: %s", err)
+	}
+	r.log = append(r.log, e)
+	r.matchIndex[r.conf.ID] = e.Index
+	r.advanceCommit()
+	for _, ch := range r.replicate {
+		kick(ch)
+	}
+	return e.Index
+}
+
+func kick(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// advanceCommit commits the entries of our term a majority holds. Must be
+// called with mu held.
+func (r *Raft) advanceCommit() {
+	matches := []uint64{}
+	for _, node := range r.conf.Nodes {
+		matches = append(matches, r.matchIndex[node])
+	}
+	sort.Sort(sort.Reverse(uint64s(matches)))
+	n := matches[r.quorum()-1]
+	if term, ok := r.termAt(n); ok && n > r.commitIndex && term == r.term {
+		r.commitIndex = n
+		kick(r.applyCh)
+	}
+}
+
+type uint64s []uint64
+
+func (p uint64s) Len() int           { return len(p) }
+func (p uint64s) Less(i, j int) bool { return p[i] < p[j] }
+func (p uint64s) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// replicator keeps node's log in line with ours while we lead.
+func (r *Raft) replicator(node string) {
+	ticker := time.NewTicker(r.conf.HeartbeatInterval)
+	for {
+		select {
+		case <-r.replicate[node]:
+		case <-ticker.C:
+		}
+		for {
+			more, _ := r.replicateTo(node)
+			if !more {
+				break
+			}
+		}
+	}
+}
+
+// replicateTo sends node the entries it is missing, or a heartbeat. It
+// returns whether more entries are left to send, and whether node still
+// acknowledges us as leader.
+func (r *Raft) replicateTo(node string) (bool, bool) {
+	r.mu.Lock()
+	if r.state != Leader {
+		r.mu.Unlock()
+		return false, false
+	}
+	term := r.term
+	next := r.nextIndex[node]
+	if next <= r.snapIndex {
+		r.mu.Unlock()
+		return r.sendSnapshot(node, term)
+	}
+	prev := next - 1
+	prevTerm, _ := r.termAt(prev)
+	end := r.lastIndex()
+	if end-prev > maxAppendEntries {
+		end = prev + maxAppendEntries
+	}
+	req := &types.AppendRequest{
+		Term:         term,
+		Leader:       r.conf.ID,
+		PrevLogIndex: prev,
+		PrevLogTerm:  prevTerm,
+		Entries:      append([]types.RaftEntry(nil), r.log[prev-r.snapIndex:end-r.snapIndex]...),
+		LeaderCommit: r.commitIndex,
+	}
+	r.mu.Unlock()
+
+	resp, err := r.trans.AppendEntries(node, req)
+	if err != nil {
+		log.Debugf("Unable to send entries to %s: %s", node, err)
+		return false, false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if resp.Term > r.term {
+		r.stepDown(resp.Term)
+		return false, false
+	}
+	if r.state != Leader || r.term != term {
+		return false, false
+	}
+	if resp.Success {
+		if match := prev + uint64(len(req.Entries)); match > r.matchIndex[node] {
+			r.matchIndex[node] = match
+			r.nextIndex[node] = match + 1
+			r.advanceCommit()
+		}
+	} else if r.nextIndex[node] == next {
+		// back off to where the logs may agree
+		next--
+		if resp.LastIndex+1 < next {
+			next = resp.LastIndex + 1
+		}
+		if next < 1 {
+			next = 1
+		}
+		r.nextIndex[node] = next
+	}
+	return r.nextIndex[node] <= r.lastIndex(), true
+}
+
+func (r *Raft) sendSnapshot(node string, term uint64) (bool, bool) {
+	snap, err := r.store.loadSnapshot()
+	if err != nil || snap == nil {
+		log.Errorf("Unable to read the raft snapshot for %s: %v", node, err)
+		return false, false
+	}
+	resp, err := r.trans.InstallSnapshot(node, &types.SnapshotRequest{
+		Term:      term,
+		Leader:    r.conf.ID,
+		LastIndex: snap.LastIndex,
+		LastTerm:  snap.LastTerm,
+		Data:      snap.Data,
+	})
+	if err != nil {
+		log.Debugf("Unable to send the raft snapshot to %s: %s", node, err)
+		return false, false
+	}
+	log.Infof("Sent raft snapshot at index %d to %s", snap.LastIndex, node)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if resp.Term > r.term {
+		r.stepDown(resp.Term)
+		return false, false
+	}
+	if r.state != Leader || r.term != term {
+		return false, false
+	}
+	if snap.LastIndex > r.matchIndex[node] {
+		r.matchIndex[node] = snap.LastIndex
+		r.nextIndex[node] = snap.LastIndex + 1
+	}
+	return r.nextIndex[node] <= r.lastIndex(), true
+}
+
+// applier applies committed entries to the FSM, in order.
+func (r *Raft) applier() {
+	for range r.applyCh {
+		for r.applyNext() {
+		}
+	}
+}
+
+// applyNext applies the entry following the last one applied, if it is
+// committed.
+func (r *Raft) applyNext() bool {
+	r.applyMU.Lock()
+	defer r.applyMU.Unlock()
+
+	r.mu.Lock()
+	if r.lastApplied >= r.commitIndex {
+		r.mu.Unlock()
+		return false
+	}
+	index := r.lastApplied + 1
+	e := r.log[index-r.snapIndex-1]
+	r.mu.Unlock()
+
+	var err error
+	if e.Command != nil {
+		if err = r.fsm.Apply(e.Command); err != nil {
+			log.Errorf("Unable to apply raft entry %d: %s", index, err)
+		}
+	}
+
+	r.mu.Lock()
+	r.lastApplied = index
+	if ch, ok := r.waiters[index]; ok {
+		ch <- err
+		delete(r.waiters, index)
+	}
+	r.applied.Broadcast()
+	compact := r.lastApplied-r.snapIndex >= r.conf.SnapshotThreshold
+	r.mu.Unlock()
+
+	if compact {
+		r.snapshot()
+	}
+	return true
+}
+
+// snapshot saves the FSM and drops the log entries it covers. Must be
+// called with applyMU held.
+func (r *Raft) snapshot() {
+	data, err := r.fsm.Snapshot()
+	if err != nil {
+		log.Errorf("Unable to snapshot: %s", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	index := r.lastApplied
+	term, _ := r.termAt(index)
+	if err := r.store.saveSnapshot(&snapshot{LastIndex: index, LastTerm: term, Data: data}); err != nil {
+		log.Errorf("Unable to save the raft snapshot: %s", err)
+		return
+	}
+	r.log = append([]types.RaftEntry(nil), r.log[index-r.snapIndex:]...)
+	r.snapIndex, r.snapTerm = index, term
+	if err := r.store.rewriteLog(r.log); err != nil {
+		log.Fatalf("Unable to compact the raft log: %s", err)
+	}
+	log.Infof("Raft snapshot taken at index %d", index)
+}
+
+// Apply appends command to the log and waits until it is applied to our
+// FSM, returning the FSM's error. Only the leader accepts commands.
+func (r *Raft) Apply(command []byte, timeout time.Duration) error {
+	r.mu.Lock()
+	if r.state != Leader {
+		err := &NotLeaderError{Leader: r.leader}
+		r.mu.Unlock()
+		return err
+	}
+	ch := make(chan error, 1)
+	r.waiters[r.lastIndex()+1] = ch
+	r.appendEntry(command)
+	r.mu.Unlock()
+
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(timeout):
+		return ErrTimeout
+	}
+}
+
+// ReadBarrier returns once our FSM holds every entry committed before the
+// call, after checking a majority still follows us. Reads done after it
+// are linearizable. Only the leader can serve them.
+func (r *Raft) ReadBarrier(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	r.mu.Lock()
+	for {
+		if r.state != Leader {
+			err := &NotLeaderError{Leader: r.leader}
+			r.mu.Unlock()
+			return err
+		}
+		// our commit index is only known to be current once an entry of
+		// our term is committed
+		if term, _ := r.termAt(r.commitIndex); term == r.term {
+			break
+		}
+		if time.Now().After(deadline) {
+			r.mu.Unlock()
+			return ErrTimeout
+		}
+		r.mu.Unlock()
+		time.Sleep(r.conf.HeartbeatInterval / 4)
+		r.mu.Lock()
+	}
+	readIndex := r.commitIndex
+	r.mu.Unlock()
+
+	acks := make(chan bool, len(r.conf.Nodes))
+	for _, node := range r.conf.Nodes {
+		if node != r.conf.ID {
+			go func(node string) {
+				_, ok := r.replicateTo(node)
+				acks <- ok
+			}(node)
+		}
+	}
+	confirmed := 1
+	for i := 1; i < len(r.conf.Nodes) && confirmed < r.quorum(); i++ {
+		if <-acks {
+			confirmed++
+		}
+	}
+	if confirmed < r.quorum() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return &NotLeaderError{Leader: r.leader}
+	}
+
+	timer := time.AfterFunc(deadline.Sub(time.Now()), func() {
+		r.mu.Lock()
+		r.applied.Broadcast()
+		r.mu.Unlock()
+	})
+	defer timer.Stop()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.lastApplied < readIndex {
+		if time.Now().After(deadline) {
+			return ErrTimeout
+		}
+		r.applied.Wait()
+	}
+	return nil
+}
+
+// Leader returns the current leader, empty if unknown.
+func (r *Raft) Leader() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.leader
+}
+
+// Status describes this node.
+func (r *Raft) Status() *types.RaftStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &types.RaftStatus{
+		ID:            r.conf.ID,
+		State:         r.state,
+		Term:          r.term,
+		Leader:        r.leader,
+		Nodes:         append([]string(nil), r.conf.Nodes...),
+		LastIndex:     r.lastIndex(),
+		CommitIndex:   r.commitIndex,
+		AppliedIndex:  r.lastApplied,
+		SnapshotIndex: r.snapIndex,
+	}
+}
+
+// RequestVote handles a candidate's request for our vote.
+func (r *Raft) RequestVote(req *types.VoteRequest) *types.VoteResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.Term > r.term {
+		r.stepDown(req.Term)
+		r.leader = ""
+	}
+	resp := &types.VoteResponse{Term: r.term}
+	if req.Term < r.term || (r.votedFor != "" && r.votedFor != req.Candidate) {
+		return resp
+	}
+	// only vote for candidates whose log holds everything we have
+	lastTerm := r.lastTerm()
+	if req.LastLogTerm < lastTerm || req.LastLogTerm == lastTerm && req.LastLogIndex < r.lastIndex() {
+		return resp
+	}
+	r.votedFor = req.Candidate
+	r.persistState()
+	r.lastContact = time.Now()
+	resp.Granted = true
+	return resp
+}
+
+// AppendEntries handles entries, or a heartbeat, sent by the leader.
+func (r *Raft) AppendEntries(req *types.AppendRequest) *types.AppendResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	resp := &types.AppendResponse{Term: r.term}
+	if req.Term < r.term {
+		return resp
+	}
+	if req.Term > r.term || r.state != Follower {
+		r.stepDown(req.Term)
+		resp.Term = r.term
+	}
+	if r.leader != req.Leader {
+		log.Infof("Following raft leader %s at term %d", req.Leader, req.Term)
+	}
+	r.leader = req.Leader
+	r.lastContact = time.Now()
+	resp.LastIndex = r.lastIndex()
+
+	if req.PrevLogIndex > r.lastIndex() {
+		return resp
+	}
+	if req.PrevLogIndex >= r.snapIndex {
+		if term, _ := r.termAt(req.PrevLogIndex); term != req.PrevLogTerm {
+			resp.LastIndex = req.PrevLogIndex - 1
+			return resp
+		}
+	}
+
+	appended := []types.RaftEntry{}
+	for _, e := range req.Entries {
+		if e.Index <= r.snapIndex {
+			continue // compacted, so committed and matching
+		}
+		if e.Index <= r.lastIndex() {
+			if term, _ := r.termAt(e.Index); term == e.Term {
+				continue
+			}
+			// conflicting entries are never committed, drop them
+			if e.Index <= r.commitIndex {
+				log.Errorf("Refusing to truncate committed raft entry %d", e.Index)
+				return resp
+			}
+			r.log = r.log[:e.Index-r.snapIndex-1]
+			if err := r.store.rewriteLog(r.log); err != nil {
+				log.Fatalf("Unable to truncate the raft log: %s", err)
+			}
+		}
+		r.log = append(r.log, e)
+		appended = append(appended, e)
+	}
+	if len(appended) > 0 {
+		if err := r.store.appendLog(appended); err != nil {
+			log.Fatalf("Unable to append to the raft log: %s", err)
+		}
+	}
+
+	last := req.PrevLogIndex + uint64(len(req.Entries))
+	if req.LeaderCommit > r.commitIndex && last > r.commitIndex {
+		r.commitIndex = req.LeaderCommit
+		if last < r.commitIndex {
+			r.commitIndex = last
+		}
+		kick(r.applyCh)
+	}
+	resp.Success = true
+	resp.LastIndex = r.lastIndex()
+	return resp
+}
+
+// InstallSnapshot replaces our FSM and log with the leader's snapshot.
+func (r *Raft) InstallSnapshot(req *types.SnapshotRequest) *types.SnapshotResponse {
+	r.mu.Lock()
+	resp := &types.SnapshotResponse{Term: r.term}
+	if req.Term < r.term {
+		r.mu.Unlock()
+		return resp
+	}
+	if req.Term > r.term || r.state != Follower {
+		r.stepDown(req.Term)
+		resp.Term = r.term
+	}
+	r.leader = req.Leader
+	r.lastContact = time.Now()
+	if req.LastIndex <= r.lastApplied {
+		r.mu.Unlock()
+		return resp
+	}
+	// don't start an election while the FSM is being restored
+	r.restoring = true
+	r.mu.Unlock()
+
+	r.applyMU.Lock()
+	defer r.applyMU.Unlock()
+	log.Infof("Restoring raft snapshot at index %d from %s", req.LastIndex, req.Leader)
+	err := r.fsm.Restore(req.Data, req.Leader)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.restoring = false
+	r.lastContact = time.Now()
+	if err != nil {
+		log.Errorf("Unable to restore the raft snapshot: %s", err)
+		return resp
+	}
+	if err := r.store.saveSnapshot(&snapshot{LastIndex: req.LastIndex, LastTerm: req.LastTerm, Data: req.Data}); err != nil {
+		log.Errorf("Unable to save the raft snapshot: %s", err)
+		return resp
+	}
+	if term, ok := r.termAt(req.LastIndex); ok && term == req.LastTerm {
+		// keep the entries following the snapshot
+		r.log = append([]types.RaftEntry(nil), r.log[req.LastIndex-r.snapIndex:]...)
+	} else {
+		r.log = nil
+	}
+	r.snapIndex, r.snapTerm = req.LastIndex, req.LastTerm
+	if err := r.store.rewriteLog(r.log); err != nil {
+		log.Fatalf("Unable to rewrite the raft log: %s", err)
+	}
+	if r.commitIndex < req.LastIndex {
+		r.commitIndex = req.LastIndex
+	}
+	r.lastApplied = req.LastIndex
+	r.applied.Broadcast()
+	return resp
+}
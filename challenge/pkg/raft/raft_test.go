@@ -0,0 +1,412 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+// memFSM records the commands applied to it.
+type memFSM struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (f *memFSM) Apply(command []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = append(f.commands, string(command))
+	return nil
+}
+
+func (f *memFSM) Snapshot() ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return json.Marshal(f.commands)
+}
+
+func (f *memFSM) Restore(data []byte, leader string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return json.Unmarshal(data, &f.commands)
+}
+
+func (f *memFSM) applied() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return strings.Join(f.commands, ",")
+}
+
+// memNetwork connects the nodes of a test group. A node that is down
+// neither sends nor receives RPCs.
+type memNetwork struct {
+	mu    sync.Mutex
+	nodes map[string]*Raft
+	down  map[string]bool
+}
+
+func (n *memNetwork) node(from, to string) (*Raft, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.down[from] || n.down[to] || n.nodes[to] == nil {
+		return nil, fmt.Errorf("%s can't reach %s", from, to)
+	}
+	return n.nodes[to], nil
+}
+
+func (n *memNetwork) setDown(id string, down bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.down[id] = down
+}
+
+// memTransport is the transport of one node of a memNetwork.
+type memTransport struct {
+	net  *memNetwork
+	from string
+}
+
+func (t *memTransport) RequestVote(node string, req *types.VoteRequest) (*types.VoteResponse, error) {
+	r, err := t.net.node(t.from, node)
+	if err != nil {
+		return nil, err
+	}
+	return r.RequestVote(req), nil
+}
+
+func (t *memTransport) AppendEntries(node string, req *types.AppendRequest) (*types.AppendResponse, error) {
+	r, err := t.net.node(t.from, node)
+	if err != nil {
+		return nil, err
+	}
+	return r.AppendEntries(req), nil
+}
+
+func (t *memTransport) InstallSnapshot(node string, req *types.SnapshotRequest) (*types.SnapshotResponse, error) {
+	r, err := t.net.node(t.from, node)
+	if err != nil {
+		return nil, err
+	}
+	return r.InstallSnapshot(req), nil
+}
+
+type testGroup struct {
+	net   *memNetwork
+	nodes map[string]*Raft
+	fsms  map[string]*memFSM
+}
+
+func newTestGroup(t *testing.T, dir string, ids ...string) *testGroup {
+	g := &testGroup{
+		net:   &memNetwork{nodes: make(map[string]*Raft), down: make(map[string]bool)},
+		nodes: make(map[string]*Raft),
+		fsms:  make(map[string]*memFSM),
+	}
+	for _, id := range ids {
+		conf := Config{
+			ID:                id,
+			Nodes:             ids,
+			Dir:               filepath.Join(dir, id),
+			HeartbeatInterval: 10 * time.Millisecond,
+			ElectionTimeout:   100 * time.Millisecond,
+		}
+		fsm := &memFSM{}
+		r, err := New(conf, fsm, &memTransport{net: g.net, from: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.nodes[id], g.fsms[id] = r, fsm
+		g.net.mu.Lock()
+		g.net.nodes[id] = r
+		g.net.mu.Unlock()
+	}
+	return g
+}
+
+// waitFor polls cond for up to 5 seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+// leader waits for a single leader among the nodes that are up, followed by
+// all of them, and returns it.
+func (g *testGroup) leader(t *testing.T) (string, uint64) {
+	var leader string
+	var term uint64
+	waitFor(t, "a leader", func() bool {
+		leader, term = "", 0
+		for id, r := range g.nodes {
+			if g.net.down[id] {
+				continue
+			}
+			st := r.Status()
+			if st.State == Leader {
+				if leader != "" {
+					return false
+				}
+				leader, term = id, st.Term
+			}
+		}
+		if leader == "" {
+			return false
+		}
+		for id, r := range g.nodes {
+			if !g.net.down[id] && r.Leader() != leader {
+				return false
+			}
+		}
+		return true
+	})
+	return leader, term
+}
+
+// applied waits for the FSMs of ids to hold commands.
+func (g *testGroup) applied(t *testing.T, commands string, ids ...string) {
+	for _, id := range ids {
+		waitFor(t, fmt.Sprintf("%s to apply %s", id, commands), func() bool {
+			return g.fsms[id].applied() == commands
+		})
+	}
+}
+
+func TestElection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ids := []string{"a", "b", "c"}
+	g := newTestGroup(t, dir, ids...)
+	first, firstTerm := g.leader(t)
+	for _, cmd := range []string{"x1", "x2"} {
+		if err := g.nodes[first].Apply([]byte(cmd), time.Second); err != nil {
+			t.Fatalf("apply %s: %s", cmd, err)
+		}
+	}
+	g.applied(t, "x1,x2", ids...)
+
+	for _, id := range ids {
+		if id == first {
+			continue
+		}
+		if err := g.nodes[id].Apply([]byte("x"), time.Second); err == nil {
+			t.Errorf("follower %s accepted a command", id)
+		} else if _, ok := err.(*NotLeaderError); !ok {
+			t.Errorf("follower %s: expected a NotLeaderError, got %s", id, err)
+		}
+	}
+
+	// cut the leader off: the others elect a new one and go on without it,
+	// while what it takes in can't be committed
+	g.net.setDown(first, true)
+	if err := g.nodes[first].Apply([]byte("lost"), 200*time.Millisecond); err == nil {
+		t.Errorf("a leader cut off from the others committed an entry")
+	}
+	second, secondTerm := g.leader(t)
+	if second == first || secondTerm <= firstTerm {
+		t.Fatalf("expected a new leader after term %d, got %s at term %d", firstTerm, second, secondTerm)
+	}
+	if err := g.nodes[second].Apply([]byte("x3"), time.Second); err != nil {
+		t.Fatalf("apply x3: %s", err)
+	}
+
+	// back in the group, the old leader follows the new one and its
+	// uncommitted entry is replaced by the new leader's log
+	g.net.setDown(first, false)
+	if leader, _ := g.leader(t); leader != second {
+		t.Errorf("expected %s to stay leader, got %s", second, leader)
+	}
+	g.applied(t, "x1,x2,x3", ids...)
+}
+
+func TestAppendEntries(t *testing.T) {
+	entries := func(terms ...uint64) []types.RaftEntry {
+		list := []types.RaftEntry{}
+		for i, term := range terms {
+			list = append(list, types.RaftEntry{Index: uint64(i + 1), Term: term})
+		}
+		return list
+	}
+	from := func(index uint64, list []types.RaftEntry) []types.RaftEntry {
+		return list[index-1:]
+	}
+
+	tests := []struct {
+		name    string
+		log     []types.RaftEntry // entries the node holds, sent at term 3
+		req     types.AppendRequest
+		success bool
+		terms   []uint64 // of the node's log afterwards
+	}{
+		{
+			name:    "empty log",
+			req:     types.AppendRequest{Term: 3, Entries: entries(1, 1)},
+			success: true,
+			terms:   []uint64{1, 1},
+		},
+		{
+			name:    "heartbeat",
+			log:     entries(1, 2),
+			req:     types.AppendRequest{Term: 3, PrevLogIndex: 2, PrevLogTerm: 2},
+			success: true,
+			terms:   []uint64{1, 2},
+		},
+		{
+			name:    "append",
+			log:     entries(1, 2),
+			req:     types.AppendRequest{Term: 3, PrevLogIndex: 2, PrevLogTerm: 2, Entries: from(3, entries(1, 2, 3))},
+			success: true,
+			terms:   []uint64{1, 2, 3},
+		},
+		{
+			name:    "gap",
+			log:     entries(1, 1),
+			req:     types.AppendRequest{Term: 3, PrevLogIndex: 4, PrevLogTerm: 1, Entries: from(5, entries(1, 1, 1, 1, 1))},
+			success: false,
+			terms:   []uint64{1, 1},
+		},
+		{
+			name:    "previous term differs",
+			log:     entries(1, 1, 1),
+			req:     types.AppendRequest{Term: 3, PrevLogIndex: 3, PrevLogTerm: 2, Entries: from(4, entries(1, 1, 2, 3))},
+			success: false,
+			terms:   []uint64{1, 1, 1},
+		},
+		{
+			name:    "conflict",
+			log:     entries(1, 1, 1),
+			req:     types.AppendRequest{Term: 3, PrevLogIndex: 1, PrevLogTerm: 1, Entries: from(2, entries(1, 2))},
+			success: true,
+			terms:   []uint64{1, 2},
+		},
+		{
+			name:    "already held",
+			log:     entries(1, 1, 2),
+			req:     types.AppendRequest{Term: 3, Entries: entries(1)},
+			success: true,
+			terms:   []uint64{1, 1, 2},
+		},
+		{
+			name:    "stale leader",
+			log:     entries(1, 1),
+			req:     types.AppendRequest{Term: 2, PrevLogIndex: 2, PrevLogTerm: 1, Entries: from(3, entries(1, 1, 2))},
+			success: false,
+			terms:   []uint64{1, 1},
+		},
+	}
+
+	for i, tt := range tests {
+		dir, err := ioutil.TempDir("", "raft")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		// the other node is never reached and we never time out, so the
+		// node only changes through the requests below
+		net := &memNetwork{nodes: make(map[string]*Raft), down: make(map[string]bool)}
+		r, err := New(Config{
+			ID:              "a",
+			Nodes:           []string{"a", "b"},
+			Dir:             dir,
+			ElectionTimeout: time.Hour,
+		}, &memFSM{}, &memTransport{net: net, from: "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tt.log) > 0 {
+			if resp := r.AppendEntries(&types.AppendRequest{Term: 3, Leader: "b", Entries: tt.log}); !resp.Success {
+				t.Fatalf("%s: unable to fill the log", tt.name)
+			}
+		}
+
+		req := tt.req
+		req.Leader = "b"
+		if resp := r.AppendEntries(&req); resp.Success != tt.success {
+			t.Errorf("%s: success is %v, expected %v", tt.name, resp.Success, tt.success)
+		}
+		r.mu.Lock()
+		terms := []uint64{}
+		for _, e := range r.log {
+			terms = append(terms, e.Term)
+		}
+		r.mu.Unlock()
+		if fmt.Sprint(terms) != fmt.Sprint(tt.terms) {
+			t.Errorf("%s (%d): log terms are %v, expected %v", tt.name, i, terms, tt.terms)
+		}
+	}
+}
+
+func TestRequestVote(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     types.VoteRequest
+		granted bool
+	}{
+		{"up to date", types.VoteRequest{Term: 4, Candidate: "b", LastLogIndex: 2, LastLogTerm: 2}, true},
+		{"longer log", types.VoteRequest{Term: 4, Candidate: "b", LastLogIndex: 5, LastLogTerm: 2}, true},
+		{"later term", types.VoteRequest{Term: 4, Candidate: "b", LastLogIndex: 1, LastLogTerm: 3}, true},
+		{"shorter log", types.VoteRequest{Term: 4, Candidate: "b", LastLogIndex: 1, LastLogTerm: 2}, false},
+		{"earlier term", types.VoteRequest{Term: 4, Candidate: "b", LastLogIndex: 9, LastLogTerm: 1}, false},
+		{"stale term", types.VoteRequest{Term: 2, Candidate: "b", LastLogIndex: 2, LastLogTerm: 2}, false},
+	}
+
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "raft")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		net := &memNetwork{nodes: make(map[string]*Raft), down: make(map[string]bool)}
+		r, err := New(Config{
+			ID:              "a",
+			Nodes:           []string{"a", "b", "c"},
+			Dir:             dir,
+			ElectionTimeout: time.Hour,
+		}, &memFSM{}, &memTransport{net: net, from: "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a log of terms 1, 2, at term 3
+		r.AppendEntries(&types.AppendRequest{Term: 3, Leader: "c", Entries: []types.RaftEntry{{Index: 1, Term: 1}, {Index: 2, Term: 2}}})
+
+		if resp := r.RequestVote(&tt.req); resp.Granted != tt.granted {
+			t.Errorf("%s: granted is %v, expected %v", tt.name, resp.Granted, tt.granted)
+		}
+		// a vote is given once per term
+		if tt.granted {
+			other := tt.req
+			other.Candidate = "c"
+			if resp := r.RequestVote(&other); resp.Granted {
+				t.Errorf("%s: voted twice in term %d", tt.name, tt.req.Term)
+			}
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package raft
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+const (
+	stateFileName    = "state.json"
+	logFileName      = "log.jsonl"
+	snapshotFileName = "snapshot.json"
+)
+
+// persistentState is what a node must remember across restarts to never
+// vote twice in a term.
+type persistentState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"voted_for,omitempty"`
+}
+
+// snapshot is the state machine as of an index of the log.
+type snapshot struct {
+	LastIndex uint64 `json:"last_index"`
+	LastTerm  uint64 `json:"last_term"`
+	Data      []byte `json:"data"`
+}
+
+// storage keeps the Raft state, log and snapshot in a directory. The log is
+// a file of JSON entries, one per line, appended to as entries come and
+// rewritten when it is truncated or compacted.
+type storage struct {
+	dir     string
+	logFile *os.File
+}
+
+func newStorage(dir string) (*storage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &storage{dir: dir}, nil
+}
+
+// writeFile replaces the file at path atomically.
+func writeFile(path string, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// readFile decodes the file at path into v. It returns false if the file
+// doesn't exist.
+func readFile(path string, v interface{}) (bool, error) {
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(buf, v)
+}
+
+func (s *storage) saveState(st *persistentState) error {
+	return writeFile(filepath.Join(s.dir, stateFileName), st)
+}
+
+func (s *storage) loadState() (*persistentState, error) {
+	st := &persistentState{}
+	_, err := readFile(filepath.Join(s.dir, stateFileName), st)
+	return st, err
+}
+
+func (s *storage) saveSnapshot(snap *snapshot) error {
+	return writeFile(filepath.Join(s.dir, snapshotFileName), snap)
+}
+
+// loadSnapshot returns the last snapshot saved, or nil.
+func (s *storage) loadSnapshot() (*snapshot, error) {
+	snap := &snapshot{}
+	found, err := readFile(filepath.Join(s.dir, snapshotFileName), snap)
+	if !found || err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// loadLog returns the entries of the log file, dropping a last entry left
+// half written by a crash.
+func (s *storage) loadLog() ([]types.RaftEntry, error) {
+	entries := []types.RaftEntry{}
+	f, err := os.Open(filepath.Join(s.dir, logFileName))
+	if os.IsNotExist(err) {
+		return entries, s.rewriteLog(entries)
+	}
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+	for scanner.Scan() {
+		e := types.RaftEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Warningf("Dropping corrupt entry at the end of the raft log: %s", err)
+			break
+		}
+		entries = append(entries, e)
+	}
+	f.Close()
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, s.rewriteLog(entries)
+}
+
+// appendLog adds entries at the end of the log file.
+func (s *storage) appendLog(entries []types.RaftEntry) error {
+	w := bufio.NewWriter(s.logFile)
+	enc := json.NewEncoder(w)
+	for i := range entries {
+		if err := enc.Encode(&entries[i]); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return s.logFile.Sync()
+}
+
+// rewriteLog replaces the log file with entries.
+func (s *storage) rewriteLog(entries []types.RaftEntry) error {
+	path := filepath.Join(s.dir, logFileName)
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for i := range entries {
+		if err := enc.Encode(&entries[i]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	if s.logFile != nil {
+		s.logFile.Close()
+	}
+	s.logFile, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
@@ -0,0 +1,236 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package ratelimit
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// pruneInterval is how often clients whose buckets filled up again are
+// forgotten.
+const pruneInterval = time.Minute
+
+// Config sets how fast each client may go. Zero rates mean no limit.
+type Config struct {
+	Requests float64 // requests per second
+	Burst    int     // requests above the rate let through at once, Requests rounded up if 0
+	Bytes    int64   // bytes per second read from bodies and sent in responses
+}
+
+// Enabled tells whether c limits anything.
+func (c Config) Enabled() bool {
+	return c.Requests > 0 || c.Bytes > 0
+}
+
+// bucket is a token bucket refilled at rate tokens per second up to burst.
+// Taking more tokens than it holds puts it in debt, which is paid back
+// before anything else is let through.
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate, burst float64, now time.Time) *bucket {
+	return &bucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// until returns how long it takes for the bucket to hold n tokens.
+func (b *bucket) until(n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// client is what the limiter keeps about a client.
+type client struct {
+	requests *bucket // nil without a request rate
+	bytes    *bucket // nil without a byte rate
+}
+
+// full tells whether the buckets of c are full again at now, which makes
+// it as good as a client never seen.
+func (c *client) full(now time.Time) bool {
+	for _, b := range []*bucket{c.requests, c.bytes} {
+		if b != nil {
+			b.refill(now)
+			if b.tokens < b.burst {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Limiter keeps the rates of clients, told apart by an identity such as
+// their API key or address.
+type Limiter struct {
+	conf Config
+
+	mu      sync.Mutex
+	clients map[string]*client
+	pruned  time.Time
+}
+
+// New returns a limiter holding each client to the rates of c.
+func New(c Config) *Limiter {
+	if c.Burst <= 0 {
+		c.Burst = int(math.Ceil(c.Requests))
+	}
+	return &Limiter{conf: c, clients: make(map[string]*client), pruned: time.Now()}
+}
+
+// client returns the client id, creating it if needed. Must be called with
+// mu held.
+func (l *Limiter) client(id string, now time.Time) *client {
+	if now.Sub(l.pruned) > pruneInterval {
+		for cid, c := range l.clients {
+			if c.full(now) {
+				delete(l.clients, cid)
+			}
+		}
+		l.pruned = now
+	}
+	c, ok := l.clients[id]
+	if !ok {
+		c = &client{}
+		if l.conf.Requests > 0 {
+			c.requests = newBucket(l.conf.Requests, float64(l.conf.Burst), now)
+		}
+		if l.conf.Bytes > 0 {
+			c.bytes = newBucket(float64(l.conf.Bytes), float64(l.conf.Bytes), now)
+		}
+		l.clients[id] = c
+	}
+	return c
+}
+
+// Allow takes a request of the client id. It tells whether the request may
+// be served now, and if not how long the client should wait: until it may
+// send another request and has paid back the bytes it went over its rate
+// with.
+func (l *Limiter) Allow(id string) (time.Duration, bool) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := l.client(id, now)
+	var wait time.Duration
+	if c.bytes != nil {
+		c.bytes.refill(now)
+		wait = c.bytes.until(0)
+	}
+	if c.requests != nil {
+		c.requests.refill(now)
+		if w := c.requests.until(1); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		return wait, false
+	}
+	if c.requests != nil {
+		c.requests.tokens--
+	}
+	return 0, true
+}
+
+// take takes n bytes of the client id, returning how long to wait for them
+// to fit in its rate.
+func (l *Limiter) take(id string, n int) time.Duration {
+	if l.conf.Bytes <= 0 || n <= 0 {
+		return 0
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.client(id, now).bytes
+	b.refill(now)
+	b.tokens -= float64(n)
+	return b.until(0)
+}
+
+// Reader returns rc slowed down to the byte rate of the client id.
+func (l *Limiter) Reader(id string, rc io.ReadCloser) io.ReadCloser {
+	if l.conf.Bytes <= 0 {
+		return rc
+	}
+	return &reader{ReadCloser: rc, l: l, id: id}
+}
+
+// Writer returns w slowed down to the byte rate of the client id.
+func (l *Limiter) Writer(id string, w http.ResponseWriter) http.ResponseWriter {
+	if l.conf.Bytes <= 0 {
+		return w
+	}
+	return &writer{ResponseWriter: w, l: l, id: id}
+}
+
+// chunk returns at most one second worth of p, so that a single read or
+// write does not take the whole byte rate for long.
+func (l *Limiter) chunk(p []byte) []byte {
+	if int64(len(p)) > l.conf.Bytes {
+		return p[:l.conf.Bytes]
+	}
+	return p
+}
+
+type reader struct {
+	io.ReadCloser
+	l  *Limiter
+	id string
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(r.l.chunk(p))
+	time.Sleep(r.l.take(r.id, n))
+	return n, err
+}
+
+type writer struct {
+	http.ResponseWriter
+	l  *Limiter
+	id string
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := w.l.chunk(p)
+		time.Sleep(w.l.take(w.id, len(chunk)))
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Flush lets streaming responses, such as watches, through.
+func (w *writer) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
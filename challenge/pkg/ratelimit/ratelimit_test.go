@@ -0,0 +1,114 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name   string
+		rate   float64
+		burst  float64
+		take   float64       // taken at start
+		after  time.Duration // when refilled
+		tokens float64
+		wait   time.Duration // for one token
+	}{
+		{name: "full", rate: 2, burst: 5, tokens: 5},
+		{name: "burst taken", rate: 2, burst: 5, take: 5, wait: 500 * time.Millisecond},
+		{name: "refilled", rate: 2, burst: 5, take: 5, after: time.Second, tokens: 2},
+		{name: "refilled to burst only", rate: 2, burst: 5, take: 5, after: time.Minute, tokens: 5},
+		{name: "in debt", rate: 2, burst: 5, take: 8, tokens: -3, wait: 2 * time.Second},
+		{name: "paying back debt", rate: 2, burst: 5, take: 8, after: time.Second, tokens: -1, wait: time.Second},
+	}
+
+	for _, tt := range tests {
+		b := newBucket(tt.rate, tt.burst, start)
+		b.tokens -= tt.take
+		b.refill(start.Add(tt.after))
+		if b.tokens != tt.tokens {
+			t.Errorf("%s: got %v tokens, expected %v", tt.name, b.tokens, tt.tokens)
+		}
+		if wait := b.until(1); wait != tt.wait {
+			t.Errorf("%s: got a wait of %s, expected %s", tt.name, wait, tt.wait)
+		}
+	}
+}
+
+func TestAllowBurst(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    Config
+		allowed int // in a row before the first refusal
+	}{
+		{name: "burst", conf: Config{Requests: 0.1, Burst: 3}, allowed: 3},
+		{name: "burst from the rate", conf: Config{Requests: 1.5}, allowed: 2},
+		{name: "burst below the rate", conf: Config{Requests: 0.1, Burst: 1}, allowed: 1},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.conf)
+		for i := 0; i < tt.allowed; i++ {
+			if wait, ok := l.Allow("c1"); !ok {
+				t.Errorf("%s: request %d refused, wait %s", tt.name, i+1, wait)
+			}
+		}
+		wait, ok := l.Allow("c1")
+		if ok {
+			t.Errorf("%s: request %d allowed past the burst", tt.name, tt.allowed+1)
+		}
+		if max := time.Duration(float64(time.Second) / tt.conf.Requests); wait <= 0 || wait > max {
+			t.Errorf("%s: got a wait of %s, expected up to %s", tt.name, wait, max)
+		}
+		if _, ok := l.Allow("c2"); !ok {
+			t.Errorf("%s: another client was refused", tt.name)
+		}
+	}
+}
+
+func TestAllowRefill(t *testing.T) {
+	l := New(Config{Requests: 50, Burst: 1})
+	if _, ok := l.Allow("c1"); !ok {
+		t.Fatal("first request refused")
+	}
+	wait, ok := l.Allow("c1")
+	if ok {
+		t.Fatal("second request allowed past the burst")
+	}
+	time.Sleep(wait)
+	if wait, ok := l.Allow("c1"); !ok {
+		t.Errorf("request refused after the bucket refilled, wait %s", wait)
+	}
+}
+
+// Bytes taken over the byte rate hold back the next request until they
+// are paid back.
+func TestAllowBytes(t *testing.T) {
+	l := New(Config{Bytes: 100})
+	if wait := l.take("c1", 50); wait != 0 {
+		t.Errorf("got a wait of %s within the rate", wait)
+	}
+	if _, ok := l.Allow("c1"); !ok {
+		t.Errorf("request refused within the byte rate")
+	}
+	if wait := l.take("c1", 150); wait <= 0 || wait > time.Second {
+		t.Errorf("got a wait of %s over the rate, expected up to 1s", wait)
+	}
+	if _, ok := l.Allow("c1"); ok {
+		t.Errorf("request allowed while in debt")
+	}
+}
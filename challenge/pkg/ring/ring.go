@@ -0,0 +1,114 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package ring
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// DefaultVNodes is the number of points each member gets on the ring.
+const DefaultVNodes = 64
+
+// Ring is a consistent hash ring. Each member is placed at VNodes points of
+// the ring; a key belongs to the members found walking clockwise from the
+// key's hash, so adding or removing a member only moves the keys next to
+// its points.
+type Ring struct {
+	VNodes int
+
+	members []string
+	points  []uint32 // sorted
+	owner   map[uint32]string
+}
+
+// New returns a ring over members with vnodes points per member, or
+// DefaultVNodes if vnodes is 0.
+func New(members []string, vnodes int) *Ring {
+	if vnodes <= 0 {
+		vnodes = DefaultVNodes
+	}
+	r := &Ring{
+		VNodes: vnodes,
+		owner:  make(map[uint32]string),
+	}
+	seen := make(map[string]bool)
+	for _, m := range members {
+		if seen[m] {
+			continue
+		}
+		seen[m] = true
+		r.members = append(r.members, m)
+		for i := 0; i < vnodes; i++ {
+			p := hash(m + "#" + strconv.Itoa(i))
+			if _, taken := r.owner[p]; taken {
+				continue
+			}
+			r.owner[p] = m
+			r.points = append(r.points, p)
+		}
+	}
+	sort.Strings(r.members)
+	sort.Sort(uint32s(r.points))
+	return r
+}
+
+// hash spreads keys evenly over the ring, which cheaper checksums don't do
+// for similar keys such as member#1, member#2...
+func hash(key string) uint32 {
+	sum := md5.Sum([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// Members returns the sorted members of the ring.
+func (r *Ring) Members() []string {
+	return append([]string(nil), r.members...)
+}
+
+// Owners returns the n distinct members responsible for key, the first one
+// being its primary owner. Fewer are returned if the ring is smaller.
+func (r *Ring) Owners(key string, n int) []string {
+	if n > len(r.members) {
+		n = len(r.members)
+	}
+	owners := make([]string, 0, n)
+	if n == 0 {
+		return owners
+	}
+	h := hash(key)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	for i := 0; i < len(r.points) && len(owners) < n; i++ {
+		m := r.owner[r.points[(start+i)%len(r.points)]]
+		if !contains(owners, m) {
+			owners = append(owners, m)
+		}
+	}
+	return owners
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+type uint32s []uint32
+
+func (p uint32s) Len() int           { return len(p) }
+func (p uint32s) Less(i, j int) bool { return p[i] < p[j] }
+func (p uint32s) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,48 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sink
+
+import (
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+// FileSink spools events to JSONL files in a directory, for consumers to
+// read on their own. A consumer reads the segment files in the order of
+// their names, skips the events up to the offset it committed, and commits
+// by atomically replacing consumers/<name> with the offset of the last
+// event it processed.
+type FileSink struct {
+	spec  string
+	spool *spool
+}
+
+func NewFileSink(dir string) (*FileSink, error) {
+	sp, err := openSpool(dir)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Spooling events to %s", dir)
+	return &FileSink{spec: FileSinkName + ":" + dir, spool: sp}, nil
+}
+
+func (fs *FileSink) Publish(e *types.Event) error {
+	_, err := fs.spool.append(e)
+	return err
+}
+
+func (fs *FileSink) Status() (*types.SinkStatus, error) {
+	return fs.spool.status(fs.spec)
+}
+
+func (fs *FileSink) Close() error {
+	return fs.spool.close()
+}
@@ -0,0 +1,68 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sink
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+
+	"github.com/op/go-logging"
+)
+
+var (
+	log = logging.MustGetLogger("challenge-sink")
+)
+
+const (
+	// FileSinkName selects the sink spooling events to JSONL files read by
+	// consumers.
+	FileSinkName = "file"
+	// UnixSinkName selects the sink serving spooled events over a Unix
+	// socket.
+	UnixSinkName = "unix"
+)
+
+// Sink publishes the events of blob writes for other processes to consume.
+// Events are numbered by increasing offsets and kept until every consumer
+// has committed an offset past them, so a consumer that restarts from its
+// committed offset sees every event at least once.
+type Sink interface {
+	// Publish adds e after the events published before. It returns once
+	// e is on disk.
+	Publish(e *types.Event) error
+	// Status returns the last offset published and the offset committed
+	// by each consumer.
+	Status() (*types.SinkStatus, error)
+	Close() error
+}
+
+// New returns the sink described by spec, "<kind>:<path>".
+func New(spec string) (Sink, error) {
+	i := strings.Index(spec, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid sink %q, expected <kind>:<path>", spec)
+	}
+	kind, path := spec[:i], spec[i+1:]
+	if path == "" {
+		return nil, fmt.Errorf("invalid sink %q, path is empty", spec)
+	}
+	switch kind {
+	case FileSinkName:
+		return NewFileSink(path)
+	case UnixSinkName:
+		return NewUnixSink(path)
+	default:
+		return nil, fmt.Errorf("Unknown sink %q", kind)
+	}
+}
@@ -0,0 +1,403 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+const (
+	segmentEvents    = 10000 // events per segment file
+	maxSegments      = 100   // kept at most, however far behind consumers are
+	segmentSuffix    = ".jsonl"
+	consumersDirName = "consumers"
+)
+
+var consumerNameRE = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// spool is a durable sequence of events in a directory, numbered from 1.
+// Events are appended to segment files named after the offset of their
+// first event, one types.EventRecord per line. A consumer commits the
+// offset of the last event it processed by writing it to
+// consumers/<name>; segments every consumer is past are removed.
+type spool struct {
+	dir string
+
+	mu       sync.Mutex
+	segments []uint64      // first offset of each segment, oldest first
+	last     uint64        // offset of the last event
+	file     *os.File      // last segment, appended to
+	inFile   int           // events in the last segment
+	notify   chan struct{} // closed and replaced on every new event
+}
+
+func segmentName(first uint64) string {
+	return fmt.Sprintf("%020d%s", first, segmentSuffix)
+}
+
+func openSpool(dir string) (*spool, error) {
+	if err := os.MkdirAll(filepath.Join(dir, consumersDirName), 0755); err != nil {
+		return nil, err
+	}
+	s := &spool{
+		dir:    dir,
+		notify: make(chan struct{}),
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), segmentSuffix) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(fi.Name(), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, first)
+	}
+	sort.Sort(uint64s(s.segments))
+
+	if len(s.segments) == 0 {
+		s.segments = []uint64{1}
+	}
+	first := s.segments[len(s.segments)-1]
+	path := filepath.Join(dir, segmentName(first))
+	if s.inFile, s.last, err = recoverSegment(path); err != nil {
+		return nil, err
+	}
+	if s.inFile == 0 {
+		s.last = first - 1
+	}
+	if s.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+type uint64s []uint64
+
+func (s uint64s) Len() int           { return len(s) }
+func (s uint64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s uint64s) Less(i, j int) bool { return s[i] < s[j] }
+
+// recoverSegment returns the number of events in the segment at path and
+// the offset of the last one, cutting off an event left half written by a
+// crash.
+func recoverSegment(path string) (int, uint64, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var (
+		r     = bufio.NewReader(f)
+		pos   int64
+		count int
+		last  uint64
+	)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return 0, 0, err
+		}
+		rec := types.EventRecord{}
+		if err == io.EOF || json.Unmarshal(line, &rec) != nil {
+			if len(line) > 0 {
+				log.Warningf("Dropping a corrupt event at the end of %s", path)
+				if err := f.Truncate(pos); err != nil {
+					return 0, 0, err
+				}
+			}
+			return count, last, nil
+		}
+		pos += int64(len(line))
+		count++
+		last = rec.Offset
+	}
+}
+
+// append adds e to the spool and returns its offset.
+func (s *spool) append(e *types.Event) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inFile >= segmentEvents {
+		if err := s.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	rec := types.EventRecord{Offset: s.last + 1, Event: *e}
+	buf, err := json.Marshal(&rec)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.file.Write(append(buf, '\n')); err != nil {
+		return 0, err
+	}
+	if err := s.file.Sync(); err != nil {
+		return 0, err
+	}
+	s.last++
+	s.inFile++
+	close(s.notify)
+	s.notify = make(chan struct{})
+	return s.last, nil
+}
+
+// rotate starts a new segment and removes the old ones nobody needs
+// anymore. Must be called with mu held.
+func (s *spool) rotate() error {
+	first := s.last + 1
+	f, err := os.OpenFile(filepath.Join(s.dir, segmentName(first)), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	s.file.Close()
+	s.file = f
+	s.inFile = 0
+	s.segments = append(s.segments, first)
+	s.prune()
+	return nil
+}
+
+// prune removes the segments every consumer committed, and the oldest ones
+// beyond maxSegments. Must be called with mu held.
+func (s *spool) prune() {
+	offsets, err := s.consumerOffsets()
+	if err != nil {
+		log.Errorf("Unable to read the offsets of the consumers of %s: %s", s.dir, err)
+		return
+	}
+	for len(s.segments) > 1 {
+		end := s.segments[1] - 1 // offset of the oldest segment's last event
+		lagging := []string{}
+		for name, offset := range offsets {
+			if offset < end {
+				lagging = append(lagging, name)
+			}
+		}
+		consumed := len(offsets) > 0 && len(lagging) == 0
+		if !consumed && len(s.segments) <= maxSegments {
+			return
+		}
+		if !consumed {
+			log.Warningf("Dropping events %d to %d of %s not consumed yet by %v", s.segments[0], end, s.dir, lagging)
+		}
+		if err := os.Remove(filepath.Join(s.dir, segmentName(s.segments[0]))); err != nil {
+			log.Errorf("Unable to remove a segment of %s: %s", s.dir, err)
+			return
+		}
+		s.segments = s.segments[1:]
+	}
+}
+
+func (s *spool) lastOffset() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// wait returns a channel closed once an event is appended.
+func (s *spool) wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.notify
+}
+
+// segmentOf returns the first offset of the segment holding the event at
+// offset, or of the oldest segment if it was removed. ok is false if there
+// is no such event yet.
+func (s *spool) segmentOf(offset uint64) (first uint64, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if offset > s.last {
+		return 0, false
+	}
+	first = s.segments[0]
+	for _, seg := range s.segments {
+		if seg > offset {
+			break
+		}
+		first = seg
+	}
+	return first, true
+}
+
+// complete tells whether no more events will be added to the segment
+// starting at first.
+func (s *spool) complete(first uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.segments[len(s.segments)-1] != first
+}
+
+func (s *spool) consumerOffsets() (map[string]uint64, error) {
+	files, err := ioutil.ReadDir(filepath.Join(s.dir, consumersDirName))
+	if err != nil {
+		return nil, err
+	}
+	offsets := make(map[string]uint64)
+	for _, fi := range files {
+		if !consumerNameRE.MatchString(fi.Name()) {
+			continue // e.g. a commit being written
+		}
+		offset, err := s.committed(fi.Name())
+		if err != nil {
+			return nil, err
+		}
+		offsets[fi.Name()] = offset
+	}
+	return offsets, nil
+}
+
+// committed returns the offset committed by a consumer, 0 if none.
+func (s *spool) committed(name string) (uint64, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(s.dir, consumersDirName, name))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	offset, err := strconv.ParseUint(strings.TrimSpace(string(buf)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid offset committed by %s: %s", name, err)
+	}
+	return offset, nil
+}
+
+// commit records that a consumer processed every event up to offset.
+// Offsets never go back.
+func (s *spool) commit(name string, offset uint64) error {
+	if !consumerNameRE.MatchString(name) {
+		return fmt.Errorf("invalid consumer name %q", name)
+	}
+	if last := s.lastOffset(); offset > last {
+		return fmt.Errorf("offset %d is past the last event %d", offset, last)
+	}
+	if cur, err := s.committed(name); err != nil || offset <= cur {
+		return err
+	}
+	path := filepath.Join(s.dir, consumersDirName, name)
+	tmpPath := filepath.Join(s.dir, consumersDirName, "."+name+".tmp")
+	if err := ioutil.WriteFile(tmpPath, []byte(strconv.FormatUint(offset, 10)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func (s *spool) status(spec string) (*types.SinkStatus, error) {
+	offsets, err := s.consumerOffsets()
+	if err != nil {
+		return nil, err
+	}
+	return &types.SinkStatus{
+		Spec:       spec,
+		LastOffset: s.lastOffset(),
+		Consumers:  offsets,
+	}, nil
+}
+
+func (s *spool) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// cursor reads the events of a spool in order.
+type cursor struct {
+	s    *spool
+	next uint64 // offset of the next event to return
+	seg  uint64 // first offset of the segment open
+	f    *os.File
+	r    *bufio.Reader
+	part []byte // start of a line still being written
+}
+
+func (s *spool) cursor(from uint64) *cursor {
+	return &cursor{s: s, next: from}
+}
+
+// read returns the next event, or nil if there is none yet.
+func (c *cursor) read() (*types.EventRecord, error) {
+	for {
+		if c.f == nil {
+			seg, ok := c.s.segmentOf(c.next)
+			if !ok {
+				return nil, nil
+			}
+			if seg > c.next {
+				log.Warningf("Events %d to %d of %s were removed before being read", c.next, seg-1, c.s.dir)
+				c.next = seg
+			}
+			f, err := os.Open(filepath.Join(c.s.dir, segmentName(seg)))
+			if err != nil {
+				return nil, err
+			}
+			c.f, c.r, c.seg = f, bufio.NewReader(f), seg
+		}
+
+		// checked before reading: once complete, reaching the end of the
+		// segment means it was all read
+		complete := c.s.complete(c.seg)
+		line, err := c.r.ReadBytes('\n')
+		c.part = append(c.part, line...)
+		if err == io.EOF {
+			if complete && len(c.part) == 0 {
+				c.close()
+				continue
+			}
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, c.part = c.part, nil
+
+		rec := &types.EventRecord{}
+		if err := json.Unmarshal(line, rec); err != nil {
+			return nil, fmt.Errorf("corrupt event in %s: %s", c.f.Name(), err)
+		}
+		if rec.Offset < c.next {
+			continue
+		}
+		c.next = rec.Offset + 1
+		return rec, nil
+	}
+}
+
+func (c *cursor) close() {
+	if c.f != nil {
+		c.f.Close()
+		c.f = nil
+	}
+	c.part = nil
+}
@@ -0,0 +1,163 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+)
+
+// spoolSuffix is added to the socket path to name the directory events are
+// spooled to.
+const spoolSuffix = ".spool"
+
+// clientMessage is what a consumer sends over the socket, one JSON object
+// per line. The first one names the consumer and, optionally, the offset to
+// start from instead of the one after its committed offset. The following
+// ones commit the offset of the last event processed.
+type clientMessage struct {
+	Consumer string `json:"consumer,omitempty"`
+	From     uint64 `json:"from,omitempty"`
+	Ack      uint64 `json:"ack,omitempty"`
+}
+
+// UnixSink spools events like a FileSink, in the directory named after the
+// socket path with a ".spool" suffix, and streams them to the consumers
+// connecting to the socket as types.EventRecord JSON lines. Events a
+// consumer didn't acknowledge are sent again when it reconnects.
+type UnixSink struct {
+	spec     string
+	spool    *spool
+	listener net.Listener
+}
+
+func NewUnixSink(path string) (*UnixSink, error) {
+	sp, err := openSpool(path + spoolSuffix)
+	if err != nil {
+		return nil, err
+	}
+	// left behind by a previous run
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		sp.close()
+		return nil, err
+	}
+	us := &UnixSink{
+		spec:     UnixSinkName + ":" + path,
+		spool:    sp,
+		listener: l,
+	}
+	go us.serve()
+	log.Infof("Serving events on %s", path)
+	return us, nil
+}
+
+func (us *UnixSink) Publish(e *types.Event) error {
+	_, err := us.spool.append(e)
+	return err
+}
+
+func (us *UnixSink) Status() (*types.SinkStatus, error) {
+	return us.spool.status(us.spec)
+}
+
+func (us *UnixSink) Close() error {
+	us.listener.Close()
+	return us.spool.close()
+}
+
+func (us *UnixSink) serve() {
+	for {
+		conn, err := us.listener.Accept()
+		if err != nil {
+			log.Debugf("No more consumers on %s: %s", us.spec, err)
+			return
+		}
+		go us.handle(conn)
+	}
+}
+
+// handle streams events to a consumer and commits its acknowledgements.
+func (us *UnixSink) handle(conn net.Conn) {
+	defer conn.Close()
+	dec := json.NewDecoder(conn)
+	sub := clientMessage{}
+	if err := dec.Decode(&sub); err != nil {
+		log.Warningf("Invalid subscription on %s: %s", us.spec, err)
+		return
+	}
+	if !consumerNameRE.MatchString(sub.Consumer) {
+		log.Warningf("Invalid consumer name %q on %s", sub.Consumer, us.spec)
+		return
+	}
+	from := sub.From
+	if from == 0 {
+		committed, err := us.spool.committed(sub.Consumer)
+		if err != nil {
+			log.Warningf("Unable to subscribe %q on %s: %s", sub.Consumer, us.spec, err)
+			return
+		}
+		from = committed + 1
+	}
+	log.Infof("Consumer %q subscribed on %s from offset %d", sub.Consumer, us.spec, from)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			m := clientMessage{}
+			if err := dec.Decode(&m); err != nil {
+				return
+			}
+			if err := us.spool.commit(sub.Consumer, m.Ack); err != nil {
+				log.Warningf("Unable to commit offset %d of %q on %s: %s", m.Ack, sub.Consumer, us.spec, err)
+				return
+			}
+		}
+	}()
+
+	c := us.spool.cursor(from)
+	defer c.close()
+	w := bufio.NewWriter(conn)
+	enc := json.NewEncoder(w)
+	for {
+		wait := us.spool.wait()
+		rec, err := c.read()
+		if err != nil {
+			log.Errorf("Unable to read events for %q on %s: %s", sub.Consumer, us.spec, err)
+			return
+		}
+		if rec != nil {
+			if err := enc.Encode(rec); err != nil {
+				return
+			}
+			continue
+		}
+		// caught up, send what is buffered
+		if err := w.Flush(); err != nil {
+			return
+		}
+		select {
+		case <-wait:
+		case <-done:
+			log.Infof("Consumer %q left %s", sub.Consumer, us.spec)
+			return
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package store
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/blob"
+)
+
+// bucketsDirName is the directory, below each directory of the store,
+// holding those of the buckets.
+const bucketsDirName = "buckets"
+
+// BucketStore keeps the blobs of each bucket in a store of their own, built
+// like the root one in a buckets/<name> directory below each of its paths.
+// Blobs outside of buckets stay in the root store.
+type BucketStore struct {
+	name string
+	conf Config
+	root Store
+
+	mu      sync.RWMutex
+	buckets map[string]Store
+	where   map[uint16]Store
+}
+
+// NewBucketStore returns a BucketStore whose root store and bucket stores
+// are those registered under name, configured like c.
+func NewBucketStore(name string, c Config) (*BucketStore, error) {
+	root, err := New(name, c)
+	if err != nil {
+		return nil, err
+	}
+	s := &BucketStore{
+		name:    name,
+		conf:    c,
+		root:    root,
+		buckets: make(map[string]Store),
+		where:   make(map[uint16]Store),
+	}
+	if err := s.load(root); err != nil {
+		return nil, err
+	}
+	// the blobs of buckets we don't know about, or not yet, are opened too
+	for _, bucket := range s.bucketsOnDisk() {
+		if _, err := s.addBucket(bucket); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// bucketsOnDisk returns the buckets with a directory below one of those
+// of the store.
+func (s *BucketStore) bucketsOnDisk() []string {
+	basePath := s.conf.BasePath
+	if basePath == "" {
+		basePath = common.DataDirBasePath
+	}
+	found := map[string]bool{}
+	buckets := []string{}
+	for _, path := range append([]string{basePath}, s.conf.Paths...) {
+		files, err := ioutil.ReadDir(filepath.Join(path, bucketsDirName))
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if file.IsDir() && !found[file.Name()] {
+				found[file.Name()] = true
+				buckets = append(buckets, file.Name())
+			}
+		}
+	}
+	return buckets
+}
+
+// bucketConfig returns c with every directory moved to the one of bucket.
+func bucketConfig(c Config, bucket string) Config {
+	sub := func(path string) string {
+		if path == "" {
+			return ""
+		}
+		return filepath.Join(path, bucketsDirName, bucket)
+	}
+	basePath := c.BasePath
+	if basePath == "" {
+		basePath = common.DataDirBasePath
+	}
+	bc := c
+	bc.BasePath = sub(basePath)
+	bc.Paths = nil
+	for _, path := range c.Paths {
+		bc.Paths = append(bc.Paths, sub(path))
+	}
+	bc.ColdPath = sub(c.ColdPath)
+	return bc
+}
+
+// load registers the blobs st holds. Must be called with mu held, or
+// before s is shared.
+func (s *BucketStore) load(st Store) error {
+	ids, err := st.List()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		s.where[id] = st
+	}
+	return nil
+}
+
+// AddBucket opens the store of bucket, if not open yet, and registers the
+// blobs it holds.
+func (s *BucketStore) AddBucket(bucket string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.addBucket(bucket)
+	return err
+}
+
+// addBucket returns the store of bucket, opening it if needed. Must be
+// called with mu held.
+func (s *BucketStore) addBucket(bucket string) (Store, error) {
+	if st, ok := s.buckets[bucket]; ok {
+		return st, nil
+	}
+	st, err := New(s.name, bucketConfig(s.conf, bucket))
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the store of bucket %s: %s", bucket, err)
+	}
+	if err := s.load(st); err != nil {
+		return nil, err
+	}
+	s.buckets[bucket] = st
+	return st, nil
+}
+
+// RemoveBucket deletes every blob of bucket. Its store stays open, empty,
+// and is used again if the bucket is created again.
+func (s *BucketStore) RemoveBucket(bucket string) error {
+	s.mu.RLock()
+	st, ok := s.buckets[bucket]
+	ids := []uint16{}
+	for id, where := range s.where {
+		if ok && where == st {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.RUnlock()
+	for _, id := range ids {
+		if err := s.Delete(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Children returns the root store and the store of each bucket.
+func (s *BucketStore) Children() []Store {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stores := []Store{s.root}
+	for _, st := range s.buckets {
+		stores = append(stores, st)
+	}
+	return stores
+}
+
+// storeOf returns the store holding blob id.
+func (s *BucketStore) storeOf(id uint16) (Store, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	st, ok := s.where[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return st, nil
+}
+
+func (s *BucketStore) PutState(bb *blob.Blob) error {
+	s.mu.Lock()
+	st, ok := s.where[bb.ID]
+	if !ok {
+		st = s.root
+		if bucket, _ := common.SplitLocation(bb.Location); bucket != "" {
+			var err error
+			if st, err = s.addBucket(bucket); err != nil {
+				s.mu.Unlock()
+				return err
+			}
+		}
+		s.where[bb.ID] = st
+	}
+	s.mu.Unlock()
+	return st.PutState(bb)
+}
+
+func (s *BucketStore) GetState(id uint16) (*blob.Blob, error) {
+	st, err := s.storeOf(id)
+	if err != nil {
+		return nil, err
+	}
+	return st.GetState(id)
+}
+
+func (s *BucketStore) PutData(id uint16, r io.Reader) (int64, error) {
+	st, err := s.storeOf(id)
+	if err != nil {
+		return 0, err
+	}
+	return st.PutData(id, r)
+}
+
+func (s *BucketStore) GetData(id uint16) (io.ReadCloser, error) {
+	st, err := s.storeOf(id)
+	if err != nil {
+		return nil, err
+	}
+	return st.GetData(id)
+}
+
+func (s *BucketStore) Delete(id uint16) error {
+	st, err := s.storeOf(id)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err := st.Delete(id); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.where, id)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *BucketStore) List() ([]uint16, error) {
+	ids := []uint16{}
+	for _, st := range s.Children() {
+		stIDs, err := st.List()
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, stIDs...)
+	}
+	return ids, nil
+}
@@ -0,0 +1,207 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/blob"
+)
+
+// DirStore keeps every blob in its own directory under BasePath. The
+// directory is named after the blob ID and holds the state file
+// (common.BlobStateFileName) and the data file (common.BlobDataFileName).
+// Errors of the file system are returned as they are, naming the file, so
+// that they can be told apart from states that can't be parsed.
+type DirStore struct {
+	BasePath string
+}
+
+// NewDirStore returns a DirStore rooted at basePath, creating the directory if
+// it does not exist yet.
+func NewDirStore(basePath string) (*DirStore, error) {
+	if basePath == "" {
+		basePath = common.DataDirBasePath
+	}
+	if err := os.MkdirAll(basePath, 0755); err != nil {
+		return nil, fmt.Errorf("Could not create data directory %s: %s", basePath, err)
+	}
+	return &DirStore{BasePath: basePath}, nil
+}
+
+// BlobDir returns the directory holding the state and data of blob id.
+func (s *DirStore) BlobDir(id uint16) string {
+	return filepath.Join(s.BasePath, strconv.Itoa(int(id)))
+}
+
+func (s *DirStore) PutState(bb *blob.Blob) error {
+	blobDir := s.BlobDir(bb.ID)
+	if err := os.MkdirAll(blobDir, 0777); err != nil {
+		return err
+	}
+
+	// the state is written aside and renamed over the old one so readers
+	// never see a half written state file
+	stateFilePath := filepath.Join(blobDir, common.BlobStateFileName)
+	tmpFilePath := stateFilePath + ".tmp"
+	f, err := os.Create(tmpFilePath)
+	if err != nil {
+		return err
+	}
+
+	fw := bufio.NewWriter(f)
+	if err := WriteState(fw, bb); err != nil {
+		f.Close()
+		return err
+	}
+	if err := fw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilePath, stateFilePath)
+}
+
+func (s *DirStore) GetState(id uint16) (*blob.Blob, error) {
+	stateFilePath := filepath.Join(s.BlobDir(id), common.BlobStateFileName)
+	strBlob, err := ReadStateFile(stateFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if strBlob == "" {
+		return nil, fmt.Errorf("no blob state found in %q", stateFilePath)
+	}
+	return blob.ParseBlob(strBlob)
+}
+
+func (s *DirStore) PutData(id uint16, r io.Reader) (int64, error) {
+	blobDir := s.BlobDir(id)
+	if err := os.MkdirAll(blobDir, 0777); err != nil {
+		return 0, err
+	}
+	dataFilePath := filepath.Join(blobDir, common.BlobDataFileName)
+
+	f, err := os.Create(dataFilePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	fw := bufio.NewWriter(f)
+	n, err := io.Copy(fw, r)
+	if err != nil {
+		return n, err
+	}
+	return n, fw.Flush()
+}
+
+func (s *DirStore) GetData(id uint16) (io.ReadCloser, error) {
+	dataFilePath := filepath.Join(s.BlobDir(id), common.BlobDataFileName)
+	f, err := os.Open(dataFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *DirStore) Delete(id uint16) error {
+	blobDir := s.BlobDir(id)
+	return os.RemoveAll(blobDir)
+}
+
+func (s *DirStore) List() ([]uint16, error) {
+	dirFiles, err := ioutil.ReadDir(s.BasePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read data directory %q: %s", s.BasePath, err)
+	}
+	ids := []uint16{}
+	for _, name := range FilterBlobDir(dirFiles) {
+		id, _ := strconv.ParseUint(name, 10, 16)
+		ids = append(ids, uint16(id))
+	}
+	return ids, nil
+}
+
+// FilterBlobDir returns a list of directories' names that possible belong to a blob.
+func FilterBlobDir(dirFiles []os.FileInfo) []string {
+	blobIDs := []string{}
+	for _, file := range dirFiles {
+		if file.IsDir() {
+			if _, err := strconv.ParseUint(file.Name(), 10, 16); err == nil {
+				blobIDs = append(blobIDs, file.Name())
+			}
+		}
+	}
+	return blobIDs
+}
+
+// WriteState writes bb in the state file format: a single line made of
+// common.BlobStateFilePrefix, the version and the base64 encoded blob,
+// followed by the blob's status log for debugability.
+func WriteState(w io.Writer, bb *blob.Blob) error {
+	bbStr64, err := bb.Base64()
+	if err != nil {
+		return fmt.Errorf("Unable to create a base64: %s", err)
+	}
+	fmt.Fprintf(w, "%s%s:%s\n", common.BlobStateFilePrefix, common.Version, bbStr64)
+	fmt.Fprint(w, "\n")
+
+	// We dump status log primarily for debugability.
+	fmt.Fprint(w, bb.Status.DumpLog())
+
+	_, err = fmt.Fprint(w, "\n")
+	return err
+}
+
+// ReadStateFile returns the line containing a Blob's state
+func ReadStateFile(stateFilePath string) (string, error) {
+	f, err := os.Open(stateFilePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return ReadState(f)
+}
+
+// ReadState returns the line containing a Blob's state from r.
+func ReadState(r io.Reader) (string, error) {
+	br := bufio.NewReader(r)
+	for {
+		s, err := br.ReadString('\n')
+		if err == io.EOF {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if strings.Contains(s, common.BlobStateFilePrefix) {
+			return s, nil
+		}
+	}
+}
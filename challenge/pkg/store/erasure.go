@@ -0,0 +1,386 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/blob"
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/erasure"
+)
+
+const (
+	ecDirName     = "ec"
+	scrubInterval = 600 // in seconds
+)
+
+// ECStore stores the data of each blob as Reed-Solomon shards spread round
+// robin over several data directories, so that losing up to Parity shards
+// (or the directories holding them) loses nothing. Every directory keeps a
+// copy of the blob's state, which records the shard layout and checksums.
+// Reads rebuild missing or corrupt shards on the fly and a background
+// scrubber writes them back. A write only succeeds once all but Parity-1
+// shards are written, so that the blob can still lose one.
+type ECStore struct {
+	Paths []string
+
+	enc    *erasure.Encoder
+	dirs   []*DirStore
+	mu     sync.Mutex
+	shards map[uint16]*blob.ShardInfo
+}
+
+// NewECStore returns an ECStore over paths using the given scheme, e.g.
+// "6+3", and starts its scrubber.
+func NewECStore(paths []string, scheme string) (*ECStore, error) {
+	data, parity, err := erasure.ParseScheme(scheme)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := erasure.New(data, parity)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("erasure coding needs at least one data directory")
+	}
+	if len(paths) < enc.Shards() {
+		log.Warningf("Only %d data directories for %d shards, losing a directory loses several shards", len(paths), enc.Shards())
+	}
+
+	s := &ECStore{
+		Paths:  paths,
+		enc:    enc,
+		shards: make(map[uint16]*blob.ShardInfo),
+	}
+	for _, path := range paths {
+		ds, err := NewDirStore(filepath.Join(path, ecDirName))
+		if err != nil {
+			return nil, err
+		}
+		s.dirs = append(s.dirs, ds)
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	go s.scrubber()
+	return s, nil
+}
+
+// open loads the shard layout of every blob, taking the most recent state
+// when the copies disagree.
+func (s *ECStore) open() error {
+	ids, err := s.List()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		bb, err := s.GetState(id)
+		if err != nil {
+			log.Warningf("Unable to read state of blob %d: %s", id, err)
+			continue
+		}
+		if bb.Shards != nil {
+			s.shards[id] = bb.Shards
+		}
+	}
+	return nil
+}
+
+func (s *ECStore) shardPath(info *blob.ShardInfo, id uint16, i int) string {
+	return filepath.Join(s.dirs[info.Dirs[i]].BlobDir(id), fmt.Sprintf("shard.%d", i))
+}
+
+func (s *ECStore) PutState(bb *blob.Blob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.putState(bb)
+}
+
+// putState writes bb, with the shard layout we know of, to every data
+// directory. It only fails if no copy could be written. Must be called with
+// mu held.
+func (s *ECStore) putState(bb *blob.Blob) error {
+	cpy := bb.DeepCopy()
+	cpy.Shards = nil
+	if info, ok := s.shards[bb.ID]; ok {
+		cpy.Shards = info.DeepCopy()
+	}
+	var lastErr error
+	written := 0
+	for _, ds := range s.dirs {
+		if err := ds.PutState(cpy); err != nil {
+			log.Warningf("Unable to write state of blob %d to %s: %s", bb.ID, ds.BasePath, err)
+			lastErr = err
+			continue
+		}
+		written++
+	}
+	if written == 0 {
+		return lastErr
+	}
+	return nil
+}
+
+func (s *ECStore) GetState(id uint16) (*blob.Blob, error) {
+	var best *blob.Blob
+	var lastErr error = ErrNotFound
+	for _, ds := range s.dirs {
+		bb, err := ds.GetState(id)
+		if err != nil {
+			if err != ErrNotFound {
+				lastErr = err
+			}
+			continue
+		}
+		if best == nil || bb.Status != nil && best.Status != nil &&
+			bb.Status.LastUpdate().After(best.Status.LastUpdate()) {
+			best = bb
+		}
+	}
+	if best == nil {
+		return nil, lastErr
+	}
+	return best, nil
+}
+
+func (s *ECStore) PutData(id uint16, r io.Reader) (int64, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return int64(len(buf)), err
+	}
+	shards := s.enc.Split(buf)
+	if err := s.enc.Encode(shards); err != nil {
+		return 0, err
+	}
+
+	info := &blob.ShardInfo{
+		Data:      s.enc.Data,
+		Parity:    s.enc.Parity,
+		Size:      int64(len(buf)),
+		Checksums: make([]uint32, len(shards)),
+		Dirs:      make([]int, len(shards)),
+	}
+	written := 0
+	for i, shard := range shards {
+		info.Dirs[i] = i % len(s.dirs)
+		info.Checksums[i] = crc32.ChecksumIEEE(shard)
+		if err := writeShard(s.shardPath(info, id, i), shard); err != nil {
+			log.Warningf("Unable to write shard %d of blob %d: %s", i, id, err)
+			continue
+		}
+		written++
+	}
+	// with only Data shards the blob would be lost with the next one
+	need := s.enc.Data + 1
+	if need > len(shards) {
+		need = len(shards)
+	}
+	if written < need {
+		return 0, fmt.Errorf("only %d of %d shards of blob %d written, need %d", written, len(shards), id, need)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shards[id] = info
+	if bb, err := s.GetState(id); err == nil {
+		if err := s.putState(bb); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(buf)), nil
+}
+
+// writeShard writes a shard aside and renames it in place.
+func writeShard(path string, shard []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, shard, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// readShards returns the shards of blob id, with nil for every missing or
+// corrupt one, and the indexes of those.
+func (s *ECStore) readShards(id uint16, info *blob.ShardInfo) ([][]byte, []int) {
+	shards := make([][]byte, len(info.Checksums))
+	bad := []int{}
+	for i := range shards {
+		shard, err := ioutil.ReadFile(s.shardPath(info, id, i))
+		if err != nil {
+			log.Debugf("Shard %d of blob %d is missing: %s", i, id, err)
+			bad = append(bad, i)
+			continue
+		}
+		if crc32.ChecksumIEEE(shard) != info.Checksums[i] {
+			log.Warningf("Shard %d of blob %d is corrupt", i, id)
+			bad = append(bad, i)
+			continue
+		}
+		shards[i] = shard
+	}
+	return shards, bad
+}
+
+func (s *ECStore) GetData(id uint16) (io.ReadCloser, error) {
+	s.mu.Lock()
+	info, ok := s.shards[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	shards, bad := s.readShards(id, info)
+	if len(bad) > 0 {
+		if err := s.enc.Reconstruct(shards); err != nil {
+			return nil, fmt.Errorf("unable to reconstruct blob %d: %s", id, err)
+		}
+	}
+	buf, err := s.enc.Join(shards, info.Size)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(buf)), nil
+}
+
+func (s *ECStore) Delete(id uint16) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var lastErr error
+	for _, ds := range s.dirs {
+		if err := ds.Delete(id); err != nil {
+			lastErr = err
+		}
+	}
+	delete(s.shards, id)
+	return lastErr
+}
+
+func (s *ECStore) List() ([]uint16, error) {
+	seen := make(map[uint16]bool)
+	ids := []uint16{}
+	listed := 0
+	for _, ds := range s.dirs {
+		dirIDs, err := ds.List()
+		if err != nil {
+			log.Warningf("Unable to list %s: %s", ds.BasePath, err)
+			continue
+		}
+		listed++
+		for _, id := range dirIDs {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	if listed == 0 {
+		return nil, fmt.Errorf("unable to list any data directory")
+	}
+	return ids, nil
+}
+
+func (s *ECStore) scrubber() {
+	ticker := time.NewTicker(scrubInterval * time.Second)
+	for range ticker.C {
+		s.Scrub()
+	}
+}
+
+// Scrub checks the shards of every blob and re-encodes the missing and
+// corrupt ones. Rebuilt shards are noted in the blob's state.
+func (s *ECStore) Scrub() {
+	s.mu.Lock()
+	ids := make([]uint16, 0, len(s.shards))
+	for id := range s.shards {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+
+	rebuilt := 0
+	for _, id := range ids {
+		n, err := s.scrub(id)
+		if err != nil {
+			log.Errorf("Unable to scrub blob %d: %s", id, err)
+			continue
+		}
+		rebuilt += n
+	}
+	if rebuilt > 0 {
+		log.Infof("Scrubber rebuilt %d shards", rebuilt)
+	}
+}
+
+// scrub repairs the shards of blob id and returns how many it rebuilt. The
+// shards are read without holding mu; if the blob was rewritten in the
+// meantime the repair is dropped.
+func (s *ECStore) scrub(id uint16) (int, error) {
+	s.mu.Lock()
+	info, ok := s.shards[id]
+	s.mu.Unlock()
+	if !ok {
+		return 0, nil
+	}
+
+	shards, bad := s.readShards(id, info)
+	if len(bad) == 0 {
+		return 0, nil
+	}
+	if err := s.enc.Reconstruct(shards); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shards[id] != info {
+		return 0, nil
+	}
+	fixed := info.DeepCopy()
+	rebuilt := []int{}
+	for _, i := range bad {
+		// try the shard's own directory first, then the next ones in
+		// case that one is gone
+		for try := 0; try < len(s.dirs); try++ {
+			fixed.Dirs[i] = (info.Dirs[i] + try) % len(s.dirs)
+			err := writeShard(s.shardPath(fixed, id, i), shards[i])
+			if err == nil {
+				rebuilt = append(rebuilt, i)
+				break
+			}
+			log.Warningf("Unable to rewrite shard %d of blob %d to %s: %s", i, id, s.dirs[fixed.Dirs[i]].BasePath, err)
+			fixed.Dirs[i] = info.Dirs[i]
+		}
+	}
+	if len(rebuilt) == 0 {
+		return 0, fmt.Errorf("no shard could be rewritten")
+	}
+	s.shards[id] = fixed
+
+	bb, err := s.GetState(id)
+	if err != nil {
+		return len(rebuilt), err
+	}
+	bb.LogStatusOK(fmt.Sprintf("Scrubber rebuilt shards %v", rebuilt))
+	return len(rebuilt), s.putState(bb)
+}
@@ -0,0 +1,645 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/blob"
+)
+
+const (
+	logDirName        = "log"
+	logDataFileSuffix = ".data"
+	logHintFileSuffix = ".hint"
+	logMergeFileName  = "MERGED"
+	logFileMaxSize    = 64 << 20
+	mergeGarbageRatio = 0.5
+
+	// a hint payload is the record offset(8) and payload length(4),
+	// followed by the blob location for state records
+	hintFixedSize = 8 + 4
+)
+
+// logFile is a log data file. The hint of every record appended to it is
+// buffered in hints and written out to the hint file once the file is
+// sealed.
+type logFile struct {
+	segment
+	hints bytes.Buffer
+}
+
+// keydirEntry points to the latest state and data records of a blob.
+type keydirEntry struct {
+	id       uint16
+	location string
+	state    *recordLoc
+	data     *recordLoc
+}
+
+// LogStore is a log-structured (Bitcask-like) store. Every state change,
+// data write and delete is appended to the active log file. An in-memory
+// keydir maps each location to the file and offset of its latest records.
+// Sealed files get a hint file listing their records so that startup does
+// not need to read the data back. Failed blobs are deleted right away by
+// appending a tombstone, and Merge rewrites the sealed files to reclaim the
+// space of replaced and deleted records. The files a merge replaced are
+// listed in a MERGED file until they are all gone, so a crash half way
+// through removing them can't bring deleted records back.
+type LogStore struct {
+	BasePath string
+
+	mu       sync.RWMutex
+	seq      uint64
+	nextFile uint32
+	files    map[uint32]*logFile
+	active   *logFile
+	keydir   map[string]*keydirEntry
+	byID     map[uint16]*keydirEntry
+}
+
+// NewLogStore opens (or creates) the log store rooted at basePath.
+func NewLogStore(basePath string) (*LogStore, error) {
+	s := &LogStore{
+		BasePath: filepath.Join(basePath, logDirName),
+		files:    make(map[uint32]*logFile),
+		keydir:   make(map[string]*keydirEntry),
+		byID:     make(map[uint16]*keydirEntry),
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *LogStore) filePath(num uint32, suffix string) string {
+	return filepath.Join(s.BasePath, fmt.Sprintf("%08d%s", num, suffix))
+}
+
+// logEntry is a record found while opening the store.
+type logEntry struct {
+	h        recordHeader
+	loc      recordLoc
+	location string
+}
+
+// open rebuilds the keydir from the hint files, or from the log files
+// themselves when a file has no hint yet.
+func (s *LogStore) open() error {
+	if err := os.MkdirAll(s.BasePath, 0755); err != nil {
+		return fmt.Errorf("Could not create log directory: %s", err)
+	}
+	if err := s.finishMerge(); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(s.BasePath)
+	if err != nil {
+		return err
+	}
+	nums := []int{}
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasSuffix(name, logDataFileSuffix) {
+			continue
+		}
+		if num, err := strconv.ParseUint(strings.TrimSuffix(name, logDataFileSuffix), 10, 32); err == nil {
+			nums = append(nums, int(num))
+		}
+	}
+	sort.Ints(nums)
+
+	entries := []logEntry{}
+	scanned := []*logFile{}
+	for i, num := range nums {
+		lf, err := s.openFile(uint32(num))
+		if err != nil {
+			return err
+		}
+		fileEntries, err := s.readHints(lf)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Warningf("Unable to use hints of log file %d, scanning it: %s", num, err)
+			}
+			if fileEntries, err = s.scanFile(lf, i < len(nums)-1); err != nil {
+				return err
+			}
+			scanned = append(scanned, lf)
+		}
+		entries = append(entries, fileEntries...)
+	}
+
+	// only the last file can be appended to, and only if it was never
+	// sealed; any other file without hints gets them now
+	for _, lf := range scanned {
+		if lf.num == s.nextFile-1 {
+			s.active = lf
+			continue
+		}
+		if err := s.seal(lf); err != nil {
+			return err
+		}
+	}
+
+	// merged files may hold older records than the files before them, so
+	// replay strictly in write order
+	sort.Sort(bySeq(entries))
+	for _, e := range entries {
+		s.apply(e.h, e.loc, e.location)
+	}
+
+	if s.active == nil || s.active.size >= logFileMaxSize {
+		if err := s.roll(); err != nil {
+			return err
+		}
+	}
+	log.Infof("Opened %d log files holding %d blobs", len(s.files), len(s.byID))
+	return nil
+}
+
+// finishMerge removes the log files listed in the MERGED file, oldest first,
+// and then the MERGED file itself.
+func (s *LogStore) finishMerge() error {
+	path := filepath.Join(s.BasePath, logMergeFileName)
+	list, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not read merged log files: %s", err)
+	}
+	for _, line := range strings.Fields(string(list)) {
+		num, err := strconv.ParseUint(line, 10, 32)
+		if err != nil {
+			return fmt.Errorf("corrupt list of merged log files: %s", err)
+		}
+		os.Remove(s.filePath(uint32(num), logHintFileSuffix))
+		if err := os.Remove(s.filePath(uint32(num), logDataFileSuffix)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not remove log file %d: %s", num, err)
+		}
+	}
+	if err := syncDir(s.BasePath); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+type bySeq []logEntry
+
+func (a bySeq) Len() int           { return len(a) }
+func (a bySeq) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a bySeq) Less(i, j int) bool { return a[i].h.seq < a[j].h.seq }
+
+func (s *LogStore) openFile(num uint32) (*logFile, error) {
+	f, err := os.OpenFile(s.filePath(num, logDataFileSuffix), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open log file %d: %s", num, err)
+	}
+	lf := &logFile{segment: segment{num: num, f: f}}
+	if fi, err := f.Stat(); err == nil {
+		lf.size = fi.Size()
+	}
+	s.files[num] = lf
+	if num >= s.nextFile {
+		s.nextFile = num + 1
+	}
+	return lf, nil
+}
+
+// readHints returns the records of lf as listed in its hint file.
+func (s *LogStore) readHints(lf *logFile) ([]logEntry, error) {
+	hf, err := os.Open(s.filePath(lf.num, logHintFileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	defer hf.Close()
+
+	entries := []logEntry{}
+	size := fileSize(hf)
+	end, err := scanRecords(hf, size, false, func(h recordHeader, off int64, payload []byte) error {
+		if len(payload) < hintFixedSize {
+			return errCorruptRecord
+		}
+		e := logEntry{h: h}
+		e.loc = recordLoc{
+			file: lf.num,
+			off:  int64(binary.BigEndian.Uint64(payload[0:])),
+			size: binary.BigEndian.Uint32(payload[8:]),
+			seq:  h.seq,
+		}
+		e.h.size = e.loc.size
+		e.location = string(payload[hintFixedSize:])
+		entries = append(entries, e)
+		return nil
+	})
+	if err == nil && end != size {
+		// hint files are written in one go, any damage means the hints
+		// can't be trusted
+		err = errCorruptRecord
+	}
+	return entries, err
+}
+
+// scanFile returns the records of lf by reading the whole file. Damaged
+// records of a sealed file are skipped; the last file may have been written
+// to when we went down, so a torn write at its tail is truncated away.
+func (s *LogStore) scanFile(lf *logFile, sealed bool) ([]logEntry, error) {
+	entries := []logEntry{}
+	end, err := scanRecords(lf.f, fileSize(lf.f), sealed, func(h recordHeader, off int64, payload []byte) error {
+		e := logEntry{h: h, loc: recordLoc{file: lf.num, off: off, size: h.size, seq: h.seq}}
+		if h.kind == recState {
+			var bb blob.Blob
+			if err := blob.ParseBase64ToBlob(string(payload), &bb); err != nil {
+				return fmt.Errorf("corrupt state of blob %d: %s", h.id, err)
+			}
+			e.location = bb.Location
+		}
+		entries = append(entries, e)
+		lf.hints.Write(hintRecord(h, e.loc, e.location))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error while reading log file %d: %s", lf.num, err)
+	}
+	if !sealed {
+		if err := lf.f.Truncate(end); err != nil {
+			return nil, err
+		}
+	}
+	lf.size = end
+	return entries, nil
+}
+
+func hintRecord(h recordHeader, loc recordLoc, location string) []byte {
+	payload := make([]byte, hintFixedSize+len(location))
+	binary.BigEndian.PutUint64(payload[0:], uint64(loc.off))
+	binary.BigEndian.PutUint32(payload[8:], loc.size)
+	copy(payload[hintFixedSize:], location)
+	return encodeRecord(h, payload)
+}
+
+// apply updates the keydir with the record at loc. Must be called with mu
+// held.
+func (s *LogStore) apply(h recordHeader, loc recordLoc, location string) {
+	if h.seq > s.seq {
+		s.seq = h.seq
+	}
+	e := s.byID[h.id]
+
+	switch h.kind {
+	case recDelete:
+		// the tombstone itself is garbage as soon as it's written, it
+		// only has to survive until the next merge
+		s.markDead(loc)
+		if e == nil {
+			return
+		}
+		s.markDead(*e.state)
+		if e.data != nil {
+			s.markDead(*e.data)
+		}
+		delete(s.byID, h.id)
+		if s.keydir[e.location] == e {
+			delete(s.keydir, e.location)
+		}
+		return
+	case recState:
+		if e == nil {
+			e = &keydirEntry{id: h.id}
+			s.byID[h.id] = e
+		}
+		if e.state != nil {
+			s.markDead(*e.state)
+		}
+		if e.location != location && s.keydir[e.location] == e {
+			delete(s.keydir, e.location)
+		}
+		e.location = location
+		e.state = &loc
+		s.keydir[location] = e
+	case recData:
+		if e == nil {
+			// data without state, the state record was lost
+			s.markDead(loc)
+			return
+		}
+		if e.data != nil {
+			s.markDead(*e.data)
+		}
+		e.data = &loc
+	}
+}
+
+func (s *LogStore) markDead(loc recordLoc) {
+	if lf, ok := s.files[loc.file]; ok {
+		lf.dead += loc.recordLen()
+	}
+}
+
+// seal writes out the hint file of lf. Must be called with mu held.
+func (s *LogStore) seal(lf *logFile) error {
+	// hints are written aside and renamed so a crash never leaves a
+	// truncated hint file behind
+	if err := writeFileSync(s.filePath(lf.num, logHintFileSuffix), lf.hints.Bytes()); err != nil {
+		return fmt.Errorf("Could not write hint file of log file %d: %s", lf.num, err)
+	}
+	lf.hints.Reset()
+	return nil
+}
+
+// writeFileSync writes data to a temporary file, flushes it to disk and
+// renames it to path.
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes the entries of directory path to disk.
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// newFile creates an empty log file. Must be called with mu held.
+func (s *LogStore) newFile() (*logFile, error) {
+	return s.openFile(s.nextFile)
+}
+
+// roll seals the active file and starts a new one. Must be called with mu
+// held.
+func (s *LogStore) roll() error {
+	if s.active != nil {
+		if err := s.seal(s.active); err != nil {
+			return err
+		}
+	}
+	lf, err := s.newFile()
+	if err != nil {
+		return err
+	}
+	s.active = lf
+	return nil
+}
+
+// appendTo appends a record to lf and buffers its hint. A zero h.seq gets
+// the next sequence number. Must be called with mu held.
+func (s *LogStore) appendTo(lf *logFile, h recordHeader, payload []byte, location string) (recordLoc, error) {
+	if h.seq == 0 {
+		s.seq++
+		h.seq = s.seq
+	}
+	buf := encodeRecord(h, payload)
+	if _, err := lf.f.WriteAt(buf, lf.size); err != nil {
+		// as is, naming the file, so that MultiStore sees an I/O error
+		return recordLoc{}, err
+	}
+	loc := recordLoc{file: lf.num, off: lf.size, size: uint32(len(payload)), seq: h.seq}
+	lf.size += int64(len(buf))
+	lf.hints.Write(hintRecord(h, loc, location))
+	return loc, nil
+}
+
+// write appends a record to the active file and applies it to the keydir.
+// Must be called with mu held.
+func (s *LogStore) write(h recordHeader, payload []byte, location string) error {
+	if s.active.size >= logFileMaxSize {
+		if err := s.roll(); err != nil {
+			return err
+		}
+	}
+	loc, err := s.appendTo(s.active, h, payload, location)
+	if err != nil {
+		return err
+	}
+	h.seq = loc.seq
+	s.apply(h, loc, location)
+	return nil
+}
+
+func (s *LogStore) readPayload(loc recordLoc) ([]byte, error) {
+	lf, ok := s.files[loc.file]
+	if !ok {
+		return nil, fmt.Errorf("log file %d not found", loc.file)
+	}
+	_, payload, err := readRecord(lf.f, loc.off, lf.size, true)
+	return payload, err
+}
+
+// PutState appends the state of bb to the log. A blob in Failure state is
+// not kept around for gc: a tombstone is written instead.
+func (s *LogStore) PutState(bb *blob.Blob) error {
+	if bb.Status != nil && bb.Status.LastStatus() == blob.Failure {
+		return s.Delete(bb.ID)
+	}
+
+	bbStr64, err := bb.Base64()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(recordHeader{kind: recState, id: bb.ID}, []byte(bbStr64), bb.Location)
+}
+
+func (s *LogStore) GetState(id uint16) (*blob.Blob, error) {
+	s.mu.RLock()
+	e, ok := s.byID[id]
+	if !ok {
+		s.mu.RUnlock()
+		return nil, ErrNotFound
+	}
+	payload, err := s.readPayload(*e.state)
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("Error while reading state of blob %d: %s", id, err)
+	}
+
+	var bb blob.Blob
+	if err := blob.ParseBase64ToBlob(string(payload), &bb); err != nil {
+		return nil, err
+	}
+	return &bb, nil
+}
+
+func (s *LogStore) PutData(id uint16, r io.Reader) (int64, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return int64(len(buf)), err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byID[id]; !ok {
+		return 0, ErrNotFound
+	}
+	return int64(len(buf)), s.write(recordHeader{kind: recData, id: id}, buf, "")
+}
+
+func (s *LogStore) GetData(id uint16) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.byID[id]
+	if !ok || e.data == nil {
+		return nil, ErrNotFound
+	}
+	payload, err := s.readPayload(*e.data)
+	if err != nil {
+		return nil, fmt.Errorf("Error while reading data of blob %d: %s", id, err)
+	}
+	return ioutil.NopCloser(bytes.NewReader(payload)), nil
+}
+
+func (s *LogStore) Delete(id uint16) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byID[id]; !ok {
+		return nil
+	}
+	return s.write(recordHeader{kind: recDelete, id: id}, nil, "")
+}
+
+func (s *LogStore) List() ([]uint16, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]uint16, 0, len(s.byID))
+	for id := range s.byID {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Merge rewrites the live records of all sealed log files into new files,
+// with their hint files, and removes the old ones. Tombstones are dropped on
+// the way: every record they could shadow lives in a sealed file and goes
+// away with it. Since a merged file is numbered after the active file, the
+// old files can't be removed in an order that never leaves a shadowed record
+// without its tombstone; the new files are flushed to disk and the old ones
+// listed in the MERGED file first, and open finishes the removal if we go
+// down half way. Nothing is done until at least mergeGarbageRatio of the
+// sealed bytes are garbage.
+func (s *LogStore) Merge() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var total, dead int64
+	sealed := map[uint32]*logFile{}
+	for num, lf := range s.files {
+		if lf == s.active {
+			continue
+		}
+		sealed[num] = lf
+		total += lf.size
+		dead += lf.dead
+	}
+	if total == 0 || float64(dead)/float64(total) < mergeGarbageRatio {
+		return nil
+	}
+	log.Debugf("Merging %d log files (%d of %d bytes are garbage)", len(sealed), dead, total)
+
+	var out *logFile
+	outs := []*logFile{}
+	copyRecord := func(kind recordKind, id uint16, loc *recordLoc, location string) error {
+		if _, ok := sealed[loc.file]; !ok {
+			return nil
+		}
+		payload, err := s.readPayload(*loc)
+		if err != nil {
+			return err
+		}
+		if out == nil || out.size >= logFileMaxSize {
+			if out != nil {
+				if err := s.seal(out); err != nil {
+					return err
+				}
+			}
+			if out, err = s.newFile(); err != nil {
+				return err
+			}
+			outs = append(outs, out)
+		}
+		newLoc, err := s.appendTo(out, recordHeader{kind: kind, id: id, seq: loc.seq}, payload, location)
+		if err != nil {
+			return err
+		}
+		*loc = newLoc
+		return nil
+	}
+	for _, e := range s.byID {
+		if err := copyRecord(recState, e.id, e.state, e.location); err != nil {
+			return err
+		}
+		if e.data != nil {
+			if err := copyRecord(recData, e.id, e.data, ""); err != nil {
+				return err
+			}
+		}
+	}
+	if out != nil {
+		if err := s.seal(out); err != nil {
+			return err
+		}
+	}
+	for _, lf := range outs {
+		if err := lf.f.Sync(); err != nil {
+			return fmt.Errorf("could not flush log file %d: %s", lf.num, err)
+		}
+	}
+
+	nums := []int{}
+	for num, lf := range sealed {
+		lf.f.Close()
+		delete(s.files, num)
+		nums = append(nums, int(num))
+	}
+	sort.Ints(nums)
+	var list bytes.Buffer
+	for _, num := range nums {
+		fmt.Fprintf(&list, "%d\n", num)
+	}
+	if err := writeFileSync(filepath.Join(s.BasePath, logMergeFileName), list.Bytes()); err != nil {
+		return fmt.Errorf("could not record merged log files: %s", err)
+	}
+	if err := s.finishMerge(); err != nil {
+		return err
+	}
+	log.Infof("Merged %d log files, reclaimed %d bytes", len(sealed), dead)
+	return nil
+}
@@ -0,0 +1,195 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/blob"
+)
+
+func putBlob(t *testing.T, s Store, id uint16, data string) {
+	bb := &blob.Blob{ID: id, Location: string('a' + rune(id)), Status: &blob.BlobStatus{}}
+	bb.LogStatusOK("test")
+	if err := s.PutState(bb); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PutData(id, strings.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkBlobs(t *testing.T, name string, s Store, expected map[uint16]string) {
+	ids, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != len(expected) {
+		t.Errorf("%s: got blobs %v, expected %d blobs", name, ids, len(expected))
+	}
+	for id, data := range expected {
+		rc, err := s.GetData(id)
+		if err != nil {
+			t.Errorf("%s: blob %d: %s", name, id, err)
+			continue
+		}
+		got, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if string(got) != data {
+			t.Errorf("%s: blob %d holds %q, expected %q", name, id, got, data)
+		}
+	}
+}
+
+// fillLog writes blobs 1 to 4 to the first log file, deletes blob 2 and
+// rewrites blob 3 in the second one, and leaves a third one active.
+func fillLog(t *testing.T, dir string) *LogStore {
+	s, err := NewLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putBlob(t, s, 1, "one")
+	putBlob(t, s, 2, strings.Repeat("two", 1000))
+	putBlob(t, s, 3, "three")
+	putBlob(t, s, 4, "four")
+	s.mu.Lock()
+	s.roll()
+	s.mu.Unlock()
+	if err := s.Delete(2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PutData(3, strings.NewReader("three again")); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	s.roll()
+	s.mu.Unlock()
+	return s
+}
+
+var mergedBlobs = map[uint16]string{1: "one", 3: "three again", 4: "four"}
+
+func TestLogStoreMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := fillLog(t, dir)
+	if err := s.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	checkBlobs(t, "merged", s, mergedBlobs)
+	for _, num := range []uint32{0, 1} {
+		if _, err := os.Stat(s.filePath(num, logDataFileSuffix)); !os.IsNotExist(err) {
+			t.Errorf("log file %d was not removed", num)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(s.BasePath, logMergeFileName)); !os.IsNotExist(err) {
+		t.Errorf("%s was not removed", logMergeFileName)
+	}
+
+	s, err = NewLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBlobs(t, "reopened", s, mergedBlobs)
+}
+
+// A crash while the merged files are being removed must not bring blob 2
+// back, whichever of them are still around.
+func TestLogStoreMergeCrash(t *testing.T) {
+	tests := []struct {
+		name string
+		left []uint32 // old log files left behind
+	}{
+		{"none removed", []uint32{0, 1}},
+		{"tombstone removed", []uint32{0}},
+		{"first removed", []uint32{1}},
+	}
+
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "logstore")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		s := fillLog(t, dir)
+		saved := map[string][]byte{}
+		for _, num := range tt.left {
+			for _, suffix := range []string{logDataFileSuffix, logHintFileSuffix} {
+				path := s.filePath(num, suffix)
+				data, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				saved[path] = data
+			}
+		}
+		if err := s.Merge(); err != nil {
+			t.Fatal(err)
+		}
+		for path, data := range saved {
+			if err := ioutil.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ioutil.WriteFile(filepath.Join(s.BasePath, logMergeFileName), []byte("0\n1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		s, err = NewLogStore(dir)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		checkBlobs(t, tt.name, s, mergedBlobs)
+	}
+}
+
+func TestLogStoreScanCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := fillLog(t, dir)
+	// damage blob 1 in the first file and drop the hints so it gets
+	// scanned; the records after it must survive
+	path := s.filePath(0, logDataFileSuffix)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[recordHeaderSize] ^= 0xff
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(s.filePath(0, logHintFileSuffix))
+
+	s, err = NewLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBlobs(t, "damaged", s, map[uint16]string{3: "three again", 4: "four"})
+	if data2, _ := ioutil.ReadFile(path); !bytes.Equal(data, data2) {
+		t.Errorf("sealed log file was modified")
+	}
+}
@@ -0,0 +1,104 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package store
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/blob"
+)
+
+// MemStore keeps all blobs in memory. Nothing survives a restart, which makes
+// it handy for tests.
+type MemStore struct {
+	mu     sync.RWMutex
+	states map[uint16]string // base64 encoded blobs
+	data   map[uint16][]byte
+}
+
+// NewMemStore returns an empty MemStore.
+func NewMemStore() *MemStore {
+	return &MemStore{
+		states: make(map[uint16]string),
+		data:   make(map[uint16][]byte),
+	}
+}
+
+func (s *MemStore) PutState(bb *blob.Blob) error {
+	// we keep the encoded form so later changes to bb don't leak into the store
+	bbStr64, err := bb.Base64()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.states[bb.ID] = bbStr64
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemStore) GetState(id uint16) (*blob.Blob, error) {
+	s.mu.RLock()
+	bbStr64, ok := s.states[id]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var bb blob.Blob
+	if err := blob.ParseBase64ToBlob(bbStr64, &bb); err != nil {
+		return nil, err
+	}
+	return &bb, nil
+}
+
+func (s *MemStore) PutData(id uint16, r io.Reader) (int64, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return int64(len(buf)), err
+	}
+	s.mu.Lock()
+	s.data[id] = buf
+	s.mu.Unlock()
+	return int64(len(buf)), nil
+}
+
+func (s *MemStore) GetData(id uint16) (io.ReadCloser, error) {
+	s.mu.RLock()
+	buf, ok := s.data[id]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	// buf is never modified in place, PutData always swaps in a new slice
+	return ioutil.NopCloser(bytes.NewReader(buf)), nil
+}
+
+func (s *MemStore) Delete(id uint16) error {
+	s.mu.Lock()
+	delete(s.states, id)
+	delete(s.data, id)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemStore) List() ([]uint16, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]uint16, 0, len(s.states))
+	for id := range s.states {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,497 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package store
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/blob"
+)
+
+const (
+	// PlaceBySpace puts new blobs on the data directory with the most free
+	// space.
+	PlaceBySpace = "space"
+	// PlaceByHash puts new blobs on a data directory picked by hashing the
+	// blob's location.
+	PlaceByHash = "hash"
+
+	probeInterval = 60 // in seconds
+	probeFileName = ".probe"
+	mirrorDirName = ".mirror"
+)
+
+// dataDir is one of the data directories of a MultiStore, usually one per
+// disk.
+type dataDir struct {
+	path   string
+	st     Store
+	failed error // set once an I/O error was seen on the directory
+
+	// copy of the states of the blobs of the directory, kept in the next
+	// one, nil if it could not be opened
+	mirror Store
+	buddy  *dataDir
+}
+
+// MultiStore spreads blobs over several data directories, each with its own
+// store. New blobs are placed by free space or by hashing their location. A
+// directory is marked as failed on the first I/O error: new blobs avoid it
+// and the data of the blobs it holds returns an error until a probe finds
+// the directory usable again. The states of the blobs of each directory are
+// mirrored in the next one, so that blobs on a directory that fails to load
+// are still known, and report the failure, after a restart.
+type MultiStore struct {
+	Placement string
+
+	open  func(path string) (Store, error)
+	mu    sync.RWMutex
+	dirs  []*dataDir
+	where map[uint16]*dataDir
+}
+
+// NewMultiStore returns a MultiStore over the given data directories, using
+// open to build the store of each one. A directory whose store can't be
+// opened starts out failed.
+func NewMultiStore(paths []string, placement string, open func(path string) (Store, error)) (*MultiStore, error) {
+	switch placement {
+	case "":
+		placement = PlaceBySpace
+	case PlaceBySpace, PlaceByHash:
+	default:
+		return nil, fmt.Errorf("Unknown placement %q", placement)
+	}
+
+	s := &MultiStore{
+		Placement: placement,
+		open:      open,
+		where:     make(map[uint16]*dataDir),
+	}
+	for _, path := range paths {
+		s.dirs = append(s.dirs, &dataDir{path: path})
+	}
+	for i, dd := range s.dirs {
+		if len(s.dirs) == 1 {
+			break
+		}
+		dd.buddy = s.dirs[(i+1)%len(s.dirs)]
+		mirror, err := open(mirrorPath(dd))
+		if err != nil {
+			log.Warningf("Unable to open the mirror of %s in %s: %s", dd.path, dd.buddy.path, err)
+		} else {
+			dd.mirror = mirror
+		}
+	}
+	for _, dd := range s.dirs {
+		if err := s.load(dd); err != nil {
+			s.fail(dd, err)
+			s.loadMirror(dd)
+		}
+	}
+	go s.prober()
+	return s, nil
+}
+
+// load opens the store of dd if needed and registers the blobs it holds.
+// Must be called with mu held.
+func (s *MultiStore) load(dd *dataDir) error {
+	if dd.st == nil {
+		st, err := s.open(dd.path)
+		if err != nil {
+			return err
+		}
+		dd.st = st
+	}
+	ids, err := dd.st.List()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if other, ok := s.where[id]; ok && other != dd {
+			log.Warningf("Blob %d found in both %s and %s, using the first one", id, other.path, dd.path)
+			continue
+		}
+		s.where[id] = dd
+	}
+	s.syncMirror(dd, ids)
+	return nil
+}
+
+// mirrorPath returns the directory the states of the blobs of dd are
+// mirrored in, named after dd's path in its buddy.
+func mirrorPath(dd *dataDir) string {
+	h := fnv.New32a()
+	h.Write([]byte(dd.path))
+	return filepath.Join(dd.buddy.path, mirrorDirName, fmt.Sprintf("%08x", h.Sum32()))
+}
+
+// syncMirror brings the mirror of dd in line with the blobs ids it holds,
+// after a crash between a write and its mirroring or with states written
+// before there was a mirror.
+func (s *MultiStore) syncMirror(dd *dataDir, ids []uint16) {
+	if dd.mirror == nil {
+		return
+	}
+	mirrored, err := dd.mirror.List()
+	if err != nil {
+		log.Warningf("Unable to list the mirror of %s: %s", dd.path, err)
+		return
+	}
+	held := make(map[uint16]bool, len(ids))
+	for _, id := range ids {
+		held[id] = true
+	}
+	for _, id := range mirrored {
+		if !held[id] {
+			dd.mirror.Delete(id)
+		}
+		delete(held, id)
+	}
+	for id := range held {
+		bb, err := dd.st.GetState(id)
+		if err != nil {
+			continue
+		}
+		if err := dd.mirror.PutState(bb); err != nil {
+			log.Warningf("Unable to mirror the state of blob %d in %s: %s", id, dd.buddy.path, err)
+			return
+		}
+	}
+}
+
+// loadMirror registers the blobs of dd, which failed to load, from its
+// mirror. Their states are read from the mirror until dd is usable again.
+// Must be called with mu held.
+func (s *MultiStore) loadMirror(dd *dataDir) {
+	if dd.mirror == nil {
+		return
+	}
+	ids, err := dd.mirror.List()
+	if err != nil {
+		log.Warningf("Unable to list the mirror of %s: %s", dd.path, err)
+		return
+	}
+	for _, id := range ids {
+		if _, ok := s.where[id]; !ok {
+			s.where[id] = dd
+		}
+	}
+	log.Warningf("Registered %d blobs of failed data directory %s from its mirror", len(ids), dd.path)
+}
+
+// reopenMirrors opens the mirrors kept in dd, usable again, that could not
+// be opened before. Must be called with mu held.
+func (s *MultiStore) reopenMirrors(dd *dataDir) {
+	for _, other := range s.dirs {
+		if other.buddy != dd || other.mirror != nil {
+			continue
+		}
+		mirror, err := s.open(mirrorPath(other))
+		if err != nil {
+			log.Warningf("Unable to open the mirror of %s in %s: %s", other.path, dd.path, err)
+			continue
+		}
+		other.mirror = mirror
+		if other.failed == nil {
+			if ids, err := other.st.List(); err == nil {
+				s.syncMirror(other, ids)
+			}
+		}
+	}
+}
+
+// mirrorState copies the state bb, just written to dd, to its mirror. A
+// failure is not the write's, it only marks the buddy as failed.
+func (s *MultiStore) mirrorState(dd *dataDir, bb *blob.Blob) {
+	if dd.mirror == nil {
+		return
+	}
+	if err := dd.mirror.PutState(bb); err != nil {
+		s.check(dd.buddy, err)
+	}
+}
+
+// Children returns the store of each data directory, and the mirrors.
+func (s *MultiStore) Children() []Store {
+	stores := []Store{}
+	for _, dd := range s.dirs {
+		if dd.st != nil {
+			stores = append(stores, dd.st)
+		}
+		if dd.mirror != nil {
+			stores = append(stores, dd.mirror)
+		}
+	}
+	return stores
+}
+
+// fail marks dd as failed because of err.
+func (s *MultiStore) fail(dd *dataDir, err error) {
+	if dd.failed == nil {
+		log.Errorf("Marking data directory %s as failed: %s", dd.path, err)
+	}
+	dd.failed = err
+}
+
+// check marks dd as failed if err is an I/O error and returns err. Other
+// errors, such as a state file that can't be parsed, only concern the blob.
+func (s *MultiStore) check(dd *dataDir, err error) error {
+	if isIOError(err) {
+		s.mu.Lock()
+		s.fail(dd, err)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// isIOError tells whether err comes from the file system, as opposed to
+// what was read from it.
+func isIOError(err error) bool {
+	switch err.(type) {
+	case *os.PathError, *os.LinkError, *os.SyscallError, syscall.Errno:
+		return true
+	}
+	return false
+}
+
+// dirOf returns the data directory holding blob id, along with an error if
+// that directory has failed.
+func (s *MultiStore) dirOf(id uint16) (*dataDir, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	dd, ok := s.where[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if dd.failed != nil {
+		return dd, fmt.Errorf("blob %d is on failed data directory %s: %s", id, dd.path, dd.failed)
+	}
+	return dd, nil
+}
+
+// sourceReader remembers the error of the reader blob data is copied from,
+// which says nothing about the data directory.
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (sr *sourceReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	if err != nil && err != io.EOF {
+		sr.err = err
+	}
+	return n, err
+}
+
+// place returns the data directory for a new blob. Must be called with mu
+// held.
+func (s *MultiStore) place(location string) (*dataDir, error) {
+	healthy := []*dataDir{}
+	for _, dd := range s.dirs {
+		if dd.failed == nil {
+			healthy = append(healthy, dd)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil, fmt.Errorf("all data directories have failed")
+	}
+
+	if s.Placement == PlaceByHash {
+		h := fnv.New32a()
+		h.Write([]byte(location))
+		return healthy[int(h.Sum32()%uint32(len(healthy)))], nil
+	}
+
+	var best *dataDir
+	var bestFree uint64
+	for _, dd := range healthy {
+		free, err := freeSpace(dd.path)
+		if err != nil {
+			log.Warningf("Unable to get free space of %s: %s", dd.path, err)
+			continue
+		}
+		if best == nil || free > bestFree {
+			best, bestFree = dd, free
+		}
+	}
+	if best == nil {
+		best = healthy[0]
+	}
+	return best, nil
+}
+
+func freeSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+func (s *MultiStore) PutState(bb *blob.Blob) error {
+	s.mu.Lock()
+	dd, ok := s.where[bb.ID]
+	if !ok {
+		var err error
+		if dd, err = s.place(bb.Location); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		s.where[bb.ID] = dd
+	}
+	failed := dd.failed
+	s.mu.Unlock()
+
+	if failed != nil {
+		return fmt.Errorf("blob %d is on failed data directory %s: %s", bb.ID, dd.path, failed)
+	}
+	if err := s.check(dd, dd.st.PutState(bb)); err != nil {
+		return err
+	}
+	s.mirrorState(dd, bb)
+	return nil
+}
+
+// GetState reads the state of blob id from its data directory, or from the
+// mirror of that directory if it has failed: the blob is still known, only
+// its data is not there.
+func (s *MultiStore) GetState(id uint16) (*blob.Blob, error) {
+	dd, err := s.dirOf(id)
+	if err == ErrNotFound {
+		return nil, err
+	}
+	if err != nil {
+		if dd.mirror == nil {
+			return nil, err
+		}
+		return dd.mirror.GetState(id)
+	}
+	bb, err := dd.st.GetState(id)
+	return bb, s.check(dd, err)
+}
+
+func (s *MultiStore) PutData(id uint16, r io.Reader) (int64, error) {
+	dd, err := s.dirOf(id)
+	if err != nil {
+		return 0, err
+	}
+	src := &sourceReader{r: r}
+	n, err := dd.st.PutData(id, src)
+	if err != nil && src.err != nil {
+		// the client or a reader on the way failed, not the directory
+		return n, err
+	}
+	return n, s.check(dd, err)
+}
+
+func (s *MultiStore) GetData(id uint16) (io.ReadCloser, error) {
+	dd, err := s.dirOf(id)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := dd.st.GetData(id)
+	return rc, s.check(dd, err)
+}
+
+func (s *MultiStore) Delete(id uint16) error {
+	dd, err := s.dirOf(id)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.check(dd, dd.st.Delete(id)); err != nil {
+		return err
+	}
+	if dd.mirror != nil {
+		if err := dd.mirror.Delete(id); err != nil {
+			s.check(dd.buddy, err)
+		}
+	}
+	s.mu.Lock()
+	delete(s.where, id)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MultiStore) List() ([]uint16, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	// blobs on failed directories too: their states are still read from
+	// the mirrors, their data reports the failure
+	ids := make([]uint16, 0, len(s.where))
+	for id := range s.where {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// prober periodically checks whether failed data directories are usable
+// again.
+func (s *MultiStore) prober() {
+	ticker := time.NewTicker(probeInterval * time.Second)
+	for range ticker.C {
+		s.mu.RLock()
+		failed := []*dataDir{}
+		for _, dd := range s.dirs {
+			if dd.failed != nil {
+				failed = append(failed, dd)
+			}
+		}
+		s.mu.RUnlock()
+
+		for _, dd := range failed {
+			if err := probe(dd.path); err != nil {
+				log.Debugf("Data directory %s still failing: %s", dd.path, err)
+				continue
+			}
+			s.mu.Lock()
+			if err := s.load(dd); err != nil {
+				log.Debugf("Data directory %s still failing: %s", dd.path, err)
+			} else {
+				dd.failed = nil
+				log.Infof("Data directory %s is usable again", dd.path)
+				s.reopenMirrors(dd)
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// probe writes, reads back and removes a small file in path.
+func probe(path string) error {
+	probePath := filepath.Join(path, probeFileName)
+	want := []byte(time.Now().String())
+	if err := ioutil.WriteFile(probePath, want, 0644); err != nil {
+		return err
+	}
+	defer os.Remove(probePath)
+	got, err := ioutil.ReadFile(probePath)
+	if err != nil {
+		return err
+	}
+	if string(got) != string(want) {
+		return fmt.Errorf("probe file read back wrong")
+	}
+	return nil
+}
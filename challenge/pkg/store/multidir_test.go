@@ -0,0 +1,89 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+)
+
+// A state that can't be parsed is an error for its blob only, while an I/O
+// error takes the whole data directory out.
+func TestMultiStoreCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(blobDir string) error
+		failed bool
+	}{
+		{
+			name: "unparsable state",
+			damage: func(blobDir string) error {
+				return ioutil.WriteFile(filepath.Join(blobDir, common.BlobStateFileName), []byte("not a state\n"), 0644)
+			},
+		},
+		{
+			name: "empty state",
+			damage: func(blobDir string) error {
+				return ioutil.WriteFile(filepath.Join(blobDir, common.BlobStateFileName), nil, 0644)
+			},
+		},
+		{
+			name: "blob directory replaced by a file",
+			damage: func(blobDir string) error {
+				if err := os.RemoveAll(blobDir); err != nil {
+					return err
+				}
+				return ioutil.WriteFile(blobDir, nil, 0644)
+			},
+			failed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "multidir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		paths := []string{filepath.Join(dir, "1"), filepath.Join(dir, "2")}
+		s, err := NewMultiStore(paths, PlaceByHash, func(path string) (Store, error) {
+			return NewDirStore(path)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		putBlob(t, s, 1, "one")
+		dd := s.where[1]
+		if err := tt.damage(dd.st.(*DirStore).BlobDir(1)); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := s.GetState(1); err == nil {
+			t.Errorf("%s: read a damaged state", tt.name)
+		}
+		if _, err := s.GetData(1); err == nil && tt.failed {
+			t.Errorf("%s: read the data of a blob on a failed directory", tt.name)
+		}
+		s.mu.RLock()
+		failed := dd.failed != nil
+		s.mu.RUnlock()
+		if failed != tt.failed {
+			t.Errorf("%s: directory failed is %v, expected %v", tt.name, failed, tt.failed)
+		}
+	}
+}
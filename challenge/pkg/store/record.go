@@ -0,0 +1,169 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// recordKind tells what the payload of a record holds.
+type recordKind uint8
+
+const (
+	recState  recordKind = iota + 1 // base64 encoded blob state
+	recData                         // blob data
+	recLarge                        // marker: blob data lives in its own file
+	recDelete                       // tombstone: blob removed
+)
+
+// A record on disk is a fixed header followed by the payload:
+//
+//	crc32(4) | kind(1) | id(2) | seq(8) | payload length(4) | payload
+//
+// The crc covers everything after itself, payload included.
+const recordHeaderSize = 4 + 1 + 2 + 8 + 4
+
+var errCorruptRecord = errors.New("corrupt record")
+
+type recordHeader struct {
+	kind recordKind
+	id   uint16
+	seq  uint64
+	size uint32 // payload length
+}
+
+// recordLoc points to a record inside a log or segment file.
+type recordLoc struct {
+	file uint32 // file number
+	off  int64  // offset of the record header
+	size uint32 // payload length
+	seq  uint64
+
+	large bool // data kept outside of the file, see recLarge
+}
+
+// recordLen returns the full on-disk length of the record at loc.
+func (loc recordLoc) recordLen() int64 {
+	return recordHeaderSize + int64(loc.size)
+}
+
+// payloadOff returns the offset of the payload of the record at loc.
+func (loc recordLoc) payloadOff() int64 {
+	return loc.off + recordHeaderSize
+}
+
+func encodeRecord(h recordHeader, payload []byte) []byte {
+	buf := make([]byte, recordHeaderSize+len(payload))
+	buf[4] = byte(h.kind)
+	binary.BigEndian.PutUint16(buf[5:], h.id)
+	binary.BigEndian.PutUint64(buf[7:], h.seq)
+	binary.BigEndian.PutUint32(buf[15:], uint32(len(payload)))
+	copy(buf[recordHeaderSize:], payload)
+	binary.BigEndian.PutUint32(buf[0:], crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+// readRecord reads the record at off from r, which holds size bytes. The
+// payload is only returned (and the checksum only verified) if withPayload is
+// set. A record running past size is reported as torn, so a corrupt length
+// never gets allocated.
+func readRecord(r io.ReaderAt, off, size int64, withPayload bool) (recordHeader, []byte, error) {
+	var h recordHeader
+	hdr := make([]byte, recordHeaderSize)
+	if _, err := r.ReadAt(hdr, off); err != nil {
+		return h, nil, err
+	}
+	h.kind = recordKind(hdr[4])
+	h.id = binary.BigEndian.Uint16(hdr[5:])
+	h.seq = binary.BigEndian.Uint64(hdr[7:])
+	h.size = binary.BigEndian.Uint32(hdr[15:])
+	if h.kind < recState || h.kind > recDelete {
+		return h, nil, errCorruptRecord
+	}
+	if off+recordHeaderSize+int64(h.size) > size {
+		return h, nil, io.ErrUnexpectedEOF
+	}
+	if !withPayload {
+		return h, nil, nil
+	}
+
+	payload := make([]byte, h.size)
+	if _, err := r.ReadAt(payload, off+recordHeaderSize); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return h, nil, err
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:])
+	crc.Write(payload)
+	if crc.Sum32() != binary.BigEndian.Uint32(hdr[0:]) {
+		return h, nil, errCorruptRecord
+	}
+	return h, payload, nil
+}
+
+// scanRecords calls fn for each valid record in r, which holds size bytes,
+// starting at offset 0. A file still being appended to can only be broken at
+// its tail, by a torn write: the scan stops at the first bad record and
+// returns its offset so the tail can be truncated away. In a sealed file a
+// bad record is damage; it is reported, skipped, and the scan goes on with
+// the next valid record.
+func scanRecords(r io.ReaderAt, size int64, sealed bool, fn func(h recordHeader, off int64, payload []byte) error) (int64, error) {
+	var off int64
+	for off < size {
+		h, payload, err := readRecord(r, off, size, true)
+		if err == io.EOF || err == io.ErrUnexpectedEOF || err == errCorruptRecord {
+			if !sealed {
+				return off, nil
+			}
+			next := nextRecord(r, off+1, size)
+			log.Warningf("Skipping %d corrupt bytes at offset %d", next-off, off)
+			off = next
+			continue
+		}
+		if err != nil {
+			return off, fmt.Errorf("error reading record at %d: %s", off, err)
+		}
+		if err := fn(h, off, payload); err != nil {
+			return off, err
+		}
+		off += recordHeaderSize + int64(h.size)
+	}
+	return off, nil
+}
+
+// nextRecord returns the offset of the first valid record in r at or after
+// off, or size if there is none.
+func nextRecord(r io.ReaderAt, off, size int64) int64 {
+	for ; off < size; off++ {
+		if _, _, err := readRecord(r, off, size, true); err == nil {
+			return off
+		}
+	}
+	return size
+}
+
+// fileSize returns the size of f, 0 if it can't be found out.
+func fileSize(f *os.File) int64 {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
@@ -0,0 +1,254 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/blob"
+)
+
+func testRecords(n int) [][]byte {
+	recs := [][]byte{}
+	for i := 1; i <= n; i++ {
+		h := recordHeader{kind: recData, id: uint16(i), seq: uint64(i)}
+		recs = append(recs, encodeRecord(h, bytes.Repeat([]byte{byte(i)}, 10*i)))
+	}
+	return recs
+}
+
+func TestScanRecords(t *testing.T) {
+	recs := testRecords(4)
+	all := bytes.Join(recs, nil)
+	off := func(i int) int64 { return int64(len(bytes.Join(recs[:i], nil))) }
+
+	tests := []struct {
+		name   string
+		data   func() []byte
+		sealed bool
+		ids    []uint16
+		end    int64
+	}{
+		{
+			name: "clean",
+			data: func() []byte { return all },
+			ids:  []uint16{1, 2, 3, 4},
+			end:  int64(len(all)),
+		},
+		{
+			name: "empty",
+			data: func() []byte { return nil },
+			ids:  []uint16{},
+			end:  0,
+		},
+		{
+			name: "torn tail",
+			data: func() []byte { return all[:len(all)-5] },
+			ids:  []uint16{1, 2, 3},
+			end:  off(3),
+		},
+		{
+			name: "torn header",
+			data: func() []byte { return all[:off(3)+3] },
+			ids:  []uint16{1, 2, 3},
+			end:  off(3),
+		},
+		{
+			name: "corrupt tail",
+			data: func() []byte {
+				b := append([]byte{}, all...)
+				b[len(b)-1] ^= 0xff
+				return b
+			},
+			ids: []uint16{1, 2, 3},
+			end: off(3),
+		},
+		{
+			name: "corrupt record in active file",
+			data: func() []byte {
+				b := append([]byte{}, all...)
+				b[off(1)+recordHeaderSize] ^= 0xff
+				return b
+			},
+			ids: []uint16{1},
+			end: off(1),
+		},
+		{
+			name: "corrupt record in sealed file",
+			data: func() []byte {
+				b := append([]byte{}, all...)
+				b[off(1)+recordHeaderSize] ^= 0xff
+				return b
+			},
+			sealed: true,
+			ids:    []uint16{1, 3, 4},
+			end:    int64(len(all)),
+		},
+		{
+			name: "huge length",
+			data: func() []byte {
+				b := append([]byte{}, all...)
+				binary.BigEndian.PutUint32(b[off(2)+15:], 0xffffffff)
+				return b
+			},
+			ids: []uint16{1, 2},
+			end: off(2),
+		},
+		{
+			name: "huge length in sealed file",
+			data: func() []byte {
+				b := append([]byte{}, all...)
+				binary.BigEndian.PutUint32(b[off(2)+15:], 0xffffffff)
+				return b
+			},
+			sealed: true,
+			ids:    []uint16{1, 2, 4},
+			end:    int64(len(all)),
+		},
+	}
+
+	for _, tt := range tests {
+		data := tt.data()
+		ids := []uint16{}
+		end, err := scanRecords(bytes.NewReader(data), int64(len(data)), tt.sealed, func(h recordHeader, off int64, payload []byte) error {
+			if len(payload) != 10*int(h.id) || payload[0] != byte(h.id) {
+				t.Errorf("%s: wrong payload for record %d", tt.name, h.id)
+			}
+			ids = append(ids, h.id)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+		if end != tt.end {
+			t.Errorf("%s: scan ended at %d, expected %d", tt.name, end, tt.end)
+		}
+		if len(ids) != len(tt.ids) {
+			t.Errorf("%s: got records %v, expected %v", tt.name, ids, tt.ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.ids[i] {
+				t.Errorf("%s: got records %v, expected %v", tt.name, ids, tt.ids)
+				break
+			}
+		}
+	}
+}
+
+func TestReadRecord(t *testing.T) {
+	h := recordHeader{kind: recState, id: 7, seq: 42}
+	buf := encodeRecord(h, []byte("payload"))
+
+	got, payload, err := readRecord(bytes.NewReader(buf), 0, int64(len(buf)), true)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got.kind != h.kind || got.id != h.id || got.seq != h.seq || got.size != 7 {
+		t.Errorf("got header %+v, expected %+v", got, h)
+	}
+	if string(payload) != "payload" {
+		t.Errorf("got payload %q", payload)
+	}
+
+	buf[0] ^= 0xff
+	if _, _, err := readRecord(bytes.NewReader(buf), 0, int64(len(buf)), true); err != errCorruptRecord {
+		t.Errorf("expected a corrupt record, got %v", err)
+	}
+	// the checksum is only verified along with the payload
+	if _, _, err := readRecord(bytes.NewReader(buf), 0, int64(len(buf)), false); err != nil {
+		t.Errorf("unexpected error reading the header only: %s", err)
+	}
+}
+
+func stateRecord(t *testing.T, id uint16, seq uint64, location string) []byte {
+	bb := &blob.Blob{ID: id, Location: location, Status: &blob.BlobStatus{}}
+	bb.LogStatusOK("test")
+	str, err := bb.Base64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encodeRecord(recordHeader{kind: recState, id: id, seq: seq}, []byte(str))
+}
+
+// A damaged record in a sealed segment must not cost the records after it,
+// while a torn write at the end of the last segment is truncated away.
+func TestSegmentStoreOpenCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "segments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, segmentsDirName), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// records hold the time of their status, so their length varies: keep
+	// the ones written to know where they end
+	a, d := stateRecord(t, 1, 1, "a"), stateRecord(t, 4, 4, "d")
+	sealed := bytes.Join([][]byte{a, stateRecord(t, 2, 2, "b"), stateRecord(t, 3, 3, "c")}, nil)
+	sealed[len(a)+recordHeaderSize] ^= 0xff
+	active := append(append([]byte{}, d...), stateRecord(t, 5, 5, "e")[:10]...)
+
+	files := map[uint32][]byte{0: sealed, 1: active}
+	s := &SegmentStore{}
+	s.BasePath = dir
+	for num, data := range files {
+		if err := ioutil.WriteFile(s.segmentPath(num), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err = NewSegmentStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		id    uint16
+		found bool
+	}{
+		{1, true},
+		{2, false},
+		{3, true},
+		{4, true},
+		{5, false},
+	} {
+		_, err := s.GetState(tt.id)
+		if tt.found && err != nil {
+			t.Errorf("blob %d: unexpected error: %s", tt.id, err)
+		}
+		if !tt.found && err != ErrNotFound {
+			t.Errorf("blob %d: expected ErrNotFound, got %v", tt.id, err)
+		}
+	}
+
+	for num, data := range files {
+		fi, err := os.Stat(s.segmentPath(num))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := int64(len(data))
+		if num == 1 {
+			expected = int64(len(d))
+		}
+		if fi.Size() != expected {
+			t.Errorf("segment %d is %d bytes, expected %d", num, fi.Size(), expected)
+		}
+	}
+}
@@ -0,0 +1,536 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/blob"
+)
+
+const (
+	// DefaultSegmentThreshold is the largest blob, in bytes, packed into a
+	// segment file when no threshold is configured.
+	DefaultSegmentThreshold = 64 * 1024
+
+	segmentsDirName     = "segments"
+	segmentFileSuffix   = ".seg"
+	largeDirName        = "large"
+	tombstoneFileName   = "tombstones.log"
+	segmentMaxSize      = 64 << 20
+	compactInterval     = 30 // in seconds
+	compactGarbageRatio = 0.5
+)
+
+// segment is an append-only file holding state and data records.
+type segment struct {
+	num    uint32
+	f      *os.File
+	size   int64  // bytes written so far
+	dead   int64  // bytes held by records that were replaced or deleted
+	minSeq uint64 // lowest record sequence in the segment
+}
+
+// SegmentStore packs blob states and the data of small blobs into large
+// append-only segment files. An in-memory offset index points to the latest
+// record of each blob; it is rebuilt by scanning the segments on startup.
+// Deletions are appended to a tombstone log and a background compactor
+// rewrites segments once most of their records are garbage. Blobs larger
+// than Threshold keep their data in their own file under the "large"
+// directory.
+type SegmentStore struct {
+	BasePath  string
+	Threshold int64
+
+	mu         sync.RWMutex
+	seq        uint64 // last record sequence handed out
+	segments   map[uint32]*segment
+	active     *segment
+	states     map[uint16]recordLoc
+	data       map[uint16]recordLoc
+	tombstones *os.File
+	large      *DirStore
+}
+
+// NewSegmentStore opens (or creates) the segment store rooted at basePath and
+// starts its compactor.
+func NewSegmentStore(basePath string, threshold int64) (*SegmentStore, error) {
+	if threshold <= 0 {
+		threshold = DefaultSegmentThreshold
+	}
+	large, err := NewDirStore(filepath.Join(basePath, largeDirName))
+	if err != nil {
+		return nil, err
+	}
+	s := &SegmentStore{
+		BasePath:  basePath,
+		Threshold: threshold,
+		segments:  make(map[uint32]*segment),
+		states:    make(map[uint16]recordLoc),
+		data:      make(map[uint16]recordLoc),
+		large:     large,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	go s.compactor()
+	return s, nil
+}
+
+func (s *SegmentStore) segmentsDir() string {
+	return filepath.Join(s.BasePath, segmentsDirName)
+}
+
+func (s *SegmentStore) segmentPath(num uint32) string {
+	return filepath.Join(s.segmentsDir(), fmt.Sprintf("%08d%s", num, segmentFileSuffix))
+}
+
+// open rebuilds the offset index from the segment files and the tombstone
+// log.
+func (s *SegmentStore) open() error {
+	if err := os.MkdirAll(s.segmentsDir(), 0755); err != nil {
+		return fmt.Errorf("Could not create segments directory: %s", err)
+	}
+	files, err := ioutil.ReadDir(s.segmentsDir())
+	if err != nil {
+		return err
+	}
+	nums := []int{}
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasSuffix(name, segmentFileSuffix) {
+			continue
+		}
+		if num, err := strconv.ParseUint(strings.TrimSuffix(name, segmentFileSuffix), 10, 32); err == nil {
+			nums = append(nums, int(num))
+		}
+	}
+	sort.Ints(nums)
+
+	// only the last segment was being appended to, the others are sealed
+	for i, num := range nums {
+		seg, err := s.openSegment(uint32(num))
+		if err != nil {
+			return err
+		}
+		sealed := i < len(nums)-1
+		end, err := scanRecords(seg.f, fileSize(seg.f), sealed, func(h recordHeader, off int64, payload []byte) error {
+			s.indexRecord(seg, recordLoc{file: seg.num, off: off, size: h.size, seq: h.seq}, h.kind, h.id)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("Error while reading segment %d: %s", seg.num, err)
+		}
+		if !sealed {
+			if err := seg.f.Truncate(end); err != nil {
+				return err
+			}
+		}
+		seg.size = end
+		s.active = seg
+	}
+
+	if err := s.loadTombstones(); err != nil {
+		return err
+	}
+
+	if s.active == nil || s.active.size >= segmentMaxSize {
+		return s.roll()
+	}
+	log.Infof("Opened %d segments holding %d blobs", len(s.segments), len(s.states))
+	return nil
+}
+
+func (s *SegmentStore) openSegment(num uint32) (*segment, error) {
+	f, err := os.OpenFile(s.segmentPath(num), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open segment %d: %s", num, err)
+	}
+	seg := &segment{num: num, f: f}
+	s.segments[num] = seg
+	return seg, nil
+}
+
+// indexRecord points the index at the record in loc unless a newer record for
+// the same blob is already known.
+func (s *SegmentStore) indexRecord(seg *segment, loc recordLoc, kind recordKind, id uint16) {
+	if loc.seq > s.seq {
+		s.seq = loc.seq
+	}
+	if seg.minSeq == 0 || loc.seq < seg.minSeq {
+		seg.minSeq = loc.seq
+	}
+
+	index := s.states
+	if kind != recState {
+		index = s.data
+		loc.large = kind == recLarge
+	}
+	if old, ok := index[id]; ok {
+		if old.seq > loc.seq {
+			seg.dead += loc.recordLen()
+			return
+		}
+		s.markDead(old)
+	}
+	index[id] = loc
+}
+
+func (s *SegmentStore) loadTombstones() error {
+	f, err := os.OpenFile(filepath.Join(s.BasePath, tombstoneFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("Could not open tombstone log: %s", err)
+	}
+	end, err := scanRecords(f, fileSize(f), false, func(h recordHeader, off int64, payload []byte) error {
+		s.applyTombstone(h.id, h.seq)
+		return nil
+	})
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("Error while reading tombstone log: %s", err)
+	}
+	if err := f.Truncate(end); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	s.tombstones = f
+	return nil
+}
+
+// applyTombstone drops the records of blob id written at or before seq.
+func (s *SegmentStore) applyTombstone(id uint16, seq uint64) {
+	if seq > s.seq {
+		s.seq = seq
+	}
+	if loc, ok := s.states[id]; ok && loc.seq <= seq {
+		s.markDead(loc)
+		delete(s.states, id)
+	}
+	if loc, ok := s.data[id]; ok && loc.seq <= seq {
+		s.markDead(loc)
+		delete(s.data, id)
+	}
+}
+
+func (s *SegmentStore) markDead(loc recordLoc) {
+	if seg, ok := s.segments[loc.file]; ok {
+		seg.dead += loc.recordLen()
+	}
+}
+
+// roll seals the active segment and starts a new one. Must be called with mu
+// held.
+func (s *SegmentStore) roll() error {
+	var num uint32
+	if s.active != nil {
+		num = s.active.num + 1
+	}
+	seg, err := s.openSegment(num)
+	if err != nil {
+		return err
+	}
+	s.active = seg
+	return nil
+}
+
+// appendRecord appends a record to the active segment. A zero h.seq gets the
+// next sequence number. Must be called with mu held.
+func (s *SegmentStore) appendRecord(h recordHeader, payload []byte) (recordLoc, error) {
+	if s.active.size >= segmentMaxSize {
+		if err := s.roll(); err != nil {
+			return recordLoc{}, err
+		}
+	}
+	if h.seq == 0 {
+		s.seq++
+		h.seq = s.seq
+	}
+	seg := s.active
+	buf := encodeRecord(h, payload)
+	if _, err := seg.f.WriteAt(buf, seg.size); err != nil {
+		// as is, naming the file, so that MultiStore sees an I/O error
+		return recordLoc{}, err
+	}
+	loc := recordLoc{file: seg.num, off: seg.size, size: uint32(len(payload)), seq: h.seq, large: h.kind == recLarge}
+	seg.size += int64(len(buf))
+	if seg.minSeq == 0 || h.seq < seg.minSeq {
+		seg.minSeq = h.seq
+	}
+	return loc, nil
+}
+
+// readPayload returns the payload of the record at loc. Must be called with
+// mu held.
+func (s *SegmentStore) readPayload(loc recordLoc) ([]byte, error) {
+	seg, ok := s.segments[loc.file]
+	if !ok {
+		return nil, fmt.Errorf("segment %d not found", loc.file)
+	}
+	_, payload, err := readRecord(seg.f, loc.off, seg.size, true)
+	return payload, err
+}
+
+func (s *SegmentStore) PutState(bb *blob.Blob) error {
+	bbStr64, err := bb.Base64()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loc, err := s.appendRecord(recordHeader{kind: recState, id: bb.ID}, []byte(bbStr64))
+	if err != nil {
+		return err
+	}
+	if old, ok := s.states[bb.ID]; ok {
+		s.markDead(old)
+	}
+	s.states[bb.ID] = loc
+	return nil
+}
+
+func (s *SegmentStore) GetState(id uint16) (*blob.Blob, error) {
+	s.mu.RLock()
+	loc, ok := s.states[id]
+	if !ok {
+		s.mu.RUnlock()
+		return nil, ErrNotFound
+	}
+	payload, err := s.readPayload(loc)
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("Error while reading state of blob %d: %s", id, err)
+	}
+
+	var bb blob.Blob
+	if err := blob.ParseBase64ToBlob(string(payload), &bb); err != nil {
+		return nil, err
+	}
+	return &bb, nil
+}
+
+func (s *SegmentStore) PutData(id uint16, r io.Reader) (int64, error) {
+	// read one byte past the threshold to find out which side we're on
+	small, err := ioutil.ReadAll(io.LimitReader(r, s.Threshold+1))
+	if err != nil {
+		return int64(len(small)), err
+	}
+
+	kind := recData
+	payload := small
+	n := int64(len(small))
+	if n > s.Threshold {
+		kind = recLarge
+		payload = nil
+		if n, err = s.large.PutData(id, io.MultiReader(bytes.NewReader(small), r)); err != nil {
+			return n, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loc, err := s.appendRecord(recordHeader{kind: kind, id: id}, payload)
+	if err != nil {
+		return n, err
+	}
+	if old, ok := s.data[id]; ok {
+		s.markDead(old)
+		if old.large && !loc.large {
+			s.large.Delete(id)
+		}
+	}
+	s.data[id] = loc
+	return n, nil
+}
+
+func (s *SegmentStore) GetData(id uint16) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	loc, ok := s.data[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if loc.large {
+		return s.large.GetData(id)
+	}
+	payload, err := s.readPayload(loc)
+	if err != nil {
+		return nil, fmt.Errorf("Error while reading data of blob %d: %s", id, err)
+	}
+	return ioutil.NopCloser(bytes.NewReader(payload)), nil
+}
+
+func (s *SegmentStore) Delete(id uint16) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	if _, err := s.tombstones.Write(encodeRecord(recordHeader{kind: recDelete, id: id, seq: s.seq}, nil)); err != nil {
+		return err
+	}
+	if loc, ok := s.data[id]; ok && loc.large {
+		if err := s.large.Delete(id); err != nil {
+			return err
+		}
+	}
+	s.applyTombstone(id, s.seq)
+	return nil
+}
+
+func (s *SegmentStore) List() ([]uint16, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]uint16, 0, len(s.states))
+	for id := range s.states {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s *SegmentStore) compactor() {
+	ticker := time.NewTicker(compactInterval * time.Second)
+	for range ticker.C {
+		if err := s.compact(); err != nil {
+			log.Warningf("segment compaction failed: %s", err)
+		}
+	}
+}
+
+// compact rewrites the live records of every sealed segment whose garbage
+// ratio is above compactGarbageRatio into the active segment and removes the
+// old file. Records keep their sequence number so tombstones still apply.
+func (s *SegmentStore) compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	compacted := 0
+	for num, seg := range s.segments {
+		if seg == s.active || seg.size == 0 ||
+			float64(seg.dead)/float64(seg.size) < compactGarbageRatio {
+			continue
+		}
+		log.Debugf("Compacting segment %d (%d of %d bytes are garbage)", num, seg.dead, seg.size)
+
+		first := s.active.num
+		move := func(index map[uint16]recordLoc, kind recordKind) error {
+			for id, loc := range index {
+				if loc.file != num {
+					continue
+				}
+				payload, err := s.readPayload(loc)
+				if err != nil {
+					return err
+				}
+				k := kind
+				if loc.large {
+					k = recLarge
+				}
+				newLoc, err := s.appendRecord(recordHeader{kind: k, id: id, seq: loc.seq}, payload)
+				if err != nil {
+					return err
+				}
+				index[id] = newLoc
+			}
+			return nil
+		}
+		if err := move(s.states, recState); err != nil {
+			return err
+		}
+		if err := move(s.data, recData); err != nil {
+			return err
+		}
+		// the moved records must be on disk before their old copy goes
+		if err := s.syncFrom(first); err != nil {
+			return err
+		}
+
+		seg.f.Close()
+		delete(s.segments, num)
+		if err := os.Remove(s.segmentPath(num)); err != nil {
+			return fmt.Errorf("could not remove segment %d: %s", num, err)
+		}
+		compacted++
+	}
+
+	if compacted == 0 {
+		return nil
+	}
+	log.Infof("Compacted %d segments", compacted)
+	return s.compactTombstones()
+}
+
+// syncFrom syncs the segments from num on, which compaction appended to, and
+// the directory holding them. Must be called with mu held.
+func (s *SegmentStore) syncFrom(num uint32) error {
+	for n, seg := range s.segments {
+		if n < num {
+			continue
+		}
+		if err := seg.f.Sync(); err != nil {
+			return fmt.Errorf("failed to sync segment %d: %s", n, err)
+		}
+	}
+	return syncDir(s.segmentsDir())
+}
+
+// compactTombstones drops the tombstones that no longer shadow any record.
+// A tombstone is only needed while some segment still holds records written
+// at or before it. Must be called with mu held.
+func (s *SegmentStore) compactTombstones() error {
+	var minSeq uint64
+	for _, seg := range s.segments {
+		if seg.minSeq != 0 && (minSeq == 0 || seg.minSeq < minSeq) {
+			minSeq = seg.minSeq
+		}
+	}
+
+	var keep bytes.Buffer
+	if minSeq == 0 {
+		// no records left at all, every tombstone can go
+		minSeq = s.seq + 1
+	}
+	if _, err := scanRecords(s.tombstones, fileSize(s.tombstones), false, func(h recordHeader, off int64, payload []byte) error {
+		if h.seq >= minSeq {
+			keep.Write(encodeRecord(h, nil))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.BasePath, tombstoneFileName)
+	if err := writeFileSync(path, keep.Bytes()); err != nil {
+		return err
+	}
+	s.tombstones.Close()
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Could not reopen tombstone log: %s", err)
+	}
+	s.tombstones = f
+	return nil
+}
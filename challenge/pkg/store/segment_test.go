@@ -0,0 +1,106 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+var (
+	two   = strings.Repeat("two", 300)
+	three = strings.Repeat("three", 150)
+)
+
+// TestSegmentStoreCompact fills the first segment with blobs 1 to 4, turns
+// most of it into garbage and checks what is left after a compaction, and
+// after reopening the store.
+func TestSegmentStoreCompact(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(s *SegmentStore) error
+		expected map[uint16]string
+	}{
+		{
+			name: "delete",
+			change: func(s *SegmentStore) error {
+				if err := s.Delete(2); err != nil {
+					return err
+				}
+				return s.Delete(3)
+			},
+			expected: map[uint16]string{1: "one", 4: "four"},
+		},
+		{
+			name: "overwrite",
+			change: func(s *SegmentStore) error {
+				if _, err := s.PutData(2, strings.NewReader("two again")); err != nil {
+					return err
+				}
+				_, err := s.PutData(3, strings.NewReader("three again"))
+				return err
+			},
+			expected: map[uint16]string{1: "one", 2: "two again", 3: "three again", 4: "four"},
+		},
+		{
+			name: "delete and overwrite",
+			change: func(s *SegmentStore) error {
+				if err := s.Delete(2); err != nil {
+					return err
+				}
+				_, err := s.PutData(3, strings.NewReader("three again"))
+				return err
+			},
+			expected: map[uint16]string{1: "one", 3: "three again", 4: "four"},
+		},
+	}
+
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "segments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		s, err := NewSegmentStore(dir, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		putBlob(t, s, 1, "one")
+		putBlob(t, s, 2, two)
+		putBlob(t, s, 3, three)
+		putBlob(t, s, 4, "four")
+		s.mu.Lock()
+		s.roll()
+		s.mu.Unlock()
+		if err := tt.change(s); err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+
+		if err := s.compact(); err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		checkBlobs(t, tt.name, s, tt.expected)
+		if _, ok := s.segments[0]; ok {
+			t.Errorf("%s: segment 0 was not compacted", tt.name)
+		}
+
+		s, err = NewSegmentStore(dir, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkBlobs(t, tt.name+" reopened", s, tt.expected)
+	}
+}
@@ -0,0 +1,179 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/blob"
+
+	"github.com/op/go-logging"
+)
+
+var (
+	log = logging.MustGetLogger("challenge-store")
+
+	// ErrNotFound is returned when the requested blob state or data does
+	// not exist in the store.
+	ErrNotFound = errors.New("not found")
+)
+
+const (
+	// DirStoreName selects the directory-per-blob layout.
+	DirStoreName = "dir"
+	// MemStoreName selects the purely in-memory store.
+	MemStoreName = "mem"
+	// SegmentStoreName selects the store packing small blobs into segments.
+	SegmentStoreName = "segment"
+	// LogStoreName selects the log-structured store.
+	LogStoreName = "log"
+)
+
+// Config holds the settings used to build a store.
+type Config struct {
+	BasePath         string // directory holding everything the store keeps on disk
+	SegmentThreshold int64  // blobs up to this size are packed into segments
+
+	// Paths, if it holds more than one directory, spreads blobs over all of
+	// them (usually one per disk) with a store of the given kind in each.
+	Paths     []string
+	Placement string // how new blobs are placed over Paths
+
+	// ColdPath, if set, turns on tiering: the store above is the hot tier
+	// and a directory store in ColdPath is the cold one.
+	ColdPath string
+	Tiering  TierPolicy
+
+	// ErasureCoding, if set to a scheme such as "6+3", stores blobs as
+	// Reed-Solomon shards spread over Paths (or BasePath) instead.
+	ErasureCoding string
+}
+
+// Store is implemented by every backend able to persist blobs. For each blob
+// a store keeps two things: the blob's state (the serialized blob.Blob) and
+// the user data.
+type Store interface {
+	// PutState saves the state of bb, creating whatever the backend needs to
+	// hold the blob on first use.
+	PutState(bb *blob.Blob) error
+	// GetState reads back the state of the blob with the given id.
+	GetState(id uint16) (*blob.Blob, error)
+	// PutData stores everything read from r as the data of blob id and
+	// returns the number of bytes written.
+	PutData(id uint16, r io.Reader) (int64, error)
+	// GetData returns a reader over the data of blob id. The caller must
+	// close it.
+	GetData(id uint16) (io.ReadCloser, error)
+	// Delete removes the state and data of blob id.
+	Delete(id uint16) error
+	// List returns the ids of all blobs that have state in the store.
+	List() ([]uint16, error)
+}
+
+// Merger is implemented by stores that reclaim the space of deleted and
+// failed blobs on their own. The daemon does not run its gc of failed blobs
+// for those and calls Merge periodically instead.
+type Merger interface {
+	Merge() error
+}
+
+// Parent is implemented by stores built on top of other stores.
+type Parent interface {
+	Children() []Store
+}
+
+// MergeAll calls Merge on st and on every store below it that is a Merger.
+func MergeAll(st Store) error {
+	if m, ok := st.(Merger); ok {
+		if err := m.Merge(); err != nil {
+			return err
+		}
+	}
+	if p, ok := st.(Parent); ok {
+		for _, child := range p.Children() {
+			if err := MergeAll(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CollectsFailed returns true if failed blobs never linger in st, that is
+// if st, or every store at the bottom of it, is a Merger.
+func CollectsFailed(st Store) bool {
+	if _, ok := st.(Merger); ok {
+		return true
+	}
+	p, ok := st.(Parent)
+	if !ok {
+		return false
+	}
+	for _, child := range p.Children() {
+		if !CollectsFailed(child) {
+			return false
+		}
+	}
+	return true
+}
+
+// New returns the store registered under name, configured with c.
+func New(name string, c Config) (Store, error) {
+	st, err := newStore(name, c)
+	if err != nil || c.ColdPath == "" {
+		return st, err
+	}
+	cold, err := NewDirStore(c.ColdPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewTieredStore(st, cold, c.Tiering)
+}
+
+func newStore(name string, c Config) (Store, error) {
+	if c.ErasureCoding != "" {
+		if name != DirStoreName && name != "" {
+			return nil, fmt.Errorf("The %s store can't be used with erasure coding, shards are kept in directories", name)
+		}
+		paths := c.Paths
+		if len(paths) == 0 {
+			paths = []string{c.BasePath}
+		}
+		return NewECStore(paths, c.ErasureCoding)
+	}
+	if len(c.Paths) <= 1 || name == MemStoreName {
+		return newLeafStore(name, c)
+	}
+	return NewMultiStore(c.Paths, c.Placement, func(path string) (Store, error) {
+		dc := c
+		dc.BasePath = path
+		return newLeafStore(name, dc)
+	})
+}
+
+func newLeafStore(name string, c Config) (Store, error) {
+	switch name {
+	case DirStoreName, "":
+		return NewDirStore(c.BasePath)
+	case MemStoreName:
+		return NewMemStore(), nil
+	case SegmentStoreName:
+		return NewSegmentStore(c.BasePath, c.SegmentThreshold)
+	case LogStoreName:
+		return NewLogStore(c.BasePath)
+	default:
+		return nil, fmt.Errorf("Unknown store %q", name)
+	}
+}
@@ -0,0 +1,406 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package store
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/pkg/blob"
+)
+
+const (
+	// HotTier and ColdTier are the values of blob.Blob.Tier.
+	HotTier  = "hot"
+	ColdTier = "cold"
+
+	DefaultColdAfter    = 24 * time.Hour
+	DefaultPromoteReads = 3
+	tierInterval        = 60 // in seconds
+)
+
+// TierPolicy decides when blobs move between the hot and the cold tier.
+type TierPolicy struct {
+	// ColdAfter is how long a blob may go unread before it moves to the
+	// cold tier.
+	ColdAfter time.Duration
+	// ColdSize, if set, sends blobs of at least that many bytes to the cold
+	// tier as soon as they were not read for a whole migration pass.
+	ColdSize int64
+	// PromoteReads is the number of reads within ColdAfter that bring a
+	// cold blob back to the hot tier.
+	PromoteReads int
+}
+
+// blobAccess tracks how a blob is being read.
+type blobAccess struct {
+	last  time.Time // last read, or when the blob was written
+	saved time.Time // last as recorded in the blob's state
+	reads []time.Time
+	size  int64
+}
+
+// TieredStore spreads blobs over a hot and a cold store. New blobs always go
+// to the hot tier; a background migrator moves blobs that are not read
+// anymore (or are big, see TierPolicy) to the cold tier, and cold blobs that
+// are read often enough are promoted back. Reads are served from whichever
+// tier holds the blob. The tier and the last access are recorded in the
+// blob's state; writing the state with the destination tier to the
+// destination is what commits a migration.
+type TieredStore struct {
+	Hot    Store
+	Cold   Store
+	Policy TierPolicy
+
+	mu      sync.Mutex
+	where   map[uint16]Store
+	gen     map[uint16]uint64 // bumped on every write, to detect races with migrations
+	access  map[uint16]*blobAccess
+	promote chan uint16
+}
+
+// NewTieredStore returns a TieredStore over hot and cold and starts its
+// migrator.
+func NewTieredStore(hot, cold Store, policy TierPolicy) (*TieredStore, error) {
+	if policy.ColdAfter <= 0 {
+		policy.ColdAfter = DefaultColdAfter
+	}
+	if policy.PromoteReads <= 0 {
+		policy.PromoteReads = DefaultPromoteReads
+	}
+	s := &TieredStore{
+		Hot:     hot,
+		Cold:    cold,
+		Policy:  policy,
+		where:   make(map[uint16]Store),
+		gen:     make(map[uint16]uint64),
+		access:  make(map[uint16]*blobAccess),
+		promote: make(chan uint16, 64),
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	go s.migrator()
+	return s, nil
+}
+
+// Children returns the hot and the cold store.
+func (s *TieredStore) Children() []Store {
+	return []Store{s.Hot, s.Cold}
+}
+
+func (s *TieredStore) tierName(st Store) string {
+	if st == s.Cold {
+		return ColdTier
+	}
+	return HotTier
+}
+
+// open finds out which tier holds each blob. A blob found in both tiers was
+// being migrated when we went down: the copy with the most recent state is
+// the one the migration committed, the other one is removed. A copy without
+// a state, or whose state names the other tier, never got committed at all.
+// Access times are taken from the states, so a restart doesn't hold off
+// demotions.
+func (s *TieredStore) open() error {
+	now := time.Now()
+	for _, st := range []Store{s.Cold, s.Hot} {
+		ids, err := st.List()
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			bb, err := st.GetState(id)
+			if err != nil || bb.Status == nil || bb.Tier != s.tierName(st) && bb.Tier != "" {
+				log.Infof("Removing incomplete %s copy of blob %d", s.tierName(st), id)
+				st.Delete(id)
+				continue
+			}
+			if other, ok := s.where[id]; ok {
+				otherBb, err := other.GetState(id)
+				if err == nil && !bb.Status.LastUpdate().After(otherBb.Status.LastUpdate()) {
+					log.Infof("Removing stale %s copy of blob %d", s.tierName(st), id)
+					st.Delete(id)
+					continue
+				}
+				log.Infof("Removing stale %s copy of blob %d", s.tierName(other), id)
+				other.Delete(id)
+			}
+			s.where[id] = st
+			last := now
+			if bb.Accessed != 0 {
+				last = time.Unix(0, bb.Accessed)
+			} else if t := bb.Status.LastUpdate(); !t.IsZero() {
+				last = t
+			}
+			s.access[id] = &blobAccess{last: last, saved: last, size: bb.Size}
+		}
+	}
+	return nil
+}
+
+// tierOf returns the store holding blob id, the hot tier for new blobs.
+// Must be called with mu held.
+func (s *TieredStore) tierOf(id uint16) Store {
+	if st, ok := s.where[id]; ok {
+		return st
+	}
+	s.where[id] = s.Hot
+	s.access[id] = &blobAccess{last: time.Now()}
+	return s.Hot
+}
+
+func (s *TieredStore) PutState(bb *blob.Blob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.tierOf(bb.ID)
+	s.gen[bb.ID]++
+	cpy := bb.DeepCopy()
+	cpy.Tier = s.tierName(st)
+	s.stampAccess(cpy)
+	return st.PutState(cpy)
+}
+
+// stampAccess records the last access of bb in its state. Must be called with
+// mu held.
+func (s *TieredStore) stampAccess(bb *blob.Blob) {
+	if a, ok := s.access[bb.ID]; ok {
+		bb.Accessed = a.last.UnixNano()
+		a.saved = a.last
+	}
+}
+
+func (s *TieredStore) GetState(id uint16) (*blob.Blob, error) {
+	s.mu.Lock()
+	st, ok := s.where[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return st.GetState(id)
+}
+
+func (s *TieredStore) PutData(id uint16, r io.Reader) (int64, error) {
+	// bumping the generation is enough to make any migration of this blob
+	// back off, so mu isn't held while the data streams in
+	s.mu.Lock()
+	st := s.tierOf(id)
+	s.gen[id]++
+	s.mu.Unlock()
+
+	n, err := st.PutData(id, r)
+
+	s.mu.Lock()
+	s.gen[id]++
+	if a, ok := s.access[id]; ok {
+		a.last = time.Now()
+		a.size = n
+	}
+	s.mu.Unlock()
+	return n, err
+}
+
+func (s *TieredStore) GetData(id uint16) (io.ReadCloser, error) {
+	s.mu.Lock()
+	st, ok := s.where[id]
+	if !ok {
+		s.mu.Unlock()
+		return nil, ErrNotFound
+	}
+	if s.recordRead(id) && st == s.Cold {
+		select {
+		case s.promote <- id:
+		default:
+			// the migrator is busy, the next read will ask again
+		}
+	}
+	s.mu.Unlock()
+	return st.GetData(id)
+}
+
+// recordRead notes a read of blob id and returns true if the blob was read
+// often enough recently to belong in the hot tier. Must be called with mu
+// held.
+func (s *TieredStore) recordRead(id uint16) bool {
+	a, ok := s.access[id]
+	if !ok {
+		a = &blobAccess{}
+		s.access[id] = a
+	}
+	now := time.Now()
+	a.last = now
+	reads := []time.Time{now}
+	for _, t := range a.reads {
+		if now.Sub(t) < s.Policy.ColdAfter && len(reads) < s.Policy.PromoteReads {
+			reads = append(reads, t)
+		}
+	}
+	a.reads = reads
+	return len(reads) >= s.Policy.PromoteReads
+}
+
+func (s *TieredStore) Delete(id uint16) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.where[id]
+	if !ok {
+		return nil
+	}
+	if err := st.Delete(id); err != nil {
+		return err
+	}
+	delete(s.where, id)
+	delete(s.gen, id)
+	delete(s.access, id)
+	return nil
+}
+
+func (s *TieredStore) List() ([]uint16, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]uint16, 0, len(s.where))
+	for id := range s.where {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s *TieredStore) migrator() {
+	ticker := time.NewTicker(tierInterval * time.Second)
+	for {
+		select {
+		case id := <-s.promote:
+			if err := s.migrate(id, s.Cold, s.Hot); err != nil {
+				log.Warningf("Unable to promote blob %d to the hot tier: %s", id, err)
+			}
+		case <-ticker.C:
+			s.saveAccess()
+			s.demote()
+		}
+	}
+}
+
+// saveAccess writes the access times that changed since they were last
+// recorded back to the blob states.
+func (s *TieredStore) saveAccess() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, st := range s.where {
+		a := s.access[id]
+		if a == nil || !a.last.After(a.saved) {
+			continue
+		}
+		bb, err := st.GetState(id)
+		if err != nil {
+			continue
+		}
+		s.stampAccess(bb)
+		if err := st.PutState(bb); err != nil {
+			log.Warningf("Unable to record the last access of blob %d: %s", id, err)
+		}
+	}
+}
+
+// demote moves every hot blob the policy wants in the cold tier.
+func (s *TieredStore) demote() {
+	now := time.Now()
+	candidates := []uint16{}
+	s.mu.Lock()
+	for id, st := range s.where {
+		a := s.access[id]
+		if st != s.Hot || a == nil {
+			continue
+		}
+		idle := now.Sub(a.last)
+		if idle >= s.Policy.ColdAfter ||
+			s.Policy.ColdSize > 0 && a.size >= s.Policy.ColdSize && idle >= tierInterval*time.Second {
+			candidates = append(candidates, id)
+		}
+	}
+	s.mu.Unlock()
+
+	moved := 0
+	for _, id := range candidates {
+		if err := s.migrate(id, s.Hot, s.Cold); err != nil {
+			log.Warningf("Unable to move blob %d to the cold tier: %s", id, err)
+			continue
+		}
+		moved++
+	}
+	if moved > 0 {
+		log.Infof("Moved %d blobs to the cold tier", moved)
+	}
+}
+
+// migrate copies blob id from one tier to the other. The state is copied
+// first, still naming the source tier so that it doesn't count as committed,
+// then the data, without holding mu; if the blob was written to in the
+// meantime the copy is thrown away. Writing the state with the destination
+// tier is the commit point, the source copy is removed afterwards.
+func (s *TieredStore) migrate(id uint16, from, to Store) error {
+	s.mu.Lock()
+	if s.where[id] != from {
+		s.mu.Unlock()
+		return nil
+	}
+	gen := s.gen[id]
+	s.mu.Unlock()
+
+	bb, err := from.GetState(id)
+	if err != nil {
+		return err
+	}
+	if bb.Status == nil || bb.Status.LastStatus() != blob.OK {
+		// only settled blobs are moved
+		return nil
+	}
+	// some stores only take data for blobs they have a state for
+	bb.Tier = s.tierName(from)
+	if err := to.PutState(bb); err != nil {
+		to.Delete(id)
+		return err
+	}
+	rc, err := from.GetData(id)
+	if err != nil {
+		to.Delete(id)
+		return err
+	}
+	_, err = to.PutData(id, rc)
+	rc.Close()
+	if err != nil {
+		to.Delete(id)
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.where[id] != from || s.gen[id] != gen {
+		to.Delete(id)
+		return fmt.Errorf("blob changed while being migrated")
+	}
+	bb.Tier = s.tierName(to)
+	s.stampAccess(bb)
+	bb.LogStatusOK(fmt.Sprintf("Moved to %s tier", bb.Tier))
+	if err := to.PutState(bb); err != nil {
+		to.Delete(id)
+		return err
+	}
+	s.where[id] = to
+	if err := from.Delete(id); err != nil {
+		log.Warningf("Unable to remove %s copy of blob %d: %s", s.tierName(from), id, err)
+	}
+	log.Debugf("Moved blob %d to the %s tier", id, bb.Tier)
+	return nil
+}
@@ -0,0 +1,510 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	mrand "math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Arvinderpal/go-storage-server/challenge/common"
+	"github.com/Arvinderpal/go-storage-server/challenge/common/types"
+
+	"github.com/op/go-logging"
+)
+
+var (
+	log = logging.MustGetLogger("challenge-webhook")
+
+	// ErrNotFound is returned for a webhook ID that isn't registered.
+	ErrNotFound = errors.New("webhook not found")
+)
+
+const (
+	// DefaultMaxAttempts is the number of deliveries tried, about four
+	// minutes apart in the end, before an event is dead-lettered.
+	DefaultMaxAttempts = 8
+
+	recentDeliveries = 100  // deliveries kept per webhook to be looked at
+	maxDeadLetters   = 1000 // per webhook, the oldest are dropped
+)
+
+// Config tunes how events are delivered.
+type Config struct {
+	Path           string        // file the webhooks and their dead letters are saved in
+	MaxAttempts    int           // deliveries tried before an event is dead-lettered
+	InitialBackoff time.Duration // wait after the first failed attempt, doubled after each
+	MaxBackoff     time.Duration
+	Timeout        time.Duration // of one attempt
+	QueueSize      int           // events waiting per webhook, more are dead-lettered
+}
+
+func (c *Config) setDefaults() {
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = DefaultMaxAttempts
+	}
+	if c.InitialBackoff == 0 {
+		c.InitialBackoff = time.Second
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = 5 * time.Minute
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Second
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = 1000
+	}
+}
+
+// hook is a registered webhook and its deliveries.
+type hook struct {
+	types.Webhook // Stats unset
+
+	queue chan *types.WebhookDelivery
+	stop  chan struct{} // closed when the webhook is removed
+
+	// guarded by the dispatcher's mu
+	stats  types.WebhookStats
+	recent []*types.WebhookDelivery // latest last
+	dead   []types.WebhookDelivery
+}
+
+// savedHook is how a webhook is saved.
+type savedHook struct {
+	types.Webhook
+	DeadLetters []types.WebhookDelivery `json:"dead_letters,omitempty"`
+}
+
+// Dispatcher posts events to the webhooks they match. Each webhook has its
+// own queue and worker, so a slow or failing endpoint only delays its own
+// events, which it receives in order.
+type Dispatcher struct {
+	conf   Config
+	client *http.Client
+
+	mu    sync.Mutex
+	hooks map[string]*hook
+}
+
+// New returns a dispatcher delivering to the webhooks saved at conf.Path.
+func New(conf Config) (*Dispatcher, error) {
+	conf.setDefaults()
+	d := &Dispatcher{
+		conf:   conf,
+		client: &http.Client{Timeout: conf.Timeout},
+		hooks:  make(map[string]*hook),
+	}
+
+	saved := []savedHook{}
+	buf, err := ioutil.ReadFile(conf.Path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(buf, &saved); err != nil {
+			return nil, fmt.Errorf("invalid webhooks file %s: %s", conf.Path, err)
+		}
+	case os.IsNotExist(err):
+	default:
+		return nil, err
+	}
+	for _, s := range saved {
+		h := d.newHook(s.Webhook)
+		h.dead = s.DeadLetters
+		h.stats.Dead = len(h.dead)
+		d.hooks[h.ID] = h
+		go d.worker(h)
+	}
+	return d, nil
+}
+
+func (d *Dispatcher) newHook(wh types.Webhook) *hook {
+	wh.Stats = nil
+	return &hook{
+		Webhook: wh,
+		queue:   make(chan *types.WebhookDelivery, d.conf.QueueSize),
+		stop:    make(chan struct{}),
+	}
+}
+
+// save writes the webhooks to the file. Must be called with mu held.
+func (d *Dispatcher) save() error {
+	saved := []savedHook{}
+	for _, h := range d.sorted() {
+		saved = append(saved, savedHook{Webhook: h.Webhook, DeadLetters: h.dead})
+	}
+	buf, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	tmpPath := d.conf.Path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, d.conf.Path)
+}
+
+// sorted returns the webhooks, oldest first. Must be called with mu held.
+func (d *Dispatcher) sorted() []*hook {
+	hooks := make([]*hook, 0, len(d.hooks))
+	for _, h := range d.hooks {
+		hooks = append(hooks, h)
+	}
+	sort.Sort(byCreated(hooks))
+	return hooks
+}
+
+type byCreated []*hook
+
+func (s byCreated) Len() int      { return len(s) }
+func (s byCreated) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byCreated) Less(i, j int) bool {
+	if !s[i].Created.Equal(s[j].Created) {
+		return s[i].Created.Before(s[j].Created)
+	}
+	return s[i].ID < s[j].ID
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("unable to read random bytes: %s", err))
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Add registers a webhook and returns it, with its ID and, unless one was
+// given, a new secret.
+func (d *Dispatcher) Add(wh *types.Webhook) (*types.Webhook, error) {
+	u, err := url.Parse(wh.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q", wh.URL)
+	}
+	for _, t := range wh.Events {
+		if t != types.OpCreate && t != types.OpUpdate && t != types.OpDelete {
+			return nil, fmt.Errorf("unknown event type %q", t)
+		}
+	}
+
+	added := *wh
+	added.ID = randomHex(8)
+	added.Created = time.Now()
+	if added.Secret == "" {
+		added.Secret = randomHex(32)
+	}
+	h := d.newHook(added)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hooks[h.ID] = h
+	if err := d.save(); err != nil {
+		delete(d.hooks, h.ID)
+		return nil, fmt.Errorf("unable to save webhooks: %s", err)
+	}
+	go d.worker(h)
+	log.Infof("Added webhook %s posting to %s", h.ID, h.URL)
+	return &added, nil
+}
+
+// Remove unregisters a webhook, dropping the events waiting for it.
+func (d *Dispatcher) Remove(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	h, ok := d.hooks[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(d.hooks, id)
+	if err := d.save(); err != nil {
+		d.hooks[id] = h
+		return fmt.Errorf("unable to save webhooks: %s", err)
+	}
+	close(h.stop)
+	log.Infof("Removed webhook %s", id)
+	return nil
+}
+
+// view returns h as shown to clients. Must be called with mu held.
+func (h *hook) view() types.Webhook {
+	wh := h.Webhook
+	wh.Secret = ""
+	stats := h.stats
+	wh.Stats = &stats
+	return wh
+}
+
+// Get returns the webhook with the given ID and its stats.
+func (d *Dispatcher) Get(id string) (*types.Webhook, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	h, ok := d.hooks[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	wh := h.view()
+	return &wh, nil
+}
+
+// List returns the webhooks and their stats, oldest first.
+func (d *Dispatcher) List() []types.Webhook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	list := []types.Webhook{}
+	for _, h := range d.sorted() {
+		list = append(list, h.view())
+	}
+	return list
+}
+
+// Deliveries returns the latest deliveries to a webhook, newest first.
+func (d *Dispatcher) Deliveries(id string) ([]types.WebhookDelivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	h, ok := d.hooks[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	list := make([]types.WebhookDelivery, 0, len(h.recent))
+	for i := len(h.recent) - 1; i >= 0; i-- {
+		list = append(list, *h.recent[i])
+	}
+	return list, nil
+}
+
+// DeadLetters returns the events a webhook was given up on, oldest first.
+func (d *Dispatcher) DeadLetters(id string) ([]types.WebhookDelivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	h, ok := d.hooks[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]types.WebhookDelivery{}, h.dead...), nil
+}
+
+// Redeliver queues the dead letters of a webhook again and returns how many
+// were. Those that don't fit in the queue stay dead.
+func (d *Dispatcher) Redeliver(id string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	h, ok := d.hooks[id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	n := 0
+	for n < len(h.dead) {
+		dl := &types.WebhookDelivery{
+			Webhook: h.ID,
+			Event:   h.dead[n].Event,
+			Status:  types.DeliveryPending,
+		}
+		if !h.enqueue(dl) {
+			break
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	h.dead = append([]types.WebhookDelivery{}, h.dead[n:]...)
+	h.stats.Dead = len(h.dead)
+	if err := d.save(); err != nil {
+		log.Errorf("Unable to save webhooks: %s", err)
+	}
+	return n, nil
+}
+
+// Notify queues e for the webhooks it matches. It doesn't block.
+func (d *Dispatcher) Notify(e types.Event) {
+	if e.ID == "" {
+		e.ID = randomHex(16)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, h := range d.hooks {
+		if !h.matches(&e) {
+			continue
+		}
+		dl := &types.WebhookDelivery{
+			Webhook: h.ID,
+			Event:   e,
+			Status:  types.DeliveryPending,
+		}
+		if !h.enqueue(dl) {
+			dl.LastError = "queue full"
+			d.deadLetter(h, dl)
+		}
+	}
+}
+
+func (h *hook) matches(e *types.Event) bool {
+	if !strings.HasPrefix(e.Location, h.Prefix) {
+		return false
+	}
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, t := range h.Events {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue adds dl to the queue unless it is full. Must be called with the
+// dispatcher's mu held.
+func (h *hook) enqueue(dl *types.WebhookDelivery) bool {
+	select {
+	case h.queue <- dl:
+	default:
+		return false
+	}
+	h.stats.Queued++
+	h.recent = append(h.recent, dl)
+	if len(h.recent) > recentDeliveries {
+		h.recent = append([]*types.WebhookDelivery{}, h.recent[len(h.recent)-recentDeliveries:]...)
+	}
+	return true
+}
+
+// deadLetter gives up on dl. Must be called with mu held.
+func (d *Dispatcher) deadLetter(h *hook, dl *types.WebhookDelivery) {
+	log.Warningf("Giving up delivering event %s to webhook %s: %s", dl.Event.ID, h.ID, dl.LastError)
+	dl.Status = types.DeliveryDead
+	h.dead = append(h.dead, *dl)
+	if len(h.dead) > maxDeadLetters {
+		h.dead = append([]types.WebhookDelivery{}, h.dead[len(h.dead)-maxDeadLetters:]...)
+	}
+	h.stats.Dead = len(h.dead)
+	if _, ok := d.hooks[h.ID]; ok {
+		if err := d.save(); err != nil {
+			log.Errorf("Unable to save webhooks: %s", err)
+		}
+	}
+}
+
+func (d *Dispatcher) worker(h *hook) {
+	for {
+		select {
+		case <-h.stop:
+			return
+		case dl := <-h.queue:
+			if !d.deliver(h, dl) {
+				return
+			}
+		}
+	}
+}
+
+// deliver posts dl until it succeeds or runs out of attempts, backing off
+// between them. It returns false if the webhook was removed meanwhile.
+func (d *Dispatcher) deliver(h *hook, dl *types.WebhookDelivery) bool {
+	backoff := d.conf.InitialBackoff
+	for {
+		code, err := d.post(h, &dl.Event)
+
+		d.mu.Lock()
+		dl.Attempts++
+		dl.LastAttempt = time.Now()
+		dl.LastCode = code
+		if err == nil {
+			dl.Status = types.DeliveryDelivered
+			dl.LastError = ""
+			h.stats.Queued--
+			h.stats.Delivered++
+			h.stats.LastDelivery = dl.LastAttempt
+			d.mu.Unlock()
+			return true
+		}
+		dl.LastError = err.Error()
+		h.stats.LastError = dl.LastError
+		if dl.Attempts >= d.conf.MaxAttempts {
+			h.stats.Queued--
+			d.deadLetter(h, dl)
+			d.mu.Unlock()
+			return true
+		}
+		h.stats.Retries++
+		d.mu.Unlock()
+
+		// jittered so that endpoints coming back aren't hit by every
+		// retry at once
+		wait := backoff/2 + time.Duration(mrand.Int63n(int64(backoff/2)+1))
+		log.Debugf("Delivery of event %s to webhook %s failed, retrying in %s: %s", dl.Event.ID, h.ID, wait, err)
+		select {
+		case <-time.After(wait):
+		case <-h.stop:
+			return false
+		}
+		if backoff *= 2; backoff > d.conf.MaxBackoff {
+			backoff = d.conf.MaxBackoff
+		}
+	}
+}
+
+// Sign returns the signature of a payload sent at timestamp, as found in
+// the common.WebhookSignatureHeader header.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// post makes one attempt at delivering e to h, returning the HTTP status
+// it got.
+func (d *Dispatcher) post(h *hook, e *types.Event) (int, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(common.WebhookIDHeader, h.ID)
+	req.Header.Set(common.WebhookEventHeader, e.Type)
+	req.Header.Set(common.WebhookTimestampHeader, timestamp)
+	req.Header.Set(common.WebhookSignatureHeader, Sign(h.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	// drained so the connection is reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
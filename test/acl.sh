@@ -0,0 +1,14 @@
+# with the server started with --credentials, alice's key able to write
+# alice-team/*, share the alice-team bucket with bob (read) and carol (read
+# and write)
+ADMIN="admin:<secret>"
+ALICE="alice:<secret>"
+BOB="bob:<secret>"
+curl --request POST --header "X-Api-Key: $ADMIN" http://localhost:7777/buckets --data '{"name": "alice-team"}'
+curl --request PUT --header "X-Api-Key: $ALICE" http://localhost:7777/buckets/alice-team/acl --data '{"readers": ["key:bob"], "writers": ["key:carol"]}'
+curl --request POST --header "X-Api-Key: $ALICE" http://localhost:7777/buckets/alice-team/store/1 --data "aaaaaaaa"
+curl --header "X-Api-Key: $BOB" http://localhost:7777/buckets/alice-team/store/1
+curl --header "X-Api-Key: $BOB" http://localhost:7777/buckets/alice-team/acl/1
+# keep bob out of alice-team/secret
+curl --request PUT --header "X-Api-Key: $ALICE" http://localhost:7777/buckets/alice-team/acl/secret --data '{"writers": ["key:carol"]}'
+curl --request DELETE --header "X-Api-Key: $ALICE" http://localhost:7777/buckets/alice-team/acl/secret
@@ -0,0 +1,10 @@
+# start two servers first:
+#   ./challenge --dir ./data-1 -s 0.0.0.0:7781
+#   ./challenge --dir ./data-2 -s 0.0.0.0:7782
+curl --request POST http://localhost:7781/store/foo1 --data "aaaaaaaa"
+curl --request POST http://localhost:7782/store/foo2 --data "bbbbbbbb"
+curl http://localhost:7781/merkle
+curl "http://localhost:7781/merkle?path=a"
+./challenge sync --dry-run localhost:7781 localhost:7782
+./challenge sync localhost:7781 localhost:7782
+curl http://localhost:7782/store/foo1
@@ -0,0 +1,12 @@
+# with the server started with --credentials --audit-dir /tmp/audit, ADMIN
+# an admin key
+ADMIN="admin:<secret>"
+curl --request POST --header "X-Api-Key: $ADMIN" http://localhost:7777/store/report --data "first"
+curl --header "X-Api-Key: $ADMIN" http://localhost:7777/store/report
+curl --request DELETE --header "X-Api-Key: $ADMIN" http://localhost:7777/store/report
+# refused, recorded as well
+curl --request DELETE http://localhost:7777/store/report
+# claiming to be another server doesn't keep a request out of the log
+curl --header "X-Peer-Request: 1" http://localhost:7777/store/report
+cat /tmp/audit/audit.log
+./challenge audit verify /tmp/audit
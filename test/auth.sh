@@ -0,0 +1,9 @@
+# create a key, add its entry to the "keys" of a credentials file, then start
+# the server with:
+#   ./challenge --credentials credentials.json
+./challenge keygen --prefix foo --perm read --perm write ci
+KEY="ci:<secret printed by keygen>"
+curl --request POST http://localhost:7777/store/foo1 --data "aaaaaaaa"
+curl --request POST --header "X-Api-Key: $KEY" http://localhost:7777/store/foo1 --data "aaaaaaaa"
+curl --header "X-Api-Key: $KEY" http://localhost:7777/store/foo1
+curl --request DELETE --header "X-Api-Key: $KEY" http://localhost:7777/store/foo1
@@ -0,0 +1,11 @@
+# with the server started with --credentials, ADMIN an admin key and TEAM_A
+# a key created with --prefix team-a/
+ADMIN="admin:<secret>"
+TEAM_A="team-a:<secret>"
+curl --request POST --header "X-Api-Key: $ADMIN" http://localhost:7777/buckets --data '{"name": "team-a", "versioning": true, "compression": "gzip", "quota_bytes": 1048576}'
+curl --request POST --header "X-Api-Key: $TEAM_A" http://localhost:7777/buckets/team-a/store/report --data "first"
+curl --request PUT --header "X-Api-Key: $TEAM_A" http://localhost:7777/buckets/team-a/store/report --data "second"
+curl --header "X-Api-Key: $TEAM_A" http://localhost:7777/buckets/team-a/store/report
+curl --header "X-Api-Key: $TEAM_A" http://localhost:7777/buckets/team-a/versions/report
+curl --header "X-Api-Key: $TEAM_A" http://localhost:7777/buckets
+curl --header "X-Api-Key: $ADMIN" http://localhost:7777/buckets/team-a
@@ -0,0 +1,13 @@
+# start three members first:
+#   ./challenge --dir ./data-1 -s 0.0.0.0:7781 --member localhost:7781 --member localhost:7782 --member localhost:7783
+#   ./challenge --dir ./data-2 -s 0.0.0.0:7782 --member localhost:7781 --member localhost:7782 --member localhost:7783
+#   ./challenge --dir ./data-3 -s 0.0.0.0:7783 --member localhost:7781 --member localhost:7782 --member localhost:7783
+# and a fourth one to add:
+#   ./challenge --dir ./data-4 -s 0.0.0.0:7784 --member localhost:7781 --member localhost:7782 --member localhost:7783 --member localhost:7784
+for i in 1 2 3 4 5 6 7 8 9; do
+	curl --request POST http://localhost:7781/store/foo$i --data "$i$i$i$i$i$i$i$i"
+done
+curl http://localhost:7782/store/foo1
+curl http://localhost:7783/cluster/ring
+curl --request PUT http://localhost:7781/cluster/ring --data '{"members": ["localhost:7781", "localhost:7782", "localhost:7783", "localhost:7784"]}'
+curl http://localhost:7784/store/foo1
@@ -0,0 +1,9 @@
+# start the server storing blobs as 2 data and 1 parity shards over three
+# directories:
+#   ./challenge --dir ./data-1 --dir ./data-2 --dir ./data-3 --ec 2+1
+curl --request POST http://localhost:7777/store/foo --data "aaaaaaaabbbbbbbbcccccccc"
+# the shards are data-<n>/ec/<id>/shard.<n>; lose one of them and the read
+# rebuilds it from the other two
+rm ./data-2/ec/*/shard.1
+curl http://localhost:7777/store/foo
+curl --request DELETE http://localhost:7777/store/foo
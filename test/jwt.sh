@@ -0,0 +1,9 @@
+# with the server started with --credentials and the credentials file
+# holding a jwt section, e.g.:
+#   {"keys": [], "jwt": {"jwks": "jwks.json", "issuer": "https://idp.example.com",
+#     "audience": "challenge", "subjects": {"alice": [{"prefix": "alice-", "perms": ["read", "write"]}]},
+#     "prefix_claim": "challenge_prefixes", "prefix_perms": ["read"]}}
+TOKEN="<RS256 or ES256 token issued for alice>"
+curl --request POST --header "Authorization: Bearer $TOKEN" http://localhost:7777/store/alice-1 --data "aaaaaaaa"
+curl --head --header "Authorization: Bearer $TOKEN" http://localhost:7777/store/alice-1
+curl --header "Authorization: Bearer $TOKEN" http://localhost:7777/store/alice-1
@@ -0,0 +1,9 @@
+# start the server with the log-structured engine:
+#   ./challenge --dir ./data --store log
+# every write below is appended to the active file under data/log/
+curl --request POST http://localhost:7777/store/foo --data "11111111111111111"
+curl --request PUT http://localhost:7777/store/foo --data "22222222222222222"
+curl http://localhost:7777/store/foo
+curl --request DELETE http://localhost:7777/store/foo
+# restart the server: the keydir is rebuilt from the log, and foo stays deleted
+curl http://localhost:7777/store/foo
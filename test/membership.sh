@@ -0,0 +1,8 @@
+# start three members gossiping:
+#   ./challenge --dir ./data-1 -s 0.0.0.0:7781 --member localhost:7781 --member localhost:7782 --member localhost:7783 --gossip :7791
+#   ./challenge --dir ./data-2 -s 0.0.0.0:7782 --member localhost:7781 --member localhost:7782 --member localhost:7783 --gossip :7792 --join localhost:7791
+#   ./challenge --dir ./data-3 -s 0.0.0.0:7783 --member localhost:7781 --member localhost:7782 --member localhost:7783 --gossip :7793 --join localhost:7791
+curl http://localhost:7781/cluster/members
+# stop the second one, it turns suspect then dead
+sleep 10
+curl http://localhost:7783/cluster/members
@@ -0,0 +1,9 @@
+# start the server with one data directory per disk, placing blobs by hash:
+#   ./challenge --dir /mnt/disk1/data --dir /mnt/disk2/data --dir /mnt/disk3/data --placement hash
+curl --request POST http://localhost:7777/store/foo1 --data "11111111111111111"
+curl --request POST http://localhost:7777/store/foo2 --data "22222222222222222"
+curl --request POST http://localhost:7777/store/foo3 --data "33333333333333333"
+# if the disk holding foo1 fails, reading it returns an error naming its
+# directory while new writes go to the remaining disks
+curl http://localhost:7777/store/foo1
+curl --request POST http://localhost:7777/store/foo4 --data "44444444444444444"
@@ -0,0 +1,10 @@
+# with the server started with --credentials and a key able to read and
+# write foo1 (see auth.sh), mint a download link and an upload link
+KEY="ci:<secret printed by keygen>"
+curl --request POST --header "X-Api-Key: $KEY" http://localhost:7777/presign --data '{"method": "GET", "location": "foo1", "expires_in": 600}'
+curl --request POST --header "X-Api-Key: $KEY" http://localhost:7777/presign --data '{"method": "PUT", "location": "foo1", "expires_in": 600}'
+# anyone with the returned URLs can then, without a key:
+URL="<url returned for PUT>"
+curl --request PUT "$URL" --data "aaaaaaaa"
+URL="<url returned for GET>"
+curl "$URL"
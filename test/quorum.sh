@@ -0,0 +1,10 @@
+# start three peers first:
+#   ./challenge --dir ./data-1 -s 0.0.0.0:7771 --peer localhost:7772 --peer localhost:7773
+#   ./challenge --dir ./data-2 -s 0.0.0.0:7772 --peer localhost:7771 --peer localhost:7773
+#   ./challenge --dir ./data-3 -s 0.0.0.0:7773 --peer localhost:7771 --peer localhost:7772
+curl --request POST http://localhost:7771/store/foo --data "11111111111111111"
+curl --header "X-Consistency: one" http://localhost:7773/store/foo
+curl --request PUT --header "X-Consistency: all" http://localhost:7772/store/foo --data "22222222222222222"
+curl --head http://localhost:7771/store/foo
+curl http://localhost:7773/store/foo
+curl --request DELETE http://localhost:7771/store/foo
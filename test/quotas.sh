@@ -0,0 +1,11 @@
+# with the server started with --credentials, ADMIN an admin key and CI a
+# key created with --prefix logs- --perm write --quota-bytes 1048576
+ADMIN="admin:<secret>"
+CI="ci:<secret>"
+curl --request PUT --header "X-Api-Key: $ADMIN" http://localhost:7777/quotas --data '{"prefix": "logs-", "quota_objects": 2}'
+curl --request POST --header "X-Api-Key: $CI" http://localhost:7777/store/logs-1 --data "first"
+curl --request POST --header "X-Api-Key: $CI" http://localhost:7777/store/logs-2 --data "second"
+# 507: the prefix holds 2 blobs already
+curl --request POST --header "X-Api-Key: $CI" http://localhost:7777/store/logs-3 --data "third"
+curl --header "X-Api-Key: $ADMIN" http://localhost:7777/usage
+curl --request DELETE --header "X-Api-Key: $ADMIN" "http://localhost:7777/quotas?prefix=logs-"
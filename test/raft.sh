@@ -0,0 +1,11 @@
+# start three raft nodes first:
+#   ./challenge --dir ./data-1 -s 0.0.0.0:7781 --raft-node localhost:7781 --raft-node localhost:7782 --raft-node localhost:7783
+#   ./challenge --dir ./data-2 -s 0.0.0.0:7782 --raft-node localhost:7781 --raft-node localhost:7782 --raft-node localhost:7783
+#   ./challenge --dir ./data-3 -s 0.0.0.0:7783 --raft-node localhost:7781 --raft-node localhost:7782 --raft-node localhost:7783
+curl http://localhost:7781/raft/status
+# writes sent to a follower are redirected to the leader
+curl --location --request POST http://localhost:7782/store/foo --data "aaaaaaaa"
+curl --location --request PUT http://localhost:7783/store/foo --data "bbbbbbbb"
+curl http://localhost:7783/store/foo
+curl --location --header "X-Consistency: linearizable" http://localhost:7782/store/foo
+curl --location --request DELETE http://localhost:7781/store/foo
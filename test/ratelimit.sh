@@ -0,0 +1,8 @@
+# with the server started with --credentials --rate-requests 2 --rate-burst 3
+# --rate-bytes 1048576, ADMIN an admin key
+ADMIN="admin:<secret>"
+# the fourth request gets a 429 with Retry-After
+for i in 1 2 3 4; do curl --include --header "X-Api-Key: $ADMIN" http://localhost:7777/healthz; done
+# a 5MB upload takes about 4 seconds
+head -c 5242880 /dev/urandom > /tmp/big
+curl --request POST --header "X-Api-Key: $ADMIN" --data-binary @/tmp/big http://localhost:7777/store/big
@@ -0,0 +1,11 @@
+# start a primary and a replica first:
+#   ./challenge --dir ./data-primary
+#   ./challenge --dir ./data-replica -s 0.0.0.0:7778 --replica-of localhost:7777
+curl --request POST http://localhost:7777/store/foo --data "11111111111111111"
+sleep 1
+curl http://localhost:7778/store/foo
+curl http://localhost:7778/healthz
+curl --request DELETE http://localhost:7777/store/foo
+curl --request POST http://localhost:7778/replication/promote
+curl --request POST http://localhost:7778/store/foo --data "22222222222222222"
+curl http://localhost:7778/store/foo
@@ -0,0 +1,10 @@
+# start the server packing blobs of up to 1KiB into segment files:
+#   ./challenge --dir ./data --store segment --segment-threshold 1024
+# a small blob goes to data/segments/, a large one to data/large/<id>/
+curl --request POST http://localhost:7777/store/small --data "11111111111111111"
+head -c 4096 /dev/zero | curl --request POST http://localhost:7777/store/large --data-binary @-
+curl http://localhost:7777/store/small
+curl --request PUT http://localhost:7777/store/small --data "22222222222222222"
+# the delete is appended to data/tombstones.log
+curl --request DELETE http://localhost:7777/store/small
+curl --request DELETE http://localhost:7777/store/large
@@ -0,0 +1,34 @@
+# create a key able to sign, add its entry to the credentials file, then
+# start the server with --credentials:
+#   ./challenge keygen --sign --prefix foo --perm read --perm write ci
+ID=ci
+SIGNING_KEY="<signing key printed by keygen>"
+HOST=localhost:7777
+
+hmac() { # hmac <hex key> <data>, prints the hex HMAC-SHA256
+	printf '%s' "$2" | openssl dgst -sha256 -mac HMAC -macopt hexkey:$1 | sed 's/^.* //'
+}
+hex() { printf '%s' "$1" | od -An -v -tx1 | tr -d ' \n'; }
+
+# signed <method> <location> [body]: sends a request signed with SIGNING_KEY
+signed() {
+	local method=$1 path=/store/$2 body=$3
+	local date=$(date -u +%Y%m%dT%H%M%SZ) day=$(date -u +%Y%m%d)
+	local payload=$(printf '%s' "$body" | sha256sum | cut -d' ' -f1)
+	local headers="host;x-challenge-content-sha256;x-challenge-date"
+	local canonical=$(printf '%s\n%s\n\nhost:%s\nx-challenge-content-sha256:%s\nx-challenge-date:%s\n\n%s\n%s' \
+		"$method" "$path" "$HOST" "$payload" "$date" "$headers" "$payload")
+	local toSign=$(printf 'CHALLENGE-HMAC-SHA256\n%s\n%s/challenge_request\n%s' \
+		"$date" "$day" "$(printf '%s' "$canonical" | sha256sum | cut -d' ' -f1)")
+	local kDay=$(hmac $(hex "CHALLENGE$SIGNING_KEY") "$day")
+	local kSign=$(hmac $kDay challenge_request)
+	local sig=$(hmac $kSign "$toSign")
+	curl --request $method "http://$HOST$path" --data-binary "$body" \
+		--header "X-Challenge-Date: $date" \
+		--header "X-Challenge-Content-Sha256: $payload" \
+		--header "Authorization: CHALLENGE-HMAC-SHA256 Credential=$ID/$day/challenge_request, SignedHeaders=$headers, Signature=$sig"
+}
+
+signed POST foo1 "aaaaaaaa"
+signed GET foo1
+signed PUT foo1 "bbbbbbbb"
@@ -0,0 +1,10 @@
+# start the server with both sinks first:
+#   ./challenge --sink file:/tmp/challenge-events --sink unix:/tmp/challenge.sock
+curl --request POST http://localhost:7777/store/foo1 --data "aaaaaaaa"
+curl --request DELETE http://localhost:7777/store/foo1
+cat /tmp/challenge-events/*.jsonl
+# commit offset 2 for the consumer "indexer" of the file sink
+echo 2 > /tmp/challenge-events/consumers/.indexer.tmp && mv /tmp/challenge-events/consumers/.indexer.tmp /tmp/challenge-events/consumers/indexer
+# read the events from the socket, acknowledging the first one
+printf '{"consumer": "indexer"}\n{"ack": 1}\n' | nc -q 1 -U /tmp/challenge.sock
+curl http://localhost:7777/sinks
@@ -0,0 +1,7 @@
+# start the server with everything kept in memory (nothing survives a restart):
+#   ./challenge --store mem
+# or with the default directory per blob under --dir:
+#   ./challenge --store dir --dir ./data
+curl --request POST http://localhost:7777/store/foo --data "11111111111111111"
+curl http://localhost:7777/store/foo
+curl --request DELETE http://localhost:7777/store/foo
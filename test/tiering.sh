@@ -0,0 +1,9 @@
+# start the server with a cold tier, moving blobs idle for a minute there
+# and blobs of 1MiB or more as soon as they are idle:
+#   ./challenge --dir ./ssd --cold-dir ./hdd --cold-after 1m --cold-size 1048576 --promote-reads 2
+curl --request POST http://localhost:7777/store/foo --data "11111111111111111"
+sleep 130
+# foo was moved to ./hdd on the next pass, and is still read transparently
+curl http://localhost:7777/store/foo
+# a second read within --cold-after brings it back to ./ssd
+curl http://localhost:7777/store/foo
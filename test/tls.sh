@@ -0,0 +1,7 @@
+# start the server with a certificate, requiring client certificates issued
+# by ca.pem, and give the client's common name grants in the credentials file:
+#   ./challenge --tls-cert server.pem --tls-key server.key --tls-client-ca ca.pem --credentials credentials.json
+curl --cacert ca.pem --cert client.pem --key client.key --request POST https://localhost:7777/store/foo1 --data "aaaaaaaa"
+curl --cacert ca.pem --cert client.pem --key client.key https://localhost:7777/store/foo1
+# after renewing server.pem and server.key, reload them without a restart
+kill -HUP <pid of the server>
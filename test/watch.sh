@@ -0,0 +1,10 @@
+# stream changes to locations starting with foo as server-sent events
+curl --no-buffer --header "Accept: text/event-stream" "http://localhost:7777/watch?prefix=foo" &
+sleep 1
+curl --request POST http://localhost:7777/store/foo1 --data "aaaaaaaa"
+curl --request PUT http://localhost:7777/store/foo1 --data "bbbbbbbb"
+curl --request DELETE http://localhost:7777/store/foo1
+sleep 1
+kill %1
+# long-poll the changes after sequence number 1 as JSON
+curl "http://localhost:7777/watch?prefix=foo&since=1"
@@ -0,0 +1,10 @@
+# register a webhook for the blobs at artifacts-*, signed with our own secret
+curl --request POST http://localhost:7777/webhooks --data '{"url": "http://localhost:9000/hook", "events": ["create", "update"], "prefix": "artifacts-", "secret": "s3cret"}'
+curl --request POST http://localhost:7777/store/artifacts-build-1 --data "aaaaaaaa"
+sleep 1
+# use the id returned above in place of <id>
+curl http://localhost:7777/webhooks
+curl http://localhost:7777/webhooks/<id>/deliveries
+curl http://localhost:7777/webhooks/<id>/dead-letters
+curl --request POST http://localhost:7777/webhooks/<id>/redeliver
+curl --request DELETE http://localhost:7777/webhooks/<id>